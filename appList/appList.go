@@ -25,13 +25,17 @@
 package appList
 
 import (
+  "errors"
   "github.com/SatoshiPortal/cam/storage"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
   camUtils "github.com/SatoshiPortal/cam/utils"
   "github.com/schulterklopfer/cyphernode_fauth/helpers"
   "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
   "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/policy"
   "github.com/schulterklopfer/cyphernode_fauth/queries"
   "os"
+  "sort"
   "sync"
   "time"
 )
@@ -42,6 +46,11 @@ type AppList struct {
   LastFileInfo os.FileInfo
   LastUpate time.Time
   InstalledApps *storage.InstalledAppsIndex
+  // sync plan exceeding the maximum number of removals
+  // waiting for an admin to confirm it
+  PendingPlan *SyncPlan
+  LastSuccessfulSync time.Time
+  LastSyncError error
   mutex sync.Mutex
 }
 
@@ -55,7 +64,7 @@ func Init( whitelistFilePath string ) error {
   }
   appList = &AppList{ InstalledApps: &storage.InstalledAppsIndex{} }
   err := appList.load()
-  if err != nil {
+  if err != nil && err != globals.ErrSyncBlocked {
     return err
   }
   helpers.SetInterval(appList.checkForChange, 1000, false)
@@ -71,7 +80,7 @@ func (appList *AppList) checkForChange() {
       appList.LastFileInfo.Size() != fileInfo.Size() ||
       appList.LastFileInfo.ModTime().Before( fileInfo.ModTime() ) ) {
     err := appList.load()
-    if err != nil {
+    if err != nil && err != globals.ErrSyncBlocked {
       logwrapper.Logger().Errorf( "Failed to load whitelist: %s", err.Error() )
     }
     appList.LastUpate = time.Now()
@@ -79,6 +88,29 @@ func (appList *AppList) checkForChange() {
   appList.LastFileInfo = fileInfo
 }
 
+type Health struct {
+  LastSuccessfulSync time.Time `json:"lastSuccessfulSync"`
+  LastSyncError      string    `json:"lastSyncError,omitempty"`
+  SyncBlocked        bool      `json:"syncBlocked"`
+}
+
+func (health *Health) IsHealthy() bool {
+  return health.LastSyncError == "" && !health.SyncBlocked
+}
+
+func (appList *AppList) Health() *Health {
+  appList.mutex.Lock()
+  defer appList.mutex.Unlock()
+  health := &Health{
+    LastSuccessfulSync: appList.LastSuccessfulSync,
+    SyncBlocked:        appList.PendingPlan != nil,
+  }
+  if appList.LastSyncError != nil {
+    health.LastSyncError = appList.LastSyncError.Error()
+  }
+  return health
+}
+
 /*
 func (appList *AppList) ContainsClientID( clientID string ) bool {
   appList.mutex.Lock()
@@ -100,169 +132,359 @@ func (appList *AppList) load() error {
 
   appList.mutex.Lock()
   defer appList.mutex.Unlock()
-  err := appList.InstalledApps.Load()
+
+  installedApps := &storage.InstalledAppsIndex{}
+  err := installedApps.Load()
   if err != nil {
+    appList.LastSyncError = err
+    return err
+  }
+
+  return appList.syncToDb( installedApps, false )
+}
+
+// rolls back syncs which are only planned
+var errDryRun = errors.New( "dry run" )
+
+// syncToDb syncs installedApps to the database. The installed apps
+// stay the last successfully synced index, if this one fails or
+// is blocked
+func (appList *AppList) syncToDb( installedApps *storage.InstalledAppsIndex, force bool ) error {
+
+  // all or nothing. if anything fails, the database stays
+  // the way it was after the last successful sync
+  var plan *SyncPlan
+  err := queries.Transaction( func( q *queries.Queries ) error {
+    var err error
+    plan, err = syncIndex( q, installedApps )
+    if err != nil {
+      return err
+    }
+    if !force && plan.exceedsMaxRemovals() {
+      return globals.ErrSyncBlocked
+    }
+    return nil
+  })
+
+  if err == globals.ErrSyncBlocked {
+    // keep everything as it is until an admin confirms the plan
+    plan.log()
+    logwrapper.Logger().Warnf( "Sync blocked: %d removals exceed the maximum of %d. Confirmation needed.", plan.Removals(), maxRemovals() )
+    plan.Blocked = true
+    appList.PendingPlan = plan
     return err
   }
 
-  err = appList.syncToDb()
   if err != nil {
+    logwrapper.Logger().Errorf( "Sync failed and was rolled back: %s", err.Error() )
+    appList.LastSyncError = err
     return err
   }
 
+  appList.synced( plan )
   return nil
 }
 
-func (appList *AppList) syncToDb() error {
+// synced records a committed sync
+func (appList *AppList) synced( plan *SyncPlan ) {
+  plan.log()
+  for _, app := range plan.apps {
+    logPolicyProblems( app )
+  }
+  appList.InstalledApps = plan.installedApps
+  appList.PendingPlan = nil
+  appList.LastSuccessfulSync = time.Now()
+  appList.LastSyncError = nil
+}
+
+// syncIndex makes the database match installedApps and returns the
+// plan of what it changed. Syncs and plans share this code, plans
+// are just rolled back
+func syncIndex( q *queries.Queries, installedApps *storage.InstalledAppsIndex ) (*SyncPlan, error) {
 
-  // 1) go through apps in applist and see if they exist in the db
-  // if not, create them
   logwrapper.Logger().Debug("Syncing to database")
-  for _, app := range appList.InstalledApps.Apps {
 
-    appFromDb, err := queries.GetAppByHash( app.GetHash() )
+  plan := newSyncPlan()
+  plan.installedApps = installedApps
+  affectedUsers := make( map[string]bool )
+
+  // users losing roles. must be called before the roles are removed
+  addAffectedUsers := func( roles []*models.RoleModel ) error {
+    for _, role := range roles {
+      var users []*models.UserModel
+      err := q.UsersForRole( &users, role )
+      if err != nil {
+        return err
+      }
+      for _, user := range users {
+        affectedUsers[user.Login] = true
+      }
+    }
+    return nil
+  }
+
+  purgeApp := func( appFromDb *models.AppModel ) error {
+    logwrapper.Logger().Debug("purging archived app from database: "+appFromDb.Name )
+    plan.AppsPurged = append( plan.AppsPurged, appLabel( appFromDb ) )
+    for _, roleFromDb := range appFromDb.AvailableRoles {
+      plan.RolesRemoved = append( plan.RolesRemoved, roleLabel( appFromDb, roleFromDb.Name ) )
+    }
+    err := addAffectedUsers( appFromDb.AvailableRoles )
     if err != nil {
       return err
     }
+    _, err = q.DeleteApp( appFromDb.ID )
+    if err != nil {
+      return err
+    }
+    // harmless if the sync is rolled back, the engine is compiled again
+    policy.Forget( appFromDb.ID )
+    return nil
+  }
+
+  // 1) go through apps in applist and see if they exist in the db
+  // if not, create them
+
+  var archivedApps []*models.AppModel
+  err := q.ArchivedApps( &archivedApps )
+  if err != nil {
+    return nil, err
+  }
+  purged := make( map[uint]bool )
+
+  for _, app := range installedApps.Apps {
+
+    appFromDb, err := q.GetAppByHash( app.GetHash() )
+    if err != nil && !errors.Is( err, globals.ErrNotFound ) {
+      return nil, err
+    }
+
+    candidate := activeCandidate( app )
+    if candidate == nil {
+      // keep whatever is in the db for this app
+      plan.Problems = append( plan.Problems, "no usable candidate for "+app.Name+", skipped" )
+      continue
+    }
+
+    appManifest, err := readManifest( app )
+    if err != nil {
+      plan.Problems = append( plan.Problems, "failed to read manifest of "+app.Name+": "+err.Error() )
+      appManifest = new( manifest )
+    }
 
     if appFromDb != nil {
       // app exists, dont insert, but check available roles
 
-      // 1) check all roles for app in applist and see if they exist in the db
-      // if not create them
-
       logwrapper.Logger().Debug("found app in database: "+app.Name )
-      logwrapper.Logger().Debug("checking roles" )
+
+      before := *appFromDb
 
       // update access policies and other properties
-      appFromDb.MountPoint = app.MountPoint
-      appFromDb.Name = app.Name
-      appFromDb.Secret = app.Secret
-      appFromDb.Version = app.Candidates[0].Version.Raw
-      appFromDb.Meta = &models.Meta{app.Meta.Icon, app.Meta.Color }
-      appFromDb.AccessPolicies = app.Candidates[0].AccessPolicies
-
-      err := queries.Update( appFromDb )
+      updateAppModel( appFromDb, app, candidate, appManifest )
+
+      if before.IsArchived() {
+        // app was reinstalled. its roles and assignments are still there
+        plan.AppsRestored = append( plan.AppsRestored, appLabel( appFromDb ) )
+        err := q.RestoreApp( appFromDb )
+        if err != nil {
+          return nil, err
+        }
+      }
+
+      if appModelChanged( &before, appFromDb ) {
+        plan.AppsUpdated = append( plan.AppsUpdated, appLabel( appFromDb ) )
+      }
+
+      err := q.Update( appFromDb )
       if err != nil {
-        return err
+        return nil, err
       }
 
-      for _, role := range app.Candidates[0].AvailableRoles {
-        found := false
-        for _, roleFromDb := range appFromDb.AvailableRoles {
-          if roleFromDb.Name == role.Name {
-            found = true
-            break
-          }
-        }
-        if !found {
-          // needs to be created in db
-          err := queries.CreateRoleForApp( appFromDb, &models.RoleModel{
-            Name:        role.Name,
-            Description: role.Description,
-            AutoAssign:  role.AutoAssign,
-          })
-          logwrapper.Logger().Debug("creating new role in database: "+role.Name )
-
-          if err != nil {
-            return err
-          }
+      logwrapper.Logger().Debug("checking roles" )
+
+      changes := diffRoles( appFromDb.AvailableRoles, candidateRoles( candidate ), appManifest.RoleRenames )
+
+      // 1) rename roles declared as renamed in the manifest, so
+      // users keep their assignments
+      for _, rename := range changes.renamed {
+        logwrapper.Logger().Debug("renaming role in database: "+rename.from.Name+" -> "+rename.to.Name )
+        plan.RolesRenamed = append( plan.RolesRenamed, roleLabel( &before, rename.from.Name )+" -> "+roleLabel( appFromDb, rename.to.Name ) )
+        err := q.RenameRole( rename.from, rename.to.Name, rename.to.Description )
+        if err != nil {
+          return nil, err
         }
       }
 
-      // 2) check all roles for app in db and see if they exist in the app from the applist
-      // if not delete them
+      // 2) create roles of the candidate missing in the db
+      for _, role := range changes.added {
+        logwrapper.Logger().Debug("creating new role in database: "+role.Name )
+        plan.RolesAdded = append( plan.RolesAdded, roleLabel( appFromDb, role.Name ) )
+        err := q.CreateRoleForApp( appFromDb, &models.RoleModel{
+          Name:        role.Name,
+          Description: role.Description,
+          AutoAssign:  role.AutoAssign,
+        })
+        if err != nil {
+          return nil, err
+        }
+      }
 
-      // reload from db in case roles changed
-      err = queries.LoadRoles(appFromDb)
+      // 3) delete roles in the db the candidate does not have anymore
+      err = addAffectedUsers( changes.removed )
       if err != nil {
-        return err
+        return nil, err
       }
-
-      for _, roleFromDb := range appFromDb.AvailableRoles {
-        found := false
-        for _, role := range app.Candidates[0].AvailableRoles {
-          if roleFromDb.Name == role.Name {
-            found = true
-            break
-          }
-        }
-        if !found {
-          // needs to be created in db
-          err := queries.RemoveRoleFromApp( appFromDb, roleFromDb.ID )
-          logwrapper.Logger().Debug("removing role from database: "+roleFromDb.Name )
-
-          if err != nil {
-            return err
-          }
-
-          err = queries.DeleteRole( roleFromDb.ID )
-          if err != nil {
-            return err
-          }
+      for _, roleFromDb := range changes.removed {
+        logwrapper.Logger().Debug("removing role from database: "+roleFromDb.Name )
+        plan.RolesRemoved = append( plan.RolesRemoved, roleLabel( &before, roleFromDb.Name ) )
+        report, err := q.RemoveRoleFromApp( appFromDb, roleFromDb.ID )
+        if err != nil {
+          return nil, err
         }
+        logwrapper.Logger().Debugf( "removed role %s from %d users", roleFromDb.Name, report.Assignments )
       }
+
+      plan.apps = append( plan.apps, appFromDb )
       continue
     }
 
     // app does not exist, create it in db
     appFromDb = &models.AppModel{
-      Hash:           app.GetHash(),
-      Secret:         app.Secret,
-      MountPoint:     app.MountPoint,
-      Name:           app.Name,
-      Meta:           &models.Meta{app.Meta.Icon, app.Meta.Color },
-      Version:        app.Candidates[0].Version.Raw,
-      AccessPolicies: app.Candidates[0].AccessPolicies,
+      Hash: app.GetHash(),
     }
+    updateAppModel( appFromDb, app, candidate, appManifest )
 
-    err = queries.CreateApp( appFromDb )
-    logwrapper.Logger().Debug("creating app in database: "+appFromDb.Name )
+    for _, archivedApp := range conflictingArchivedApps( archivedApps, appFromDb ) {
+      if purged[archivedApp.ID] {
+        continue
+      }
+      // make room for the new app
+      err = purgeApp( archivedApp )
+      if err != nil {
+        return nil, err
+      }
+      purged[archivedApp.ID] = true
+    }
 
+    logwrapper.Logger().Debug("creating app in database: "+appFromDb.Name )
+    plan.AppsAdded = append( plan.AppsAdded, appLabel( appFromDb ) )
+    err = q.CreateApp( appFromDb )
     if err != nil {
-      return err
+      return nil, err
     }
-    for _, role := range app.Candidates[0].AvailableRoles {
-      err = queries.CreateRoleForApp( appFromDb, &models.RoleModel{
+
+    for _, role := range candidateRoles( candidate ) {
+      plan.RolesAdded = append( plan.RolesAdded, roleLabel( appFromDb, role.Name ) )
+      err = q.CreateRoleForApp( appFromDb, &models.RoleModel{
         Name:        role.Name,
         Description: role.Description,
         AutoAssign:  role.AutoAssign,
       })
       if err != nil {
-        return err
+        return nil, err
       }
     }
+
+    plan.apps = append( plan.apps, appFromDb )
   }
 
   // 2) go through apps in database and see if they exist in the applist
-  // if not, delete them
+  // if not, archive them. purge archived ones past their retention
 
   var appsFromDb []*models.AppModel
-  // exclude app id == 1, cause its the admin app
-  err := queries.Find( &appsFromDb, []interface{}{"id != ?", 1 }, "", -1,0,true)
+  // exclude the admin app, it is not in the app list
+  err = q.Find( &appsFromDb, []interface{}{"mount_point != ?", globals.BASE_ADMIN_MOUNTPOINT }, "", -1,0,true)
   if err != nil {
-    return err
+    return nil, err
   }
 
+  now := time.Now()
+
   for _, appFromDb := range appsFromDb {
-    found := false
-    for _, app := range appList.InstalledApps.Apps {
-      if app.GetHash() == appFromDb.Hash {
-        found = true
-        break
-      }
+    if containsHash( installedApps, appFromDb.Hash ) || purged[appFromDb.ID] {
+      continue
     }
 
-    if !found {
-      // delete
-      err := queries.DeleteApp( appFromDb.ID )
-      logwrapper.Logger().Debug("removing app from database: "+appFromDb.Name )
+    if !appFromDb.IsArchived() {
+      // roles are kept, but users lose access to the app
+      logwrapper.Logger().Debug("archiving app in database: "+appFromDb.Name )
+      plan.AppsArchived = append( plan.AppsArchived, appLabel( appFromDb ) )
+      err := addAffectedUsers( appFromDb.AvailableRoles )
+      if err != nil {
+        return nil, err
+      }
+      err = q.ArchiveApp( appFromDb )
+      if err != nil {
+        return nil, err
+      }
+      continue
+    }
 
+    if archiveExpired( appFromDb, now ) {
+      err := purgeApp( appFromDb )
       if err != nil {
-        return err
+        return nil, err
       }
     }
   }
 
-  return nil
+  // 3) give users the roles granted to them by the admin's
+  // config file and the manifests of the apps
+
+  var users []*models.UserModel
+  err = q.Find( &users, nil, "", -1, 0, false )
+  if err != nil {
+    return nil, err
+  }
+
+  granted, err := q.GrantRoles( users )
+  if err != nil {
+    return nil, err
+  }
+
+  for login, labels := range granted {
+    for _, label := range labels {
+      plan.RolesGranted = append( plan.RolesGranted, label+" to "+login )
+    }
+  }
+  sort.Strings( plan.RolesGranted )
+
+  for login := range affectedUsers {
+    plan.UsersAffected = append( plan.UsersAffected, login )
+  }
+  sort.Strings( plan.UsersAffected )
+
+  return plan, nil
+}
+
+func keyLabelsOf( app *storage.App ) models.StringList {
+  keyLabels := make( models.StringList, 0, len(app.Keys) )
+  for _, key := range app.Keys {
+    if key == nil || key.Label == "" {
+      continue
+    }
+    keyLabels = append( keyLabels, key.Label )
+  }
+  return keyLabels
+}
+
+// approvals for groups an app does not ask for anymore are dropped, so
+// apps never keep more privileges than they need
+func stillRequested( approvedGroups models.StringList, requestedGroups models.StringList ) models.StringList {
+  groups := make( models.StringList, 0, len(approvedGroups) )
+  for _, group := range approvedGroups {
+    if requestedGroups.Contains( group ) {
+      groups = append( groups, group )
+    }
+  }
+  return groups
+}
+
+func logPolicyProblems( app *models.AppModel ) {
+  for _, problem := range app.PolicyProblems {
+    logwrapper.Logger().Warnf( "Access policies of %s: %s", app.Name, problem )
+  }
+  if app.Quarantined {
+    logwrapper.Logger().Errorf( "Quarantining %s because of fatal problems in its access policies", app.Name )
+  }
 }
@@ -0,0 +1,76 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package appList
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/helpers"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/policy"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "time"
+)
+
+// how long apps removed from the installed apps index are kept
+// before they are purged. negative values keep them forever
+func archiveRetention() time.Duration {
+  retention, err := time.ParseDuration( helpers.GetenvOrDefault( globals.CNA_APPLIST_ARCHIVE_RETENTION_ENV_KEY ) )
+  if err != nil {
+    return -1
+  }
+  return retention
+}
+
+func archiveExpired( app *models.AppModel, now time.Time ) bool {
+  retention := archiveRetention()
+  return app.IsArchived() && retention >= 0 && app.ArchivedAt.Add( retention ).Before( now )
+}
+
+// archived apps occupying the mount point or secret of app. they
+// have to be purged before app can be created
+func conflictingArchivedApps( archivedApps []*models.AppModel, app *models.AppModel ) []*models.AppModel {
+  conflicts := make( []*models.AppModel, 0 )
+  for _, archivedApp := range archivedApps {
+    if archivedApp.Hash == app.Hash {
+      continue
+    }
+    if archivedApp.MountPoint == app.MountPoint || archivedApp.Secret == app.Secret {
+      conflicts = append( conflicts, archivedApp )
+    }
+  }
+  return conflicts
+}
+
+// Purge deletes an archived app, its roles and their assignments for good
+func (appList *AppList) Purge( appId uint ) (*queries.DeleteReport, error) {
+  appList.mutex.Lock()
+  defer appList.mutex.Unlock()
+  report, err := queries.PurgeApp( appId )
+  if err != nil {
+    return nil, err
+  }
+  policy.Forget( appId )
+  return report, nil
+}
@@ -0,0 +1,204 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package appList
+
+import (
+  "github.com/SatoshiPortal/cam/storage"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "os"
+  "testing"
+  "time"
+)
+
+// the admin app is created like it is when seeded. it is never archived
+func createAdminApp( t *testing.T ) {
+  err := queries.CreateApp( &models.AppModel{ Name: "admin", Hash: "adminHash", Secret: "adminSecret", MountPoint: globals.BASE_ADMIN_MOUNTPOINT } )
+  if err != nil {
+    t.Fatal( err )
+  }
+}
+
+func archivedSince( t *testing.T, app *models.AppModel, archivedAt time.Time ) {
+  err := dataSource.GetDB().Model( app ).Update( "archived_at", &archivedAt ).Error
+  if err != nil {
+    t.Fatal( err )
+  }
+}
+
+func TestArchiveAndRestore(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  // without the admin app shop is the first app. apps are told apart
+  // by their mount point, not by their id
+  shop := newApp( "shop", "customer" )
+  appList := &AppList{}
+  err := appList.syncToDb( index( shop ), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  alice := createUser( t, "alice", roleOf( t, shop, "customer" ) )
+  appId := appFromDb( t, shop ).ID
+
+  err = appList.syncToDb( index(), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if !appFromDb( t, shop ).IsArchived() {
+    t.Fatal( "expected shop to be archived" )
+  }
+  roles, err := queries.RolesOfUserInApp( alice.ID, appId )
+  if err != nil || len(roles) != 1 {
+    t.Errorf( "expected archiving to keep the roles of alice, got %v %v", roles, err )
+  }
+
+  // reinstalling the same app restores it by its hash
+  plan, err := syncPlanOf( appList, index( shop ) )
+  if err != nil || !sameStrings( plan.AppsRestored, "shop (shop)" ) || len(plan.AppsAdded) != 0 {
+    t.Errorf( "expected shop to be restored, got %+v %v", plan, err )
+  }
+  err = appList.syncToDb( index( shop ), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  appModel := appFromDb( t, shop )
+  if appModel.IsArchived() || appModel.ID != appId {
+    t.Errorf( "expected shop to be restored as app %d, got app %d archived at %v", appId, appModel.ID, appModel.ArchivedAt )
+  }
+  roles, err = queries.RolesOfUserInApp( alice.ID, appId )
+  if err != nil || len(roles) != 1 || roles[0].Name != "customer" {
+    t.Errorf( "expected alice to be a customer of the restored shop, got %v %v", roles, err )
+  }
+}
+
+func TestRetentionPurge(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+  createAdminApp( t )
+
+  shop, blog := newApp( "shop", "customer" ), newApp( "blog", "reader" )
+  appList := &AppList{}
+  err := appList.syncToDb( index( shop, blog ), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  createUser( t, "alice", roleOf( t, shop, "customer" ) )
+  err = appList.syncToDb( index(), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  os.Setenv( globals.CNA_APPLIST_ARCHIVE_RETENTION_ENV_KEY, "24h" )
+  archivedSince( t, appFromDb( t, shop ), time.Now().Add( -25*time.Hour ) )
+  archivedSince( t, appFromDb( t, blog ), time.Now().Add( -23*time.Hour ) )
+
+  plan, err := syncPlanOf( appList, index() )
+  if err != nil || !sameStrings( plan.AppsPurged, "shop (shop)" ) || !sameStrings( plan.RolesRemoved, "shop/customer" ) ||
+    !sameStrings( plan.UsersAffected, "alice" ) {
+    t.Errorf( "expected shop to be purged, got %+v %v", plan, err )
+  }
+  err = appList.syncToDb( index(), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if _, err := queries.GetAppByHash( shop.GetHash() ); err == nil {
+    t.Error( "expected shop to be purged after its retention" )
+  }
+  if !appFromDb( t, blog ).IsArchived() {
+    t.Error( "expected blog to stay archived within its retention" )
+  }
+
+  // negative retentions keep archived apps forever
+  os.Setenv( globals.CNA_APPLIST_ARCHIVE_RETENTION_ENV_KEY, "-1h" )
+  archivedSince( t, appFromDb( t, blog ), time.Now().Add( -24*365*time.Hour ) )
+  err = appList.syncToDb( index(), false )
+  if err != nil || countApps( t ) != 2 {
+    t.Errorf( "expected blog to be kept, got %d apps %v", countApps( t ), err )
+  }
+}
+
+func TestConflictPurge(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+  createAdminApp( t )
+
+  shop := newApp( "shop", "customer" )
+  appList := &AppList{}
+  err := appList.syncToDb( index( shop ), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  createUser( t, "alice", roleOf( t, shop, "customer" ) )
+  err = appList.syncToDb( index(), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  // another app on the mount point of the archived shop
+  otherShop := newApp( "shop", "cashier" )
+  otherShop.Source = storage.NewFileSource( "file:///apps/otherShop" )
+  otherShop.Secret = "otherShopSecret"
+  otherShop.BuildHash()
+  // and one using its secret
+  blog := newApp( "blog", "reader" )
+  blog.Secret = shop.Secret
+
+  plan, err := syncPlanOf( appList, index( otherShop ) )
+  if err != nil || !sameStrings( plan.AppsPurged, "shop (shop)" ) || !sameStrings( plan.AppsAdded, "shop (shop)" ) ||
+    !sameStrings( plan.UsersAffected, "alice" ) {
+    t.Errorf( "expected the archived shop to make room for the new one, got %+v %v", plan, err )
+  }
+
+  err = appList.syncToDb( index( otherShop ), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if _, err := queries.GetAppByHash( shop.GetHash() ); err == nil {
+    t.Error( "expected the archived shop to be purged" )
+  }
+  if appModel := appFromDb( t, otherShop ); appModel.IsArchived() || len(appModel.AvailableRoles) != 1 || appModel.AvailableRoles[0].Name != "cashier" {
+    t.Errorf( "expected the new shop to be created, got %+v", appModel )
+  }
+
+  // archive the new shop and take its secret
+  err = appList.syncToDb( index(), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  blog.Secret = otherShop.Secret
+  err = appList.syncToDb( index( blog ), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if _, err := queries.GetAppByHash( otherShop.GetHash() ); err == nil {
+    t.Error( "expected the archived app with the secret of blog to be purged" )
+  }
+  if countApps( t ) != 2 {
+    t.Errorf( "expected only the admin app and blog to be left, got %d apps", countApps( t ) )
+  }
+}
@@ -0,0 +1,181 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package appList
+
+import (
+  "encoding/json"
+  camGlobals "github.com/SatoshiPortal/cam/globals"
+  "github.com/SatoshiPortal/cam/storage"
+  "github.com/SatoshiPortal/cam/version"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "io/ioutil"
+  "path/filepath"
+  "strings"
+)
+
+// activeCandidate picks the candidate of app which is actually in use:
+// 1) the one installed in the app's directory
+// 2) the one matching the latest version of the app
+// 3) the one with the highest version
+// returns nil if app has no usable candidates
+func activeCandidate( app *storage.App ) *storage.AppCandidate {
+  candidates := make( []*storage.AppCandidate, 0, len(app.Candidates) )
+  for _, candidate := range app.Candidates {
+    if candidate == nil || candidate.Version == nil {
+      continue
+    }
+    candidates = append( candidates, candidate )
+  }
+
+  if len(candidates) == 0 {
+    return nil
+  }
+
+  if len(candidates) == 1 {
+    return candidates[0]
+  }
+
+  if installedVersion := installedCandidateVersion( app ); installedVersion != nil {
+    for _, candidate := range candidates {
+      if candidate.Version.IsEqual( installedVersion ) {
+        return candidate
+      }
+    }
+  }
+
+  if app.Latest != "" {
+    latestVersion := version.NewVersion( app.Latest )
+    for _, candidate := range candidates {
+      if candidate.Version.IsEqual( latestVersion ) {
+        return candidate
+      }
+    }
+  }
+
+  highest := candidates[0]
+  for _, candidate := range candidates[1:] {
+    if compareVersions( candidate.Version, highest.Version ) > 0 {
+      highest = candidate
+    }
+  }
+  return highest
+}
+
+// installedCandidate holds the properties of the candidate.json in
+// an app's directory cam drops when building the index
+type installedCandidate struct {
+  Version        *version.Version      `json:"version"`
+  AccessPolicies models.AccessPolicies `json:"accessPolicies"`
+}
+
+func readInstalledCandidate( app *storage.App ) *installedCandidate {
+  dir := appDir( app )
+  if dir == "" {
+    return nil
+  }
+
+  candidateJsonBytes, err := ioutil.ReadFile( filepath.Join( dir, camGlobals.CANDIDATE_DESCRIPTION_FILE ) )
+  if err != nil {
+    return nil
+  }
+
+  candidate := new( installedCandidate )
+  err = json.Unmarshal( candidateJsonBytes, candidate )
+  if err != nil || candidate.Version == nil {
+    return nil
+  }
+
+  return candidate
+}
+
+func installedCandidateVersion( app *storage.App ) *version.Version {
+  candidate := readInstalledCandidate( app )
+  if candidate == nil {
+    return nil
+  }
+  return candidate.Version
+}
+
+// access policies of candidate including their conditions, if
+// candidate is the one installed in the app's directory
+func accessPoliciesOf( app *storage.App, candidate *storage.AppCandidate ) models.AccessPolicies {
+  installed := readInstalledCandidate( app )
+  if installed != nil && installed.Version.IsEqual( candidate.Version ) {
+    return installed.AccessPolicies
+  }
+  return models.AccessPoliciesFrom( candidate.AccessPolicies )
+}
+
+// compares major, minor and patch. versions without misc part
+// are higher than the ones with, so 1.0.0 > 1.0.0-rc1
+func compareVersions( a *version.Version, b *version.Version ) int {
+  if a.Major != b.Major {
+    return a.Major - b.Major
+  }
+  if a.Minor != b.Minor {
+    return a.Minor - b.Minor
+  }
+  if a.Patch != b.Patch {
+    return a.Patch - b.Patch
+  }
+  if a.Misc == b.Misc {
+    return 0
+  }
+  if a.Misc == "" {
+    return 1
+  }
+  if b.Misc == "" {
+    return -1
+  }
+  return strings.Compare( a.Misc, b.Misc )
+}
+
+func candidateRoles( candidate *storage.AppCandidate ) []*storage.Role {
+  roles := make( []*storage.Role, 0, len(candidate.AvailableRoles) )
+  for _, role := range candidate.AvailableRoles {
+    if role == nil {
+      continue
+    }
+    roles = append( roles, role )
+  }
+  return roles
+}
+
+func roleNamesOf( roles []*storage.Role ) []string {
+  roleNames := make( []string, 0, len(roles) )
+  for _, role := range roles {
+    roleNames = append( roleNames, role.Name )
+  }
+  return roleNames
+}
+
+func findRole( roles []*storage.Role, name string ) *storage.Role {
+  for _, role := range roles {
+    if role.Name == name {
+      return role
+    }
+  }
+  return nil
+}
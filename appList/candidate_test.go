@@ -0,0 +1,91 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package appList
+
+import (
+  "github.com/SatoshiPortal/cam/storage"
+  "github.com/SatoshiPortal/cam/version"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "testing"
+)
+
+func candidateWithVersion( raw string ) *storage.AppCandidate {
+  return &storage.AppCandidate{ Version: version.NewVersion( raw ) }
+}
+
+func TestActiveCandidate(t *testing.T) {
+  if activeCandidate( &storage.App{} ) != nil {
+    t.Error( "app without candidates must not have an active candidate" )
+  }
+
+  app := &storage.App{
+    Candidates: []*storage.AppCandidate{
+      candidateWithVersion( "v0.1.0" ),
+      candidateWithVersion( "v0.3.0-rc1" ),
+      candidateWithVersion( "v0.3.0" ),
+      candidateWithVersion( "v0.2.5" ),
+    },
+  }
+
+  if candidate := activeCandidate( app ); candidate.Version.Raw != "v0.3.0" {
+    t.Errorf( "expected highest version v0.3.0, got %s", candidate.Version.Raw )
+  }
+
+  app.Latest = "v0.2.5"
+  if candidate := activeCandidate( app ); candidate.Version.Raw != "v0.2.5" {
+    t.Errorf( "expected latest version v0.2.5, got %s", candidate.Version.Raw )
+  }
+}
+
+func TestDiffRoles(t *testing.T) {
+  rolesFromDb := []*models.RoleModel{
+    {Name: "user"},
+    {Name: "operator"},
+    {Name: "legacy"},
+  }
+  roles := []*storage.Role{
+    {Name: "user"},
+    {Name: "admin"},
+    {Name: "viewer"},
+  }
+
+  changes := diffRoles( rolesFromDb, roles, map[string]string{
+    "operator": "admin",
+    // target does not exist in the candidate
+    "legacy": "gone",
+  })
+
+  if len(changes.renamed) != 1 || changes.renamed[0].from.Name != "operator" || changes.renamed[0].to.Name != "admin" {
+    t.Errorf( "expected operator to be renamed to admin, got %v", changes.renamed )
+  }
+
+  if len(changes.added) != 1 || changes.added[0].Name != "viewer" {
+    t.Errorf( "expected viewer to be added, got %v", changes.added )
+  }
+
+  if len(changes.removed) != 1 || changes.removed[0].Name != "legacy" {
+    t.Errorf( "expected legacy to be removed, got %v", changes.removed )
+  }
+}
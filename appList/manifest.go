@@ -0,0 +1,107 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package appList
+
+import (
+  "encoding/json"
+  camGlobals "github.com/SatoshiPortal/cam/globals"
+  "github.com/SatoshiPortal/cam/storage"
+  camUtils "github.com/SatoshiPortal/cam/utils"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+)
+
+// manifest holds the properties of an app's app.json which are
+// unknown to cam and therefore don't make it into the installed
+// apps index
+type manifest struct {
+  GatekeeperGroups []string `json:"gatekeeperGroups"`
+  // old role name -> new role name. users assigned to the old role
+  // keep their assignment when the app is upgraded
+  RoleRenames map[string]string `json:"roleRenames"`
+  // whenRole is a label like admin/admin, grant the name of
+  // a role of this app
+  RoleGrants []*models.RoleGrant `json:"roleGrants"`
+}
+
+// apps may only grant their own roles
+func (m *manifest) roleGrantsFor( mountPoint string ) models.RoleGrants {
+  grants := make( models.RoleGrants, 0, len(m.RoleGrants) )
+  for _, grant := range m.RoleGrants {
+    if grant == nil || strings.Contains( grant.Grant, "/" ) {
+      continue
+    }
+    ownGrant := &models.RoleGrant{
+      WhenRole: grant.WhenRole,
+      Grant:    models.RoleLabel( mountPoint, grant.Grant ),
+    }
+    if ownGrant.IsValid() {
+      grants = append( grants, ownGrant )
+    }
+  }
+  return grants
+}
+
+func appDir( app *storage.App ) string {
+  if app.Path == "" {
+    return ""
+  }
+  if _, err := os.Stat( app.Path ); err == nil {
+    return app.Path
+  }
+  // install dir might be mounted somewhere else in this container
+  return filepath.Join( camUtils.GetInstallDirPath(), filepath.Base( app.Path ) )
+}
+
+func readManifest( app *storage.App ) (*manifest, error) {
+  m := new( manifest )
+
+  dir := appDir( app )
+
+  if dir == "" {
+    return m, nil
+  }
+
+  manifestJsonBytes, err := ioutil.ReadFile( filepath.Join( dir, camGlobals.APP_DESCRIPTION_FILE ) )
+
+  if os.IsNotExist( err ) {
+    return m, nil
+  }
+
+  if err != nil {
+    return nil, err
+  }
+
+  err = json.Unmarshal( manifestJsonBytes, m )
+
+  if err != nil {
+    return nil, err
+  }
+
+  return m, nil
+}
@@ -0,0 +1,258 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package appList
+
+import (
+  "encoding/json"
+  "fmt"
+  "github.com/SatoshiPortal/cam/storage"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/helpers"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/policy"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "strconv"
+  "time"
+)
+
+// SyncPlan describes what a sync of the installed apps index
+// would change in the database
+type SyncPlan struct {
+  AppsAdded     []string  `json:"appsAdded"`
+  AppsUpdated   []string  `json:"appsUpdated"`
+  AppsArchived  []string  `json:"appsArchived"`
+  AppsRestored  []string  `json:"appsRestored"`
+  AppsPurged    []string  `json:"appsPurged"`
+  RolesAdded    []string  `json:"rolesAdded"`
+  RolesRemoved  []string  `json:"rolesRemoved"`
+  RolesRenamed  []string  `json:"rolesRenamed"`
+  RolesGranted  []string  `json:"rolesGranted"`
+  UsersAffected []string  `json:"usersAffected"`
+  // unreadable manifests, apps without usable candidates
+  Problems      []string  `json:"problems"`
+  Blocked       bool      `json:"blocked"`
+  CreatedAt     time.Time `json:"createdAt"`
+  // apps as they were synced, to log their policy problems once
+  // the sync is committed
+  apps []*models.AppModel
+  // index the plan syncs. blocked indexes are only kept here until
+  // the plan is confirmed
+  installedApps *storage.InstalledAppsIndex
+}
+
+func newSyncPlan() *SyncPlan {
+  return &SyncPlan{
+    AppsAdded:     make( []string, 0 ),
+    AppsUpdated:   make( []string, 0 ),
+    AppsArchived:  make( []string, 0 ),
+    AppsRestored:  make( []string, 0 ),
+    AppsPurged:    make( []string, 0 ),
+    RolesAdded:    make( []string, 0 ),
+    RolesRemoved:  make( []string, 0 ),
+    RolesRenamed:  make( []string, 0 ),
+    RolesGranted:  make( []string, 0 ),
+    UsersAffected: make( []string, 0 ),
+    Problems:      make( []string, 0 ),
+    CreatedAt:     time.Now(),
+  }
+}
+
+// Removals counts archived and purged apps and removed roles
+func (plan *SyncPlan) Removals() int {
+  return len(plan.AppsArchived) + len(plan.AppsPurged) + len(plan.RolesRemoved)
+}
+
+func (plan *SyncPlan) IsEmpty() bool {
+  return len(plan.AppsAdded) == 0 &&
+      len(plan.AppsUpdated) == 0 &&
+      len(plan.AppsRestored) == 0 &&
+      plan.Removals() == 0 &&
+      len(plan.RolesAdded) == 0 &&
+      len(plan.RolesRenamed) == 0 &&
+      len(plan.RolesGranted) == 0
+}
+
+// same changes, no matter when the plans were made
+func (plan *SyncPlan) sameAs( other *SyncPlan ) bool {
+  a := *plan
+  b := *other
+  a.CreatedAt, b.CreatedAt = time.Time{}, time.Time{}
+  a.Blocked, b.Blocked = false, false
+  aJsonBytes, _ := json.Marshal( &a )
+  bJsonBytes, _ := json.Marshal( &b )
+  return string(aJsonBytes) == string(bJsonBytes)
+}
+
+func (plan *SyncPlan) log() {
+  for _, problem := range plan.Problems {
+    logwrapper.Logger().Warnf( "Sync: %s", problem )
+  }
+  if plan.IsEmpty() {
+    logwrapper.Logger().Debug( "Sync plan: nothing to do" )
+    return
+  }
+  logwrapper.Logger().Infof(
+    "Sync plan: apps added %v, apps updated %v, apps archived %v, apps restored %v, apps purged %v, roles added %v, roles removed %v, roles renamed %v, roles granted %v, users affected %v",
+    plan.AppsAdded, plan.AppsUpdated, plan.AppsArchived, plan.AppsRestored, plan.AppsPurged, plan.RolesAdded, plan.RolesRemoved, plan.RolesRenamed, plan.RolesGranted, plan.UsersAffected )
+}
+
+// maximum number of removals a sync may do without confirmation.
+// negative values disable the safeguard. invalid values must not,
+// so they fall back to the default
+func maxRemovals() int {
+  value := helpers.GetenvOrDefault( globals.CNA_APPLIST_SYNC_MAX_REMOVALS_ENV_KEY )
+  maxRemovals, err := strconv.Atoi( value )
+  if err != nil {
+    logwrapper.Logger().Errorf( "Invalid %s %q, using %s", globals.CNA_APPLIST_SYNC_MAX_REMOVALS_ENV_KEY, value, globals.DEFAULTS[globals.CNA_APPLIST_SYNC_MAX_REMOVALS_ENV_KEY] )
+    maxRemovals, _ = strconv.Atoi( globals.DEFAULTS[globals.CNA_APPLIST_SYNC_MAX_REMOVALS_ENV_KEY] )
+  }
+  return maxRemovals
+}
+
+func (plan *SyncPlan) exceedsMaxRemovals() bool {
+  max := maxRemovals()
+  return max >= 0 && plan.Removals() > max
+}
+
+func appLabel( app *models.AppModel ) string {
+  return fmt.Sprintf( "%s (%s)", app.Name, app.MountPoint )
+}
+
+func roleLabel( app *models.AppModel, roleName string ) string {
+  return app.MountPoint+"/"+roleName
+}
+
+// applies the properties of app from the installed apps index to appModel
+func updateAppModel( appModel *models.AppModel, app *storage.App, candidate *storage.AppCandidate, appManifest *manifest ) {
+  appModel.MountPoint = app.MountPoint
+  appModel.Name = app.Name
+  appModel.Secret = app.Secret
+  // version of the active candidate
+  appModel.Version = candidate.Version.Raw
+  appModel.Meta = &models.Meta{}
+  if app.Meta != nil {
+    appModel.Meta.Icon = app.Meta.Icon
+    appModel.Meta.Color = app.Meta.Color
+  }
+  appModel.AccessPolicies = accessPoliciesOf( app, candidate )
+  policy.ValidateApp( appModel, roleNamesOf( candidateRoles( candidate ) ) )
+  appModel.KeyLabels = keyLabelsOf( app )
+  appModel.RequestedGroups = appManifest.GatekeeperGroups
+  appModel.ApprovedGroups = stillRequested( appModel.ApprovedGroups, appModel.RequestedGroups )
+  appModel.RoleGrants = appManifest.roleGrantsFor( app.MountPoint )
+}
+
+// archiving and restoring is not considered a change here
+func appModelChanged( before *models.AppModel, after *models.AppModel ) bool {
+  if before.Secret != after.Secret {
+    return true
+  }
+  b := *before
+  a := *after
+  b.ArchivedAt, a.ArchivedAt = nil, nil
+  beforeJsonBytes, _ := json.Marshal( &b )
+  afterJsonBytes, _ := json.Marshal( &a )
+  return string(beforeJsonBytes) != string(afterJsonBytes)
+}
+
+// plan syncs the installed apps index in a transaction which is
+// rolled back, so the plan tells exactly what a sync would change
+func (appList *AppList) plan() (*SyncPlan, error) {
+  var plan *SyncPlan
+  err := queries.Transaction( func( q *queries.Queries ) error {
+    var err error
+    plan, err = syncIndex( q, appList.InstalledApps )
+    if err != nil {
+      return err
+    }
+    return errDryRun
+  })
+  if err != errDryRun {
+    return nil, err
+  }
+  return plan, nil
+}
+
+func containsHash( installedApps *storage.InstalledAppsIndex, hash string ) bool {
+  for _, app := range installedApps.Apps {
+    if app.GetHash() == hash {
+      return true
+    }
+  }
+  return false
+}
+
+// Plan returns the sync plan waiting for confirmation or, if there
+// is none, what a sync of the currently loaded index would change
+func (appList *AppList) Plan() (*SyncPlan, error) {
+  appList.mutex.Lock()
+  defer appList.mutex.Unlock()
+  if appList.PendingPlan != nil {
+    return appList.PendingPlan, nil
+  }
+  return appList.plan()
+}
+
+// Confirm applies a sync plan which was blocked because it
+// exceeded the maximum number of removals
+func (appList *AppList) Confirm() error {
+  appList.mutex.Lock()
+  defer appList.mutex.Unlock()
+
+  if appList.PendingPlan == nil {
+    return globals.ErrNoPendingSyncPlan
+  }
+
+  var plan *SyncPlan
+  err := queries.Transaction( func( q *queries.Queries ) error {
+    var err error
+    plan, err = syncIndex( q, appList.PendingPlan.installedApps )
+    if err != nil {
+      return err
+    }
+    if !plan.sameAs( appList.PendingPlan ) {
+      // something changed in the meantime. admin has to look at it again
+      return globals.ErrSyncPlanChanged
+    }
+    return nil
+  })
+
+  if err == globals.ErrSyncPlanChanged {
+    plan.Blocked = true
+    appList.PendingPlan = plan
+    return err
+  }
+
+  if err != nil {
+    logwrapper.Logger().Errorf( "Confirmed sync failed and was rolled back: %s", err.Error() )
+    appList.LastSyncError = err
+    return err
+  }
+
+  logwrapper.Logger().Warn( "Applied confirmed sync plan" )
+  appList.synced( plan )
+  return nil
+}
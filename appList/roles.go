@@ -0,0 +1,80 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package appList
+
+import (
+  "github.com/SatoshiPortal/cam/storage"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+)
+
+type roleRename struct {
+  from *models.RoleModel
+  to *storage.Role
+}
+
+// roleChanges describes how the roles of an app in the database have
+// to change to match the roles of its active candidate
+type roleChanges struct {
+  added []*storage.Role
+  renamed []*roleRename
+  removed []*models.RoleModel
+}
+
+func diffRoles( rolesFromDb []*models.RoleModel, roles []*storage.Role, renames map[string]string ) *roleChanges {
+  changes := new( roleChanges )
+
+  inDb := func( name string ) bool {
+    for _, roleFromDb := range rolesFromDb {
+      if roleFromDb.Name == name {
+        return true
+      }
+    }
+    return false
+  }
+
+  renamedTo := make( map[string]bool )
+
+  for _, roleFromDb := range rolesFromDb {
+    if findRole( roles, roleFromDb.Name ) != nil {
+      continue
+    }
+    newName, ok := renames[roleFromDb.Name]
+    role := findRole( roles, newName )
+    if ok && role != nil && !inDb( newName ) && !renamedTo[newName] {
+      renamedTo[newName] = true
+      changes.renamed = append( changes.renamed, &roleRename{ from: roleFromDb, to: role } )
+      continue
+    }
+    changes.removed = append( changes.removed, roleFromDb )
+  }
+
+  for _, role := range roles {
+    if !inDb( role.Name ) && !renamedTo[role.Name] {
+      changes.added = append( changes.added, role )
+    }
+  }
+
+  return changes
+}
@@ -0,0 +1,468 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package appList
+
+import (
+  camGlobals "github.com/SatoshiPortal/cam/globals"
+  "github.com/SatoshiPortal/cam/storage"
+  "github.com/SatoshiPortal/cam/version"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/sirupsen/logrus"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func openDb( t *testing.T ) func() {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "appList" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  err = dataSource.Init( "sqlite://"+filepath.Join( dir, "test.sqlite3" ) )
+  if err != nil {
+    os.RemoveAll( dir )
+    t.Fatal( err )
+  }
+  // no grants of the host this runs on
+  os.Setenv( globals.CNA_ROLE_GRANTS_FILE_ENV_KEY, filepath.Join( dir, "roleGrants.json" ) )
+  return func() {
+    os.Unsetenv( globals.CNA_ROLE_GRANTS_FILE_ENV_KEY )
+    os.Unsetenv( globals.CNA_APPLIST_SYNC_MAX_REMOVALS_ENV_KEY )
+    os.Unsetenv( globals.CNA_APPLIST_ARCHIVE_RETENTION_ENV_KEY )
+    dataSource.Close()
+    os.RemoveAll( dir )
+  }
+}
+
+func newApp( label string, roleNames ...string ) *storage.App {
+  roles := make( []*storage.Role, len(roleNames) )
+  for i, roleName := range roleNames {
+    roles[i] = &storage.Role{ Name: roleName }
+  }
+  app := &storage.App{
+    Label:      label,
+    Name:       label,
+    MountPoint: label,
+    Secret:     label+"Secret",
+    Source:     storage.NewFileSource( "file:///apps/"+label ),
+    Candidates: []*storage.AppCandidate{
+      { Version: version.NewVersion( "v1.0.0" ), AvailableRoles: roles },
+    },
+  }
+  app.BuildHash()
+  return app
+}
+
+func index( apps ...*storage.App ) *storage.InstalledAppsIndex {
+  installedApps := &storage.InstalledAppsIndex{}
+  installedApps.Apps = apps
+  return installedApps
+}
+
+func appFromDb( t *testing.T, app *storage.App ) *models.AppModel {
+  appModel, err := queries.GetAppByHash( app.GetHash() )
+  if err != nil {
+    t.Fatalf( "%s: %v", app.Label, err )
+  }
+  return appModel
+}
+
+func roleOf( t *testing.T, app *storage.App, roleName string ) *models.RoleModel {
+  for _, role := range appFromDb( t, app ).AvailableRoles {
+    if role.Name == roleName {
+      return role
+    }
+  }
+  t.Fatalf( "%s has no role %s", app.Label, roleName )
+  return nil
+}
+
+func createUser( t *testing.T, login string, roles ...*models.RoleModel ) *models.UserModel {
+  user := &models.UserModel{ Login: login, Password: "hash", Roles: roles }
+  err := queries.CreateUser( user )
+  if err != nil {
+    t.Fatal( err )
+  }
+  return user
+}
+
+func countApps( t *testing.T ) int {
+  var apps []*models.AppModel
+  err := queries.Find( &apps, nil, "", -1, 0, false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  return len(apps)
+}
+
+func sameStrings( a []string, b ...string ) bool {
+  if len(a) != len(b) {
+    return false
+  }
+  for i := range a {
+    if a[i] != b[i] {
+      return false
+    }
+  }
+  return true
+}
+
+func TestPlan(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  shop, blog := newApp( "shop", "customer", "admin" ), newApp( "blog", "reader" )
+  appList := &AppList{ InstalledApps: index( shop, blog ) }
+
+  plan, err := appList.Plan()
+  if err != nil {
+    t.Fatal( err )
+  }
+  if !sameStrings( plan.AppsAdded, "shop (shop)", "blog (blog)" ) ||
+    !sameStrings( plan.RolesAdded, "shop/customer", "shop/admin", "blog/reader" ) {
+    t.Errorf( "unexpected plan %+v", plan )
+  }
+  if countApps( t ) != 0 {
+    t.Fatal( "plans must not change the database" )
+  }
+
+  err = appList.syncToDb( appList.InstalledApps, false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if countApps( t ) != 2 || appList.LastSuccessfulSync.IsZero() {
+    t.Fatal( "expected the planned apps to be synced" )
+  }
+
+  plan, err = appList.Plan()
+  if err != nil || !plan.IsEmpty() {
+    t.Errorf( "expected an empty plan after the sync, got %+v %v", plan, err )
+  }
+
+  createUser( t, "alice", roleOf( t, blog, "reader" ) )
+  bob := createUser( t, "bob", roleOf( t, shop, "customer" ) )
+
+  // grants become applicable once the granted role exists
+  err = ioutil.WriteFile( os.Getenv( globals.CNA_ROLE_GRANTS_FILE_ENV_KEY ), []byte(`[{"whenRole":"shop/customer","grant":"wiki/editor"}]`), 0600 )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  // manifests which can't be read are reported
+  brokenDir, err := ioutil.TempDir( "", "wiki" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer os.RemoveAll( brokenDir )
+  err = ioutil.WriteFile( filepath.Join( brokenDir, "app.json" ), []byte(`{`), 0600 )
+  if err != nil {
+    t.Fatal( err )
+  }
+  wiki := newApp( "wiki", "editor" )
+  wiki.Path = brokenDir
+
+  plan, err = syncPlanOf( appList, index( shop, wiki ) )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if !sameStrings( plan.AppsAdded, "wiki (wiki)" ) || !sameStrings( plan.AppsArchived, "blog (blog)" ) ||
+    !sameStrings( plan.RolesGranted, "wiki/editor to bob" ) || !sameStrings( plan.UsersAffected, "alice" ) {
+    t.Errorf( "unexpected plan %+v", plan )
+  }
+  if len(plan.Problems) != 1 {
+    t.Errorf( "expected the broken manifest of wiki to be reported, got %v", plan.Problems )
+  }
+  if countApps( t ) != 2 {
+    t.Fatal( "plans must not change the database" )
+  }
+
+  // the sync does what was planned
+  err = appList.syncToDb( index( shop, wiki ), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if !appFromDb( t, blog ).IsArchived() {
+    t.Error( "expected blog to be archived" )
+  }
+  roles, err := queries.RolesOfUserInApp( bob.ID, appFromDb( t, wiki ).ID )
+  if err != nil || len(roles) != 1 || roles[0].Name != "editor" {
+    t.Errorf( "expected bob to be granted wiki/editor, got %v %v", roles, err )
+  }
+}
+
+// syncPlanOf plans a sync of installedApps without committing to it
+func syncPlanOf( appList *AppList, installedApps *storage.InstalledAppsIndex ) (*SyncPlan, error) {
+  previousInstalledApps := appList.InstalledApps
+  defer func() { appList.InstalledApps = previousInstalledApps }()
+  appList.InstalledApps = installedApps
+  return appList.plan()
+}
+
+func TestSafeguard(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  shop, blog := newApp( "shop", "customer" ), newApp( "blog", "reader" )
+  synced := index( shop, blog )
+  appList := &AppList{ InstalledApps: index() }
+
+  os.Setenv( globals.CNA_APPLIST_SYNC_MAX_REMOVALS_ENV_KEY, "0" )
+
+  err := appList.syncToDb( synced, false )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  createUser( t, "alice", roleOf( t, blog, "reader" ) )
+
+  err = appList.syncToDb( index( shop ), false )
+  if err != globals.ErrSyncBlocked {
+    t.Fatalf( "expected %v, got %v", globals.ErrSyncBlocked, err )
+  }
+  if appList.InstalledApps != synced {
+    t.Error( "blocked indexes must not replace the synced one" )
+  }
+  if appFromDb( t, blog ).IsArchived() {
+    t.Error( "blocked syncs must not change the database" )
+  }
+  if !appList.Health().SyncBlocked {
+    t.Error( "blocked syncs must be reported by the health" )
+  }
+
+  plan, err := appList.Plan()
+  if err != nil || plan != appList.PendingPlan || !plan.Blocked || !sameStrings( plan.AppsArchived, "blog (blog)" ) {
+    t.Errorf( "expected the blocked plan, got %+v %v", plan, err )
+  }
+
+  err = appList.Confirm()
+  if err != nil {
+    t.Fatal( err )
+  }
+  if !appFromDb( t, blog ).IsArchived() || appList.PendingPlan != nil || len(appList.InstalledApps.Apps) != 1 {
+    t.Error( "confirmed plans must be applied" )
+  }
+
+  if err := appList.Confirm(); err != globals.ErrNoPendingSyncPlan {
+    t.Errorf( "expected %v, got %v", globals.ErrNoPendingSyncPlan, err )
+  }
+}
+
+func TestConfirmChangedPlan(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  shop, blog := newApp( "shop", "customer" ), newApp( "blog", "reader" )
+  appList := &AppList{ InstalledApps: index() }
+
+  os.Setenv( globals.CNA_APPLIST_SYNC_MAX_REMOVALS_ENV_KEY, "0" )
+
+  err := appList.syncToDb( index( shop, blog ), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  err = appList.syncToDb( index( shop ), false )
+  if err != globals.ErrSyncBlocked {
+    t.Fatalf( "expected %v, got %v", globals.ErrSyncBlocked, err )
+  }
+
+  // the plan would affect alice now, which the admin did not see
+  createUser( t, "alice", roleOf( t, blog, "reader" ) )
+
+  err = appList.Confirm()
+  if err != globals.ErrSyncPlanChanged {
+    t.Fatalf( "expected %v, got %v", globals.ErrSyncPlanChanged, err )
+  }
+  if appFromDb( t, blog ).IsArchived() {
+    t.Error( "changed plans must not be applied" )
+  }
+  if !appList.PendingPlan.Blocked || !sameStrings( appList.PendingPlan.UsersAffected, "alice" ) {
+    t.Errorf( "expected the changed plan to wait for confirmation, got %+v", appList.PendingPlan )
+  }
+
+  err = appList.Confirm()
+  if err != nil || !appFromDb( t, blog ).IsArchived() {
+    t.Errorf( "expected the changed plan to be applied once confirmed, got %v", err )
+  }
+}
+
+func TestSameAs(t *testing.T) {
+  a, b := newSyncPlan(), newSyncPlan()
+  a.AppsArchived = append( a.AppsArchived, "blog (blog)" )
+  b.AppsArchived = append( b.AppsArchived, "blog (blog)" )
+  b.Blocked = true
+  b.CreatedAt = a.CreatedAt.Add( 1 )
+  b.apps = []*models.AppModel{ {} }
+
+  if !a.sameAs( b ) {
+    t.Error( "plans with the same changes must be the same" )
+  }
+
+  b.UsersAffected = append( b.UsersAffected, "alice" )
+  if a.sameAs( b ) {
+    t.Error( "plans affecting other users must differ" )
+  }
+}
+
+func TestMaxRemovals(t *testing.T) {
+  defer os.Unsetenv( globals.CNA_APPLIST_SYNC_MAX_REMOVALS_ENV_KEY )
+
+  os.Setenv( globals.CNA_APPLIST_SYNC_MAX_REMOVALS_ENV_KEY, "3" )
+  if maxRemovals() != 3 {
+    t.Errorf( "expected 3, got %d", maxRemovals() )
+  }
+
+  os.Setenv( globals.CNA_APPLIST_SYNC_MAX_REMOVALS_ENV_KEY, "three" )
+  if maxRemovals() != -1 {
+    t.Errorf( "invalid values must fall back to the default, got %d", maxRemovals() )
+  }
+
+  plan := newSyncPlan()
+  plan.AppsArchived = append( plan.AppsArchived, "blog (blog)" )
+  plan.RolesRemoved = append( plan.RolesRemoved, "blog/reader", "blog/writer" )
+  os.Setenv( globals.CNA_APPLIST_SYNC_MAX_REMOVALS_ENV_KEY, "2" )
+  if plan.Removals() != 3 || !plan.exceedsMaxRemovals() {
+    t.Error( "expected 3 removals to exceed the maximum of 2" )
+  }
+}
+
+func roleNames( roles []*models.RoleModel ) []string {
+  names := make( []string, len(roles) )
+  for i, role := range roles {
+    names[i] = role.Name
+  }
+  return names
+}
+
+func TestSyncRollback(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  shop, blog := newApp( "shop", "customer", "admin" ), newApp( "blog", "reader" )
+  synced := index( shop, blog )
+  appList := &AppList{ InstalledApps: index() }
+
+  err := appList.syncToDb( synced, false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  alice := createUser( t, "alice", roleOf( t, blog, "reader" ) )
+  bob := createUser( t, "bob", roleOf( t, shop, "admin" ) )
+
+  // role grants are applied last, so everything else was already
+  // changed when the sync fails
+  err = ioutil.WriteFile( os.Getenv( globals.CNA_ROLE_GRANTS_FILE_ENV_KEY ), []byte(`[{`), 0600 )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  err = appList.syncToDb( index( newApp( "shop", "customer", "operator" ), newApp( "wiki", "editor" ) ), false )
+  if err == nil {
+    t.Fatal( "expected the sync to fail" )
+  }
+  if appList.InstalledApps != synced || appList.LastSyncError == nil || appList.Health().IsHealthy() {
+    t.Error( "failed syncs must keep the synced index and be reported" )
+  }
+
+  if countApps( t ) != 2 {
+    t.Error( "apps created by the failed sync must be rolled back" )
+  }
+  if appFromDb( t, blog ).IsArchived() {
+    t.Error( "apps archived by the failed sync must be restored" )
+  }
+  if names := roleNames( appFromDb( t, shop ).AvailableRoles ); !sameStrings( names, "customer", "admin" ) {
+    t.Errorf( "roles changed by the failed sync must be rolled back, got %v", names )
+  }
+
+  for _, assignment := range []struct{
+    user *models.UserModel
+    app *storage.App
+    role string
+  }{
+    { alice, blog, "reader" },
+    { bob, shop, "admin" },
+  } {
+    roles, err := queries.RolesOfUserInApp( assignment.user.ID, appFromDb( t, assignment.app ).ID )
+    if err != nil || !sameStrings( roleNames( roles ), assignment.role ) {
+      t.Errorf( "%s must keep %s/%s, got %v %v", assignment.user.Login, assignment.app.Label, assignment.role, roleNames( roles ), err )
+    }
+  }
+}
+
+func TestApprovalsOfGroupsNoLongerRequested(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  dir, err := ioutil.TempDir( "", "appList" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer os.RemoveAll( dir )
+
+  writeManifest := func( manifestJson string ) {
+    err := ioutil.WriteFile( filepath.Join( dir, camGlobals.APP_DESCRIPTION_FILE ), []byte(manifestJson), 0644 )
+    if err != nil {
+      t.Fatal( err )
+    }
+  }
+
+  shop := newApp( "shop", "customer" )
+  shop.Path = dir
+  appList := &AppList{}
+
+  writeManifest( `{"gatekeeperGroups":["stats","spender"]}` )
+  err = appList.syncToDb( index( shop ), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  err = queries.ApproveAppGroups( appFromDb( t, shop ), []string{ "stats", "spender" } )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  writeManifest( `{"gatekeeperGroups":["stats"]}` )
+  err = appList.syncToDb( index( shop ), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  appModel := appFromDb( t, shop )
+  if !sameStrings( appModel.RequestedGroups, "stats" ) || !sameStrings( appModel.ApprovedGroups, "stats" ) {
+    t.Errorf( "expected only the approval of stats to be kept, got %v approved of %v", appModel.ApprovedGroups, appModel.RequestedGroups )
+  }
+
+  writeManifest( `{}` )
+  err = appList.syncToDb( index( shop ), false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if appModel := appFromDb( t, shop ); len(appModel.ApprovedGroups) != 0 {
+    t.Errorf( "expected all approvals to be dropped, got %v", appModel.ApprovedGroups )
+  }
+}
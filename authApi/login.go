@@ -0,0 +1,226 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package authApi
+
+import (
+  "errors"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/helpers"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/loginThrottle"
+  "github.com/schulterklopfer/cyphernode_fauth/mfa"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "math"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+)
+
+type loginRequest struct {
+  Login    string `json:"login" form:"login"`
+  Password string `json:"password" form:"password"`
+  // totp code or recovery code of users with totp
+  Otp      string `json:"otp" form:"otp"`
+  // assertion of users with webauthn credentials
+  Webauthn *webauthnAssertion `json:"webauthn" form:"-"`
+}
+
+type LoginResponse struct {
+  Token     string    `json:"token"`
+  ExpiresAt time.Time `json:"expiresAt"`
+  Amr       []string  `json:"amr"`
+  // mfa is required for admins, but the user has not enrolled totp
+  // or webauthn yet. admin rights are only granted after enrolment
+  MfaEnrollmentRequired bool `json:"mfaEnrollmentRequired,omitempty"`
+}
+
+// MfaRequiredResponse is sent with unauthorized, if the password of
+// a user with a second factor was right, but the second factor was
+// not given. Methods are the second factors of the user. Webauthn is
+// the ceremony to answer, if the user has webauthn credentials
+type MfaRequiredResponse struct {
+  MfaRequired bool                      `json:"mfaRequired"`
+  Methods     []string                  `json:"methods"`
+  Webauthn    *WebauthnRequestChallenge `json:"webauthn,omitempty"`
+}
+
+// Login checks the credentials of a user and responds with a session
+// token, which is set as session cookie too. Users with totp or
+// webauthn credentials need a one time password or a webauthn
+// assertion as well. Failed logins are throttled per login and per
+// source ip
+func Login( c *gin.Context ) {
+  var request loginRequest
+  err := c.ShouldBind( &request )
+
+  if err != nil || request.Login == "" || request.Password == "" {
+    c.AbortWithStatus(http.StatusBadRequest)
+    return
+  }
+
+  ip := loginThrottle.ClientIP( c.Request )
+  retryAfter, err := loginThrottle.Check( request.Login, ip )
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  if retryAfter > 0 {
+    c.Header("Retry-After", strconv.Itoa( int(math.Ceil( retryAfter.Seconds() )) ) )
+    c.Header("X-Status-Reason", globals.ErrLoginThrottled.Error() )
+    c.AbortWithStatus(http.StatusTooManyRequests)
+    return
+  }
+
+  user, err := queries.Authenticate( request.Login, request.Password )
+
+  if err == globals.ErrInvalidCredentials {
+    loginFailed( c, request.Login, ip, err )
+    return
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  amr := []string{ forwardAuth.AMR_PASSWORD }
+
+  methods, err := mfa.Methods( user.ID )
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  enrolled := len(methods) > 0
+
+  switch {
+  case !enrolled:
+  case request.Otp != "" && hasMethod( methods, mfa.METHOD_TOTP ):
+    err = mfa.Verify( user, request.Otp )
+
+    if err == globals.ErrInvalidOtp {
+      loginFailed( c, request.Login, ip, err )
+      return
+    }
+
+    if err != nil {
+      c.Header("X-Status-Reason", err.Error() )
+      c.AbortWithStatus(http.StatusInternalServerError)
+      return
+    }
+
+    amr = append( amr, forwardAuth.AMR_OTP, forwardAuth.AMR_MFA )
+  case request.Webauthn != nil && request.Webauthn.Credential != nil && hasMethod( methods, mfa.METHOD_WEBAUTHN ):
+    err = mfa.VerifyWebauthn( user, request.Webauthn.CeremonyId, request.Webauthn.Credential )
+
+    if errors.Is( err, globals.ErrInvalidWebauthnResponse ) {
+      loginFailed( c, request.Login, ip, err )
+      return
+    }
+
+    if err != nil {
+      c.Header("X-Status-Reason", err.Error() )
+      c.AbortWithStatus(http.StatusInternalServerError)
+      return
+    }
+
+    amr = append( amr, forwardAuth.AMR_HARDWARE_KEY, forwardAuth.AMR_MFA )
+  default:
+    mfaRequired( c, user, methods )
+    return
+  }
+
+  err = loginThrottle.Success( request.Login, ip )
+  if err != nil {
+    logwrapper.Logger().Errorf( "failed to reset failed logins: %s", err.Error() )
+  }
+
+  response, err := startSession( c, user, amr )
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  if !enrolled && mfa.RequiredForAdmins() {
+    response.MfaEnrollmentRequired, err = queries.IsAdminUser( user.ID )
+    if err != nil {
+      logwrapper.Logger().Errorf( "failed to check admin role: %s", err.Error() )
+    }
+  }
+
+  c.JSON( http.StatusOK, response )
+}
+
+// loginFailed counts a failed login and responds with unauthorized
+func loginFailed( c *gin.Context, login string, ip string, reason error ) {
+  err := loginThrottle.Failure( login, ip )
+  if err != nil {
+    logwrapper.Logger().Errorf( "failed to count failed login: %s", err.Error() )
+  }
+  c.Header("X-Status-Reason", reason.Error() )
+  c.AbortWithStatus(http.StatusUnauthorized)
+}
+
+// startSession mints a session token of user and sets it as session
+// cookie
+func startSession( c *gin.Context, user *models.UserModel, amr []string ) (*LoginResponse, error) {
+  lifetime, err := time.ParseDuration( helpers.GetenvOrDefault( globals.CNA_SESSION_LIFETIME_ENV_KEY ) )
+  if err != nil {
+    return nil, err
+  }
+
+  token, expiresAt, err := forwardAuth.MintSessionToken( user, lifetime, amr )
+  if err != nil {
+    return nil, err
+  }
+
+  setSessionCookie( c, token, lifetime )
+
+  return &LoginResponse{ Token: token, ExpiresAt: expiresAt, Amr: amr }, nil
+}
+
+func setSessionCookie( c *gin.Context, token string, lifetime time.Duration ) {
+  c.SetSameSite( http.SameSiteLaxMode )
+  c.SetCookie(
+    helpers.GetenvOrDefault( globals.CNA_SESSION_COOKIE_NAME_ENV_KEY ),
+    token,
+    int(lifetime.Seconds()),
+    "/",
+    helpers.GetenvOrDefault( globals.OIDC_SSO_COOKIE_DOMAIN_ENV_KEY ),
+    strings.HasPrefix( helpers.GetenvOrDefault( globals.BASE_URL_EXTERNAL_ENV_KEY ), "https://" ),
+    true )
+}
@@ -0,0 +1,263 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package authApi_test
+
+import (
+  "encoding/json"
+  "github.com/dgrijalva/jwt-go"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/authApi"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/loginThrottle"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/password"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/schulterklopfer/cyphernode_fauth/totp"
+  "github.com/sirupsen/logrus"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+)
+
+func openDb( t *testing.T ) func() {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  gin.SetMode( gin.TestMode )
+  dir, err := ioutil.TempDir( "", "authApi" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  err = dataSource.Init( "sqlite://"+filepath.Join( dir, "test.sqlite3" ) )
+  if err != nil {
+    os.RemoveAll( dir )
+    t.Fatal( err )
+  }
+  return func() {
+    dataSource.Close()
+    os.RemoveAll( dir )
+  }
+}
+
+func createUser( t *testing.T, login string, plainPassword string ) *models.UserModel {
+  hashedPassword, err := password.HashPassword( plainPassword )
+  if err != nil {
+    t.Fatal( err )
+  }
+  user := &models.UserModel{ Login: login, Password: hashedPassword }
+  err = queries.CreateUser( user )
+  if err != nil {
+    t.Fatal( err )
+  }
+  return user
+}
+
+func login( engine *gin.Engine, body string ) *httptest.ResponseRecorder {
+  request := httptest.NewRequest( "POST", globals.AUTH_ENDPOINTS_LOGIN, strings.NewReader( body ) )
+  request.Header.Set( "Content-Type", "application/json" )
+  request.RemoteAddr = "10.0.0.1:1234"
+  recorder := httptest.NewRecorder()
+  engine.ServeHTTP( recorder, request )
+  return recorder
+}
+
+func post( engine *gin.Engine, path string, token string, body string ) *httptest.ResponseRecorder {
+  request := httptest.NewRequest( "POST", path, strings.NewReader( body ) )
+  request.Header.Set( "Content-Type", "application/json" )
+  request.Header.Set( "Authorization", "Bearer "+token )
+  recorder := httptest.NewRecorder()
+  engine.ServeHTTP( recorder, request )
+  return recorder
+}
+
+func TestLogin(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  loginThrottle.Configure( loginThrottle.Config{
+    Login:           loginThrottle.Limits{ FreeFailures: 1, LockoutFailures: 3 },
+    Ip:              loginThrottle.Limits{ FreeFailures: 10, LockoutFailures: 20 },
+    BackoffBase:     time.Minute,
+    LockoutDuration: time.Hour,
+    FailureWindow:   time.Hour,
+  })
+  defer loginThrottle.Configure( loginThrottle.DefaultConfig )
+
+  user := createUser( t, "alice", "correct horse" )
+
+  engine := gin.New()
+  engine.POST( globals.AUTH_ENDPOINTS_LOGIN, authApi.Login )
+
+  response := login( engine, `{"login":"alice","password":"correct horse"}` )
+  if response.Code != http.StatusOK {
+    t.Fatalf( "expected %d, got %d", http.StatusOK, response.Code )
+  }
+
+  var loginResponse authApi.LoginResponse
+  err := json.Unmarshal( response.Body.Bytes(), &loginResponse )
+  if err != nil {
+    t.Fatal( err )
+  }
+  token, err := jwt.Parse( loginResponse.Token, func( token *jwt.Token ) (interface{}, error) {
+    return []byte(globals.DEFAULTS[globals.CNA_COOKIE_SECRET_ENV_KEY]), nil
+  })
+  if err != nil || token.Claims.(jwt.MapClaims)["id"] != float64(user.ID) {
+    t.Errorf( "expected a valid session token of alice, got %v", err )
+  }
+  if !strings.Contains( response.Header().Get( "Set-Cookie" ), globals.DEFAULTS[globals.CNA_SESSION_COOKIE_NAME_ENV_KEY]+"="+loginResponse.Token ) {
+    t.Error( "session cookie not set" )
+  }
+
+  if login( engine, `{"login":"alice"}` ).Code != http.StatusBadRequest {
+    t.Error( "logins without password must fail" )
+  }
+
+  response = login( engine, `{"login":"alice","password":"wrong horse"}` )
+  if response.Code != http.StatusUnauthorized {
+    t.Errorf( "expected %d, got %d", http.StatusUnauthorized, response.Code )
+  }
+
+  response = login( engine, `{"login":"alice","password":"wrong horse"}` )
+  if response.Code != http.StatusUnauthorized {
+    t.Errorf( "expected %d, got %d", http.StatusUnauthorized, response.Code )
+  }
+
+  response = login( engine, `{"login":"alice","password":"correct horse"}` )
+  if response.Code != http.StatusTooManyRequests || response.Header().Get( "Retry-After" ) != "60" {
+    t.Errorf( "expected %d with a retry after 60s, got %d %s", http.StatusTooManyRequests, response.Code, response.Header().Get( "Retry-After" ) )
+  }
+
+  response = login( engine, `{"login":"nobody","password":"correct horse"}` )
+  if response.Code != http.StatusUnauthorized {
+    t.Errorf( "unknown logins must fail with %d, got %d", http.StatusUnauthorized, response.Code )
+  }
+}
+
+func TestLoginThrottledIp(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  loginThrottle.Configure( loginThrottle.Config{
+    Login:           loginThrottle.Limits{ FreeFailures: 10, LockoutFailures: 20 },
+    Ip:              loginThrottle.Limits{ FreeFailures: 1, LockoutFailures: 3 },
+    BackoffBase:     time.Minute,
+    LockoutDuration: time.Hour,
+    FailureWindow:   time.Hour,
+  })
+  defer loginThrottle.Configure( loginThrottle.DefaultConfig )
+
+  createUser( t, "alice", "correct horse" )
+
+  engine := gin.New()
+  engine.POST( globals.AUTH_ENDPOINTS_LOGIN, authApi.Login )
+
+  spoofed := func( forwardedFor string, body string ) int {
+    request := httptest.NewRequest( "POST", globals.AUTH_ENDPOINTS_LOGIN, strings.NewReader( body ) )
+    request.Header.Set( "Content-Type", "application/json" )
+    request.Header.Set( "X-Forwarded-For", forwardedFor )
+    request.Header.Set( "X-Real-Ip", forwardedFor )
+    request.RemoteAddr = "10.0.0.1:1234"
+    recorder := httptest.NewRecorder()
+    engine.ServeHTTP( recorder, request )
+    return recorder.Code
+  }
+
+  if code := spoofed( "1.1.1.1", `{"login":"alice","password":"wrong horse"}` ); code != http.StatusUnauthorized {
+    t.Fatalf( "expected %d, got %d", http.StatusUnauthorized, code )
+  }
+  if code := spoofed( "2.2.2.2", `{"login":"bob","password":"wrong horse"}` ); code != http.StatusUnauthorized {
+    t.Fatalf( "expected %d, got %d", http.StatusUnauthorized, code )
+  }
+  if code := spoofed( "3.3.3.3", `{"login":"alice","password":"correct horse"}` ); code != http.StatusTooManyRequests {
+    t.Errorf( "spoofed forwarded headers must not reset the failures of the ip, got %d", code )
+  }
+}
+
+func TestLoginWithTotp(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  createUser( t, "alice", "correct horse" )
+
+  engine := gin.New()
+  engine.POST( globals.AUTH_ENDPOINTS_LOGIN, authApi.Login )
+  engine.POST( globals.AUTH_ENDPOINTS_TOTP, forwardAuth.RequireUser, authApi.EnrollTotp )
+  engine.POST( globals.AUTH_ENDPOINTS_TOTP_CONFIRM, forwardAuth.RequireUser, authApi.ConfirmTotp )
+
+  var session authApi.LoginResponse
+  response := login( engine, `{"login":"alice","password":"correct horse"}` )
+  _ = json.Unmarshal( response.Body.Bytes(), &session )
+  if response.Code != http.StatusOK || len(session.Amr) != 1 || session.Amr[0] != forwardAuth.AMR_PASSWORD {
+    t.Fatalf( "expected a password session, got %d %v", response.Code, session.Amr )
+  }
+
+  response = post( engine, globals.AUTH_ENDPOINTS_TOTP, session.Token, "" )
+  var enrollment struct{ Secret string }
+  _ = json.Unmarshal( response.Body.Bytes(), &enrollment )
+  if response.Code != http.StatusOK || enrollment.Secret == "" {
+    t.Fatalf( "expected a totp secret, got %d", response.Code )
+  }
+
+  if post( engine, globals.AUTH_ENDPOINTS_TOTP, "", "" ).Code != http.StatusUnauthorized {
+    t.Error( "enrolment needs a session" )
+  }
+
+  code, _ := totp.Code( enrollment.Secret, totp.Step( time.Now() ) )
+  response = post( engine, globals.AUTH_ENDPOINTS_TOTP_CONFIRM, session.Token, `{"code":"`+code+`"}` )
+  var confirmation struct {
+    RecoveryCodes []string               `json:"recoveryCodes"`
+    Session       *authApi.LoginResponse `json:"session"`
+  }
+  _ = json.Unmarshal( response.Body.Bytes(), &confirmation )
+  if response.Code != http.StatusOK || len(confirmation.RecoveryCodes) == 0 {
+    t.Fatalf( "expected recovery codes, got %d", response.Code )
+  }
+  if confirmation.Session != nil || response.Header().Get( "Set-Cookie" ) != "" {
+    t.Error( "confirming totp must not upgrade the session" )
+  }
+
+  response = login( engine, `{"login":"alice","password":"correct horse"}` )
+  if response.Code != http.StatusUnauthorized || !strings.Contains( response.Body.String(), `"mfaRequired":true` ) {
+    t.Errorf( "expected a one time password to be required, got %d %s", response.Code, response.Body.String() )
+  }
+
+  response = login( engine, `{"login":"alice","password":"correct horse","otp":"000000"}` )
+  if response.Code != http.StatusUnauthorized || response.Header().Get( "X-Status-Reason" ) != globals.ErrInvalidOtp.Error() {
+    t.Errorf( "expected an invalid one time password, got %d", response.Code )
+  }
+
+  response = login( engine, `{"login":"alice","password":"correct horse","otp":"`+confirmation.RecoveryCodes[0]+`"}` )
+  session = authApi.LoginResponse{}
+  _ = json.Unmarshal( response.Body.Bytes(), &session )
+  if response.Code != http.StatusOK || len(session.Amr) != 3 || session.Amr[2] != forwardAuth.AMR_MFA {
+    t.Errorf( "expected a mfa session with a recovery code, got %d %v", response.Code, session.Amr )
+  }
+}
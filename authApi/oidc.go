@@ -0,0 +1,182 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package authApi
+
+import (
+  "errors"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/helpers"
+  "github.com/schulterklopfer/cyphernode_fauth/oidc"
+  "net/http"
+  "net/url"
+)
+
+// OidcDiscovery responds with the provider metadata
+func OidcDiscovery( c *gin.Context ) {
+  c.JSON( http.StatusOK, oidc.Discovery() )
+}
+
+// OidcJwks responds with the keys tokens are signed with
+func OidcJwks( c *gin.Context ) {
+  c.JSON( http.StatusOK, oidc.Jwks() )
+}
+
+// OidcAuthorize redirects users with a session back to the app with
+// an authorization code. Users without a session are sent to the
+// login first, which redirects back here
+func OidcAuthorize( c *gin.Context ) {
+  var request oidc.AuthorizationRequest
+  err := c.ShouldBind( &request )
+
+  if err != nil {
+    c.AbortWithStatus(http.StatusBadRequest)
+    return
+  }
+
+  // errors about client and redirect uri must not be redirected
+  _, err = oidc.Client( request.ClientId, request.RedirectUri )
+
+  if err == oidc.ErrUnknownClient || err == oidc.ErrInvalidRedirectUri {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusBadRequest)
+    return
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  if requestErr := request.Check(); requestErr != nil {
+    c.Redirect( http.StatusFound, request.ErrorRedirect( requestErr ) )
+    return
+  }
+
+  session, err := forwardAuth.CurrentSession( c )
+
+  if err == globals.ErrNoSession {
+    if request.Prompt == oidc.PROMPT_NONE {
+      c.Redirect( http.StatusFound, request.ErrorRedirect( &oidc.Error{ Code: oidc.ERROR_LOGIN_REQUIRED } ) )
+      return
+    }
+    loginUrl := helpers.AbsoluteURL( globals.UNAUTHORIZED_REDIRECT_URL )
+    c.Redirect( http.StatusFound, loginUrl+"?redirect="+url.QueryEscape( request.Url() ) )
+    return
+  }
+
+  if err != nil {
+    c.Redirect( http.StatusFound, request.ErrorRedirect( &oidc.Error{ Code: oidc.ERROR_SERVER_ERROR } ) )
+    return
+  }
+
+  code, err := oidc.IssueCode( &request, session.User.ID, session.Amr, session.AuthTime )
+
+  if err != nil {
+    c.Redirect( http.StatusFound, request.ErrorRedirect( &oidc.Error{ Code: oidc.ERROR_SERVER_ERROR } ) )
+    return
+  }
+
+  c.Redirect( http.StatusFound, request.CodeRedirect( code ) )
+}
+
+// OidcToken exchanges an authorization code for an id token and an
+// access token. Clients authenticate with basic auth or with their
+// id and secret in the form
+func OidcToken( c *gin.Context ) {
+  var request oidc.TokenRequest
+  err := c.ShouldBind( &request )
+
+  if err != nil {
+    oidcError( c, http.StatusBadRequest, &oidc.Error{ Code: oidc.ERROR_INVALID_REQUEST } )
+    return
+  }
+
+  if clientId, clientSecret, ok := c.Request.BasicAuth(); ok {
+    // url encoded as of RFC 6749 2.3.1
+    request.ClientId, _ = url.QueryUnescape( clientId )
+    request.ClientSecret, _ = url.QueryUnescape( clientSecret )
+  }
+
+  tokens, err := oidc.Exchange( &request )
+
+  var exchangeErr *oidc.Error
+  if errors.As( err, &exchangeErr ) {
+    status := http.StatusBadRequest
+    if exchangeErr.Code == oidc.ERROR_INVALID_CLIENT {
+      c.Header("WWW-Authenticate", `Basic realm="oidc"`)
+      status = http.StatusUnauthorized
+    }
+    oidcError( c, status, exchangeErr )
+    return
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    oidcError( c, http.StatusInternalServerError, &oidc.Error{ Code: oidc.ERROR_SERVER_ERROR } )
+    return
+  }
+
+  c.Header("Cache-Control", "no-store")
+  c.Header("Pragma", "no-cache")
+  c.JSON( http.StatusOK, tokens )
+}
+
+// OidcUserInfo responds with the claims of the user of the access
+// token
+func OidcUserInfo( c *gin.Context ) {
+  accessToken := helpers.TokenFromBearerAuthHeader( c.Request.Header.Get("authorization") )
+
+  if accessToken == "" {
+    c.Header("WWW-Authenticate", `Bearer realm="oidc"`)
+    c.AbortWithStatus(http.StatusUnauthorized)
+    return
+  }
+
+  claims, err := oidc.UserInfo( accessToken )
+
+  var userInfoErr *oidc.Error
+  if errors.As( err, &userInfoErr ) {
+    c.Header("WWW-Authenticate", `Bearer realm="oidc", error="`+userInfoErr.Code+`"`)
+    oidcError( c, http.StatusUnauthorized, userInfoErr )
+    return
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  c.JSON( http.StatusOK, claims )
+}
+
+// oidcError responds with an error body of RFC 6749
+func oidcError( c *gin.Context, status int, err *oidc.Error ) {
+  c.Header("Cache-Control", "no-store")
+  c.AbortWithStatusJSON( status, err )
+}
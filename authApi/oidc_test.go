@@ -0,0 +1,175 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package authApi_test
+
+import (
+  "crypto/rand"
+  "crypto/rsa"
+  "encoding/json"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/authApi"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/oidc"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "strings"
+  "testing"
+)
+
+func authorize( engine *gin.Engine, token string, query url.Values ) *httptest.ResponseRecorder {
+  request := httptest.NewRequest( "GET", globals.AUTH_ENDPOINTS_OIDC+globals.OIDC_ENDPOINTS_AUTHORIZE+"?"+query.Encode(), nil )
+  if token != "" {
+    request.Header.Set( "Authorization", "Bearer "+token )
+  }
+  recorder := httptest.NewRecorder()
+  engine.ServeHTTP( recorder, request )
+  return recorder
+}
+
+func TestOidc(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  key, err := rsa.GenerateKey( rand.Reader, 2048 )
+  if err != nil {
+    t.Fatal( err )
+  }
+  oidc.UseSigningKey( key )
+  oidc.Configure( oidc.DefaultConfig )
+
+  app := &models.AppModel{ Name: "app", Hash: "appHash", Secret: "app secret", MountPoint: "app",
+    AvailableRoles: []*models.RoleModel{ { Name: "reader" } } }
+  if err := queries.CreateApp( app ); err != nil {
+    t.Fatal( err )
+  }
+  createUser( t, "alice", "correct horse" )
+
+  engine := gin.New()
+  engine.POST( globals.AUTH_ENDPOINTS_LOGIN, authApi.Login )
+  oidcGroup := engine.Group( globals.AUTH_ENDPOINTS_OIDC )
+  oidcGroup.GET( globals.OIDC_ENDPOINTS_DISCOVERY, authApi.OidcDiscovery )
+  oidcGroup.GET( globals.OIDC_ENDPOINTS_AUTHORIZE, authApi.OidcAuthorize )
+  oidcGroup.POST( globals.OIDC_ENDPOINTS_TOKEN, authApi.OidcToken )
+  oidcGroup.GET( globals.OIDC_ENDPOINTS_USERINFO, authApi.OidcUserInfo )
+
+  redirectUri := "http://www.cna.localhost:3030/app/callback"
+  query := url.Values{
+    "response_type":         { "code" },
+    "client_id":             { "appHash" },
+    "redirect_uri":          { redirectUri },
+    "scope":                 { "openid profile" },
+    "state":                 { "state" },
+    "code_challenge":        { "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" },
+    "code_challenge_method": { "S256" },
+  }
+
+  response := authorize( engine, "", query )
+  location, _ := url.Parse( response.Header().Get( "Location" ) )
+  if response.Code != http.StatusFound || location == nil || location.Query().Get( "redirect" ) == "" {
+    t.Fatalf( "expected a redirect to the login, got %d %s", response.Code, response.Header().Get( "Location" ) )
+  }
+
+  query.Set( "prompt", "none" )
+  response = authorize( engine, "", query )
+  location, _ = url.Parse( response.Header().Get( "Location" ) )
+  if response.Code != http.StatusFound || location == nil ||
+    location.Query().Get( "error" ) != oidc.ERROR_LOGIN_REQUIRED || location.Query().Get( "state" ) != "state" {
+    t.Fatalf( "expected %s, got %d %s", oidc.ERROR_LOGIN_REQUIRED, response.Code, response.Header().Get( "Location" ) )
+  }
+  query.Del( "prompt" )
+
+  query.Set( "redirect_uri", "http://evil.com/app/callback" )
+  response = authorize( engine, "", query )
+  if response.Code != http.StatusBadRequest || response.Header().Get( "Location" ) != "" {
+    t.Fatalf( "foreign redirect uris must not be redirected to, got %d", response.Code )
+  }
+  query.Set( "redirect_uri", redirectUri )
+
+  var session authApi.LoginResponse
+  response = login( engine, `{"login":"alice","password":"correct horse"}` )
+  _ = json.Unmarshal( response.Body.Bytes(), &session )
+
+  response = authorize( engine, session.Token, query )
+  location, _ = url.Parse( response.Header().Get( "Location" ) )
+  if response.Code != http.StatusFound || location == nil || !strings.HasPrefix( location.String(), redirectUri ) ||
+    location.Query().Get( "code" ) == "" || location.Query().Get( "state" ) != "state" {
+    t.Fatalf( "expected a code, got %d %s", response.Code, response.Header().Get( "Location" ) )
+  }
+
+  form := url.Values{
+    "grant_type":    { "authorization_code" },
+    "code":          { location.Query().Get( "code" ) },
+    "redirect_uri":  { redirectUri },
+    "code_verifier": { "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk" },
+  }
+  unauthenticated := url.Values{ "client_id": { "appHash" }, "client_secret": { "" } }
+  for name, values := range form {
+    unauthenticated[name] = values
+  }
+  request := httptest.NewRequest( "POST", globals.AUTH_ENDPOINTS_OIDC+globals.OIDC_ENDPOINTS_TOKEN, strings.NewReader( unauthenticated.Encode() ) )
+  request.Header.Set( "Content-Type", "application/x-www-form-urlencoded" )
+  response = httptest.NewRecorder()
+  engine.ServeHTTP( response, request )
+  if response.Code != http.StatusUnauthorized || response.Header().Get( "WWW-Authenticate" ) == "" {
+    t.Fatalf( "empty client secrets must be refused, got %d", response.Code )
+  }
+
+  request = httptest.NewRequest( "POST", globals.AUTH_ENDPOINTS_OIDC+globals.OIDC_ENDPOINTS_TOKEN, strings.NewReader( form.Encode() ) )
+  request.Header.Set( "Content-Type", "application/x-www-form-urlencoded" )
+  request.SetBasicAuth( "appHash", url.QueryEscape( "app secret" ) )
+  response = httptest.NewRecorder()
+  engine.ServeHTTP( response, request )
+
+  var tokens oidc.TokenResponse
+  _ = json.Unmarshal( response.Body.Bytes(), &tokens )
+  if response.Code != http.StatusOK || tokens.IdToken == "" || tokens.AccessToken == "" {
+    t.Fatalf( "expected tokens, got %d %s", response.Code, response.Body.String() )
+  }
+  if response.Header().Get( "Cache-Control" ) != "no-store" {
+    t.Errorf( "token responses must not be cached" )
+  }
+
+  request = httptest.NewRequest( "GET", globals.AUTH_ENDPOINTS_OIDC+globals.OIDC_ENDPOINTS_USERINFO, nil )
+  request.Header.Set( "Authorization", "Bearer "+tokens.AccessToken )
+  response = httptest.NewRecorder()
+  engine.ServeHTTP( response, request )
+
+  var userInfo map[string]interface{}
+  _ = json.Unmarshal( response.Body.Bytes(), &userInfo )
+  if response.Code != http.StatusOK || userInfo["preferred_username"] != "alice" {
+    t.Fatalf( "expected the user info of alice, got %d %s", response.Code, response.Body.String() )
+  }
+
+  request = httptest.NewRequest( "GET", globals.AUTH_ENDPOINTS_OIDC+globals.OIDC_ENDPOINTS_USERINFO, nil )
+  request.Header.Set( "Authorization", "Bearer "+tokens.IdToken )
+  response = httptest.NewRecorder()
+  engine.ServeHTTP( response, request )
+  if response.Code != http.StatusUnauthorized || response.Header().Get( "WWW-Authenticate" ) == "" {
+    t.Fatalf( "expected %d, got %d", http.StatusUnauthorized, response.Code )
+  }
+}
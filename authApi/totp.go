@@ -0,0 +1,149 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package authApi
+
+import (
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/mfa"
+  "net/http"
+)
+
+type otpRequest struct {
+  Code string `json:"code" form:"code"`
+}
+
+type RecoveryCodes struct {
+  RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// EnrollTotp creates a totp secret for the user of the session and
+// responds with its provisioning uri. It has to be confirmed with
+// ConfirmTotp before it is used
+func EnrollTotp( c *gin.Context ) {
+  enrollment, err := mfa.Enroll( forwardAuth.UserFromContext( c ) )
+
+  if err == globals.ErrTotpAlreadyEnrolled {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusConflict)
+    return
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  c.JSON( http.StatusOK, enrollment )
+}
+
+// ConfirmTotp activates the totp secret of the user of the session
+// and responds with recovery codes. The session stays as it is.
+// Sessions authenticated with both factors need a login with a code
+func ConfirmTotp( c *gin.Context ) {
+  code, ok := bindOtp( c )
+  if !ok {
+    return
+  }
+
+  user := forwardAuth.UserFromContext( c )
+  recoveryCodes, err := mfa.Confirm( user, code )
+
+  if !handleMfaError( c, err ) {
+    return
+  }
+
+  c.JSON( http.StatusOK, &RecoveryCodes{ RecoveryCodes: recoveryCodes } )
+}
+
+// DisableTotp removes totp of the user of the session after checking
+// a totp or recovery code
+func DisableTotp( c *gin.Context ) {
+  code, ok := bindOtp( c )
+  if !ok {
+    return
+  }
+
+  err := mfa.Disable( forwardAuth.UserFromContext( c ), code )
+
+  if !handleMfaError( c, err ) {
+    return
+  }
+
+  c.Status(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user of
+// the session after checking a totp or recovery code
+func RegenerateRecoveryCodes( c *gin.Context ) {
+  code, ok := bindOtp( c )
+  if !ok {
+    return
+  }
+
+  recoveryCodes, err := mfa.RegenerateRecoveryCodes( forwardAuth.UserFromContext( c ), code )
+
+  if !handleMfaError( c, err ) {
+    return
+  }
+
+  c.JSON( http.StatusOK, &RecoveryCodes{ RecoveryCodes: recoveryCodes } )
+}
+
+func bindOtp( c *gin.Context ) (string, bool) {
+  var request otpRequest
+  err := c.ShouldBind( &request )
+
+  if err != nil || request.Code == "" {
+    c.AbortWithStatus(http.StatusBadRequest)
+    return "", false
+  }
+
+  return request.Code, true
+}
+
+// handleMfaError responds with the status of err. It tells if there
+// was no error
+func handleMfaError( c *gin.Context, err error ) bool {
+  switch err {
+  case nil:
+    return true
+  case globals.ErrInvalidOtp:
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusUnauthorized)
+  case globals.ErrTotpNotEnrolled:
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusNotFound)
+  case globals.ErrTotpAlreadyEnrolled:
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusConflict)
+  default:
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+  }
+  return false
+}
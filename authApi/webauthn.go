@@ -0,0 +1,261 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package authApi
+
+import (
+  "errors"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/loginThrottle"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/mfa"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/schulterklopfer/cyphernode_fauth/webauthn"
+  "github.com/sirupsen/logrus"
+  "math"
+  "net/http"
+  "strconv"
+)
+
+// WebauthnCreationChallenge starts a registration. PublicKey is
+// passed to navigator.credentials.create
+type WebauthnCreationChallenge struct {
+  CeremonyId string                    `json:"ceremonyId"`
+  PublicKey  *webauthn.CreationOptions `json:"publicKey"`
+}
+
+// WebauthnRequestChallenge starts an authentication. PublicKey is
+// passed to navigator.credentials.get
+type WebauthnRequestChallenge struct {
+  CeremonyId string                   `json:"ceremonyId"`
+  PublicKey  *webauthn.RequestOptions `json:"publicKey"`
+}
+
+type webauthnRegistrationRequest struct {
+  CeremonyId string                         `json:"ceremonyId"`
+  Name       string                         `json:"name"`
+  Credential *webauthn.RegistrationResponse `json:"credential"`
+}
+
+// webauthnAssertion is the answer to a WebauthnRequestChallenge
+type webauthnAssertion struct {
+  CeremonyId string                           `json:"ceremonyId"`
+  Credential *webauthn.AuthenticationResponse `json:"credential"`
+}
+
+// BeginWebauthnRegistration starts registering a webauthn credential
+// of the user of the session
+func BeginWebauthnRegistration( c *gin.Context ) {
+  ceremonyId, options, err := mfa.BeginWebauthnRegistration( forwardAuth.UserFromContext( c ) )
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  c.JSON( http.StatusOK, &WebauthnCreationChallenge{ CeremonyId: ceremonyId, PublicKey: options } )
+}
+
+// FinishWebauthnRegistration stores the credential the authenticator
+// created and responds with it. The session stays as it is, since
+// whoever holds it registered the credential. Sessions authenticated
+// with the credential need a login with an assertion of it
+func FinishWebauthnRegistration( c *gin.Context ) {
+  var request webauthnRegistrationRequest
+  err := c.ShouldBindJSON( &request )
+
+  if err != nil || request.CeremonyId == "" || request.Credential == nil || len(request.Name) > 100 {
+    c.AbortWithStatus(http.StatusBadRequest)
+    return
+  }
+
+  user := forwardAuth.UserFromContext( c )
+  credential, err := mfa.FinishWebauthnRegistration( user, request.CeremonyId, request.Name, request.Credential )
+
+  if errors.Is( err, globals.ErrInvalidWebauthnResponse ) {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusBadRequest)
+    return
+  }
+
+  if err == globals.ErrWebauthnCredentialRegistered {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusConflict)
+    return
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  c.JSON( http.StatusOK, credential )
+}
+
+// GetWebauthnCredentials lists the webauthn credentials of the user
+// of the session
+func GetWebauthnCredentials( c *gin.Context ) {
+  credentials, err := queries.WebauthnCredentialsOfUser( forwardAuth.UserFromContext( c ).ID )
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  c.JSON( http.StatusOK, credentials )
+}
+
+// DeleteWebauthnCredential removes a webauthn credential of the user
+// of the session
+func DeleteWebauthnCredential( c *gin.Context ) {
+  credentialId, err := strconv.Atoi( c.Param("credentialId") )
+
+  if err != nil || credentialId <= 0 {
+    c.AbortWithStatus(http.StatusBadRequest)
+    return
+  }
+
+  err = mfa.DeleteWebauthnCredential( forwardAuth.UserFromContext( c ), uint(credentialId) )
+
+  if errors.Is( err, globals.ErrNotFound ) {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusNotFound)
+    return
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  c.Status(http.StatusNoContent)
+}
+
+// BeginWebauthnLogin starts a passwordless login with a passkey.
+// Source ips throttled because of failed logins are refused
+func BeginWebauthnLogin( c *gin.Context ) {
+  retryAfter, err := loginThrottle.Check( "", loginThrottle.ClientIP( c.Request ) )
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  if retryAfter > 0 {
+    c.Header("Retry-After", strconv.Itoa( int(math.Ceil( retryAfter.Seconds() )) ) )
+    c.Header("X-Status-Reason", globals.ErrLoginThrottled.Error() )
+    c.AbortWithStatus(http.StatusTooManyRequests)
+    return
+  }
+
+  ceremonyId, options, err := mfa.BeginWebauthnLogin()
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  c.JSON( http.StatusOK, &WebauthnRequestChallenge{ CeremonyId: ceremonyId, PublicKey: options } )
+}
+
+// FinishWebauthnLogin checks the passkey of a passwordless login and
+// responds with a session token, which is set as session cookie too.
+// The authenticator verified the user, so the session counts as
+// authenticated with more than one factor. Signatures can't be
+// guessed, but failures count for the source ip, so clients sending
+// garbage can't keep starting ceremonies
+func FinishWebauthnLogin( c *gin.Context ) {
+  var request webauthnAssertion
+  err := c.ShouldBindJSON( &request )
+
+  if err != nil || request.CeremonyId == "" || request.Credential == nil {
+    c.AbortWithStatus(http.StatusBadRequest)
+    return
+  }
+
+  user, err := mfa.FinishWebauthnLogin( request.CeremonyId, request.Credential )
+
+  if errors.Is( err, globals.ErrInvalidWebauthnResponse ) {
+    loginFailed( c, "", loginThrottle.ClientIP( c.Request ), err )
+    return
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  logwrapper.Audit( logwrapper.AUDIT_LOGIN_SUCCEEDED, logrus.Fields{ "login": user.Login, "ip": loginThrottle.ClientIP( c.Request ), "method": mfa.METHOD_WEBAUTHN } )
+
+  response, err := startSession( c, user, []string{ forwardAuth.AMR_HARDWARE_KEY, forwardAuth.AMR_MFA } )
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  c.JSON( http.StatusOK, response )
+}
+
+// mfaRequired responds with unauthorized and the second factors user
+// enrolled. With webauthn a ceremony is started, whose assertion is
+// sent with the repeated login
+func mfaRequired( c *gin.Context, user *models.UserModel, methods []string ) {
+  response := &MfaRequiredResponse{ MfaRequired: true, Methods: methods }
+
+  if hasMethod( methods, mfa.METHOD_WEBAUTHN ) {
+    ceremonyId, options, err := mfa.BeginWebauthnVerification( user )
+
+    if err != nil {
+      c.Header("X-Status-Reason", err.Error() )
+      c.AbortWithStatus(http.StatusInternalServerError)
+      return
+    }
+
+    response.Webauthn = &WebauthnRequestChallenge{ CeremonyId: ceremonyId, PublicKey: options }
+  }
+
+  c.Header("X-Status-Reason", globals.ErrMfaRequired.Error() )
+  c.AbortWithStatusJSON(http.StatusUnauthorized, response )
+}
+
+func hasMethod( methods []string, method string ) bool {
+  for _, other := range methods {
+    if other == method {
+      return true
+    }
+  }
+  return false
+}
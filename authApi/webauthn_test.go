@@ -0,0 +1,191 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package authApi_test
+
+import (
+  "encoding/json"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/authApi"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/loginThrottle"
+  "github.com/schulterklopfer/cyphernode_fauth/mfa"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/test_helpers"
+  "github.com/schulterklopfer/cyphernode_fauth/webauthn"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+)
+
+func toJson( t *testing.T, value interface{} ) string {
+  encoded, err := json.Marshal( value )
+  if err != nil {
+    t.Fatal( err )
+  }
+  return string(encoded)
+}
+
+func TestWebauthn(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  createUser( t, "alice", "correct horse" )
+  authenticator := test_helpers.NewSoftwareAuthenticator( webauthn.DefaultConfig.Origin )
+
+  engine := gin.New()
+  engine.POST( globals.AUTH_ENDPOINTS_LOGIN, authApi.Login )
+  engine.POST( globals.AUTH_ENDPOINTS_WEBAUTHN_REGISTER, forwardAuth.RequireUser, authApi.BeginWebauthnRegistration )
+  engine.POST( globals.AUTH_ENDPOINTS_WEBAUTHN_REGISTER_FINISH, forwardAuth.RequireUser, authApi.FinishWebauthnRegistration )
+  engine.POST( globals.AUTH_ENDPOINTS_WEBAUTHN_LOGIN, authApi.BeginWebauthnLogin )
+  engine.POST( globals.AUTH_ENDPOINTS_WEBAUTHN_LOGIN_FINISH, authApi.FinishWebauthnLogin )
+
+  var session authApi.LoginResponse
+  response := login( engine, `{"login":"alice","password":"correct horse"}` )
+  _ = json.Unmarshal( response.Body.Bytes(), &session )
+  if response.Code != http.StatusOK {
+    t.Fatalf( "expected %d, got %d", http.StatusOK, response.Code )
+  }
+
+  var creation authApi.WebauthnCreationChallenge
+  response = post( engine, globals.AUTH_ENDPOINTS_WEBAUTHN_REGISTER, session.Token, "" )
+  _ = json.Unmarshal( response.Body.Bytes(), &creation )
+  if response.Code != http.StatusOK || creation.CeremonyId == "" || creation.PublicKey == nil {
+    t.Fatalf( "expected a registration ceremony, got %d", response.Code )
+  }
+
+  credential, err := authenticator.Create( creation.PublicKey )
+  if err != nil {
+    t.Fatal( err )
+  }
+  body := toJson( t, map[string]interface{}{ "ceremonyId": creation.CeremonyId, "name": "yubikey", "credential": credential } )
+  var registration struct {
+    models.WebauthnCredentialModel
+    Session *authApi.LoginResponse `json:"session"`
+  }
+  response = post( engine, globals.AUTH_ENDPOINTS_WEBAUTHN_REGISTER_FINISH, session.Token, body )
+  _ = json.Unmarshal( response.Body.Bytes(), &registration )
+  if response.Code != http.StatusOK || registration.Name != "yubikey" {
+    t.Fatalf( "expected a registered credential, got %d %s", response.Code, response.Header().Get( "X-Status-Reason" ) )
+  }
+  if registration.Session != nil || strings.Contains( response.Header().Get( "Set-Cookie" ), globals.DEFAULTS[globals.CNA_SESSION_COOKIE_NAME_ENV_KEY] ) {
+    t.Error( "registering a credential must not upgrade the session" )
+  }
+
+  if post( engine, globals.AUTH_ENDPOINTS_WEBAUTHN_REGISTER_FINISH, session.Token, body ).Code != http.StatusBadRequest {
+    t.Error( "registration ceremonies must be finished only once" )
+  }
+
+  var mfaRequired authApi.MfaRequiredResponse
+  response = login( engine, `{"login":"alice","password":"correct horse"}` )
+  _ = json.Unmarshal( response.Body.Bytes(), &mfaRequired )
+  if response.Code != http.StatusUnauthorized || !mfaRequired.MfaRequired || len(mfaRequired.Methods) != 1 ||
+    mfaRequired.Methods[0] != mfa.METHOD_WEBAUTHN || mfaRequired.Webauthn == nil {
+    t.Fatalf( "expected a webauthn ceremony, got %d %s", response.Code, response.Body.String() )
+  }
+
+  assertion, err := authenticator.Get( mfaRequired.Webauthn.PublicKey )
+  if err != nil {
+    t.Fatal( err )
+  }
+  body = `{"login":"alice","password":"correct horse","webauthn":`+toJson( t, map[string]interface{}{ "ceremonyId": mfaRequired.Webauthn.CeremonyId, "credential": assertion } )+`}`
+  session = authApi.LoginResponse{}
+  response = login( engine, body )
+  _ = json.Unmarshal( response.Body.Bytes(), &session )
+  if response.Code != http.StatusOK || strings.Join( session.Amr, " " ) != "pwd hwk mfa" {
+    t.Errorf( "expected a mfa session, got %d %v", response.Code, session.Amr )
+  }
+
+  if login( engine, body ).Code != http.StatusUnauthorized {
+    t.Error( "assertions must not be replayed" )
+  }
+
+  var request authApi.WebauthnRequestChallenge
+  response = post( engine, globals.AUTH_ENDPOINTS_WEBAUTHN_LOGIN, "", "" )
+  _ = json.Unmarshal( response.Body.Bytes(), &request )
+  if response.Code != http.StatusOK || len(request.PublicKey.AllowCredentials) != 0 {
+    t.Fatalf( "expected a passkey ceremony, got %d", response.Code )
+  }
+
+  assertion, err = authenticator.Get( request.PublicKey )
+  if err != nil {
+    t.Fatal( err )
+  }
+  body = toJson( t, map[string]interface{}{ "ceremonyId": request.CeremonyId, "credential": assertion } )
+  session = authApi.LoginResponse{}
+  response = post( engine, globals.AUTH_ENDPOINTS_WEBAUTHN_LOGIN_FINISH, "", body )
+  _ = json.Unmarshal( response.Body.Bytes(), &session )
+  if response.Code != http.StatusOK || strings.Join( session.Amr, " " ) != "hwk mfa" {
+    t.Errorf( "expected a passwordless mfa session, got %d %v", response.Code, session.Amr )
+  }
+
+  if post( engine, globals.AUTH_ENDPOINTS_WEBAUTHN_LOGIN_FINISH, "", body ).Code != http.StatusUnauthorized {
+    t.Error( "passkey assertions must not be replayed" )
+  }
+}
+
+func TestWebauthnLoginThrottled(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  loginThrottle.Configure( loginThrottle.Config{
+    Login:           loginThrottle.Limits{ FreeFailures: 10, LockoutFailures: 20 },
+    Ip:              loginThrottle.Limits{ FreeFailures: 1, LockoutFailures: 3 },
+    BackoffBase:     time.Minute,
+    LockoutDuration: time.Hour,
+    FailureWindow:   time.Hour,
+  })
+  defer loginThrottle.Configure( loginThrottle.DefaultConfig )
+
+  engine := gin.New()
+  engine.POST( globals.AUTH_ENDPOINTS_WEBAUTHN_LOGIN, authApi.BeginWebauthnLogin )
+  engine.POST( globals.AUTH_ENDPOINTS_WEBAUTHN_LOGIN_FINISH, authApi.FinishWebauthnLogin )
+
+  send := func( path string, body string ) int {
+    request := httptest.NewRequest( "POST", path, strings.NewReader( body ) )
+    request.Header.Set( "Content-Type", "application/json" )
+    request.RemoteAddr = "10.0.0.1:1234"
+    recorder := httptest.NewRecorder()
+    engine.ServeHTTP( recorder, request )
+    return recorder.Code
+  }
+
+  if code := send( globals.AUTH_ENDPOINTS_WEBAUTHN_LOGIN, "" ); code != http.StatusOK {
+    t.Fatalf( "expected %d, got %d", http.StatusOK, code )
+  }
+
+  garbage := `{"ceremonyId":"unknown","credential":{"id":"AAAA","rawId":"AAAA","type":"public-key","response":{}}}`
+  for i := 0; i < 2; i++ {
+    if code := send( globals.AUTH_ENDPOINTS_WEBAUTHN_LOGIN_FINISH, garbage ); code != http.StatusUnauthorized {
+      t.Fatalf( "expected %d, got %d", http.StatusUnauthorized, code )
+    }
+  }
+
+  if code := send( globals.AUTH_ENDPOINTS_WEBAUTHN_LOGIN, "" ); code != http.StatusTooManyRequests {
+    t.Errorf( "ips with failed passkey logins must be throttled, got %d", code )
+  }
+}
@@ -0,0 +1,278 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package backup
+
+import (
+  "archive/tar"
+  "bytes"
+  "compress/gzip"
+  "crypto/sha256"
+  "encoding/hex"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "github.com/schulterklopfer/cyphernode_fauth/migrations"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "gorm.io/gorm"
+  "io"
+  "io/ioutil"
+  "sort"
+  "time"
+)
+
+// version of the archive layout. bump it when the layout or the
+// data file changes in a way older versions can't read
+const FORMAT_VERSION = 1
+
+const MANIFEST_FILE = "manifest.json"
+const DATA_FILE = "data.json"
+const KEYS_FILE = "keys.properties"
+const ACTIONS_FILE = "api.properties"
+
+var ErrUnsupportedFormat = errors.New( "backup has an unsupported format version" )
+var ErrChecksumMismatch = errors.New( "backup is corrupted or was modified" )
+var ErrNewerSchema = errors.New( "backup was made with a newer database schema" )
+var ErrSchemaNotMigrated = errors.New( "database schema is not up to date" )
+var ErrNoKeys = errors.New( "backup contains no keys and actions files" )
+
+// Manifest describes a backup archive. It is the first file of the
+// archive and holds the sha256 of every other file. Checksum covers
+// the manifest itself, so no part of the archive can be changed
+// without being noticed
+type Manifest struct {
+  FormatVersion int               `json:"formatVersion"`
+  SchemaVersion int               `json:"schemaVersion"`
+  CreatedAt     time.Time         `json:"createdAt"`
+  Files         map[string]string `json:"files"`
+  Checksum      string            `json:"checksum"`
+}
+
+// Options name the keys and actions files of the gatekeeper, which
+// are only backed up and restored if WithKeys is set
+type Options struct {
+  WithKeys    bool
+  KeysFile    string
+  ActionsFile string
+}
+
+type assignment struct {
+  UserId uint `json:"userId"`
+  RoleId uint `json:"roleId"`
+}
+
+// data is everything stored in the database but login throttles.
+// app secrets, totp secrets and recovery code hashes are not part
+// of their models' json, so they are kept by id
+type data struct {
+  Users               []*models.UserModel               `json:"users"`
+  Apps                []*models.AppModel                `json:"apps"`
+  AppSecrets          map[uint]string                   `json:"appSecrets"`
+  Roles               []*models.RoleModel               `json:"roles"`
+  Assignments         []*assignment                     `json:"assignments"`
+  Totps               []*models.TotpModel               `json:"totps"`
+  TotpSecrets         map[uint]string                   `json:"totpSecrets"`
+  RecoveryCodes       []*models.RecoveryCodeModel       `json:"recoveryCodes"`
+  RecoveryCodeHashes  map[uint]string                   `json:"recoveryCodeHashes"`
+  WebauthnCredentials []*models.WebauthnCredentialModel `json:"webauthnCredentials"`
+}
+
+func (manifest *Manifest) checksum() string {
+  names := make( []string, 0, len(manifest.Files) )
+  for name := range manifest.Files {
+    names = append( names, name )
+  }
+  sort.Strings( names )
+
+  hash := sha256.New()
+  fmt.Fprintf( hash, "%d:%d:%s\n", manifest.FormatVersion, manifest.SchemaVersion, manifest.CreatedAt.UTC().Format( time.RFC3339Nano ) )
+  for _, name := range names {
+    fmt.Fprintf( hash, "%s:%s\n", name, manifest.Files[name] )
+  }
+  return hex.EncodeToString( hash.Sum( nil ) )
+}
+
+func fileChecksum( content []byte ) string {
+  sum := sha256.Sum256( content )
+  return hex.EncodeToString( sum[:] )
+}
+
+// Write writes a gzipped tar archive of the database and, if
+// options.WithKeys is set, of the keys and actions files to writer.
+// Soft deleted rows and password hashes are included, so the
+// archive has to be kept as safe as the database itself
+func Write( db *gorm.DB, writer io.Writer, options *Options ) (*Manifest, error) {
+  schemaVersion, err := migrations.Current( db )
+  if err != nil {
+    return nil, err
+  }
+
+  dump, err := load( db )
+  if err != nil {
+    return nil, err
+  }
+
+  dataJson, err := json.Marshal( dump )
+  if err != nil {
+    return nil, err
+  }
+
+  files := map[string][]byte{ DATA_FILE: dataJson }
+
+  if options != nil && options.WithKeys {
+    for name, path := range map[string]string{ KEYS_FILE: options.KeysFile, ACTIONS_FILE: options.ActionsFile } {
+      content, err := ioutil.ReadFile( path )
+      if err != nil {
+        return nil, err
+      }
+      files[name] = content
+    }
+  }
+
+  manifest := &Manifest{
+    FormatVersion: FORMAT_VERSION,
+    SchemaVersion: schemaVersion,
+    CreatedAt:     time.Now().UTC(),
+    Files:         make( map[string]string ),
+  }
+  for name, content := range files {
+    manifest.Files[name] = fileChecksum( content )
+  }
+  manifest.Checksum = manifest.checksum()
+
+  manifestJson, err := json.MarshalIndent( manifest, "", "  " )
+  if err != nil {
+    return nil, err
+  }
+
+  var archive bytes.Buffer
+  err = writeArchive( &archive, manifestJson, files, manifest.CreatedAt )
+  if err != nil {
+    return nil, err
+  }
+
+  _, err = writer.Write( archive.Bytes() )
+  if err != nil {
+    return nil, err
+  }
+  return manifest, nil
+}
+
+// load reads all rows, deleted ones too, in one transaction, so
+// they are consistent with each other
+func load( db *gorm.DB ) (*data, error) {
+  dump := &data{
+    AppSecrets:         make( map[uint]string ),
+    TotpSecrets:        make( map[uint]string ),
+    RecoveryCodeHashes: make( map[uint]string ),
+  }
+
+  err := db.Transaction( func( tx *gorm.DB ) error {
+    err := tx.Unscoped().Order( "id" ).Find( &dump.Users ).Error
+    if err != nil {
+      return err
+    }
+    err = tx.Unscoped().Order( "id" ).Find( &dump.Apps ).Error
+    if err != nil {
+      return err
+    }
+    err = tx.Unscoped().Order( "id" ).Find( &dump.Roles ).Error
+    if err != nil {
+      return err
+    }
+    err = tx.Order( "id" ).Find( &dump.Totps ).Error
+    if err != nil {
+      return err
+    }
+    err = tx.Order( "id" ).Find( &dump.RecoveryCodes ).Error
+    if err != nil {
+      return err
+    }
+    err = tx.Order( "id" ).Find( &dump.WebauthnCredentials ).Error
+    if err != nil {
+      return err
+    }
+    return tx.Table( "user_roles" ).
+      Select( "user_model_id AS user_id, role_model_id AS role_id" ).
+      Order( "user_model_id, role_model_id" ).
+      Scan( &dump.Assignments ).Error
+  })
+
+  if err != nil {
+    return nil, err
+  }
+
+  for _, app := range dump.Apps {
+    dump.AppSecrets[app.ID] = app.Secret
+  }
+  for _, totp := range dump.Totps {
+    dump.TotpSecrets[totp.ID] = totp.Secret
+  }
+  for _, code := range dump.RecoveryCodes {
+    dump.RecoveryCodeHashes[code.ID] = code.CodeHash
+  }
+  return dump, nil
+}
+
+func writeArchive( writer io.Writer, manifestJson []byte, files map[string][]byte, modTime time.Time ) error {
+  gzipWriter := gzip.NewWriter( writer )
+  tarWriter := tar.NewWriter( gzipWriter )
+
+  names := make( []string, 0, len(files) )
+  for name := range files {
+    names = append( names, name )
+  }
+  sort.Strings( names )
+
+  write := func( name string, content []byte ) error {
+    err := tarWriter.WriteHeader( &tar.Header{
+      Name:    name,
+      Mode:    0600,
+      Size:    int64(len(content)),
+      ModTime: modTime,
+    })
+    if err != nil {
+      return err
+    }
+    _, err = tarWriter.Write( content )
+    return err
+  }
+
+  err := write( MANIFEST_FILE, manifestJson )
+  if err != nil {
+    return err
+  }
+  for _, name := range names {
+    err = write( name, files[name] )
+    if err != nil {
+      return err
+    }
+  }
+
+  err = tarWriter.Close()
+  if err != nil {
+    return err
+  }
+  return gzipWriter.Close()
+}
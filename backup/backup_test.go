@@ -0,0 +1,228 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package backup
+
+import (
+  "archive/tar"
+  "bytes"
+  "compress/gzip"
+  "encoding/json"
+  "errors"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/migrations"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/sirupsen/logrus"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+)
+
+func openDb( t *testing.T, dir string, name string ) {
+  err := dataSource.Init( "sqlite://"+filepath.Join( dir, name ) )
+  if err != nil {
+    t.Fatal( err )
+  }
+}
+
+// rewrite changes the files of an archive without fixing checksums
+func rewrite( t *testing.T, archive []byte, change func( files map[string][]byte ) ) []byte {
+  gzipReader, err := gzip.NewReader( bytes.NewReader( archive ) )
+  if err != nil {
+    t.Fatal( err )
+  }
+  tarReader := tar.NewReader( gzipReader )
+  files := make( map[string][]byte )
+  for {
+    header, err := tarReader.Next()
+    if err != nil {
+      break
+    }
+    content, _ := ioutil.ReadAll( tarReader )
+    files[header.Name] = content
+  }
+
+  change( files )
+
+  manifestJson := files[MANIFEST_FILE]
+  delete( files, MANIFEST_FILE )
+  var out bytes.Buffer
+  err = writeArchive( &out, manifestJson, files, time.Now() )
+  if err != nil {
+    t.Fatal( err )
+  }
+  return out.Bytes()
+}
+
+func TestBackupAndRestore(t *testing.T) {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "backup" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer os.RemoveAll( dir )
+
+  options := &Options{
+    WithKeys:    true,
+    KeysFile:    filepath.Join( dir, "keys.properties" ),
+    ActionsFile: filepath.Join( dir, "api.properties" ),
+  }
+  ioutil.WriteFile( options.KeysFile, []byte("kapi_id=\"000\";kapi_key=\"aaaa\"\n"), 0600 )
+  ioutil.WriteFile( options.ActionsFile, []byte("action_getinfo=stats\n"), 0600 )
+
+  openDb( t, dir, "source.sqlite3" )
+
+  app := &models.AppModel{
+    Name: "app", Hash: "appHash", Secret: "appSecret", MountPoint: "app",
+    AvailableRoles: []*models.RoleModel{ { Name: "reader" }, { Name: "writer" } },
+  }
+  if err := queries.CreateApp( app ); err != nil {
+    t.Fatal( err )
+  }
+  alice := &models.UserModel{ Login: "alice", Password: "hash", Roles: app.AvailableRoles[:1] }
+  bob := &models.UserModel{ Login: "bob", Password: "hash" }
+  for _, user := range []*models.UserModel{ alice, bob } {
+    if err := queries.CreateUser( user ); err != nil {
+      t.Fatal( err )
+    }
+  }
+  if err := dataSource.GetDB().Delete( bob ).Error; err != nil {
+    t.Fatal( err )
+  }
+  if err := queries.SaveTotp( &models.TotpModel{ UserId: alice.ID, Secret: "totpSecret", Confirmed: true } ); err != nil {
+    t.Fatal( err )
+  }
+  if err := queries.ReplaceRecoveryCodes( alice.ID, []string{ "codeHash" } ); err != nil {
+    t.Fatal( err )
+  }
+  if err := queries.CreateWebauthnCredential( &models.WebauthnCredentialModel{ UserId: alice.ID, CredentialId: "credentialId", PublicKey: []byte{ 1, 2, 3 }, SignCount: 7 } ); err != nil {
+    t.Fatal( err )
+  }
+
+  var archive bytes.Buffer
+  manifest, err := Write( dataSource.GetDB(), &archive, options )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if manifest.SchemaVersion != migrations.Latest() || len(manifest.Files) != 3 {
+    t.Errorf( "unexpected manifest %v", manifest )
+  }
+  dataSource.Close()
+
+  os.Remove( options.KeysFile )
+  os.Remove( options.ActionsFile )
+
+  openDb( t, dir, "target.sqlite3" )
+  defer dataSource.Close()
+
+  other := &models.UserModel{ Login: "other", Password: "hash" }
+  if err := queries.CreateUser( other ); err != nil {
+    t.Fatal( err )
+  }
+
+  _, err = Restore( dataSource.GetDB(), bytes.NewReader( archive.Bytes() ), options )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  var restoredApp models.AppModel
+  if err := queries.Get( &restoredApp, app.ID, true ); err != nil {
+    t.Fatal( err )
+  }
+  if restoredApp.Secret != "appSecret" || len(restoredApp.AvailableRoles) != 2 {
+    t.Errorf( "unexpected restored app %v", restoredApp )
+  }
+
+  var users []*models.UserModel
+  if err := queries.Find( &users, nil, "id", -1, 0, true ); err != nil {
+    t.Fatal( err )
+  }
+  if len(users) != 1 || users[0].Login != "alice" || len(users[0].Roles) != 1 || users[0].Roles[0].Name != "reader" {
+    t.Errorf( "expected alice with her role only, got %v", users )
+  }
+
+  var deleted []*models.UserModel
+  dataSource.GetDB().Unscoped().Where( "deleted_at IS NOT NULL" ).Find( &deleted )
+  if len(deleted) != 1 || deleted[0].Login != "bob" {
+    t.Errorf( "expected deleted bob to be restored, got %v", deleted )
+  }
+
+  totp, err := queries.TotpOfUser( alice.ID )
+  if err != nil || totp.Secret != "totpSecret" || !totp.Confirmed {
+    t.Errorf( "totp of alice not restored: %v, %v", totp, err )
+  }
+  used, err := queries.UseRecoveryCode( alice.ID, "codeHash" )
+  if err != nil || !used {
+    t.Errorf( "recovery code of alice not restored: %v", err )
+  }
+  credential, err := queries.WebauthnCredential( "credentialId" )
+  if err != nil || credential.UserId != alice.ID || !bytes.Equal( credential.PublicKey, []byte{ 1, 2, 3 } ) || credential.SignCount != 7 {
+    t.Errorf( "webauthn credential of alice not restored: %v, %v", credential, err )
+  }
+
+  keys, err := ioutil.ReadFile( options.KeysFile )
+  if err != nil || string(keys) != "kapi_id=\"000\";kapi_key=\"aaaa\"\n" {
+    t.Errorf( "keys file not restored: %s, %v", keys, err )
+  }
+
+  // new rows continue after the restored ids
+  carol := &models.UserModel{ Login: "carol", Password: "hash" }
+  if err := queries.CreateUser( carol ); err != nil {
+    t.Fatal( err )
+  }
+
+  tampered := rewrite( t, archive.Bytes(), func( files map[string][]byte ) {
+    files[DATA_FILE] = bytes.Replace( files[DATA_FILE], []byte("alice"), []byte("mallory"), 1 )
+  })
+  _, err = Restore( dataSource.GetDB(), bytes.NewReader( tampered ), options )
+  if !errors.Is( err, ErrChecksumMismatch ) {
+    t.Errorf( "expected %v, got %v", ErrChecksumMismatch, err )
+  }
+
+  newer := rewrite( t, archive.Bytes(), func( files map[string][]byte ) {
+    var m Manifest
+    json.Unmarshal( files[MANIFEST_FILE], &m )
+    m.SchemaVersion = migrations.Latest()+1
+    m.Checksum = m.checksum()
+    files[MANIFEST_FILE], _ = json.Marshal( &m )
+  })
+  _, err = Restore( dataSource.GetDB(), bytes.NewReader( newer ), options )
+  if !errors.Is( err, ErrNewerSchema ) {
+    t.Errorf( "expected %v, got %v", ErrNewerSchema, err )
+  }
+
+  var withoutKeys bytes.Buffer
+  _, err = Write( dataSource.GetDB(), &withoutKeys, nil )
+  if err != nil {
+    t.Fatal( err )
+  }
+  _, err = Restore( dataSource.GetDB(), bytes.NewReader( withoutKeys.Bytes() ), options )
+  if err != ErrNoKeys {
+    t.Errorf( "expected %v, got %v", ErrNoKeys, err )
+  }
+}
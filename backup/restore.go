@@ -0,0 +1,242 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package backup
+
+import (
+  "archive/tar"
+  "compress/gzip"
+  "encoding/json"
+  "fmt"
+  "github.com/schulterklopfer/cyphernode_fauth/migrations"
+  "gorm.io/gorm"
+  "gorm.io/gorm/clause"
+  "io"
+  "io/ioutil"
+)
+
+// tables in the order their rows are deleted
+var tables = []string{ "webauthn_credential_models", "recovery_code_models", "totp_models", "user_roles", "role_models", "app_models", "user_models" }
+
+// tables with an id sequence
+var serialTables = []string{ "webauthn_credential_models", "recovery_code_models", "totp_models", "role_models", "app_models", "user_models" }
+
+// Restore replaces everything in the database with the contents of
+// the archive in reader. Nothing is changed, if the archive is
+// corrupted, was made with a newer schema or the database is not
+// migrated to the newest schema this binary knows. With
+// options.WithKeys the keys and actions files are restored as well
+func Restore( db *gorm.DB, reader io.Reader, options *Options ) (*Manifest, error) {
+  manifest, files, err := readArchive( reader )
+  if err != nil {
+    return nil, err
+  }
+
+  schemaVersion, err := migrations.Current( db )
+  if err != nil {
+    return nil, err
+  }
+  if manifest.SchemaVersion > migrations.Latest() {
+    return nil, fmt.Errorf( "%w: %d, this binary knows %d", ErrNewerSchema, manifest.SchemaVersion, migrations.Latest() )
+  }
+  if schemaVersion != migrations.Latest() {
+    return nil, fmt.Errorf( "%w: %d of %d applied", ErrSchemaNotMigrated, schemaVersion, migrations.Latest() )
+  }
+
+  withKeys := options != nil && options.WithKeys
+  if withKeys && ( files[KEYS_FILE] == nil || files[ACTIONS_FILE] == nil ) {
+    return nil, ErrNoKeys
+  }
+
+  var dump data
+  err = json.Unmarshal( files[DATA_FILE], &dump )
+  if err != nil {
+    return nil, err
+  }
+
+  err = db.Transaction( func( tx *gorm.DB ) error {
+    return replace( tx, &dump )
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  if withKeys {
+    // cyphernode keys reload the files when they change
+    err = ioutil.WriteFile( options.KeysFile, files[KEYS_FILE], 0600 )
+    if err != nil {
+      return nil, err
+    }
+    err = ioutil.WriteFile( options.ActionsFile, files[ACTIONS_FILE], 0600 )
+    if err != nil {
+      return nil, err
+    }
+  }
+
+  return manifest, nil
+}
+
+// readArchive reads all files of the archive and checks them
+// against the manifest
+func readArchive( reader io.Reader ) (*Manifest, map[string][]byte, error) {
+  gzipReader, err := gzip.NewReader( reader )
+  if err != nil {
+    return nil, nil, err
+  }
+  defer gzipReader.Close()
+
+  tarReader := tar.NewReader( gzipReader )
+  files := make( map[string][]byte )
+  for {
+    header, err := tarReader.Next()
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      return nil, nil, err
+    }
+    content, err := ioutil.ReadAll( tarReader )
+    if err != nil {
+      return nil, nil, err
+    }
+    files[header.Name] = content
+  }
+
+  manifestJson, ok := files[MANIFEST_FILE]
+  if !ok {
+    return nil, nil, fmt.Errorf( "%w: no manifest", ErrChecksumMismatch )
+  }
+  delete( files, MANIFEST_FILE )
+
+  var manifest Manifest
+  err = json.Unmarshal( manifestJson, &manifest )
+  if err != nil {
+    return nil, nil, err
+  }
+
+  if manifest.FormatVersion != FORMAT_VERSION {
+    return nil, nil, fmt.Errorf( "%w: %d", ErrUnsupportedFormat, manifest.FormatVersion )
+  }
+
+  if manifest.Checksum != manifest.checksum() {
+    return nil, nil, fmt.Errorf( "%w: manifest", ErrChecksumMismatch )
+  }
+
+  if len(files) != len(manifest.Files) {
+    return nil, nil, fmt.Errorf( "%w: files differ from manifest", ErrChecksumMismatch )
+  }
+  for name, content := range files {
+    if manifest.Files[name] != fileChecksum( content ) {
+      return nil, nil, fmt.Errorf( "%w: %s", ErrChecksumMismatch, name )
+    }
+  }
+
+  if _, ok := files[DATA_FILE]; !ok {
+    return nil, nil, fmt.Errorf( "%w: no %s", ErrChecksumMismatch, DATA_FILE )
+  }
+
+  return &manifest, files, nil
+}
+
+// replace deletes all rows and inserts the ones of dump with their
+// ids. hooks are skipped, since they would add roles to users
+func replace( tx *gorm.DB, dump *data ) error {
+  for _, table := range tables {
+    err := tx.Exec( "DELETE FROM "+table ).Error
+    if err != nil {
+      return err
+    }
+  }
+
+  for _, app := range dump.Apps {
+    app.Secret = dump.AppSecrets[app.ID]
+  }
+  for _, totp := range dump.Totps {
+    totp.Secret = dump.TotpSecrets[totp.ID]
+  }
+  for _, code := range dump.RecoveryCodes {
+    code.CodeHash = dump.RecoveryCodeHashes[code.ID]
+  }
+
+  // a new statement for every table
+  insert := func( rows interface{} ) error {
+    return tx.Session( &gorm.Session{ SkipHooks: true } ).Omit( clause.Associations ).Create( rows ).Error
+  }
+
+  if len(dump.Users) > 0 {
+    err := insert( &dump.Users )
+    if err != nil {
+      return err
+    }
+  }
+  if len(dump.Apps) > 0 {
+    err := insert( &dump.Apps )
+    if err != nil {
+      return err
+    }
+  }
+  if len(dump.Roles) > 0 {
+    err := insert( &dump.Roles )
+    if err != nil {
+      return err
+    }
+  }
+  if len(dump.Totps) > 0 {
+    err := insert( &dump.Totps )
+    if err != nil {
+      return err
+    }
+  }
+  if len(dump.RecoveryCodes) > 0 {
+    err := insert( &dump.RecoveryCodes )
+    if err != nil {
+      return err
+    }
+  }
+  if len(dump.WebauthnCredentials) > 0 {
+    err := insert( &dump.WebauthnCredentials )
+    if err != nil {
+      return err
+    }
+  }
+  for _, a := range dump.Assignments {
+    err := tx.Exec( "INSERT INTO user_roles (user_model_id, role_model_id) VALUES (?, ?)", a.UserId, a.RoleId ).Error
+    if err != nil {
+      return err
+    }
+  }
+
+  // rows were inserted with their ids, so postgres' sequences
+  // have to continue after them
+  if tx.Dialector.Name() == "postgres" {
+    for _, table := range serialTables {
+      err := tx.Exec( fmt.Sprintf( "SELECT setval(pg_get_serial_sequence('%s', 'id'), COALESCE(MAX(id), 1), MAX(id) IS NOT NULL) FROM %s", table, table ) ).Error
+      if err != nil {
+        return err
+      }
+    }
+  }
+
+  return nil
+}
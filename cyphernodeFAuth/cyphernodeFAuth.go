@@ -31,8 +31,17 @@ import (
   "github.com/schulterklopfer/cyphernode_fauth/dataSource"
   "github.com/schulterklopfer/cyphernode_fauth/globals"
   "github.com/schulterklopfer/cyphernode_fauth/helpers"
+  "github.com/schulterklopfer/cyphernode_fauth/loginThrottle"
   "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/oidc"
+  "github.com/schulterklopfer/cyphernode_fauth/password"
+  "github.com/schulterklopfer/cyphernode_fauth/webauthn"
   "golang.org/x/sync/errgroup"
+  "net"
+  "net/url"
+  "strconv"
+  "strings"
+  "time"
 )
 
 type Config struct {
@@ -82,8 +91,32 @@ func (cyphernodeFAuth *CyphernodeFAuth) Init() error {
     return err
   }
 
+  err = configurePasswords()
+  if err != nil {
+    logwrapper.Logger().Error("Failed to configure password hashing and policy" )
+    return err
+  }
+
+  err = configureLoginThrottle()
+  if err != nil {
+    logwrapper.Logger().Error("Failed to configure login throttling" )
+    return err
+  }
+
+  err = configureWebauthn()
+  if err != nil {
+    logwrapper.Logger().Error("Failed to configure webauthn" )
+    return err
+  }
+
+  err = configureOidc()
+  if err != nil {
+    logwrapper.Logger().Error("Failed to configure the oidc provider" )
+    return err
+  }
+
   cyphernodeFAuth.routerGroups = make(map[string]*gin.RouterGroup)
-  err = cyphernodeFAuth.migrate()
+  err = cyphernodeFAuth.seed()
   if err != nil {
     logwrapper.Logger().Error("Failed to init database" )
     return err
@@ -92,6 +125,9 @@ func (cyphernodeFAuth *CyphernodeFAuth) Init() error {
   cyphernodeFAuth.engineAuth = gin.New()
   cyphernodeFAuth.initAuthHandlers()
 
+  cyphernodeFAuth.engineInternal = gin.New()
+  cyphernodeFAuth.initInternalHandlers()
+
   err = appList.Init( helpers.GetenvOrDefault( globals.CYPHERAPPS_INSTALL_DIR_ENV_KEY ) )
   if err != nil {
     logwrapper.Logger().Error("Failed to init applist" )
@@ -101,6 +137,169 @@ func (cyphernodeFAuth *CyphernodeFAuth) Init() error {
   return nil
 }
 
+// configurePasswords sets the hash params of new passwords and the
+// policy new passwords have to comply with
+func configurePasswords() error {
+  minLength, err := strconv.Atoi( helpers.GetenvOrDefault( globals.CNA_PASSWORD_MIN_LENGTH_ENV_KEY ) )
+  if err != nil {
+    return err
+  }
+  bcryptCost, err := strconv.Atoi( helpers.GetenvOrDefault( globals.CNA_PASSWORD_BCRYPT_COST_ENV_KEY ) )
+  if err != nil {
+    return err
+  }
+  argon2Time, err := strconv.ParseUint( helpers.GetenvOrDefault( globals.CNA_PASSWORD_ARGON2_TIME_ENV_KEY ), 10, 32 )
+  if err != nil {
+    return err
+  }
+  argon2Memory, err := strconv.ParseUint( helpers.GetenvOrDefault( globals.CNA_PASSWORD_ARGON2_MEMORY_ENV_KEY ), 10, 32 )
+  if err != nil {
+    return err
+  }
+  argon2Threads, err := strconv.ParseUint( helpers.GetenvOrDefault( globals.CNA_PASSWORD_ARGON2_THREADS_ENV_KEY ), 10, 8 )
+  if err != nil {
+    return err
+  }
+
+  err = password.Configure( password.Params{
+    Algorithm:     helpers.GetenvOrDefault( globals.CNA_PASSWORD_HASH_ALGORITHM_ENV_KEY ),
+    BcryptCost:    bcryptCost,
+    Argon2Time:    uint32(argon2Time),
+    Argon2Memory:  uint32(argon2Memory),
+    Argon2Threads: uint8(argon2Threads),
+  })
+  if err != nil {
+    return err
+  }
+
+  passwordPolicy := password.Policy{ MinLength: minLength }
+  breachedFile := helpers.GetenvOrDefault( globals.CNA_PASSWORD_BREACHED_FILE_ENV_KEY )
+  if breachedFile != "" {
+    passwordPolicy.Forbidden, err = password.LoadForbidden( breachedFile )
+    if err != nil {
+      return err
+    }
+  }
+  password.ConfigurePolicy( passwordPolicy )
+  return nil
+}
+
+// configureLoginThrottle sets how failed logins are throttled
+func configureLoginThrottle() error {
+  config := loginThrottle.Config{}
+
+  for envKey, value := range map[string]*int{
+    globals.CNA_LOGIN_FREE_FAILURES_ENV_KEY:       &config.Login.FreeFailures,
+    globals.CNA_LOGIN_LOCKOUT_FAILURES_ENV_KEY:    &config.Login.LockoutFailures,
+    globals.CNA_LOGIN_IP_FREE_FAILURES_ENV_KEY:    &config.Ip.FreeFailures,
+    globals.CNA_LOGIN_IP_LOCKOUT_FAILURES_ENV_KEY: &config.Ip.LockoutFailures,
+  } {
+    var err error
+    *value, err = strconv.Atoi( helpers.GetenvOrDefault( envKey ) )
+    if err != nil {
+      return err
+    }
+  }
+
+  for envKey, value := range map[string]*time.Duration{
+    globals.CNA_LOGIN_BACKOFF_BASE_ENV_KEY:     &config.BackoffBase,
+    globals.CNA_LOGIN_LOCKOUT_DURATION_ENV_KEY: &config.LockoutDuration,
+    globals.CNA_LOGIN_FAILURE_WINDOW_ENV_KEY:   &config.FailureWindow,
+  } {
+    var err error
+    *value, err = time.ParseDuration( helpers.GetenvOrDefault( envKey ) )
+    if err != nil {
+      return err
+    }
+  }
+
+  for _, proxy := range strings.Split( helpers.GetenvOrDefault( globals.CNA_LOGIN_TRUSTED_PROXIES_ENV_KEY ), "," ) {
+    proxy = strings.TrimSpace( proxy )
+    if proxy == "" {
+      continue
+    }
+    if !strings.Contains( proxy, "/" ) {
+      if strings.Contains( proxy, ":" ) {
+        proxy += "/128"
+      } else {
+        proxy += "/32"
+      }
+    }
+    _, network, err := net.ParseCIDR( proxy )
+    if err != nil {
+      return err
+    }
+    config.TrustedProxies = append( config.TrustedProxies, network )
+  }
+
+  loginThrottle.Configure( config )
+  return nil
+}
+
+// configureWebauthn sets the relying party of webauthn ceremonies.
+// It is the cookie domain and the origin of the external base url,
+// unless set explicitly
+func configureWebauthn() error {
+  config := webauthn.Config{
+    RpId:   helpers.GetenvOrDefault( globals.CNA_WEBAUTHN_RP_ID_ENV_KEY ),
+    RpName: helpers.GetenvOrDefault( globals.CNA_WEBAUTHN_RP_NAME_ENV_KEY ),
+    Origin: helpers.GetenvOrDefault( globals.CNA_WEBAUTHN_ORIGIN_ENV_KEY ),
+  }
+
+  if config.RpId == "" {
+    config.RpId = strings.TrimPrefix( helpers.GetenvOrDefault( globals.OIDC_SSO_COOKIE_DOMAIN_ENV_KEY ), "." )
+  }
+
+  if config.Origin == "" {
+    baseUrl, err := url.Parse( helpers.GetenvOrDefault( globals.BASE_URL_EXTERNAL_ENV_KEY ) )
+    if err != nil {
+      return err
+    }
+    config.Origin = baseUrl.Scheme+"://"+baseUrl.Host
+  }
+
+  var err error
+  config.Timeout, err = time.ParseDuration( helpers.GetenvOrDefault( globals.CNA_WEBAUTHN_TIMEOUT_ENV_KEY ) )
+  if err != nil {
+    return err
+  }
+
+  config.MaxCeremonies, err = strconv.Atoi( helpers.GetenvOrDefault( globals.CNA_WEBAUTHN_MAX_CEREMONIES_ENV_KEY ) )
+  if err != nil {
+    return err
+  }
+
+  webauthn.Configure( config )
+  return nil
+}
+
+// configureOidc loads or creates the signing key of the oidc
+// provider and sets its issuer
+func configureOidc() error {
+  key, err := oidc.LoadOrCreateSigningKey( helpers.GetenvOrDefault( globals.CNA_OIDC_SIGNING_KEY_FILE_ENV_KEY ) )
+  if err != nil {
+    return err
+  }
+  oidc.UseSigningKey( key )
+
+  config := oidc.Config{
+    Issuer:      helpers.GetenvOrDefault( globals.CNA_OIDC_ISSUER_ENV_KEY ),
+    AppsBaseUrl: strings.TrimRight( helpers.GetenvOrDefault( globals.BASE_URL_EXTERNAL_ENV_KEY ), "/" ),
+  }
+
+  if config.Issuer == "" {
+    config.Issuer = config.AppsBaseUrl+globals.AUTH_ENDPOINTS_OIDC
+  }
+
+  config.TokenLifetime, err = time.ParseDuration( helpers.GetenvOrDefault( globals.CNA_OIDC_TOKEN_LIFETIME_ENV_KEY ) )
+  if err != nil {
+    return err
+  }
+
+  oidc.Configure( config )
+  return nil
+}
+
 func (cyphernodeFAuth *CyphernodeFAuth) Engine() *gin.Engine {
   return cyphernodeFAuth.engineExternal
 }
@@ -113,6 +312,10 @@ func (cyphernodeFAuth *CyphernodeFAuth) Start() {
     return  cyphernodeFAuth.engineAuth.Run(":3032")
   })
 
+  g.Go(func() error {
+    return  cyphernodeFAuth.engineInternal.Run(":3033")
+  })
+
   if err := g.Wait(); err != nil {
     logwrapper.Logger().Fatal(err)
   }
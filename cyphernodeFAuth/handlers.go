@@ -25,11 +25,53 @@
 package cyphernodeFAuth
 
 import (
+  "github.com/schulterklopfer/cyphernode_fauth/authApi"
   "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
   "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/internalApi"
 )
 
 func (cyphernodeFAuth *CyphernodeFAuth) initAuthHandlers() {
   cyphernodeFAuth.engineAuth.GET( globals.FORWARD_AUTH_ENDPOINTS_AUTH, forwardAuth.ForwardUserAuth)
   cyphernodeFAuth.engineAuth.GET( globals.PROXY_GATEKEEPER_ENDPOINTS_AUTH, forwardAuth.ForwardGatekeeperAuth)
+  cyphernodeFAuth.engineAuth.GET( globals.PROXY_GATEKEEPER_ENDPOINTS_APP_AUTH, forwardAuth.ForwardAppAuth, forwardAuth.ForwardAppGatekeeperAuth)
+  cyphernodeFAuth.engineAuth.POST( globals.AUTH_ENDPOINTS_LOGIN, authApi.Login)
+  cyphernodeFAuth.engineAuth.POST( globals.AUTH_ENDPOINTS_TOTP, forwardAuth.RequireUser, authApi.EnrollTotp)
+  cyphernodeFAuth.engineAuth.POST( globals.AUTH_ENDPOINTS_TOTP_CONFIRM, forwardAuth.RequireUser, authApi.ConfirmTotp)
+  cyphernodeFAuth.engineAuth.POST( globals.AUTH_ENDPOINTS_TOTP_DISABLE, forwardAuth.RequireUser, authApi.DisableTotp)
+  cyphernodeFAuth.engineAuth.POST( globals.AUTH_ENDPOINTS_RECOVERY_CODES, forwardAuth.RequireUser, authApi.RegenerateRecoveryCodes)
+  cyphernodeFAuth.engineAuth.POST( globals.AUTH_ENDPOINTS_WEBAUTHN_REGISTER, forwardAuth.RequireUser, authApi.BeginWebauthnRegistration)
+  cyphernodeFAuth.engineAuth.POST( globals.AUTH_ENDPOINTS_WEBAUTHN_REGISTER_FINISH, forwardAuth.RequireUser, authApi.FinishWebauthnRegistration)
+  cyphernodeFAuth.engineAuth.GET( globals.AUTH_ENDPOINTS_WEBAUTHN_CREDENTIALS, forwardAuth.RequireUser, authApi.GetWebauthnCredentials)
+  cyphernodeFAuth.engineAuth.DELETE( globals.AUTH_ENDPOINTS_WEBAUTHN_CREDENTIAL, forwardAuth.RequireUser, authApi.DeleteWebauthnCredential)
+  cyphernodeFAuth.engineAuth.POST( globals.AUTH_ENDPOINTS_WEBAUTHN_LOGIN, authApi.BeginWebauthnLogin)
+  cyphernodeFAuth.engineAuth.POST( globals.AUTH_ENDPOINTS_WEBAUTHN_LOGIN_FINISH, authApi.FinishWebauthnLogin)
+
+  oidcGroup := cyphernodeFAuth.engineAuth.Group( globals.AUTH_ENDPOINTS_OIDC )
+  oidcGroup.GET( globals.OIDC_ENDPOINTS_DISCOVERY, authApi.OidcDiscovery)
+  oidcGroup.GET( globals.OIDC_ENDPOINTS_JWKS, authApi.OidcJwks)
+  oidcGroup.GET( globals.OIDC_ENDPOINTS_AUTHORIZE, authApi.OidcAuthorize)
+  oidcGroup.POST( globals.OIDC_ENDPOINTS_AUTHORIZE, authApi.OidcAuthorize)
+  oidcGroup.POST( globals.OIDC_ENDPOINTS_TOKEN, authApi.OidcToken)
+  oidcGroup.GET( globals.OIDC_ENDPOINTS_USERINFO, authApi.OidcUserInfo)
+  oidcGroup.POST( globals.OIDC_ENDPOINTS_USERINFO, authApi.OidcUserInfo)
+}
+
+// only reachable from inside the cyphernode network
+func (cyphernodeFAuth *CyphernodeFAuth) initInternalHandlers() {
+  cyphernodeFAuth.engineInternal.GET( globals.INTERNAL_ENDPOINTS_HEALTH, internalApi.GetHealth)
+  cyphernodeFAuth.engineInternal.GET( globals.INTERNAL_ENDPOINTS_BEARER, forwardAuth.ForwardAppAuth, internalApi.MintBearer)
+  cyphernodeFAuth.engineInternal.GET( globals.INTERNAL_ENDPOINTS_APP_GROUPS, forwardAuth.RequireAdminUser, internalApi.GetAppGroups)
+  cyphernodeFAuth.engineInternal.PUT( globals.INTERNAL_ENDPOINTS_APP_GROUPS, forwardAuth.RequireAdminUser, internalApi.ApproveAppGroups)
+  cyphernodeFAuth.engineInternal.GET( globals.INTERNAL_ENDPOINTS_SYNC_PLAN, forwardAuth.RequireAdminUser, internalApi.GetSyncPlan)
+  cyphernodeFAuth.engineInternal.POST( globals.INTERNAL_ENDPOINTS_SYNC_PLAN_CONFIRM, forwardAuth.RequireAdminUser, internalApi.ConfirmSyncPlan)
+  cyphernodeFAuth.engineInternal.GET( globals.INTERNAL_ENDPOINTS_ARCHIVED_APPS, forwardAuth.RequireAdminUser, internalApi.GetArchivedApps)
+  cyphernodeFAuth.engineInternal.DELETE( globals.INTERNAL_ENDPOINTS_ARCHIVED_APP, forwardAuth.RequireAdminUser, internalApi.PurgeArchivedApp)
+  cyphernodeFAuth.engineInternal.POST( globals.INTERNAL_ENDPOINTS_POLICY_SIMULATION, forwardAuth.RequireAdminUser, internalApi.SimulatePolicies)
+  cyphernodeFAuth.engineInternal.POST( globals.INTERNAL_ENDPOINTS_USERS_IMPORT, forwardAuth.RequireAdminUser, internalApi.ImportUsers)
+  cyphernodeFAuth.engineInternal.GET( globals.INTERNAL_ENDPOINTS_USERS_EXPORT, forwardAuth.RequireAdminUser, internalApi.ExportUsers)
+  cyphernodeFAuth.engineInternal.GET( globals.INTERNAL_ENDPOINTS_LOGIN_THROTTLES, forwardAuth.RequireAdminUser, internalApi.GetLoginThrottles)
+  cyphernodeFAuth.engineInternal.DELETE( globals.INTERNAL_ENDPOINTS_LOGIN_THROTTLE, forwardAuth.RequireAdminUser, internalApi.UnlockLogin)
+  cyphernodeFAuth.engineInternal.DELETE( globals.INTERNAL_ENDPOINTS_USER_TOTP, forwardAuth.RequireAdminUser, internalApi.ResetTotp)
+  cyphernodeFAuth.engineInternal.DELETE( globals.INTERNAL_ENDPOINTS_USER_WEBAUTHN, forwardAuth.RequireAdminUser, internalApi.ResetWebauthn)
 }
@@ -0,0 +1,189 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cyphernodeKeys
+
+import (
+  "regexp"
+  "sort"
+  "strings"
+)
+
+// Rules in api.properties look like this:
+//
+//   action_getbalance=stats              exact action, first path segment
+//   action_getbalance*=stats             glob on the first path segment
+//   action_GET:getbalance*=stats         same, but only for GET requests
+//   action_GET,POST:wallet/*/info=admin  glob on the whole path
+//   action_wallet/**=admin               ** also matches across "/"
+//
+// Patterns without a "/" are matched against the first path segment only,
+// which is how actions were always resolved. Patterns containing a "/" are
+// matched against the whole path. The query string is never part of the match.
+//
+// When more than one rule matches, the first one in this order wins:
+//   1) rules on the whole path beat rules on the first path segment,
+//      so deeper paths can be restricted next to existing actions
+//   2) rules without wildcards beat rules with wildcards
+//   3) rules with more literal characters beat rules with less
+//   4) rules with a method qualifier beat rules without
+//   5) rules further up in the file beat rules further down
+
+type actionRule struct {
+  methods []string
+  pattern string
+  group   string
+  line    int
+
+  matcher *regexp.Regexp
+  literal bool
+  deep    bool
+  weight  int
+}
+
+func newActionRule( spec string, group string, line int ) *actionRule {
+  rule := &actionRule{
+    group: strings.TrimSpace( group ),
+    line:  line,
+  }
+
+  spec = strings.TrimSpace( spec )
+
+  if i := strings.Index( spec, ":" ); i > 0 && isMethodList( spec[:i] ) {
+    for _, method := range strings.Split( spec[:i], "," ) {
+      rule.methods = append( rule.methods, strings.ToUpper( strings.TrimSpace( method ) ) )
+    }
+    spec = spec[i+1:]
+  }
+
+  rule.pattern = strings.Trim( spec, "/" )
+
+  if rule.pattern == "" || rule.group == "" {
+    return nil
+  }
+
+  rule.deep = strings.Contains( rule.pattern, "/" )
+  rule.literal = !strings.ContainsAny( rule.pattern, "*?" )
+  rule.weight = len( strings.NewReplacer( "*", "", "?", "" ).Replace( rule.pattern ) )
+
+  if !rule.literal {
+    rule.matcher = regexp.MustCompile( globToRegexp( rule.pattern ) )
+  }
+
+  return rule
+}
+
+func isMethodList( s string ) bool {
+  for _, method := range strings.Split( s, "," ) {
+    method = strings.TrimSpace( method )
+    if method == "" {
+      return false
+    }
+    for _, r := range method {
+      if ( r < 'a' || r > 'z' ) && ( r < 'A' || r > 'Z' ) {
+        return false
+      }
+    }
+  }
+  return true
+}
+
+func globToRegexp( glob string ) string {
+  var sb strings.Builder
+  sb.WriteString( "^" )
+  for i := 0; i < len( glob ); i++ {
+    switch glob[i] {
+    case '*':
+      if i+1 < len( glob ) && glob[i+1] == '*' {
+        sb.WriteString( ".*" )
+        i++
+      } else {
+        sb.WriteString( "[^/]*" )
+      }
+    case '?':
+      sb.WriteString( "[^/]" )
+    default:
+      sb.WriteString( regexp.QuoteMeta( string( glob[i] ) ) )
+    }
+  }
+  sb.WriteString( "$" )
+  return sb.String()
+}
+
+func (rule *actionRule) matches( method string, path string, action string ) bool {
+  if len( rule.methods ) > 0 {
+    method = strings.ToUpper( method )
+    methodMatches := false
+    for _, m := range rule.methods {
+      if m == method {
+        methodMatches = true
+        break
+      }
+    }
+    if !methodMatches {
+      return false
+    }
+  }
+
+  target := action
+  if rule.deep {
+    target = path
+  }
+
+  if rule.literal {
+    return rule.pattern == target
+  }
+  return rule.matcher.MatchString( target )
+}
+
+func sortActionRules( rules []*actionRule ) {
+  sort.SliceStable( rules, func(i, j int) bool {
+    a := rules[i]
+    b := rules[j]
+    // whole path rules only ever match paths deeper than one segment
+    if a.deep != b.deep {
+      return a.deep
+    }
+    if a.literal != b.literal {
+      return a.literal
+    }
+    if a.weight != b.weight {
+      return a.weight > b.weight
+    }
+    if ( len( a.methods ) > 0 ) != ( len( b.methods ) > 0 ) {
+      return len( a.methods ) > 0
+    }
+    return a.line < b.line
+  })
+}
+
+// splits an uri like /getbalance/foo?bar=baz into the path "getbalance/foo"
+// and the action "getbalance"
+func splitActionPath( uri string ) (string, string) {
+  if i := strings.IndexAny( uri, "?#" ); i >= 0 {
+    uri = uri[:i]
+  }
+  path := strings.Trim( uri, "/" )
+  return path, strings.Split( path, "/" )[0]
+}
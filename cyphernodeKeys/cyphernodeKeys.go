@@ -35,6 +35,7 @@ import (
   "github.com/pkg/errors"
   "github.com/schulterklopfer/cyphernode_fauth/helpers"
   "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "io"
   "os"
   "strings"
   "sync"
@@ -53,8 +54,8 @@ type CyphernodeKeys struct {
   // label -> groups
   groups                    map[string][]string
 
-  // action -> group
-  actions                   map[string]string
+  // action rules, sorted by precedence
+  actions                   []*actionRule
 
   lastKeysConfigFileInfo    os.FileInfo
   lastActionsConfigFileInfo os.FileInfo
@@ -64,6 +65,9 @@ type CyphernodeKeys struct {
 }
 
 
+// lifetime of bearer tokens created by BearerFromKey
+const BEARER_LIFETIME_SECONDS = 10
+
 var instance *CyphernodeKeys
 var once sync.Once
 
@@ -113,12 +117,27 @@ func Instance() *CyphernodeKeys {
   return instance
 }
 
+// New parses keys and action rules in the format of the config
+// files without watching any files. Instance is left alone
+func New( keysConfig io.Reader, actionsConfig io.Reader ) (*CyphernodeKeys, error) {
+  cyphernodeKeys := &CyphernodeKeys{}
+  err := cyphernodeKeys.parseKeysConfigFile( keysConfig )
+  if err != nil {
+    return nil, err
+  }
+  err = cyphernodeKeys.parseActionsConfigFile( actionsConfig )
+  if err != nil {
+    return nil, err
+  }
+  return cyphernodeKeys, nil
+}
+
 
 /* legacy: parse strange key file format
 kapi_id="001";kapi_key="a27f9e73fdde6a5005879c273c9aea5e8d917eec77bbdfd73272c0af9b4c6b7a";kapi_groups="watcher";eval ugroups_${kapi_id}=${kapi_groups};eval ukey_${kapi_id}=${kapi_key}
 */
 
-func (cyphernodeKeys *CyphernodeKeys) parseKeysConfigFile(file *os.File) error {
+func (cyphernodeKeys *CyphernodeKeys) parseKeysConfigFile(file io.Reader) error {
   cyphernodeKeys.loadKeysMutex.Lock()
   defer cyphernodeKeys.loadKeysMutex.Unlock()
   cyphernodeKeys.keys = make(map[string]string)
@@ -168,13 +187,15 @@ func (cyphernodeKeys *CyphernodeKeys) parseKeysConfigFile(file *os.File) error {
   return scanner.Err()
 }
 
-func (cyphernodeKeys *CyphernodeKeys) parseActionsConfigFile(file *os.File) error {
+func (cyphernodeKeys *CyphernodeKeys) parseActionsConfigFile(file io.Reader) error {
   cyphernodeKeys.loadActionsMutex.Lock()
   defer cyphernodeKeys.loadActionsMutex.Unlock()
-  cyphernodeKeys.actions = make(map[string]string)
+  cyphernodeKeys.actions = make([]*actionRule, 0)
   scanner := bufio.NewScanner(file)
+  lineNumber := 0
   for scanner.Scan() {
     line := scanner.Text()
+    lineNumber++
 
     if strings.HasPrefix(line, "#") {
       continue
@@ -186,9 +207,17 @@ func (cyphernodeKeys *CyphernodeKeys) parseActionsConfigFile(file *os.File) erro
       continue
     }
 
-    cyphernodeKeys.actions[strings.TrimPrefix( kv[0],"action_" )]=kv[1]
+    rule := newActionRule( strings.TrimPrefix( kv[0],"action_" ), kv[1], lineNumber )
+
+    if rule == nil {
+      logwrapper.Logger().Warnf( "Ignoring invalid action rule in line %d: %s", lineNumber, line )
+      continue
+    }
+
+    cyphernodeKeys.actions = append( cyphernodeKeys.actions, rule )
 
   }
+  sortActionRules( cyphernodeKeys.actions )
   return scanner.Err()
 }
 
@@ -197,7 +226,7 @@ func (cyphernodeKeys *CyphernodeKeys) BearerFromKey( keyLabel string ) (string,
   defer cyphernodeKeys.loadKeysMutex.Unlock()
   if keyHex, ok := (*cyphernodeKeys).keys[keyLabel]; ok {
     header := "{\"alg\":\"HS256\",\"typ\":\"JWT\"}"
-    payload := fmt.Sprintf("{\"id\":\"%s\",\"exp\":%d}", keyLabel, time.Now().Unix()+10 )
+    payload := fmt.Sprintf("{\"id\":\"%s\",\"exp\":%d}", keyLabel, time.Now().Unix()+BEARER_LIFETIME_SECONDS )
 
     h64 := base64.StdEncoding.EncodeToString( []byte(header) )
     p64 := base64.StdEncoding.EncodeToString( []byte(payload) )
@@ -228,22 +257,51 @@ func (cyphernodeKeys *CyphernodeKeys) CheckSignature( keyLabel string, signed st
   return false
 }
 
-func (cyphernodeKeys *CyphernodeKeys) ActionAllowed( keyLabel string, action string ) bool {
+// GroupForAction returns the group of the first action rule matching
+// method and uri, see actionRule.go for the precedence rules
+func (cyphernodeKeys *CyphernodeKeys) GroupForAction( method string, uri string ) (string, bool) {
+  cyphernodeKeys.loadActionsMutex.Lock()
+  defer cyphernodeKeys.loadActionsMutex.Unlock()
+  return cyphernodeKeys.groupForAction( method, uri )
+}
+
+func (cyphernodeKeys *CyphernodeKeys) groupForAction( method string, uri string ) (string, bool) {
+  path, action := splitActionPath( uri )
+  if action == "" {
+    return "", false
+  }
+  for _, rule := range cyphernodeKeys.actions {
+    if rule.matches( method, path, action ) {
+      return rule.group, true
+    }
+  }
+  return "", false
+}
+
+func (cyphernodeKeys *CyphernodeKeys) ActionAllowed( keyLabel string, method string, uri string ) bool {
   cyphernodeKeys.loadKeysMutex.Lock()
   defer cyphernodeKeys.loadKeysMutex.Unlock()
+
+  if groups, exists := cyphernodeKeys.groups[keyLabel]; exists {
+    // we found groups for the key label
+    return cyphernodeKeys.ActionAllowedForGroups( groups, method, uri )
+  }
+  return false
+}
+
+// ActionAllowedForGroups checks if the group of the action rule matching
+// method and uri is one of groups
+func (cyphernodeKeys *CyphernodeKeys) ActionAllowedForGroups( groups []string, method string, uri string ) bool {
   cyphernodeKeys.loadActionsMutex.Lock()
   defer cyphernodeKeys.loadActionsMutex.Unlock()
 
-  if group, exists0 := cyphernodeKeys.actions[action]; exists0 {
+  if group, exists := cyphernodeKeys.groupForAction( method, uri ); exists {
     // we found a group for this action
-    if groups, exists1 := cyphernodeKeys.groups[keyLabel]; exists1 {
-      // we found groups for the key label
-      if helpers.SliceIndex( len(groups), func(i int) bool {
-        return groups[i] == group
-      }) != -1 {
-        // group of action is in groups of key. all is good
-        return true
-      }
+    if helpers.SliceIndex( len(groups), func(i int) bool {
+      return groups[i] == group
+    }) != -1 {
+      // group of action is in groups. all is good
+      return true
     }
   }
   return false
@@ -288,12 +346,14 @@ func (cyphernodeKeys *CyphernodeKeys) checkActionsConfigFileChange() {
     file, err := os.Open( cyphernodeKeys.ActionsConfigFilePath )
     if err != nil {
       logwrapper.Logger().Error( err.Error() )
+      return
     }
-    err = cyphernodeKeys.parseKeysConfigFile( file )
+    defer file.Close()
+    err = cyphernodeKeys.parseActionsConfigFile( file )
     if err != nil {
       logwrapper.Logger().Error( err.Error() )
     }
     cyphernodeKeys.LastActionsUpdate = time.Now()
-    cyphernodeKeys.lastActionsConfigFileInfo = fileInfo
   }
+  cyphernodeKeys.lastActionsConfigFileInfo = fileInfo
 }
\ No newline at end of file
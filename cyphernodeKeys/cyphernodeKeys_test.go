@@ -0,0 +1,132 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package cyphernodeKeys_test
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/cyphernodeKeys"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+const testKeys = `kapi_id="000";kapi_key="aaaa";kapi_groups="stats";eval ugroups_${kapi_id}=${kapi_groups};eval ukey_${kapi_id}=${kapi_key}
+kapi_id="001";kapi_key="bbbb";kapi_groups="stats,watcher";eval ugroups_${kapi_id}=${kapi_groups};eval ukey_${kapi_id}=${kapi_key}
+kapi_id="003";kapi_key="cccc";kapi_groups="stats,watcher,spender,admin";eval ugroups_${kapi_id}=${kapi_groups};eval ukey_${kapi_id}=${kapi_key}
+`
+
+const testActions = `# comment
+action_getblockchaininfo=stats
+action_get*=watcher
+action_GET:getbalance*=stats
+action_getbalance=spender
+action_GET,POST:wallet/*/info=watcher
+action_wallet/**=admin
+action_wallet/=
+action_watchtxid=stats
+action_watchtxid/**/delete=admin
+`
+
+func TestActionRules(t *testing.T) {
+  dir, err := ioutil.TempDir( "", "cyphernodeKeys" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer os.RemoveAll( dir )
+
+  keysFile := filepath.Join( dir, "keys.properties" )
+  actionsFile := filepath.Join( dir, "api.properties" )
+  _ = ioutil.WriteFile( keysFile, []byte(testKeys), 0644 )
+  _ = ioutil.WriteFile( actionsFile, []byte(testActions), 0644 )
+
+  err = cyphernodeKeys.Init( keysFile, actionsFile )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  cases := []struct {
+    method string
+    uri    string
+    group  string
+  }{
+    {"GET", "/getblockchaininfo", "stats"},
+    {"GET", "/getblockchaininfo/", "stats"},
+    {"GET", "/getblockchaininfo?foo=bar", "stats"},
+    // exact rule beats method qualified glob
+    {"GET", "/getbalance", "spender"},
+    // method qualified glob with longer prefix beats shorter glob
+    {"GET", "/getbalancebyxpub", "stats"},
+    {"POST", "/getbalancebyxpub", "watcher"},
+    {"GET", "/gettxns", "watcher"},
+    {"POST", "/wallet/foo/info", "watcher"},
+    {"DELETE", "/wallet/foo/info", "admin"},
+    {"GET", "/wallet/foo/bar/baz", "admin"},
+    {"GET", "/wallet", ""},
+    // whole path rule beats first segment literal on deeper paths
+    {"GET", "/watchtxid", "stats"},
+    {"GET", "/watchtxid/abc", "stats"},
+    {"DELETE", "/watchtxid/abc/delete", "admin"},
+    {"GET", "/", ""},
+    {"GET", "/unknown", ""},
+  }
+
+  for _, c := range cases {
+    group, ok := cyphernodeKeys.Instance().GroupForAction( c.method, c.uri )
+    if c.group == "" && ok {
+      t.Errorf( "%s %s should not match, but matched %s", c.method, c.uri, group )
+    }
+    if c.group != "" && group != c.group {
+      t.Errorf( "%s %s should match %s, but matched %s", c.method, c.uri, c.group, group )
+    }
+  }
+
+  if !cyphernodeKeys.Instance().ActionAllowed( "001", "POST", "/getbalancebyxpub" ) {
+    t.Error( "key 001 should be allowed to POST getbalancebyxpub" )
+  }
+
+  if cyphernodeKeys.Instance().ActionAllowed( "000", "POST", "/getbalancebyxpub" ) {
+    t.Error( "key 000 should not be allowed to POST getbalancebyxpub" )
+  }
+
+  if cyphernodeKeys.Instance().ActionAllowed( "001", "GET", "/getbalance" ) {
+    t.Error( "key 001 should not be allowed to GET getbalance" )
+  }
+
+  if !cyphernodeKeys.Instance().ActionAllowed( "003", "GET", "/wallet/a/b" ) {
+    t.Error( "key 003 should be allowed to GET wallet/a/b" )
+  }
+
+  if !cyphernodeKeys.Instance().ActionAllowedForGroups( []string{"watcher"}, "GET", "/gettxns" ) {
+    t.Error( "group watcher should be allowed to GET gettxns" )
+  }
+
+  if cyphernodeKeys.Instance().ActionAllowedForGroups( []string{"watcher"}, "GET", "/getbalance" ) {
+    t.Error( "group watcher should not be allowed to GET getbalance" )
+  }
+
+  if cyphernodeKeys.Instance().ActionAllowedForGroups( nil, "GET", "/gettxns" ) {
+    t.Error( "no groups should never be allowed" )
+  }
+}
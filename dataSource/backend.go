@@ -0,0 +1,109 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package dataSource
+
+import (
+  "gorm.io/driver/postgres"
+  "gorm.io/driver/sqlite"
+  "gorm.io/gorm"
+  "strings"
+)
+
+// Backend opens databases for the DSNs it supports
+type Backend interface {
+  Name() string
+  Supports( dsn string ) bool
+  Dialector( dsn string ) gorm.Dialector
+}
+
+// sqlite is chosen by a sqlite:// or file: DSN or a path to a
+// .db, .sqlite or .sqlite3 file. everything else goes to postgres
+var backends = []Backend{
+  &sqliteBackend{},
+  &postgresBackend{},
+}
+
+func backendFor( dsn string ) Backend {
+  for _, backend := range backends {
+    if backend.Supports( dsn ) {
+      return backend
+    }
+  }
+  return nil
+}
+
+type postgresBackend struct {}
+
+func (backend *postgresBackend) Name() string {
+  return "postgres"
+}
+
+// key=value DSNs and postgres:// urls
+func (backend *postgresBackend) Supports( dsn string ) bool {
+  return true
+}
+
+func (backend *postgresBackend) Dialector( dsn string ) gorm.Dialector {
+  return postgres.New(postgres.Config{
+    DSN: dsn,
+    PreferSimpleProtocol: true, // disables implicit prepared statement usage
+  })
+}
+
+type sqliteBackend struct {}
+
+func (backend *sqliteBackend) Name() string {
+  return "sqlite"
+}
+
+func (backend *sqliteBackend) Supports( dsn string ) bool {
+  if strings.HasPrefix( dsn, "sqlite://" ) || strings.HasPrefix( dsn, "file:" ) {
+    return true
+  }
+  path := dsn
+  if i := strings.Index( path, "?" ); i != -1 {
+    path = path[:i]
+  }
+  for _, suffix := range []string{ ".db", ".sqlite", ".sqlite3" } {
+    if strings.HasSuffix( path, suffix ) {
+      return true
+    }
+  }
+  return false
+}
+
+// foreign keys are off in sqlite unless asked for, which would
+// silently skip the cascading deletes
+func (backend *sqliteBackend) Dialector( dsn string ) gorm.Dialector {
+  dsn = strings.TrimPrefix( dsn, "sqlite://" )
+  if !strings.Contains( dsn, "_foreign_keys=" ) && !strings.Contains( dsn, "_fk=" ) {
+    separator := "?"
+    if strings.Contains( dsn, "?" ) {
+      separator = "&"
+    }
+    dsn += separator+"_foreign_keys=1"
+  }
+  return sqlite.Open( dsn )
+}
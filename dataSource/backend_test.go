@@ -0,0 +1,44 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package dataSource
+
+import "testing"
+
+func TestBackendFor(t *testing.T) {
+  cases := map[string]string{
+    "host=db port=5432 user=cnadmin password=cnadmin dbname=cnadmin sslmode=disable": "postgres",
+    "postgres://cnadmin:cnadmin@db:5432/cnadmin?sslmode=disable":                     "postgres",
+    "sqlite:///data/cnadmin.sqlite3":                                                "sqlite",
+    "file:cnadmin?mode=memory&cache=shared":                                          "sqlite",
+    "/data/cnadmin.db":                                                              "sqlite",
+    "/tmp/tests.sqlite3?_foreign_keys=on":                                           "sqlite",
+  }
+
+  for dsn, name := range cases {
+    if backend := backendFor( dsn ); backend.Name() != name {
+      t.Errorf( "%s: expected %s backend, got %s", dsn, name, backend.Name() )
+    }
+  }
+}
@@ -26,11 +26,9 @@ package dataSource
 
 import (
   "github.com/schulterklopfer/cyphernode_fauth/globals"
-  "gorm.io/driver/postgres"
   "gorm.io/gorm"
-  //_ "github.com/jinzhu/gorm/dialects/sqlite"
   "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
-  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/migrations"
 )
 
 var db *gorm.DB
@@ -39,28 +37,49 @@ func GetDB() *gorm.DB {
   return db
 }
 
+// Init opens the database and applies all pending migrations
 func Init( dsn string ) error {
+  if db != nil {
+    return nil
+  }
+  err := Open( dsn )
+  if err != nil {
+    return err
+  }
+  err = Migrate()
+  if err != nil {
+    return err
+  }
+  return nil
+}
+
+// Open opens the database without touching its schema
+func Open( dsn string ) error {
   if db != nil {
     return nil
   }
   var err error
-  logwrapper.Logger().Info( "Opening database "+dsn)
+  backend := backendFor( dsn )
+  logwrapper.Logger().Infof( "Opening %s database %s", backend.Name(), dsn )
 
-  db, err = gorm.Open(postgres.New(postgres.Config{
-    DSN: dsn,
-    PreferSimpleProtocol: true, // disables implicit prepared statement usage
-  }), &gorm.Config{})
+  db, err = gorm.Open( backend.Dialector( dsn ), &gorm.Config{})
 
-  //db, err = gorm.Open("sqlite3", dsn )
   if err != nil {
     logwrapper.Logger().Panic("failed to connect to database "+err.Error() )
     return err
   }
-  err = AutoMigrate()
+  return nil
+}
+
+func Ping() error {
+  if db == nil {
+    return globals.ErrDatabaseNotInitialised
+  }
+  sqlDB, err := db.DB()
   if err != nil {
     return err
   }
-  return nil
+  return sqlDB.Ping()
 }
 
 func Close() {
@@ -76,13 +95,14 @@ func Close() {
   db = nil
 }
 
-func AutoMigrate() error {
+func Migrate() error {
   if db == nil {
     return globals.ErrDatabaseNotInitialised
   }
   logwrapper.Logger().Info( "Migrating database")
-  return db.AutoMigrate(
-    &models.UserModel{},
-    &models.AppModel{},
-    &models.RoleModel{} )
-}
\ No newline at end of file
+  applied, err := migrations.Up( db, 0 )
+  for _, migration := range applied {
+    logwrapper.Logger().Infof( "Applied migration %d %s", migration.Version, migration.Name )
+  }
+  return err
+}
@@ -29,11 +29,24 @@ import (
   "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
   "github.com/schulterklopfer/cyphernode_fauth/models"
   "github.com/sirupsen/logrus"
+  "io/ioutil"
+  "net"
+  "os"
+  "path/filepath"
   "testing"
+  "time"
 )
 
 func TestDataSource(t *testing.T) {
   logwrapper.Logger().SetLevel( logrus.PanicLevel )
+
+  // Open panics if the database is not there
+  connection, err := net.DialTimeout( "tcp", "localhost:5432", time.Second )
+  if err != nil {
+    t.Skip( "no postgres database reachable: "+err.Error() )
+  }
+  connection.Close()
+
   dbDsn := "host=localhost port=5432 user=cnadmin password=cnadmin dbname=cnadmin sslmode=disable"
   dataSource.Init(dbDsn)
 
@@ -47,6 +60,27 @@ func TestDataSource(t *testing.T) {
 
 }
 
+func TestSqliteDataSource(t *testing.T) {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "dataSource" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer os.RemoveAll( dir )
+
+  dbDsn := "sqlite://"+filepath.Join( dir, "test.sqlite3" )
+  dataSource.Init(dbDsn)
+
+  t.Run("testCreateApp", testCreateApp )
+  t.Run("testLoadApp", testLoadApp )
+  t.Run("testLoadRole", testLoadRole )
+  t.Run("testCreateUser", testCreateUser )
+  t.Run("testLoadUser", testLoadUser )
+
+  dataSource.Close()
+
+}
+
 func testCreateApp(t *testing.T) {
 
   app1 := new(models.AppModel)
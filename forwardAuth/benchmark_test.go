@@ -0,0 +1,105 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package forwardAuth_test
+
+import (
+  "github.com/SatoshiPortal/cam/storage"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/schulterklopfer/cyphernode_fauth/stores"
+  "github.com/sirupsen/logrus"
+  "gorm.io/gorm"
+  "io/ioutil"
+  "net/http"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+// BenchmarkForwardUserAuth reports the queries per request against
+// the database stores. Loading the app and the user with all their
+// roles took 4 of them
+func BenchmarkForwardUserAuth(b *testing.B) {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "forwardAuth" )
+  if err != nil {
+    b.Fatal( err )
+  }
+  defer os.RemoveAll( dir )
+
+  err = dataSource.Init( "sqlite://"+filepath.Join( dir, "test.sqlite3" ) )
+  if err != nil {
+    b.Fatal( err )
+  }
+  defer dataSource.Close()
+
+  app := &models.AppModel{
+    Name: "app", Hash: "appHash", Secret: appSecret, MountPoint: "app",
+    AvailableRoles: []*models.RoleModel{ { Name: "user" }, { Name: "other" } },
+    AccessPolicies: models.AccessPolicies{
+      { AccessPolicy: storage.AccessPolicy{ Effect: "allow", Patterns: []string{"^/api"}, Roles: []string{"user"}, Actions: []string{"*"} } },
+    },
+  }
+  if err := queries.CreateApp( app ); err != nil {
+    b.Fatal( err )
+  }
+
+  user := &models.UserModel{ Login: "alice", Password: "secret", Roles: app.AvailableRoles }
+  if err := queries.CreateUser( user ); err != nil {
+    b.Fatal( err )
+  }
+
+  var count int64
+  err = dataSource.GetDB().Callback().Query().After( "gorm:query" ).Register( "test:count", func( db *gorm.DB ) {
+    count++
+  })
+  if err != nil {
+    b.Fatal( err )
+  }
+
+  forwardAuth.UseStores( stores.Database() )
+  defer forwardAuth.UseStores( stores.Database() )
+
+  headers := map[string]string{
+    "x-forwarded-prefix": "/app",
+    "x-forwarded-host":   "localhost",
+    "x-forwarded-proto":  "https",
+    "x-forwarded-method": "GET",
+    "x-forwarded-uri":    "/api/status",
+    "authorization":      bearer( sessionToken( user.ID ) ),
+  }
+
+  b.ResetTimer()
+  count = 0
+  for i:=0; i<b.N; i++ {
+    if status := serve( headers, forwardAuth.ForwardUserAuth ); status != http.StatusOK {
+      b.Fatalf( "expected %d, got %d", http.StatusOK, status )
+    }
+  }
+  b.ReportMetric( float64(count)/float64(b.N), "queries/op" )
+}
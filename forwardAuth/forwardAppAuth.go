@@ -32,22 +32,27 @@ import (
   "github.com/gin-gonic/gin"
   "github.com/schulterklopfer/cyphernode_fauth/helpers"
   "github.com/schulterklopfer/cyphernode_fauth/models"
-  "github.com/schulterklopfer/cyphernode_fauth/queries"
   "net/http"
 )
 
+const appContextKey = "app"
+
+// ForwardAppAuth authenticates a cypherapp by a token signed with
+// the app's secret. The token needs the app's ID in the "id" claim.
+// On success the app is stored in the gin context, see AppFromContext
 func ForwardAppAuth( c *gin.Context ) {
 
   // get symmetrically signed token
   tokenString := helpers.TokenFromBearerAuthHeader( c.Request.Header.Get("authorization") )
 
   if tokenString == "" {
-    c.Status(http.StatusUnauthorized)
+    c.AbortWithStatus(http.StatusUnauthorized)
     return
   }
 
+  var app *models.AppModel
 
-  _, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+  token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
     // Don't forget to validate the alg is what you expect:
     if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
       return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
@@ -59,23 +64,48 @@ func ForwardAppAuth( c *gin.Context ) {
       return nil, errors.New("No app id in claims")
     }
 
-    appId := uint(appIdFloat.(float64))
-    var app models.AppModel
-    err := queries.Get( &app, appId,false )
+    appIdNumber, ok := appIdFloat.(float64)
+
+    if !ok {
+      return nil, errors.New("App id in claims is not a number")
+    }
+
+    var err error
+    app, err = backend.Apps.AppById( c.Request.Context(), uint(appIdNumber) )
 
     if err != nil {
       return nil, err
     }
 
+    // never accept tokens for apps without a secret,
+    // since they would be signed with an empty key
+    if app.Secret == "" {
+      return nil, errors.New("No such app or app has no secret")
+    }
+
+    if app.IsArchived() {
+      return nil, errors.New("App is archived")
+    }
+
     return hex.DecodeString( app.Secret )
   })
 
-  if err != nil {
-    c.Status(http.StatusUnauthorized)
+  if err != nil || !token.Valid {
+    c.AbortWithStatus(http.StatusUnauthorized)
     return
   }
 
-
+  c.Set( appContextKey, app )
 
   c.Next()
 }
+
+// AppFromContext returns the app authenticated by ForwardAppAuth
+func AppFromContext( c *gin.Context ) *models.AppModel {
+  if value, exists := c.Get( appContextKey ); exists {
+    if app, ok := value.(*models.AppModel); ok {
+      return app
+    }
+  }
+  return nil
+}
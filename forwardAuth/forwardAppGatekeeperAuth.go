@@ -0,0 +1,59 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package forwardAuth
+
+import (
+  "github.com/gin-gonic/gin"
+  "net/http"
+)
+
+// ForwardAppGatekeeperAuth checks gatekeeper requests of a cypherapp
+// against the gatekeeper groups an admin approved for this app.
+// Needs to run after ForwardAppAuth.
+func ForwardAppGatekeeperAuth(c *gin.Context) {
+
+  app := AppFromContext( c )
+
+  if app == nil {
+    c.Status(http.StatusUnauthorized)
+    return
+  }
+
+  uriInAp := c.Request.Header.Get("x-forwarded-uri")
+  method := c.Request.Header.Get("x-forwarded-method")
+
+  if uriInAp == "/" || uriInAp == "" {
+    c.Status(http.StatusUnauthorized)
+    return
+  }
+
+  if backend.Keys.ActionAllowedForGroups( app.ApprovedGroups, method, uriInAp ) {
+    c.Status(http.StatusOK)
+    return
+  }
+
+  c.Status(http.StatusForbidden)
+
+}
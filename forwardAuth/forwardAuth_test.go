@@ -0,0 +1,368 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package forwardAuth_test
+
+import (
+  "encoding/base64"
+  "encoding/hex"
+  "fmt"
+  "github.com/SatoshiPortal/cam/storage"
+  "github.com/dgrijalva/jwt-go"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/stores"
+  "gorm.io/gorm"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "testing"
+  "time"
+)
+
+const appSecret = "00112233445566778899aabbccddeeff"
+
+func init() {
+  gin.SetMode( gin.TestMode )
+}
+
+func testStores() *stores.Memory {
+  memory := stores.NewMemory()
+  keys := stores.NewMemoryKeys()
+
+  adminRole := &models.RoleModel{ Model: gorm.Model{ ID: 1 }, Name: globals.BASE_ADMIN_ROLE, AppId: 1 }
+  userRole := &models.RoleModel{ Model: gorm.Model{ ID: 2 }, Name: "user", AppId: 2 }
+  archivedAt := time.Now()
+
+  memory.AddApp( &models.AppModel{
+    Model: gorm.Model{ ID: 1 },
+    MountPoint: globals.BASE_ADMIN_MOUNTPOINT,
+    AccessPolicies: models.AccessPolicies{
+      { AccessPolicy: storage.AccessPolicy{ Effect: "allow", Patterns: []string{"^/users"}, Roles: []string{globals.BASE_ADMIN_ROLE}, Actions: []string{"*"} } },
+    },
+  })
+  memory.AddApp( &models.AppModel{
+    Model: gorm.Model{ ID: 2 },
+    MountPoint: "app",
+    Secret: appSecret,
+    ApprovedGroups: models.StringList{ "stats" },
+    AccessPolicies: models.AccessPolicies{
+      { AccessPolicy: storage.AccessPolicy{ Effect: "allow", Patterns: []string{"^/public"}, Roles: []string{"*"}, Actions: []string{"get"} } },
+      { AccessPolicy: storage.AccessPolicy{ Effect: "allow", Patterns: []string{"^/api"}, Roles: []string{"user"}, Actions: []string{"*"} } },
+      { AccessPolicy: storage.AccessPolicy{ Effect: "allow", Patterns: []string{"^/wallet"}, Roles: []string{"user"}, Actions: []string{"*"} }, RequireMfa: true },
+    },
+  })
+  memory.AddApp( &models.AppModel{ Model: gorm.Model{ ID: 3 }, MountPoint: "quarantined", Secret: "aa", Quarantined: true } )
+  memory.AddApp( &models.AppModel{ Model: gorm.Model{ ID: 4 }, MountPoint: "archived", Secret: appSecret, ArchivedAt: &archivedAt } )
+
+  memory.AddUser( &models.UserModel{ Model: gorm.Model{ ID: 1 }, Login: "admin", Roles: []*models.RoleModel{ adminRole } } )
+  memory.AddUser( &models.UserModel{ Model: gorm.Model{ ID: 2 }, Login: "alice", Roles: []*models.RoleModel{ userRole } } )
+  memory.AddUser( &models.UserModel{ Model: gorm.Model{ ID: 3 }, Login: "bob" } )
+
+  keys.AddKey( "000", "aaaa", "stats" )
+  keys.AddKey( "003", "cccc", "stats", "spender" )
+  keys.AddAction( "getinfo", "stats" )
+  keys.AddAction( "spend", "spender" )
+  // matched like api.properties rules in production
+  keys.AddAction( "GET:wallet/*/info", "stats" )
+
+  forwardAuth.UseStores( stores.InMemory( memory, keys ) )
+  gatekeeperKeys = keys
+  return memory
+}
+
+var gatekeeperKeys *stores.MemoryKeys
+
+func signedToken( claims jwt.MapClaims, secret []byte ) string {
+  token, _ := jwt.NewWithClaims( jwt.SigningMethodHS256, claims ).SignedString( secret )
+  return token
+}
+
+func sessionToken( userId uint ) string {
+  return signedToken( jwt.MapClaims{ "id": userId }, []byte(globals.DEFAULTS[globals.CNA_COOKIE_SECRET_ENV_KEY]) )
+}
+
+func mfaSessionToken( userId uint ) string {
+  amr := []string{ forwardAuth.AMR_PASSWORD, forwardAuth.AMR_OTP, forwardAuth.AMR_MFA }
+  return signedToken( jwt.MapClaims{ "id": userId, "amr": amr }, []byte(globals.DEFAULTS[globals.CNA_COOKIE_SECRET_ENV_KEY]) )
+}
+
+func gatekeeperToken( keyLabel string, signingLabel string ) string {
+  header := base64.RawURLEncoding.EncodeToString( []byte("{\"alg\":\"HS256\",\"typ\":\"JWT\"}") )
+  payload := base64.RawURLEncoding.EncodeToString( []byte(fmt.Sprintf( "{\"id\":\"%s\",\"exp\":%d}", keyLabel, time.Now().Unix()+60 )) )
+  return header+"."+payload+"."+gatekeeperKeys.Sign( signingLabel, header+"."+payload )
+}
+
+// expiredBearer is a bearer like the ones minted by BearerFromKey,
+// which expired long ago
+func expiredBearer( keyLabel string ) string {
+  header := base64.StdEncoding.EncodeToString( []byte("{\"alg\":\"HS256\",\"typ\":\"JWT\"}") )
+  payload := base64.StdEncoding.EncodeToString( []byte(fmt.Sprintf( "{\"id\":\"%s\",\"exp\":1600000000}", keyLabel )) )
+  return "Bearer "+header+"."+payload+"."+gatekeeperKeys.Sign( keyLabel, header+"."+payload )
+}
+
+func serve( headers map[string]string, handlers ...gin.HandlerFunc ) int {
+  engine := gin.New()
+  engine.GET( "/auth", handlers... )
+  request := httptest.NewRequest( "GET", "/auth", nil )
+  for key, value := range headers {
+    request.Header.Set( key, value )
+  }
+  recorder := httptest.NewRecorder()
+  engine.ServeHTTP( recorder, request )
+  return recorder.Code
+}
+
+// passed is the handler after middleware which
+// calls Next on success
+func passed( c *gin.Context ) {
+  c.Status( http.StatusOK )
+}
+
+func bearer( token string ) string {
+  if token == "" {
+    return ""
+  }
+  return "Bearer "+token
+}
+
+func TestForwardUserAuth(t *testing.T) {
+  testStores()
+
+  cases := []struct {
+    prefix string
+    method string
+    uri    string
+    token  string
+    status int
+  }{
+    {"", "GET", "/public", "", http.StatusTemporaryRedirect},
+    {"/unknown", "GET", "/public", "", http.StatusNotFound},
+    {"/archived", "GET", "/public", "", http.StatusNotFound},
+    {"/quarantined", "GET", "/public", "", http.StatusServiceUnavailable},
+    {"/app", "GET", "/public", "", http.StatusOK},
+    {"/app", "POST", "/public", "", http.StatusTemporaryRedirect},
+    {"/app", "GET", "/api/status", "", http.StatusTemporaryRedirect},
+    {"/app", "GET", "/api/status", sessionToken( 2 ), http.StatusOK},
+    {"/app", "POST", "/api/status", sessionToken( 2 ), http.StatusOK},
+    {"/app", "GET", "/api/status", sessionToken( 3 ), http.StatusTemporaryRedirect},
+    // roles of other apps don't count
+    {"/app", "GET", "/api/status", sessionToken( 1 ), http.StatusTemporaryRedirect},
+    {"/app", "GET", "/api/status", sessionToken( 99 ), http.StatusTemporaryRedirect},
+    {"/app", "GET", "/api/status", signedToken( jwt.MapClaims{ "id": 2 }, []byte("wrong") ), http.StatusTemporaryRedirect},
+    {"/app", "GET", "/public", sessionToken( 99 ), http.StatusOK},
+    // policies can require a second factor
+    {"/app", "GET", "/wallet", sessionToken( 2 ), http.StatusTemporaryRedirect},
+    {"/app", "GET", "/wallet", mfaSessionToken( 2 ), http.StatusOK},
+    {"/admin", "GET", "/users", sessionToken( 1 ), http.StatusOK},
+  }
+
+  for _, c := range cases {
+    status := serve( map[string]string{
+      "x-forwarded-prefix": c.prefix,
+      "x-forwarded-host":   "localhost",
+      "x-forwarded-proto":  "https",
+      "x-forwarded-method": c.method,
+      "x-forwarded-uri":    c.uri,
+      "authorization":      bearer( c.token ),
+    }, forwardAuth.ForwardUserAuth )
+
+    if status != c.status {
+      t.Errorf( "%s %s%s: expected %d, got %d", c.method, c.prefix, c.uri, c.status, status )
+    }
+  }
+}
+
+func TestForwardGatekeeperAuth(t *testing.T) {
+  testStores()
+
+  cases := []struct {
+    uri    string
+    token  string
+    status int
+  }{
+    {"/getinfo", gatekeeperToken( "000", "000" ), http.StatusOK},
+    {"/spend", gatekeeperToken( "000", "000" ), http.StatusUnauthorized},
+    {"/spend", gatekeeperToken( "003", "003" ), http.StatusOK},
+    {"/unknown", gatekeeperToken( "003", "003" ), http.StatusUnauthorized},
+    {"/wallet/main/info", gatekeeperToken( "000", "000" ), http.StatusOK},
+    {"/wallet/main/spend", gatekeeperToken( "000", "000" ), http.StatusUnauthorized},
+    // signed with another key
+    {"/getinfo", gatekeeperToken( "000", "003" ), http.StatusUnauthorized},
+    {"/getinfo", gatekeeperToken( "999", "999" ), http.StatusUnauthorized},
+    {"/getinfo", "", http.StatusUnauthorized},
+    {"/", gatekeeperToken( "000", "000" ), http.StatusUnauthorized},
+  }
+
+  for _, c := range cases {
+    status := serve( map[string]string{
+      "x-forwarded-method": "GET",
+      "x-forwarded-uri":    c.uri,
+      "authorization":      bearer( c.token ),
+    }, forwardAuth.ForwardGatekeeperAuth )
+
+    if status != c.status {
+      t.Errorf( "%s with %s: expected %d, got %d", c.uri, c.token, c.status, status )
+    }
+  }
+}
+
+func TestForwardAppAuth(t *testing.T) {
+  testStores()
+
+  secret, _ := hex.DecodeString( appSecret )
+
+  cases := []struct {
+    uri    string
+    token  string
+    status int
+  }{
+    {"/getinfo", signedToken( jwt.MapClaims{ "id": 2 }, secret ), http.StatusOK},
+    {"/spend", signedToken( jwt.MapClaims{ "id": 2 }, secret ), http.StatusForbidden},
+    {"/getinfo", signedToken( jwt.MapClaims{ "id": 2 }, []byte("wrong") ), http.StatusUnauthorized},
+    {"/getinfo", signedToken( jwt.MapClaims{ "id": 4 }, secret ), http.StatusUnauthorized},
+    {"/getinfo", signedToken( jwt.MapClaims{ "id": 99 }, secret ), http.StatusUnauthorized},
+    // the admin app has no secret
+    {"/getinfo", signedToken( jwt.MapClaims{ "id": 1 }, []byte{} ), http.StatusUnauthorized},
+    {"/getinfo", "", http.StatusUnauthorized},
+  }
+
+  for _, c := range cases {
+    status := serve( map[string]string{
+      "x-forwarded-method": "GET",
+      "x-forwarded-uri":    c.uri,
+      "authorization":      bearer( c.token ),
+    }, forwardAuth.ForwardAppAuth, forwardAuth.ForwardAppGatekeeperAuth )
+
+    if status != c.status {
+      t.Errorf( "%s with %s: expected %d, got %d", c.uri, c.token, c.status, status )
+    }
+  }
+}
+
+func TestExpiredBearers(t *testing.T) {
+  testStores()
+
+  minted, err := gatekeeperKeys.BearerFromKey( "000" )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  secret, _ := hex.DecodeString( appSecret )
+
+  cases := []struct {
+    name     string
+    bearer   string
+    handlers []gin.HandlerFunc
+    status   int
+  }{
+    {"minted gatekeeper bearer", minted, []gin.HandlerFunc{ forwardAuth.ForwardGatekeeperAuth }, http.StatusOK},
+    {"expired gatekeeper bearer", expiredBearer( "000" ), []gin.HandlerFunc{ forwardAuth.ForwardGatekeeperAuth }, http.StatusUnauthorized},
+    {"expired app bearer", expiredBearer( "000" ), []gin.HandlerFunc{ forwardAuth.ForwardAppAuth, forwardAuth.ForwardAppGatekeeperAuth }, http.StatusUnauthorized},
+    {"expired app token", bearer( signedToken( jwt.MapClaims{ "id": 2, "exp": 1600000000 }, secret ) ), []gin.HandlerFunc{ forwardAuth.ForwardAppAuth, forwardAuth.ForwardAppGatekeeperAuth }, http.StatusUnauthorized},
+  }
+
+  for _, c := range cases {
+    status := serve( map[string]string{
+      "x-forwarded-method": "GET",
+      "x-forwarded-uri":    "/getinfo",
+      "authorization":      c.bearer,
+    }, c.handlers... )
+
+    if status != c.status {
+      t.Errorf( "%s: expected %d, got %d", c.name, c.status, status )
+    }
+  }
+}
+
+func TestRequireAdminUser(t *testing.T) {
+  testStores()
+
+  cases := []struct {
+    token  string
+    status int
+  }{
+    {sessionToken( 1 ), http.StatusOK},
+    {sessionToken( 2 ), http.StatusForbidden},
+    {sessionToken( 99 ), http.StatusUnauthorized},
+    {signedToken( jwt.MapClaims{ "id": 1 }, []byte("wrong") ), http.StatusUnauthorized},
+    {"", http.StatusUnauthorized},
+  }
+
+  for _, c := range cases {
+    status := serve( map[string]string{
+      "authorization": bearer( c.token ),
+    }, forwardAuth.RequireAdminUser, passed )
+
+    if status != c.status {
+      t.Errorf( "%s: expected %d, got %d", c.token, c.status, status )
+    }
+  }
+}
+
+func TestMfaRequiredForAdmins(t *testing.T) {
+  testStores()
+  _ = os.Setenv( globals.CNA_MFA_REQUIRED_FOR_ADMINS_ENV_KEY, "true" )
+  defer os.Unsetenv( globals.CNA_MFA_REQUIRED_FOR_ADMINS_ENV_KEY )
+
+  cases := []struct {
+    token  string
+    status int
+  }{
+    {sessionToken( 1 ), http.StatusForbidden},
+    {mfaSessionToken( 1 ), http.StatusOK},
+    {mfaSessionToken( 2 ), http.StatusForbidden},
+  }
+
+  for _, c := range cases {
+    status := serve( map[string]string{
+      "authorization": bearer( c.token ),
+    }, forwardAuth.RequireAdminUser, passed )
+
+    if status != c.status {
+      t.Errorf( "%s: expected %d, got %d", c.token, c.status, status )
+    }
+  }
+
+  // admins without a second factor lose their admin role
+  for token, status := range map[string]int{
+    sessionToken( 1 ):    http.StatusTemporaryRedirect,
+    mfaSessionToken( 1 ): http.StatusOK,
+  } {
+    got := serve( map[string]string{
+      "x-forwarded-prefix": "/admin",
+      "x-forwarded-host":   "localhost",
+      "x-forwarded-proto":  "https",
+      "x-forwarded-method": "GET",
+      "x-forwarded-uri":    "/users",
+      "authorization":      bearer( token ),
+    }, forwardAuth.ForwardUserAuth )
+
+    if got != status {
+      t.Errorf( "%s: expected %d, got %d", token, status, got )
+    }
+  }
+}
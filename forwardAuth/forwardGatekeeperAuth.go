@@ -27,10 +27,10 @@ package forwardAuth
 import (
   "github.com/dgrijalva/jwt-go"
   "github.com/gin-gonic/gin"
-  "github.com/schulterklopfer/cyphernode_fauth/cyphernodeKeys"
   "github.com/schulterklopfer/cyphernode_fauth/helpers"
   "net/http"
   "strings"
+  "time"
 )
 
 func ForwardGatekeeperAuth(c *gin.Context) {
@@ -44,6 +44,7 @@ func ForwardGatekeeperAuth(c *gin.Context) {
     return
   }
 
+  method := c.Request.Header.Get("x-forwarded-method")
   action := strings.Split( strings.TrimPrefix(uriInAp,"/"), "/" )[0]
 
   if action == "" {
@@ -54,6 +55,12 @@ func ForwardGatekeeperAuth(c *gin.Context) {
   tokenString := helpers.TokenFromBearerAuthHeader( c.Request.Header.Get("authorization") )
   token, _ := jwt.Parse(tokenString, nil)
 
+  // malformed tokens are not parsed at all
+  if token == nil {
+    c.Status(http.StatusUnauthorized)
+    return
+  }
+
   claims, ok := token.Claims.(jwt.MapClaims)
 
   if !ok {
@@ -70,12 +77,19 @@ func ForwardGatekeeperAuth(c *gin.Context) {
     tokenParts := strings.Split( token.Raw, "." )
     // custom legacy jwt signing stuff...
     // TODO: use standard jwt tokens
-    if !cyphernodeKeys.Instance().CheckSignature(keyLabel, strings.Join( tokenParts[0:2], "." ), tokenParts[2] ) {
+    if !backend.Keys.CheckSignature(keyLabel, strings.Join( tokenParts[0:2], "." ), tokenParts[2] ) {
+      c.Status(http.StatusUnauthorized)
+      return
+    }
+
+    // claims are not validated by jwt.Parse without a key func, and
+    // bearers without an expiry would be valid forever
+    if !claims.VerifyExpiresAt( time.Now().Unix(), true ) {
       c.Status(http.StatusUnauthorized)
       return
     }
 
-    if cyphernodeKeys.Instance().ActionAllowed( keyLabel, action ) {
+    if backend.Keys.ActionAllowed( keyLabel, method, uriInAp ) {
       c.Status(http.StatusOK)
       return
     }
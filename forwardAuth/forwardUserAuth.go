@@ -25,13 +25,11 @@
 package forwardAuth
 
 import (
-  "fmt"
+  "errors"
   "github.com/dgrijalva/jwt-go"
   "github.com/gin-gonic/gin"
   "github.com/schulterklopfer/cyphernode_fauth/globals"
-  "github.com/schulterklopfer/cyphernode_fauth/helpers"
-  "github.com/schulterklopfer/cyphernode_fauth/models"
-  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/schulterklopfer/cyphernode_fauth/policy"
   "net/http"
   "strings"
 )
@@ -52,7 +50,13 @@ func ForwardUserAuth(c *gin.Context) {
   // x-forwarded-prefix header idetentifies the app we want to auth against
   mountPoint := prefix[1:]
 
-  app, err := queries.GetAppByMountPoint( mountPoint )
+  app, err := backend.Apps.AppByMountPoint( c.Request.Context(), mountPoint )
+
+  if errors.Is( err, globals.ErrNotFound ) {
+    c.Header("X-Status-Reason", err.Error() )
+    c.Status(http.StatusNotFound)
+    return
+  }
 
   if err != nil {
     c.Header("X-Status-Reason", err.Error() )
@@ -60,33 +64,22 @@ func ForwardUserAuth(c *gin.Context) {
     return
   }
 
+  if app.Quarantined {
+    c.Header("X-Status-Reason", "app is quarantined" )
+    c.Status(http.StatusServiceUnavailable)
+    return
+  }
+
   uriInAp := c.Request.Header.Get("x-forwarded-uri")
   method := c.Request.Header.Get("x-forwarded-method")
 
-  // access not granted. See if we have a valid token
-  // and check access again
-  tokenString := helpers.TokenFromBearerAuthHeader( c.Request.Header.Get("authorization") )
-
-  if tokenString == "" {
-    // lets see if there is a cookie where we can get the auth from
-    if sessionCookie, err := c.Request.Cookie(helpers.GetenvOrDefault(globals.CNA_SESSION_COOKIE_NAME_ENV_KEY)); err == nil {
-      tokenString = sessionCookie.Value
-    }
-  }
+  // see if we have a valid token. the user's roles
+  // are checked against the app's access policies
+  tokenString := sessionTokenString( c )
 
-  // Parse takes the token string and a function for looking up the key. The latter is especially
-  // useful if you use multiple keys for your application.  The standard is to use 'kid' in the
-  // head of the token to identify which key to use, but the parsed token (head and claims) is provided
-  // to the callback, providing flexibility.
   var token *jwt.Token
   if tokenString != "" {
-    token, err = jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-      // Don't forget to validate the alg is what you expect:
-      if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-        return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-      }
-      return []byte(helpers.GetenvOrDefault(globals.CNA_COOKIE_SECRET_ENV_KEY)), nil
-    })
+    token, err = parseSessionToken( tokenString )
 
     if err == nil {
       // set correct headers for cypherapp down the line
@@ -96,63 +89,36 @@ func ForwardUserAuth(c *gin.Context) {
 
   }
 
-  // check for public access
-  accessGranted := false
-  for _, accessPolicy := range app.AccessPolicies {
-    accessGranted = accessPolicy.Check( method, uriInAp, nil )
-    if accessGranted {
-      break
+  request := &policy.Request{
+    Method: method,
+    URI:    uriInAp,
+    Header: c.Request.Header,
+  }
+
+  // without a valid session, only public access is possible
+  if token != nil && token.Valid {
+    roleNames, err := roleNamesFromSessionToken( c.Request.Context(), token, app )
+
+    if err == nil {
+      request.Roles = roleNames
+    } else {
+      c.Header("X-Status-Reason", err.Error() )
     }
+
+    request.Mfa = sessionHasMfa( token )
+    policy.DropAdminWithoutMfa( app, request )
   }
 
-  if accessGranted {
+  decision := policy.ForApp( app ).Evaluate( request )
+
+  if decision.Allowed {
     c.Status(http.StatusOK)
     return
   }
 
-  if token != nil {
-    if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-
-      subject, exists := claims["id"]
-
-      if !exists {
-        c.Header("X-Status-Reason", "no subject claims" )
-        c.Redirect( http.StatusTemporaryRedirect, forwardedProto+"://"+forwardedHost+globals.UNAUTHORIZED_REDIRECT_URL )
-        return
-      }
-
-      userId := uint(subject.(float64))
-      var user models.UserModel
-      err := queries.Get( &user, userId,true )
-
-      if err != nil || user.ID == 0 {
-        c.Redirect( http.StatusTemporaryRedirect, forwardedProto+"://"+forwardedHost+globals.UNAUTHORIZED_REDIRECT_URL )
-        return
-      }
-
-      var roleNames []string;
-      for _, role := range user.Roles {
-        if role.AppId != app.ID {
-          continue
-        }
-        roleNames = append( roleNames, role.Name )
-      }
-
-      accessGranted := false
-      for _, accessPolicy := range app.AccessPolicies {
-        accessGranted = accessPolicy.Check( method, uriInAp, roleNames )
-        if accessGranted {
-          break
-        }
-      }
-
-      if accessGranted {
-        c.Status(http.StatusOK)
-        return
-      }
-    }
+  if c.Writer.Header().Get("X-Status-Reason") == "" {
+    c.Header("X-Status-Reason", decision.Reason )
   }
-
   c.Redirect( http.StatusTemporaryRedirect, forwardedProto+"://"+forwardedHost+globals.UNAUTHORIZED_REDIRECT_URL )
 
 }
@@ -0,0 +1,313 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package forwardAuth
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "github.com/dgrijalva/jwt-go"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/helpers"
+  "github.com/schulterklopfer/cyphernode_fauth/mfa"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/stores"
+  "net/http"
+  "time"
+)
+
+const userContextKey = "user"
+
+// Session is a valid session of a user. Amr lists how the user was
+// authenticated at AuthTime
+type Session struct {
+  User     *models.UserModel
+  Amr      []string
+  AuthTime time.Time
+}
+
+// authentication methods of a session as of RFC 8176. sessions
+// with AMR_MFA were authenticated with more than one factor
+const AMR_PASSWORD = "pwd"
+const AMR_OTP = "otp"
+const AMR_HARDWARE_KEY = "hwk"
+const AMR_MFA = "mfa"
+
+// session token is either a bearer token or the session cookie
+func sessionTokenString( c *gin.Context ) string {
+  tokenString := helpers.TokenFromBearerAuthHeader( c.Request.Header.Get("authorization") )
+
+  if tokenString == "" {
+    // lets see if there is a cookie where we can get the auth from
+    if sessionCookie, err := c.Request.Cookie(helpers.GetenvOrDefault(globals.CNA_SESSION_COOKIE_NAME_ENV_KEY)); err == nil {
+      tokenString = sessionCookie.Value
+    }
+  }
+  return tokenString
+}
+
+func parseSessionToken( tokenString string ) (*jwt.Token, error) {
+  // Parse takes the token string and a function for looking up the key. The latter is especially
+  // useful if you use multiple keys for your application.  The standard is to use 'kid' in the
+  // head of the token to identify which key to use, but the parsed token (head and claims) is provided
+  // to the callback, providing flexibility.
+  return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+    // Don't forget to validate the alg is what you expect:
+    if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+      return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+    }
+    return []byte(helpers.GetenvOrDefault(globals.CNA_COOKIE_SECRET_ENV_KEY)), nil
+  })
+}
+
+// MintSessionToken signs a session token of user, which expires
+// after lifetime. amr lists how the user was authenticated
+func MintSessionToken( user *models.UserModel, lifetime time.Duration, amr []string ) (string, time.Time, error) {
+  now := time.Now()
+  expiresAt := now.Add( lifetime )
+  claims := jwt.MapClaims{
+    "id":    user.ID,
+    "login": user.Login,
+    "amr":   amr,
+    "iat":   now.Unix(),
+    "exp":   expiresAt.Unix(),
+  }
+  tokenString, err := jwt.NewWithClaims( jwt.SigningMethodHS256, claims ).SignedString( []byte(helpers.GetenvOrDefault(globals.CNA_COOKIE_SECRET_ENV_KEY)) )
+  return tokenString, expiresAt, err
+}
+
+func userIdFromSessionToken( token *jwt.Token ) (uint, error) {
+  claims, ok := token.Claims.(jwt.MapClaims)
+
+  if !ok || !token.Valid {
+    return 0, errors.New("invalid token")
+  }
+
+  subject, exists := claims["id"]
+
+  if !exists {
+    return 0, errors.New("no subject claims")
+  }
+
+  userId, ok := subject.(float64)
+
+  if !ok {
+    return 0, errors.New("subject claim is not a number")
+  }
+
+  return uint(userId), nil
+}
+
+// sessionHasMfa tells if the session of token was authenticated
+// with more than one factor
+func sessionHasMfa( token *jwt.Token ) bool {
+  claims, ok := token.Claims.(jwt.MapClaims)
+  if !ok || !token.Valid {
+    return false
+  }
+  amr, ok := claims["amr"].([]interface{})
+  if !ok {
+    return false
+  }
+  for _, method := range amr {
+    if method == AMR_MFA {
+      return true
+    }
+  }
+  return false
+}
+
+func userFromSessionToken( ctx context.Context, token *jwt.Token ) (*models.UserModel, error) {
+  userId, err := userIdFromSessionToken( token )
+
+  if err != nil {
+    return nil, err
+  }
+
+  return backend.Users.UserById( ctx, userId )
+}
+
+// roleNamesFromSessionToken returns the names of the roles the
+// user of token has in app. Unknown users have no roles, so the
+// user itself is not loaded
+func roleNamesFromSessionToken( ctx context.Context, token *jwt.Token, app *models.AppModel ) ([]string, error) {
+  userId, err := userIdFromSessionToken( token )
+
+  if err != nil {
+    return nil, err
+  }
+
+  roles, err := backend.Roles.RolesOfUserInApp( ctx, userId, app.ID )
+
+  if err != nil {
+    return nil, err
+  }
+
+  return stores.RoleNames( roles ), nil
+}
+
+func isAdminUser( ctx context.Context, user *models.UserModel ) bool {
+  adminApp, err := backend.Apps.AppByMountPoint( ctx, globals.BASE_ADMIN_MOUNTPOINT )
+  if err != nil {
+    return false
+  }
+  roles, err := backend.Roles.RolesOfUserInApp( ctx, user.ID, adminApp.ID )
+  if err != nil {
+    return false
+  }
+  for _, role := range roles {
+    if role.Name == globals.BASE_ADMIN_ROLE {
+      return true
+    }
+  }
+  return false
+}
+
+// CurrentSession returns the session of the request. It fails with
+// globals.ErrNoSession, if there is no valid one
+func CurrentSession( c *gin.Context ) (*Session, error) {
+  tokenString := sessionTokenString( c )
+
+  if tokenString == "" {
+    return nil, globals.ErrNoSession
+  }
+
+  token, err := parseSessionToken( tokenString )
+
+  if err != nil || !token.Valid {
+    return nil, globals.ErrNoSession
+  }
+
+  userId, err := userIdFromSessionToken( token )
+
+  if err != nil {
+    return nil, globals.ErrNoSession
+  }
+
+  user, err := backend.Users.UserById( c.Request.Context(), userId )
+
+  if errors.Is( err, globals.ErrNotFound ) {
+    return nil, globals.ErrNoSession
+  }
+
+  if err != nil {
+    return nil, err
+  }
+
+  session := &Session{ User: user }
+  claims := token.Claims.(jwt.MapClaims)
+  if issuedAt, ok := claims["iat"].(float64); ok {
+    session.AuthTime = time.Unix( int64(issuedAt), 0 )
+  }
+  if amr, ok := claims["amr"].([]interface{}); ok {
+    for _, method := range amr {
+      if name, ok := method.(string); ok {
+        session.Amr = append( session.Amr, name )
+      }
+    }
+  }
+  return session, nil
+}
+
+// authenticateSession returns the user and the token of a valid
+// session. It aborts with unauthorized otherwise
+func authenticateSession( c *gin.Context ) (*models.UserModel, *jwt.Token, bool) {
+  tokenString := sessionTokenString( c )
+
+  if tokenString == "" {
+    c.AbortWithStatus(http.StatusUnauthorized)
+    return nil, nil, false
+  }
+
+  token, err := parseSessionToken( tokenString )
+
+  if err != nil {
+    c.AbortWithStatus(http.StatusUnauthorized)
+    return nil, nil, false
+  }
+
+  user, err := userFromSessionToken( c.Request.Context(), token )
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusUnauthorized)
+    return nil, nil, false
+  }
+
+  return user, token, true
+}
+
+// RequireUser only lets requests with a valid session pass. The user
+// is stored in the gin context, see UserFromContext
+func RequireUser( c *gin.Context ) {
+  user, _, ok := authenticateSession( c )
+
+  if !ok {
+    return
+  }
+
+  c.Set( userContextKey, user )
+
+  c.Next()
+}
+
+// RequireAdminUser only lets requests with a valid session of a user
+// with the admin role of the admin app pass. If mfa is required for
+// admins, the session needs a second factor. The user is stored in the
+// gin context, see UserFromContext
+func RequireAdminUser( c *gin.Context ) {
+  user, token, ok := authenticateSession( c )
+
+  if !ok {
+    return
+  }
+
+  if !isAdminUser( c.Request.Context(), user ) {
+    c.AbortWithStatus(http.StatusForbidden)
+    return
+  }
+
+  if mfa.RequiredForAdmins() && !sessionHasMfa( token ) {
+    c.Header("X-Status-Reason", globals.ErrMfaRequired.Error() )
+    c.AbortWithStatus(http.StatusForbidden)
+    return
+  }
+
+  c.Set( userContextKey, user )
+
+  c.Next()
+}
+
+// UserFromContext returns the user authenticated by RequireUser or
+// RequireAdminUser
+func UserFromContext( c *gin.Context ) *models.UserModel {
+  if value, exists := c.Get( userContextKey ); exists {
+    if user, ok := value.(*models.UserModel); ok {
+      return user
+    }
+  }
+  return nil
+}
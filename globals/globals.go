@@ -49,13 +49,77 @@ const GATEKEEPER_PORT_ENV_KEY = "GATEKEEPER_PORT"
 const KEYS_FILE_ENV_KEY = "CYPHERNODE_KEYS_FILE"
 const ACTIONS_FILE_ENV_KEY = "CYPHERNODE_ACTIONS_FILE"
 const CERT_FILE_ENV_KEY = "CYPHERNODE_CERT_FILE"
+const CNA_APPLIST_SYNC_MAX_REMOVALS_ENV_KEY = "CNA_APPLIST_SYNC_MAX_REMOVALS"
+const CNA_APPLIST_ARCHIVE_RETENTION_ENV_KEY = "CNA_APPLIST_ARCHIVE_RETENTION"
+const CNA_ROLE_GRANTS_FILE_ENV_KEY = "CNA_ROLE_GRANTS_FILE"
+const CNA_PASSWORD_MIN_LENGTH_ENV_KEY = "CNA_PASSWORD_MIN_LENGTH"
+const CNA_PASSWORD_BREACHED_FILE_ENV_KEY = "CNA_PASSWORD_BREACHED_FILE"
+const CNA_PASSWORD_HASH_ALGORITHM_ENV_KEY = "CNA_PASSWORD_HASH_ALGORITHM"
+const CNA_PASSWORD_BCRYPT_COST_ENV_KEY = "CNA_PASSWORD_BCRYPT_COST"
+const CNA_PASSWORD_ARGON2_TIME_ENV_KEY = "CNA_PASSWORD_ARGON2_TIME"
+const CNA_PASSWORD_ARGON2_MEMORY_ENV_KEY = "CNA_PASSWORD_ARGON2_MEMORY"
+const CNA_PASSWORD_ARGON2_THREADS_ENV_KEY = "CNA_PASSWORD_ARGON2_THREADS"
+const CNA_SESSION_LIFETIME_ENV_KEY = "CNA_SESSION_LIFETIME"
+const CNA_LOGIN_FREE_FAILURES_ENV_KEY = "CNA_LOGIN_FREE_FAILURES"
+const CNA_LOGIN_LOCKOUT_FAILURES_ENV_KEY = "CNA_LOGIN_LOCKOUT_FAILURES"
+const CNA_LOGIN_IP_FREE_FAILURES_ENV_KEY = "CNA_LOGIN_IP_FREE_FAILURES"
+const CNA_LOGIN_IP_LOCKOUT_FAILURES_ENV_KEY = "CNA_LOGIN_IP_LOCKOUT_FAILURES"
+const CNA_LOGIN_BACKOFF_BASE_ENV_KEY = "CNA_LOGIN_BACKOFF_BASE"
+const CNA_LOGIN_LOCKOUT_DURATION_ENV_KEY = "CNA_LOGIN_LOCKOUT_DURATION"
+const CNA_LOGIN_FAILURE_WINDOW_ENV_KEY = "CNA_LOGIN_FAILURE_WINDOW"
+const CNA_LOGIN_TRUSTED_PROXIES_ENV_KEY = "CNA_LOGIN_TRUSTED_PROXIES"
+const CNA_MFA_REQUIRED_FOR_ADMINS_ENV_KEY = "CNA_MFA_REQUIRED_FOR_ADMINS"
+const CNA_TOTP_ISSUER_ENV_KEY = "CNA_TOTP_ISSUER"
+const CNA_WEBAUTHN_RP_ID_ENV_KEY = "CNA_WEBAUTHN_RP_ID"
+const CNA_WEBAUTHN_RP_NAME_ENV_KEY = "CNA_WEBAUTHN_RP_NAME"
+const CNA_WEBAUTHN_ORIGIN_ENV_KEY = "CNA_WEBAUTHN_ORIGIN"
+const CNA_WEBAUTHN_TIMEOUT_ENV_KEY = "CNA_WEBAUTHN_TIMEOUT"
+const CNA_WEBAUTHN_MAX_CEREMONIES_ENV_KEY = "CNA_WEBAUTHN_MAX_CEREMONIES"
+const CNA_OIDC_ISSUER_ENV_KEY = "CNA_OIDC_ISSUER"
+const CNA_OIDC_SIGNING_KEY_FILE_ENV_KEY = "CNA_OIDC_SIGNING_KEY_FILE"
+const CNA_OIDC_TOKEN_LIFETIME_ENV_KEY = "CNA_OIDC_TOKEN_LIFETIME"
 
 
 const BASE_ADMIN_MOUNTPOINT string = "admin"
+const BASE_ADMIN_ROLE string = "admin"
 
 /** urls and endpoints **/
 const FORWARD_AUTH_ENDPOINTS_AUTH = "/public"
 const PROXY_GATEKEEPER_ENDPOINTS_AUTH = "/gatekeeper"
+const PROXY_GATEKEEPER_ENDPOINTS_APP_AUTH = "/gatekeeper/app"
+const INTERNAL_ENDPOINTS_BEARER = "/bearer/:keyLabel"
+const INTERNAL_ENDPOINTS_APP_GROUPS = "/apps/:appId/groups"
+const INTERNAL_ENDPOINTS_HEALTH = "/health"
+const INTERNAL_ENDPOINTS_SYNC_PLAN = "/applist/plan"
+const INTERNAL_ENDPOINTS_SYNC_PLAN_CONFIRM = "/applist/plan/confirm"
+const INTERNAL_ENDPOINTS_ARCHIVED_APPS = "/archived-apps"
+const INTERNAL_ENDPOINTS_ARCHIVED_APP = "/archived-apps/:appId"
+const INTERNAL_ENDPOINTS_POLICY_SIMULATION = "/policies/simulate"
+const INTERNAL_ENDPOINTS_USERS_IMPORT = "/users/import"
+const INTERNAL_ENDPOINTS_USERS_EXPORT = "/users/export"
+const INTERNAL_ENDPOINTS_LOGIN_THROTTLES = "/login-throttles"
+const INTERNAL_ENDPOINTS_LOGIN_THROTTLE = "/login-throttles/:kind/:key"
+const INTERNAL_ENDPOINTS_USER_TOTP = "/users/:userId/totp"
+const INTERNAL_ENDPOINTS_USER_WEBAUTHN = "/users/:userId/webauthn"
+const AUTH_ENDPOINTS_LOGIN = "/login"
+const AUTH_ENDPOINTS_TOTP = "/mfa/totp"
+const AUTH_ENDPOINTS_TOTP_CONFIRM = "/mfa/totp/confirm"
+const AUTH_ENDPOINTS_TOTP_DISABLE = "/mfa/totp/disable"
+const AUTH_ENDPOINTS_RECOVERY_CODES = "/mfa/recovery-codes"
+const AUTH_ENDPOINTS_WEBAUTHN_REGISTER = "/mfa/webauthn/register"
+const AUTH_ENDPOINTS_WEBAUTHN_REGISTER_FINISH = "/mfa/webauthn/register/finish"
+const AUTH_ENDPOINTS_WEBAUTHN_CREDENTIALS = "/mfa/webauthn/credentials"
+const AUTH_ENDPOINTS_WEBAUTHN_CREDENTIAL = "/mfa/webauthn/credentials/:credentialId"
+const AUTH_ENDPOINTS_WEBAUTHN_LOGIN = "/login/webauthn"
+const AUTH_ENDPOINTS_WEBAUTHN_LOGIN_FINISH = "/login/webauthn/finish"
+const AUTH_ENDPOINTS_OIDC = "/oidc"
+
+// below AUTH_ENDPOINTS_OIDC and the issuer
+const OIDC_ENDPOINTS_DISCOVERY = "/.well-known/openid-configuration"
+const OIDC_ENDPOINTS_JWKS = "/jwks"
+const OIDC_ENDPOINTS_AUTHORIZE = "/authorize"
+const OIDC_ENDPOINTS_TOKEN = "/token"
+const OIDC_ENDPOINTS_USERINFO = "/userinfo"
 
 const UNAUTHORIZED_REDIRECT_URL string = "/admin"
 
@@ -87,6 +151,62 @@ var DEFAULTS = map[string]string{
   GATEKEEPER_HOST_ENV_KEY:         "gatekeeper",
   GATEKEEPER_PORT_ENV_KEY:         "2009",
   CNA_SESSION_COOKIE_NAME_ENV_KEY: "io.cyphernode.session",
+  // negative values disable the safeguard
+  CNA_APPLIST_SYNC_MAX_REMOVALS_ENV_KEY: "-1",
+  // how long apps removed from the index are kept. negative values keep them forever
+  CNA_APPLIST_ARCHIVE_RETENTION_ENV_KEY: "720h",
+  // json list of {"whenRole": "admin/admin", "grant": "myapp/operator"}
+  CNA_ROLE_GRANTS_FILE_ENV_KEY: "/data/roleGrants.json",
+  CNA_PASSWORD_MIN_LENGTH_ENV_KEY: "8",
+  // optional list of forbidden passwords, one per line, checked
+  // in addition to the bundled list of common passwords
+  CNA_PASSWORD_BREACHED_FILE_ENV_KEY: "",
+  // bcrypt or argon2id. hashes made with other settings are
+  // replaced on the next successful login
+  CNA_PASSWORD_HASH_ALGORITHM_ENV_KEY: "bcrypt",
+  CNA_PASSWORD_BCRYPT_COST_ENV_KEY:    "10",
+  CNA_PASSWORD_ARGON2_TIME_ENV_KEY:    "1",
+  // in KiB
+  CNA_PASSWORD_ARGON2_MEMORY_ENV_KEY:  "65536",
+  CNA_PASSWORD_ARGON2_THREADS_ENV_KEY: "2",
+  CNA_SESSION_LIFETIME_ENV_KEY: "12h",
+  // failed logins per login and per source ip. after the free
+  // failures logins are delayed, doubling the delay with every
+  // failure, after the lockout failures they are refused for the
+  // lockout duration. failures are forgotten after the window
+  CNA_LOGIN_FREE_FAILURES_ENV_KEY:       "3",
+  CNA_LOGIN_LOCKOUT_FAILURES_ENV_KEY:    "10",
+  CNA_LOGIN_IP_FREE_FAILURES_ENV_KEY:    "10",
+  CNA_LOGIN_IP_LOCKOUT_FAILURES_ENV_KEY: "50",
+  CNA_LOGIN_BACKOFF_BASE_ENV_KEY:        "1s",
+  CNA_LOGIN_LOCKOUT_DURATION_ENV_KEY:    "15m",
+  CNA_LOGIN_FAILURE_WINDOW_ENV_KEY:      "24h",
+  // comma separated ips or cidrs of reverse proxies, like traefik,
+  // whose X-Forwarded-For tells the source ip of logins. without
+  // any, the remote address of the connection is the source ip
+  CNA_LOGIN_TRUSTED_PROXIES_ENV_KEY: "",
+  // admins without mfa only get the rights of regular users of
+  // the admin app, until they enrolled totp
+  CNA_MFA_REQUIRED_FOR_ADMINS_ENV_KEY: "false",
+  // shown in authenticator apps
+  CNA_TOTP_ISSUER_ENV_KEY: "Cyphernode",
+  // credentials are bound to the relying party id, a domain, and
+  // only accepted from pages of the origin. empty values are taken
+  // from the cookie domain and the external base url
+  CNA_WEBAUTHN_RP_ID_ENV_KEY:   "",
+  CNA_WEBAUTHN_RP_NAME_ENV_KEY: "Cyphernode",
+  CNA_WEBAUTHN_ORIGIN_ENV_KEY:  "",
+  CNA_WEBAUTHN_TIMEOUT_ENV_KEY: "5m",
+  // unfinished ceremonies kept in memory. the oldest ones are
+  // dropped when there are more
+  CNA_WEBAUTHN_MAX_CEREMONIES_ENV_KEY: "10000",
+  // cypherapps use this service as openid connect provider. an
+  // empty issuer is the external base url with /oidc. its paths
+  // have to be routed to the oidc endpoints of the auth engine
+  CNA_OIDC_ISSUER_ENV_KEY:           "",
+  // rsa key tokens are signed with. created, if missing
+  CNA_OIDC_SIGNING_KEY_FILE_ENV_KEY: "/data/oidcSigningKey.pem",
+  CNA_OIDC_TOKEN_LIFETIME_ENV_KEY:   "1h",
 }
 
 
@@ -97,6 +217,29 @@ var ErrNoSuchRole = errors.New( "no such role" )
 var ErrCannotAddExistingRole = errors.New( "cannot add existing role to app" )
 var ErrUserAlreadyHasRole = errors.New( "user already has role" )
 var ErrNoSuchApp = errors.New( "no such app" )
+var ErrNotFound = errors.New( "not found" )
 var ErrMigrationFailed = errors.New( "migration failed" )
 var ErrDatabaseNotInitialised = errors.New( "database not initialised")
-var ErrActionForbidden = errors.New( "action forbidden" )
\ No newline at end of file
+var ErrActionForbidden = errors.New( "action forbidden" )
+var ErrGroupNotRequested = errors.New( "group was not requested by app" )
+var ErrNoPendingSyncPlan = errors.New( "no sync plan waiting for confirmation" )
+var ErrSyncPlanChanged = errors.New( "sync plan changed since it was blocked" )
+var ErrSyncBlocked = errors.New( "sync exceeds the maximum number of removals and needs confirmation" )
+var ErrAppNotArchived = errors.New( "app is not archived" )
+var ErrIncompleteSimulation = errors.New( "mount point and uri are needed" )
+var ErrUnknownFormat = errors.New( "unknown format" )
+var ErrInvalidImport = errors.New( "import has invalid records" )
+var ErrPasswordTooShort = errors.New( "password is too short" )
+var ErrPasswordTooCommon = errors.New( "password is too common or known to be breached" )
+var ErrPasswordIsLogin = errors.New( "password must not be the login" )
+var ErrInvalidCredentials = errors.New( "invalid login or password" )
+var ErrLoginThrottled = errors.New( "too many failed logins, try again later" )
+var ErrUnknownLoginThrottleKind = errors.New( "unknown login throttle kind" )
+var ErrMfaRequired = errors.New( "multi factor authentication required" )
+var ErrInvalidOtp = errors.New( "invalid one time password" )
+var ErrTotpNotEnrolled = errors.New( "totp is not enrolled" )
+var ErrTotpAlreadyEnrolled = errors.New( "totp is already enrolled" )
+var ErrInvalidWebauthnResponse = errors.New( "invalid webauthn response" )
+var ErrWebauthnNotEnrolled = errors.New( "no webauthn credential registered" )
+var ErrWebauthnCredentialRegistered = errors.New( "webauthn credential is already registered" )
+var ErrNoSession = errors.New( "no valid session" )
\ No newline at end of file
@@ -129,7 +129,10 @@ func AbsoluteURLFromHost( host string, path string ) string {
 }
 
 
-func SetByJsonTag( obj interface{}, values *map[string]interface{} ) {
+// SetByJsonTag sets the fields of obj from values by their json tags.
+// Fields tagged with sbjt:"hashPassword" have to comply with the
+// password policy and are hashed before they are set
+func SetByJsonTag( obj interface{}, values *map[string]interface{} ) error {
 
   // evaluate sbjt tag actions like hashing passwords
   structType := reflect.TypeOf(obj).Elem()
@@ -144,7 +147,15 @@ func SetByJsonTag( obj interface{}, values *map[string]interface{} ) {
         switch sbjtTag {
         case "hashPassword":
           if reflect.TypeOf(jsonFieldValue).Kind() == reflect.String {
-            hashedPassword, _ := password.HashPassword(jsonFieldValue.(string))
+            login, _ := (*values)["login"].(string)
+            err := password.Check( login, jsonFieldValue.(string) )
+            if err != nil {
+              return err
+            }
+            hashedPassword, err := password.HashPassword(jsonFieldValue.(string))
+            if err != nil {
+              return err
+            }
             (*values)[jsonFieldName] = hashedPassword
           }
           break
@@ -153,8 +164,11 @@ func SetByJsonTag( obj interface{}, values *map[string]interface{} ) {
     }
   }
 
-  jsonStringBytes, _ := json.Marshal( values )
-  _ = json.Unmarshal( jsonStringBytes, obj )
+  jsonStringBytes, err := json.Marshal( values )
+  if err != nil {
+    return err
+  }
+  return json.Unmarshal( jsonStringBytes, obj )
 
 }
 
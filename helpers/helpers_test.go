@@ -27,6 +27,7 @@ package helpers_test
 import (
   "github.com/schulterklopfer/cyphernode_fauth/globals"
   "github.com/schulterklopfer/cyphernode_fauth/helpers"
+  "github.com/schulterklopfer/cyphernode_fauth/password"
   "os"
   "testing"
 )
@@ -55,9 +56,10 @@ func TestSetByJsonTag(t *testing.T) {
     "gfloat64": float64(3.0),
   }
 
-  helpers.SetByJsonTag(  &target, &newValues )
+  err := helpers.SetByJsonTag(  &target, &newValues )
 
-  if target.Aint != 2 ||
+  if err != nil ||
+      target.Aint != 2 ||
       target.Bint32 != 3 ||
       target.Cint64 != 4 ||
       target.Dstring != "bar" ||
@@ -69,6 +71,35 @@ func TestSetByJsonTag(t *testing.T) {
 
 }
 
+type testUser struct {
+  Login string `json:"login"`
+  Password string `json:"password" sbjt:"hashPassword"`
+}
+
+func TestSetByJsonTagHashesPassword(t *testing.T) {
+
+  var target testUser
+
+  err := helpers.SetByJsonTag( &target, &map[string]interface{}{ "login": "someone", "password": "correct horse battery" } )
+
+  if err != nil || !password.CheckPasswordHash( "correct horse battery", target.Password ) {
+    t.Error( "password was not hashed" )
+  }
+
+  err = helpers.SetByJsonTag( &target, &map[string]interface{}{ "login": "someone", "password": "short" } )
+
+  if err != globals.ErrPasswordTooShort {
+    t.Errorf( "expected %v, got %v", globals.ErrPasswordTooShort, err )
+  }
+
+  err = helpers.SetByJsonTag( &target, &map[string]interface{}{ "login": "SomeoneElse", "password": "someoneelse" } )
+
+  if err != globals.ErrPasswordIsLogin {
+    t.Errorf( "expected %v, got %v", globals.ErrPasswordIsLogin, err )
+  }
+
+}
+
 func TestAbsoluteURL( t *testing.T ) {
 
   _ = os.Setenv( globals.BASE_URL_EXTERNAL_ENV_KEY, "http://www.foo.com")
@@ -0,0 +1,119 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internalApi
+
+import (
+  "errors"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "net/http"
+  "strconv"
+)
+
+type appGroups struct {
+  RequestedGroups models.StringList `json:"requestedGroups"`
+  ApprovedGroups  models.StringList `json:"approvedGroups"`
+}
+
+func appFromParam( c *gin.Context ) *models.AppModel {
+  appId, err := strconv.Atoi( c.Param("appId") )
+
+  if err != nil || appId <= 0 {
+    c.AbortWithStatus(http.StatusBadRequest)
+    return nil
+  }
+
+  var app models.AppModel
+  err = queries.WithContext( c.Request.Context() ).Get( &app, uint(appId), false )
+
+  if errors.Is( err, globals.ErrNotFound ) {
+    c.AbortWithStatus(http.StatusNotFound)
+    return nil
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return nil
+  }
+
+  return &app
+}
+
+// GetAppGroups lists the gatekeeper groups an app requested
+// and the ones approved by an admin
+func GetAppGroups( c *gin.Context ) {
+  app := appFromParam( c )
+
+  if app == nil {
+    return
+  }
+
+  c.JSON( http.StatusOK, &appGroups{
+    RequestedGroups: app.RequestedGroups,
+    ApprovedGroups:  app.ApprovedGroups,
+  })
+}
+
+// ApproveAppGroups replaces the approved gatekeeper groups of an app
+func ApproveAppGroups( c *gin.Context ) {
+  app := appFromParam( c )
+
+  if app == nil {
+    return
+  }
+
+  var input appGroups
+  err := c.BindJSON( &input )
+
+  if err != nil {
+    return
+  }
+
+  err = queries.ApproveAppGroups( app, input.ApprovedGroups )
+
+  if err == globals.ErrGroupNotRequested {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusBadRequest)
+    return
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  logwrapper.Logger().Infof( "%s approved gatekeeper groups %v for app %s", forwardAuth.UserFromContext( c ).Login, app.ApprovedGroups, app.Name )
+
+  c.JSON( http.StatusOK, &appGroups{
+    RequestedGroups: app.RequestedGroups,
+    ApprovedGroups:  app.ApprovedGroups,
+  })
+}
@@ -0,0 +1,155 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internalApi_test
+
+import (
+  "encoding/json"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/internalApi"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/sirupsen/logrus"
+  "io/ioutil"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "testing"
+  "time"
+)
+
+// openDb creates the admin app and an admin user, and returns a
+// session token of the admin
+func openDb( t *testing.T ) (string, func()) {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "internalApi" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  err = dataSource.Init( "sqlite://"+filepath.Join( dir, "test.sqlite3" ) )
+  if err != nil {
+    os.RemoveAll( dir )
+    t.Fatal( err )
+  }
+
+  adminApp := &models.AppModel{ Name: "admin", Hash: "adminHash", Secret: "adminSecret", MountPoint: globals.BASE_ADMIN_MOUNTPOINT,
+    AvailableRoles: []*models.RoleModel{ { Name: globals.BASE_ADMIN_ROLE } } }
+  err = queries.CreateApp( adminApp )
+  if err != nil {
+    t.Fatal( err )
+  }
+  admin := &models.UserModel{ Login: "admin", Password: "hash", Roles: adminApp.AvailableRoles }
+  err = queries.CreateUser( admin )
+  if err != nil {
+    t.Fatal( err )
+  }
+  token, _, err := forwardAuth.MintSessionToken( admin, time.Hour, []string{ forwardAuth.AMR_PASSWORD } )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  return token, func() {
+    dataSource.Close()
+    os.RemoveAll( dir )
+  }
+}
+
+func serve( engine *gin.Engine, method string, path string, token string, body string ) *httptest.ResponseRecorder {
+  request := httptest.NewRequest( method, path, strings.NewReader( body ) )
+  request.Header.Set( "Content-Type", "application/json" )
+  if token != "" {
+    request.Header.Set( "Authorization", "Bearer "+token )
+  }
+  recorder := httptest.NewRecorder()
+  engine.ServeHTTP( recorder, request )
+  return recorder
+}
+
+func TestAppGroups(t *testing.T) {
+  token, closeDb := openDb( t )
+  defer closeDb()
+
+  app := &models.AppModel{ Name: "shop", Hash: "shopHash", Secret: "shopSecret", MountPoint: "shop",
+    RequestedGroups: models.StringList{ "stats", "watcher" } }
+  err := queries.CreateApp( app )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  engine := gin.New()
+  engine.GET( globals.INTERNAL_ENDPOINTS_APP_GROUPS, forwardAuth.RequireAdminUser, internalApi.GetAppGroups )
+  engine.PUT( globals.INTERNAL_ENDPOINTS_APP_GROUPS, forwardAuth.RequireAdminUser, internalApi.ApproveAppGroups )
+  path := strings.Replace( globals.INTERNAL_ENDPOINTS_APP_GROUPS, ":appId", strconv.Itoa( int(app.ID) ), 1 )
+
+  var groups struct {
+    RequestedGroups []string `json:"requestedGroups"`
+    ApprovedGroups  []string `json:"approvedGroups"`
+  }
+
+  response := serve( engine, "PUT", path, token, `{"approvedGroups":["stats","stats"]}` )
+  _ = json.Unmarshal( response.Body.Bytes(), &groups )
+  if response.Code != http.StatusOK || len(groups.ApprovedGroups) != 1 || groups.ApprovedGroups[0] != "stats" {
+    t.Fatalf( "expected stats to be approved once, got %d %s", response.Code, response.Body.String() )
+  }
+
+  response = serve( engine, "PUT", path, token, `{"approvedGroups":["stats","spender"]}` )
+  if response.Code != http.StatusBadRequest || response.Header().Get( "X-Status-Reason" ) != globals.ErrGroupNotRequested.Error() {
+    t.Errorf( "expected approving an unrequested group to be rejected, got %d", response.Code )
+  }
+
+  response = serve( engine, "GET", path, token, "" )
+  _ = json.Unmarshal( response.Body.Bytes(), &groups )
+  if response.Code != http.StatusOK || len(groups.RequestedGroups) != 2 || len(groups.ApprovedGroups) != 1 || groups.ApprovedGroups[0] != "stats" {
+    t.Errorf( "expected a rejected approval to keep the approved groups, got %d %s", response.Code, response.Body.String() )
+  }
+
+  if err := queries.ApproveAppGroups( app, []string{ "spender" } ); err != globals.ErrGroupNotRequested {
+    t.Errorf( "expected %v, got %v", globals.ErrGroupNotRequested, err )
+  }
+
+  cases := []struct {
+    method string
+    path   string
+    token  string
+    status int
+  }{
+    {"GET", path, "", http.StatusUnauthorized},
+    {"GET", "/apps/99/groups", token, http.StatusNotFound},
+    {"GET", "/apps/shop/groups", token, http.StatusBadRequest},
+    {"PUT", "/apps/99/groups", token, http.StatusNotFound},
+  }
+
+  for _, testCase := range cases {
+    if status := serve( engine, testCase.method, testCase.path, testCase.token, `{"approvedGroups":[]}` ).Code; status != testCase.status {
+      t.Errorf( "%s %s: expected %d, got %d", testCase.method, testCase.path, testCase.status, status )
+    }
+  }
+}
@@ -0,0 +1,75 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internalApi
+
+import (
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/appList"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "net/http"
+)
+
+// GetSyncPlan shows the app list sync plan waiting for confirmation
+// or a dry run of the currently loaded installed apps index
+func GetSyncPlan( c *gin.Context ) {
+  plan, err := appList.Get().Plan()
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  c.JSON( http.StatusOK, plan )
+}
+
+// ConfirmSyncPlan applies a blocked app list sync plan
+func ConfirmSyncPlan( c *gin.Context ) {
+  err := appList.Get().Confirm()
+
+  if err == globals.ErrNoPendingSyncPlan {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusNotFound)
+    return
+  }
+
+  if err == globals.ErrSyncPlanChanged {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusConflict)
+    return
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  logwrapper.Logger().Infof( "%s confirmed app list sync plan", forwardAuth.UserFromContext( c ).Login )
+
+  c.Status(http.StatusOK)
+}
@@ -0,0 +1,87 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internalApi
+
+import (
+  "errors"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/appList"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "net/http"
+  "strconv"
+)
+
+// GetArchivedApps lists apps removed from the installed apps index
+// which are not purged yet
+func GetArchivedApps( c *gin.Context ) {
+  var apps []*models.AppModel
+  err := queries.ArchivedApps( &apps )
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  c.JSON( http.StatusOK, apps )
+}
+
+// PurgeArchivedApp deletes an archived app, its roles and their
+// assignments for good and responds with what was removed
+func PurgeArchivedApp( c *gin.Context ) {
+  appId, err := strconv.Atoi( c.Param("appId") )
+
+  if err != nil || appId <= 0 {
+    c.AbortWithStatus(http.StatusBadRequest)
+    return
+  }
+
+  report, err := appList.Get().Purge( uint(appId) )
+
+  if errors.Is( err, globals.ErrNoSuchApp ) {
+    c.AbortWithStatus(http.StatusNotFound)
+    return
+  }
+
+  if err == globals.ErrAppNotArchived {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusConflict)
+    return
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  logwrapper.Logger().Infof( "%s purged archived app %d", forwardAuth.UserFromContext( c ).Login, appId )
+
+  c.JSON( http.StatusOK, report )
+}
@@ -0,0 +1,110 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internalApi_test
+
+import (
+  "encoding/json"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/appList"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/internalApi"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "net/http"
+  "strconv"
+  "strings"
+  "testing"
+)
+
+func TestArchivedApps(t *testing.T) {
+  token, closeDb := openDb( t )
+  defer closeDb()
+
+  // there is no installed apps index here. purging does not need one
+  _ = appList.Init( "" )
+
+  shop := &models.AppModel{ Name: "shop", Hash: "shopHash", Secret: "shopSecret", MountPoint: "shop",
+    AvailableRoles: []*models.RoleModel{ { Name: "customer" } } }
+  blog := &models.AppModel{ Name: "blog", Hash: "blogHash", Secret: "blogSecret", MountPoint: "blog" }
+  for _, app := range []*models.AppModel{ shop, blog } {
+    if err := queries.CreateApp( app ); err != nil {
+      t.Fatal( err )
+    }
+  }
+  alice := &models.UserModel{ Login: "alice", Password: "hash", Roles: shop.AvailableRoles }
+  if err := queries.CreateUser( alice ); err != nil {
+    t.Fatal( err )
+  }
+  if err := queries.ArchiveApp( shop ); err != nil {
+    t.Fatal( err )
+  }
+
+  engine := gin.New()
+  engine.GET( globals.INTERNAL_ENDPOINTS_ARCHIVED_APPS, forwardAuth.RequireAdminUser, internalApi.GetArchivedApps )
+  engine.DELETE( globals.INTERNAL_ENDPOINTS_ARCHIVED_APP, forwardAuth.RequireAdminUser, internalApi.PurgeArchivedApp )
+  archivedApp := func( app *models.AppModel ) string {
+    return strings.Replace( globals.INTERNAL_ENDPOINTS_ARCHIVED_APP, ":appId", strconv.Itoa( int(app.ID) ), 1 )
+  }
+
+  var archivedApps []*models.AppModel
+  response := serve( engine, "GET", globals.INTERNAL_ENDPOINTS_ARCHIVED_APPS, token, "" )
+  _ = json.Unmarshal( response.Body.Bytes(), &archivedApps )
+  if response.Code != http.StatusOK || len(archivedApps) != 1 || archivedApps[0].ID != shop.ID {
+    t.Fatalf( "expected shop to be listed, got %d %s", response.Code, response.Body.String() )
+  }
+
+  cases := []struct {
+    path   string
+    status int
+  }{
+    {"/archived-apps/shop", http.StatusBadRequest},
+    {"/archived-apps/99", http.StatusNotFound},
+    // only archived apps can be purged
+    {archivedApp( blog ), http.StatusConflict},
+  }
+
+  for _, testCase := range cases {
+    if status := serve( engine, "DELETE", testCase.path, token, "" ).Code; status != testCase.status {
+      t.Errorf( "%s: expected %d, got %d", testCase.path, testCase.status, status )
+    }
+  }
+
+  // new roles are given to the admins as well
+  var report queries.DeleteReport
+  response = serve( engine, "DELETE", archivedApp( shop ), token, "" )
+  _ = json.Unmarshal( response.Body.Bytes(), &report )
+  if response.Code != http.StatusOK || len(report.Apps) != 1 || len(report.Roles) != 1 || report.Assignments != 2 {
+    t.Errorf( "expected shop, its role and the assignments to alice and the admin to be purged, got %d %s", response.Code, response.Body.String() )
+  }
+
+  response = serve( engine, "GET", globals.INTERNAL_ENDPOINTS_ARCHIVED_APPS, token, "" )
+  if response.Code != http.StatusOK || strings.TrimSpace( response.Body.String() ) != "[]" {
+    t.Errorf( "expected no archived apps to be left, got %d %s", response.Code, response.Body.String() )
+  }
+  if serve( engine, "DELETE", archivedApp( shop ), token, "" ).Code != http.StatusNotFound {
+    t.Error( "expected purged apps to be gone" )
+  }
+}
@@ -0,0 +1,72 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internalApi
+
+import (
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/cyphernodeKeys"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "net/http"
+)
+
+type bearerResponse struct {
+  Bearer    string `json:"bearer"`
+  ExpiresIn int    `json:"expiresIn"`
+}
+
+// MintBearer creates a short lived gatekeeper bearer token for a key label.
+// Needs to run after forwardAuth.ForwardAppAuth and only hands out bearers
+// for key labels assigned to the authenticated app, so cypherapps never
+// need to know the raw cyphernode api keys.
+func MintBearer( c *gin.Context ) {
+  app := forwardAuth.AppFromContext( c )
+
+  if app == nil {
+    c.AbortWithStatus(http.StatusUnauthorized)
+    return
+  }
+
+  keyLabel := c.Param("keyLabel")
+
+  if keyLabel == "" || !app.KeyLabels.Contains( keyLabel ) {
+    logwrapper.Logger().Warnf( "app %s requested bearer for key label %s which is not assigned to it", app.Name, keyLabel )
+    c.AbortWithStatus(http.StatusForbidden)
+    return
+  }
+
+  bearer, err := forwardAuth.Keys().BearerFromKey( keyLabel )
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusNotFound)
+    return
+  }
+
+  c.JSON( http.StatusOK, &bearerResponse{
+    Bearer:    bearer,
+    ExpiresIn: cyphernodeKeys.BEARER_LIFETIME_SECONDS,
+  })
+}
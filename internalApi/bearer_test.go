@@ -0,0 +1,133 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internalApi_test
+
+import (
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/hex"
+  "encoding/json"
+  "github.com/dgrijalva/jwt-go"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/cyphernodeKeys"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/internalApi"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/stores"
+  "gorm.io/gorm"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+)
+
+const appSecret = "00112233445566778899aabbccddeeff"
+
+func init() {
+  gin.SetMode( gin.TestMode )
+}
+
+func appToken( appId uint ) string {
+  secret, _ := hex.DecodeString( appSecret )
+  token, _ := jwt.NewWithClaims( jwt.SigningMethodHS256, jwt.MapClaims{ "id": appId } ).SignedString( secret )
+  return token
+}
+
+func TestMintBearer(t *testing.T) {
+  keys := stores.NewMemoryKeys()
+  keys.AddKey( "000", "aaaa", "stats" )
+  keys.AddKey( "003", "cccc", "stats", "spender" )
+  keys.AddAction( "getinfo", "stats" )
+
+  memory := stores.NewMemory()
+  memory.AddApp( &models.AppModel{ Model: gorm.Model{ ID: 2 }, MountPoint: "app", Secret: appSecret, KeyLabels: models.StringList{ "000", "002" } } )
+  forwardAuth.UseStores( stores.InMemory( memory, keys ) )
+  defer forwardAuth.UseStores( stores.Database() )
+
+  engine := gin.New()
+  engine.GET( globals.INTERNAL_ENDPOINTS_BEARER, forwardAuth.ForwardAppAuth, internalApi.MintBearer )
+
+  mint := func( keyLabel string, token string ) *httptest.ResponseRecorder {
+    request := httptest.NewRequest( "GET", strings.Replace( globals.INTERNAL_ENDPOINTS_BEARER, ":keyLabel", keyLabel, 1 ), nil )
+    if token != "" {
+      request.Header.Set( "Authorization", "Bearer "+token )
+    }
+    recorder := httptest.NewRecorder()
+    engine.ServeHTTP( recorder, request )
+    return recorder
+  }
+
+  response := mint( "000", appToken( 2 ) )
+  var minted struct {
+    Bearer    string `json:"bearer"`
+    ExpiresIn int    `json:"expiresIn"`
+  }
+  _ = json.Unmarshal( response.Body.Bytes(), &minted )
+  if response.Code != http.StatusOK || minted.ExpiresIn != cyphernodeKeys.BEARER_LIFETIME_SECONDS {
+    t.Fatalf( "expected a bearer living %d seconds, got %d %s", cyphernodeKeys.BEARER_LIFETIME_SECONDS, response.Code, response.Body.String() )
+  }
+
+  parts := strings.Split( strings.TrimPrefix( minted.Bearer, "Bearer " ), "." )
+  if len(parts) != 3 {
+    t.Fatalf( "expected a jwt, got %s", minted.Bearer )
+  }
+  h := hmac.New( sha256.New, []byte("aaaa") )
+  h.Write( []byte(parts[0]+"."+parts[1]) )
+  if hex.EncodeToString( h.Sum(nil) ) != parts[2] {
+    t.Error( "expected the bearer to be signed with the key of the label" )
+  }
+  payload, _ := base64.StdEncoding.DecodeString( parts[1] )
+  var claims struct {
+    Id  string `json:"id"`
+    Exp int64  `json:"exp"`
+  }
+  _ = json.Unmarshal( payload, &claims )
+  expiresAt := time.Now().Unix()+cyphernodeKeys.BEARER_LIFETIME_SECONDS
+  if claims.Id != "000" || claims.Exp < expiresAt-2 || claims.Exp > expiresAt {
+    t.Errorf( "expected a bearer for 000 expiring in %d seconds, got %s", cyphernodeKeys.BEARER_LIFETIME_SECONDS, payload )
+  }
+
+  cases := []struct {
+    keyLabel string
+    token    string
+    status   int
+  }{
+    // assigned to the app but unknown to the key store
+    {"002", appToken( 2 ), http.StatusNotFound},
+    // known to the key store but not assigned to the app
+    {"003", appToken( 2 ), http.StatusForbidden},
+    {"000", appToken( 99 ), http.StatusUnauthorized},
+    {"000", "", http.StatusUnauthorized},
+  }
+
+  for _, testCase := range cases {
+    if status := mint( testCase.keyLabel, testCase.token ).Code; status != testCase.status {
+      t.Errorf( "%s: expected %d, got %d", testCase.keyLabel, testCase.status, status )
+    }
+  }
+}
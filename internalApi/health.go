@@ -0,0 +1,60 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internalApi
+
+import (
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/appList"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "net/http"
+)
+
+type health struct {
+  Healthy       bool            `json:"healthy"`
+  DatabaseError string          `json:"databaseError,omitempty"`
+  AppList       *appList.Health `json:"appList,omitempty"`
+}
+
+// GetHealth reports database connectivity and the state of the app list sync
+func GetHealth( c *gin.Context ) {
+  h := &health{ Healthy: true }
+
+  if err := dataSource.Ping(); err != nil {
+    h.Healthy = false
+    h.DatabaseError = err.Error()
+  }
+
+  if appList.Get() != nil {
+    h.AppList = appList.Get().Health()
+    h.Healthy = h.Healthy && h.AppList.IsHealthy()
+  }
+
+  if !h.Healthy {
+    c.JSON( http.StatusServiceUnavailable, h )
+    return
+  }
+
+  c.JSON( http.StatusOK, h )
+}
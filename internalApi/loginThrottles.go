@@ -0,0 +1,76 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internalApi
+
+import (
+  "errors"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/loginThrottle"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "net/http"
+)
+
+// GetLoginThrottles lists the logins and ips which are refused
+// logins right now
+func GetLoginThrottles( c *gin.Context ) {
+  throttles, err := loginThrottle.Blocked()
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  c.JSON( http.StatusOK, throttles )
+}
+
+// UnlockLogin forgets the failed logins of a login or an ip, so
+// logins are accepted again right away
+func UnlockLogin( c *gin.Context ) {
+  kind := c.Param("kind")
+
+  if !models.IsLoginThrottleKind( kind ) {
+    c.Header("X-Status-Reason", globals.ErrUnknownLoginThrottleKind.Error() )
+    c.AbortWithStatus(http.StatusBadRequest)
+    return
+  }
+
+  err := loginThrottle.Unlock( kind, c.Param("key"), forwardAuth.UserFromContext( c ).Login )
+
+  if errors.Is( err, globals.ErrNotFound ) {
+    c.AbortWithStatus(http.StatusNotFound)
+    return
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,111 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internalApi_test
+
+import (
+  "encoding/json"
+  "github.com/SatoshiPortal/cam/storage"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/internalApi"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/policy"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "net/http"
+  "testing"
+)
+
+func TestSimulatePolicies(t *testing.T) {
+  token, closeDb := openDb( t )
+  defer closeDb()
+
+  shop := &models.AppModel{ Name: "shop", Hash: "shopHash", Secret: "shopSecret", MountPoint: "shop",
+    AvailableRoles: []*models.RoleModel{ { Name: "viewer" } },
+    AccessPolicies: models.AccessPolicies{
+      { AccessPolicy: storage.AccessPolicy{ Effect: "allow", Patterns: []string{"^/"}, Roles: []string{"viewer"}, Actions: []string{"get"} } },
+      { AccessPolicy: storage.AccessPolicy{ Effect: "deny", Patterns: []string{"^/admin"}, Roles: []string{"*"}, Actions: []string{"*"} } },
+    },
+  }
+  if err := queries.CreateApp( shop ); err != nil {
+    t.Fatal( err )
+  }
+  alice := &models.UserModel{ Login: "alice", Password: "hash", Roles: shop.AvailableRoles }
+  if err := queries.CreateUser( alice ); err != nil {
+    t.Fatal( err )
+  }
+
+  engine := gin.New()
+  engine.POST( globals.INTERNAL_ENDPOINTS_POLICY_SIMULATION, forwardAuth.RequireAdminUser, internalApi.SimulatePolicies )
+
+  simulate := func( body string ) (int, *policy.SimulationResult) {
+    response := serve( engine, "POST", globals.INTERNAL_ENDPOINTS_POLICY_SIMULATION, token, body )
+    var result policy.SimulationResult
+    _ = json.Unmarshal( response.Body.Bytes(), &result )
+    return response.Code, &result
+  }
+
+  status, result := simulate( `{"mountPoint":"shop","login":"alice","method":"GET","uri":"/products"}` )
+  if status != http.StatusOK || !result.Decision.Allowed || result.Decision.PolicyIndex != 0 ||
+    len(result.Roles) != 1 || result.Roles[0] != "viewer" {
+    t.Fatalf( "expected alice to be allowed by policy #0, got %d %+v", status, result.Decision )
+  }
+  // every policy is explained
+  if len(result.Decision.Evaluations) != 2 || !result.Decision.Evaluations[0].Matched || result.Decision.Evaluations[1].Matched ||
+    result.Decision.Evaluations[1].Reason == "" {
+    t.Errorf( "expected both policies to be explained, got %+v", result.Decision.Evaluations )
+  }
+
+  status, result = simulate( `{"mountPoint":"shop","roles":["viewer"],"method":"GET","uri":"/admin/settings"}` )
+  if status != http.StatusOK || result.Decision.Allowed || result.Decision.PolicyIndex != 1 ||
+    result.Policy == nil || result.Policy.Effect != policy.EFFECT_DENY {
+    t.Errorf( "expected the deny policy #1 to decide, got %d %+v", status, result.Decision )
+  }
+
+  status, result = simulate( `{"mountPoint":"shop","method":"GET","uri":"/products"}` )
+  if status != http.StatusOK || result.Decision.Allowed || result.Decision.PolicyIndex != -1 || result.Roles != nil {
+    t.Errorf( "expected anonymous requests to be refused, got %d %+v", status, result.Decision )
+  }
+
+  cases := []struct {
+    body   string
+    status int
+  }{
+    {`{"mountPoint":"blog","roles":["viewer"],"method":"GET","uri":"/"}`, http.StatusNotFound},
+    {`{"mountPoint":"shop","login":"bob","method":"GET","uri":"/"}`, http.StatusNotFound},
+    {`{"mountPoint":"shop","method":"GET"}`, http.StatusBadRequest},
+    {`{"mountPoint":`, http.StatusBadRequest},
+  }
+
+  for _, testCase := range cases {
+    if status, _ := simulate( testCase.body ); status != testCase.status {
+      t.Errorf( "%s: expected %d, got %d", testCase.body, testCase.status, status )
+    }
+  }
+
+  if serve( engine, "POST", globals.INTERNAL_ENDPOINTS_POLICY_SIMULATION, "", `{}` ).Code != http.StatusUnauthorized {
+    t.Error( "simulations need an admin session" )
+  }
+}
@@ -0,0 +1,180 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package internalApi
+
+import (
+  "bytes"
+  "errors"
+  "github.com/gin-gonic/gin"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/mfa"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/schulterklopfer/cyphernode_fauth/userTransfer"
+  "net/http"
+  "strconv"
+)
+
+var contentTypes = map[string]string{
+  userTransfer.FORMAT_CSV:  "text/csv; charset=utf-8",
+  userTransfer.FORMAT_JSON: "application/json; charset=utf-8",
+}
+
+// ImportUsers creates and updates users from a csv or json body,
+// see userTransfer.Import. Roles are only added, never removed.
+// ?dryRun=true only validates the body
+func ImportUsers( c *gin.Context ) {
+  records, err := userTransfer.Decode( c.Request.Body, c.DefaultQuery( "format", userTransfer.FORMAT_JSON ) )
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusBadRequest)
+    return
+  }
+
+  dryRun := c.Query( "dryRun" ) == "true"
+  report, err := userTransfer.Import( records, dryRun )
+
+  if err == globals.ErrInvalidImport {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatusJSON( http.StatusUnprocessableEntity, report )
+    return
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  if !dryRun {
+    logwrapper.Logger().Infof( "%s imported users, %d created, %d updated", forwardAuth.UserFromContext( c ).Login, len(report.Created), len(report.Updated) )
+  }
+
+  c.JSON( http.StatusOK, report )
+}
+
+// ExportUsers responds with all users in csv or json. Password
+// hashes are only included with ?passwordHashes=true
+func ExportUsers( c *gin.Context ) {
+  format := c.DefaultQuery( "format", userTransfer.FORMAT_JSON )
+  contentType, ok := contentTypes[format]
+
+  if !ok {
+    c.Header("X-Status-Reason", globals.ErrUnknownFormat.Error() )
+    c.AbortWithStatus(http.StatusBadRequest)
+    return
+  }
+
+  withPasswordHashes := c.Query( "passwordHashes" ) == "true"
+  records, err := userTransfer.Export( withPasswordHashes )
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  var body bytes.Buffer
+  err = userTransfer.Encode( &body, format, records )
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  if withPasswordHashes {
+    logwrapper.Logger().Infof( "%s exported users with password hashes", forwardAuth.UserFromContext( c ).Login )
+  }
+
+  c.Data( http.StatusOK, contentType, body.Bytes() )
+}
+
+// ResetTotp removes totp and the recovery codes of a user, who lost
+// the device
+func ResetTotp( c *gin.Context ) {
+  userId, err := strconv.Atoi( c.Param("userId") )
+
+  if err != nil || userId <= 0 {
+    c.AbortWithStatus(http.StatusBadRequest)
+    return
+  }
+
+  var user models.UserModel
+  err = queries.Get( &user, uint(userId), false )
+
+  if err == nil {
+    err = mfa.Reset( &user, forwardAuth.UserFromContext( c ).Login )
+  }
+
+  if errors.Is( err, globals.ErrNotFound ) {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusNotFound)
+    return
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  c.Status(http.StatusNoContent)
+}
+
+// ResetWebauthn removes all webauthn credentials of a user, who lost
+// the authenticators
+func ResetWebauthn( c *gin.Context ) {
+  userId, err := strconv.Atoi( c.Param("userId") )
+
+  if err != nil || userId <= 0 {
+    c.AbortWithStatus(http.StatusBadRequest)
+    return
+  }
+
+  var user models.UserModel
+  err = queries.Get( &user, uint(userId), false )
+
+  if err == nil {
+    err = mfa.ResetWebauthn( &user, forwardAuth.UserFromContext( c ).Login )
+  }
+
+  if errors.Is( err, globals.ErrNotFound ) || err == globals.ErrWebauthnNotEnrolled {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusNotFound)
+    return
+  }
+
+  if err != nil {
+    c.Header("X-Status-Reason", err.Error() )
+    c.AbortWithStatus(http.StatusInternalServerError)
+    return
+  }
+
+  c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,273 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package loginThrottle
+
+import (
+  "errors"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/sirupsen/logrus"
+  "net"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+)
+
+// Limits tell how many failed logins are tolerated. After FreeFailures
+// logins are delayed with an exponential backoff, after
+// LockoutFailures they are refused for the lockout duration
+type Limits struct {
+  FreeFailures    int
+  LockoutFailures int
+}
+
+// Config of the throttling. Failures older than FailureWindow are
+// forgotten. X-Forwarded-For is only believed when the request comes
+// from one of the TrustedProxies
+type Config struct {
+  Login           Limits
+  Ip              Limits
+  BackoffBase     time.Duration
+  LockoutDuration time.Duration
+  FailureWindow   time.Duration
+  TrustedProxies  []*net.IPNet
+}
+
+// many users may share the ip of a proxy or a nat, so ips get
+// more failures than logins
+var DefaultConfig = Config{
+  Login:           Limits{ FreeFailures: 3, LockoutFailures: 10 },
+  Ip:              Limits{ FreeFailures: 10, LockoutFailures: 50 },
+  BackoffBase:     time.Second,
+  LockoutDuration: 15*time.Minute,
+  FailureWindow:   24*time.Hour,
+}
+
+var config = DefaultConfig
+
+// serializes read, count and save of throttles
+var mutex sync.Mutex
+
+func Configure( newConfig Config ) {
+  mutex.Lock()
+  defer mutex.Unlock()
+  config = newConfig
+}
+
+// ClientIP returns the ip failures of request are counted for. It is
+// the remote address, unless that is a trusted proxy. Then it is the
+// last address in X-Forwarded-For not added by a trusted proxy, since
+// the client itself may send any X-Forwarded-For
+func ClientIP( request *http.Request ) string {
+  mutex.Lock()
+  trustedProxies := config.TrustedProxies
+  mutex.Unlock()
+
+  ip, _, err := net.SplitHostPort( strings.TrimSpace( request.RemoteAddr ) )
+  if err != nil {
+    ip = strings.TrimSpace( request.RemoteAddr )
+  }
+
+  if !isTrusted( ip, trustedProxies ) {
+    return ip
+  }
+
+  forwardedFor := strings.Split( strings.Join( request.Header[http.CanonicalHeaderKey( "X-Forwarded-For" )], "," ), "," )
+  for i := len(forwardedFor)-1; i >= 0; i-- {
+    forwarded := strings.TrimSpace( forwardedFor[i] )
+    if net.ParseIP( forwarded ) == nil {
+      break
+    }
+    ip = forwarded
+    if !isTrusted( ip, trustedProxies ) {
+      break
+    }
+  }
+  return ip
+}
+
+// Check returns how long logins of login from ip are refused. Zero
+// means a login may be tried now
+func Check( login string, ip string ) (time.Duration, error) {
+  mutex.Lock()
+  defer mutex.Unlock()
+
+  login = loginKey( login )
+  now := time.Now()
+  var retryAfter time.Duration
+
+  for _, throttle := range []struct{ kind, key string }{
+    { models.LOGIN_THROTTLE_KIND_LOGIN, login },
+    { models.LOGIN_THROTTLE_KIND_IP, ip },
+  } {
+    if throttle.key == "" {
+      continue
+    }
+    existing, err := queries.LoginThrottle( throttle.kind, throttle.key )
+    if err != nil {
+      return 0, err
+    }
+    if wait := existing.BlockedUntil.Sub( now ); wait > retryAfter {
+      retryAfter = wait
+    }
+  }
+
+  if retryAfter > 0 {
+    logwrapper.Audit( logwrapper.AUDIT_LOGIN_REFUSED, logrus.Fields{
+      "login": login, "ip": ip, "retryAfter": retryAfter.String(),
+    })
+  }
+
+  return retryAfter, nil
+}
+
+// Failure counts a failed login of login from ip
+func Failure( login string, ip string ) error {
+  mutex.Lock()
+  defer mutex.Unlock()
+
+  login = loginKey( login )
+  now := time.Now()
+  logwrapper.Audit( logwrapper.AUDIT_LOGIN_FAILED, logrus.Fields{ "login": login, "ip": ip } )
+
+  err := countFailure( models.LOGIN_THROTTLE_KIND_LOGIN, login, config.Login, now )
+  if err != nil {
+    return err
+  }
+  return countFailure( models.LOGIN_THROTTLE_KIND_IP, ip, config.Ip, now )
+}
+
+// Success forgets the failed logins of login. The failures of ip are
+// kept, otherwise one known password would allow guessing others
+func Success( login string, ip string ) error {
+  mutex.Lock()
+  defer mutex.Unlock()
+
+  login = loginKey( login )
+  logwrapper.Audit( logwrapper.AUDIT_LOGIN_SUCCEEDED, logrus.Fields{ "login": login, "ip": ip } )
+
+  err := queries.DeleteLoginThrottle( models.LOGIN_THROTTLE_KIND_LOGIN, login )
+  if err != nil && !errors.Is( err, globals.ErrNotFound ) {
+    return err
+  }
+  return nil
+}
+
+// Unlock forgets the failed logins of key, so logins are accepted
+// again right away
+func Unlock( kind string, key string, unlockedBy string ) error {
+  mutex.Lock()
+  defer mutex.Unlock()
+
+  if kind == models.LOGIN_THROTTLE_KIND_LOGIN {
+    key = loginKey( key )
+  }
+
+  err := queries.DeleteLoginThrottle( kind, key )
+  if err != nil {
+    return err
+  }
+
+  logwrapper.Audit( logwrapper.AUDIT_LOGIN_UNLOCKED, logrus.Fields{ kind: key, "by": unlockedBy } )
+  return nil
+}
+
+// Blocked lists the throttles refusing logins right now
+func Blocked() ([]*models.LoginThrottleModel, error) {
+  throttles := make( []*models.LoginThrottleModel, 0 )
+  err := queries.BlockedLoginThrottles( &throttles, time.Now() )
+  return throttles, err
+}
+
+// loginKey is the key failures of login are counted for. Logins
+// differing in case or surrounding spaces share it, so they cannot
+// be used to escape the throttle
+func loginKey( login string ) string {
+  return strings.ToLower( strings.TrimSpace( login ) )
+}
+
+func isTrusted( ip string, trustedProxies []*net.IPNet ) bool {
+  parsed := net.ParseIP( ip )
+  if parsed == nil {
+    return false
+  }
+  for _, trusted := range trustedProxies {
+    if trusted.Contains( parsed ) {
+      return true
+    }
+  }
+  return false
+}
+
+func countFailure( kind string, key string, limits Limits, now time.Time ) error {
+  if key == "" {
+    return nil
+  }
+
+  throttle, err := queries.LoginThrottle( kind, key )
+  if err != nil {
+    return err
+  }
+
+  if now.Sub( throttle.LastFailureAt ) > config.FailureWindow {
+    throttle.Failures = 0
+    throttle.LockedOut = false
+  }
+
+  throttle.Failures++
+  throttle.LastFailureAt = now
+  throttle.BlockedUntil = now.Add( blockFor( throttle.Failures, limits ) )
+
+  if throttle.Failures >= limits.LockoutFailures {
+    throttle.LockedOut = true
+    logwrapper.Audit( logwrapper.AUDIT_LOGIN_LOCKED_OUT, logrus.Fields{
+      kind: key, "failures": throttle.Failures, "until": throttle.BlockedUntil,
+    })
+  }
+
+  return queries.SaveLoginThrottle( throttle )
+}
+
+// blockFor doubles the delay with every failure after the free ones
+// until the lockout duration is reached
+func blockFor( failures int, limits Limits ) time.Duration {
+  if failures >= limits.LockoutFailures {
+    return config.LockoutDuration
+  }
+  if failures <= limits.FreeFailures {
+    return 0
+  }
+  delay := config.BackoffBase
+  for i := limits.FreeFailures+1; i < failures && delay < config.LockoutDuration; i++ {
+    delay *= 2
+  }
+  if delay > config.LockoutDuration {
+    return config.LockoutDuration
+  }
+  return delay
+}
@@ -0,0 +1,222 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package loginThrottle_test
+
+import (
+  "errors"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/loginThrottle"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/sirupsen/logrus"
+  "io/ioutil"
+  "net"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+)
+
+var testConfig = loginThrottle.Config{
+  Login:           loginThrottle.Limits{ FreeFailures: 2, LockoutFailures: 5 },
+  Ip:              loginThrottle.Limits{ FreeFailures: 3, LockoutFailures: 8 },
+  BackoffBase:     time.Minute,
+  LockoutDuration: time.Hour,
+  FailureWindow:   24*time.Hour,
+}
+
+func openDb( t *testing.T ) (string, func()) {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "loginThrottle" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  dsn := "sqlite://"+filepath.Join( dir, "test.sqlite3" )
+  err = dataSource.Init( dsn )
+  if err != nil {
+    os.RemoveAll( dir )
+    t.Fatal( err )
+  }
+  loginThrottle.Configure( testConfig )
+  return dsn, func() {
+    loginThrottle.Configure( loginThrottle.DefaultConfig )
+    dataSource.Close()
+    os.RemoveAll( dir )
+  }
+}
+
+func fail( t *testing.T, login string, ip string, times int ) {
+  for i := 0; i < times; i++ {
+    err := loginThrottle.Failure( login, ip )
+    if err != nil {
+      t.Fatal( err )
+    }
+  }
+}
+
+func waitFor( t *testing.T, login string, ip string ) time.Duration {
+  retryAfter, err := loginThrottle.Check( login, ip )
+  if err != nil {
+    t.Fatal( err )
+  }
+  return retryAfter
+}
+
+func TestBackoffAndLockout(t *testing.T) {
+  _, closeDb := openDb( t )
+  defer closeDb()
+
+  fail( t, "alice", "10.0.0.1", 2 )
+  if waitFor( t, "alice", "10.0.0.1" ) != 0 {
+    t.Error( "free failures must not delay logins" )
+  }
+
+  expected := []time.Duration{ time.Minute, 2*time.Minute }
+  for _, delay := range expected {
+    fail( t, "alice", "10.0.0.2", 1 )
+    retryAfter := waitFor( t, "alice", "" )
+    if retryAfter <= delay-time.Second || retryAfter > delay {
+      t.Errorf( "expected a delay of %s, got %s", delay, retryAfter )
+    }
+  }
+
+  fail( t, "alice", "10.0.0.3", 1 )
+  if waitFor( t, "alice", "" ) <= 4*time.Minute-time.Second {
+    t.Error( "delay must double with every failure" )
+  }
+
+  fail( t, "alice", "10.0.0.3", 1 )
+  blocked, err := loginThrottle.Blocked()
+  if err != nil {
+    t.Fatal( err )
+  }
+  if len(blocked) != 1 || blocked[0].Key != "alice" || !blocked[0].LockedOut {
+    t.Fatalf( "expected alice to be locked out, got %v", blocked )
+  }
+  if waitFor( t, "alice", "" ) <= time.Hour-time.Second {
+    t.Error( "locked out logins must wait for the lockout duration" )
+  }
+
+  if waitFor( t, "bob", "10.0.0.1" ) != 0 {
+    t.Error( "other logins must not be throttled" )
+  }
+
+  err = loginThrottle.Success( "alice", "10.0.0.1" )
+  if err != nil || waitFor( t, "alice", "" ) != 0 {
+    t.Errorf( "success must forget the failures of alice, got %v", err )
+  }
+}
+
+func TestIpThrottle(t *testing.T) {
+  _, closeDb := openDb( t )
+  defer closeDb()
+
+  for _, login := range []string{ "a", "b", "c", "d", "e", "f", "g", "h" } {
+    fail( t, login, "10.0.0.1", 1 )
+  }
+
+  if waitFor( t, "someone", "10.0.0.1" ) <= time.Hour-time.Second {
+    t.Error( "ip must be locked out after failures of many logins" )
+  }
+  if waitFor( t, "someone", "10.0.0.2" ) != 0 {
+    t.Error( "other ips must not be throttled" )
+  }
+
+  err := loginThrottle.Success( "someone", "10.0.0.1" )
+  if err != nil || waitFor( t, "", "10.0.0.1" ) == 0 {
+    t.Error( "success must not forget the failures of the ip" )
+  }
+
+  err = loginThrottle.Unlock( models.LOGIN_THROTTLE_KIND_IP, "10.0.0.1", "admin" )
+  if err != nil || waitFor( t, "someone", "10.0.0.1" ) != 0 {
+    t.Errorf( "unlocked ip must not be throttled, got %v", err )
+  }
+
+  err = loginThrottle.Unlock( models.LOGIN_THROTTLE_KIND_IP, "10.0.0.1", "admin" )
+  if !errors.Is( err, globals.ErrNotFound ) {
+    t.Errorf( "expected not found, got %v", err )
+  }
+}
+
+func TestLoginKey(t *testing.T) {
+  _, closeDb := openDb( t )
+  defer closeDb()
+
+  fail( t, "Alice", "", 2 )
+  fail( t, " alice ", "", 1 )
+  if waitFor( t, "ALICE", "" ) == 0 {
+    t.Error( "logins differing in case and spaces must share their failures" )
+  }
+
+  err := loginThrottle.Success( "alice", "" )
+  if err != nil || waitFor( t, "Alice", "" ) != 0 {
+    t.Errorf( "success must forget the failures of all spellings, got %v", err )
+  }
+}
+
+func TestClientIP(t *testing.T) {
+  _, traefik, _ := net.ParseCIDR( "172.16.0.0/12" )
+  config := testConfig
+  config.TrustedProxies = []*net.IPNet{ traefik }
+  loginThrottle.Configure( config )
+  defer loginThrottle.Configure( loginThrottle.DefaultConfig )
+
+  for _, test := range []struct{ remoteAddr, forwardedFor, ip string }{
+    { "10.0.0.1:1234", "", "10.0.0.1" },
+    { "10.0.0.1:1234", "1.2.3.4", "10.0.0.1" },
+    { "172.16.0.2:1234", "", "172.16.0.2" },
+    { "172.16.0.2:1234", "10.0.0.1", "10.0.0.1" },
+    { "172.16.0.2:1234", "1.2.3.4, 10.0.0.1", "10.0.0.1" },
+    { "172.16.0.2:1234", "1.2.3.4, 10.0.0.1, 172.16.0.3", "10.0.0.1" },
+    { "172.16.0.2:1234", "garbage", "172.16.0.2" },
+  } {
+    request := httptest.NewRequest( "POST", "/login", nil )
+    request.RemoteAddr = test.remoteAddr
+    if test.forwardedFor != "" {
+      request.Header.Set( "X-Forwarded-For", test.forwardedFor )
+    }
+    if ip := loginThrottle.ClientIP( request ); ip != test.ip {
+      t.Errorf( "%s forwarded for %q: expected %s, got %s", test.remoteAddr, test.forwardedFor, test.ip, ip )
+    }
+  }
+}
+
+func TestPersistence(t *testing.T) {
+  dsn, closeDb := openDb( t )
+  defer closeDb()
+
+  fail( t, "alice", "", 5 )
+  dataSource.Close()
+
+  err := dataSource.Init( dsn )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if waitFor( t, "alice", "" ) <= time.Hour-time.Second {
+    t.Error( "lockout must survive restarts" )
+  }
+}
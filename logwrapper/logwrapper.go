@@ -75,4 +75,23 @@ func InvalidArgValue(argumentName string, argumentValue string) {
 // MissingArg is a standard error message
 func  MissingArg(argumentName string) {
   standardLogger.Errorf(missingArgMessage.message, argumentName)
-}
\ No newline at end of file
+}
+// audit events. they are logged with the field event, so they can be
+// filtered from the rest of the log
+const AUDIT_LOGIN_SUCCEEDED = "login_succeeded"
+const AUDIT_LOGIN_FAILED = "login_failed"
+const AUDIT_LOGIN_REFUSED = "login_refused"
+const AUDIT_LOGIN_LOCKED_OUT = "login_locked_out"
+const AUDIT_LOGIN_UNLOCKED = "login_unlocked"
+const AUDIT_TOTP_ENROLLED = "totp_enrolled"
+const AUDIT_TOTP_DISABLED = "totp_disabled"
+const AUDIT_RECOVERY_CODES_CREATED = "recovery_codes_created"
+const AUDIT_RECOVERY_CODE_USED = "recovery_code_used"
+const AUDIT_WEBAUTHN_REGISTERED = "webauthn_registered"
+const AUDIT_WEBAUTHN_REMOVED = "webauthn_removed"
+const AUDIT_WEBAUTHN_CLONED = "webauthn_cloned"
+
+// Audit logs a security relevant event with fields
+func Audit( event string, fields logrus.Fields ) {
+  Logger().WithFields( fields ).WithField( "event", event ).Info( "audit" )
+}
@@ -25,20 +25,189 @@
 package main
 
 import (
+  "fmt"
+  "github.com/schulterklopfer/cyphernode_fauth/backup"
   "github.com/schulterklopfer/cyphernode_fauth/cyphernodeFAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
   "github.com/schulterklopfer/cyphernode_fauth/globals"
   "github.com/schulterklopfer/cyphernode_fauth/helpers"
   "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/migrations"
   "github.com/sirupsen/logrus"
   "log"
   "net/http"
   "os"
+  "strconv"
   _ "net/http/pprof"
 )
 
+const MIGRATE_USAGE = "usage: cyphernode_fauth migrate status | up [version] | down [steps]"
+const BACKUP_USAGE = "usage: cyphernode_fauth backup [--keys] <file>"
+const RESTORE_USAGE = "usage: cyphernode_fauth restore [--keys] <file>"
+
+// runMigrate handles "migrate status", "migrate up [version]" and
+// "migrate down [steps]". up without a version applies everything,
+// down without steps reverts the last applied migration
+func runMigrate( args []string ) error {
+  if len(args) == 0 {
+    return fmt.Errorf( MIGRATE_USAGE )
+  }
+
+  number := 0
+  if len(args) > 1 {
+    var err error
+    number, err = strconv.Atoi( args[1] )
+    if err != nil || number < 0 {
+      return fmt.Errorf( MIGRATE_USAGE )
+    }
+  }
+
+  err := dataSource.Open( helpers.GetenvOrDefault(globals.CNA_ADMIN_DATABASE_DSN_ENV_KEY ) )
+  if err != nil {
+    return err
+  }
+  defer dataSource.Close()
+
+  db := dataSource.GetDB()
+
+  switch args[0] {
+  case "status":
+    statuses, err := migrations.Status( db )
+    if err != nil {
+      return err
+    }
+    for _, status := range statuses {
+      appliedAt := "pending"
+      if status.Applied {
+        appliedAt = status.AppliedAt.Format( "2006-01-02 15:04:05" )
+      }
+      fmt.Printf( "%4d  %-30s  %s\n", status.Version, status.Name, appliedAt )
+    }
+  case "up":
+    applied, err := migrations.Up( db, number )
+    for _, migration := range applied {
+      fmt.Printf( "applied %d %s\n", migration.Version, migration.Name )
+    }
+    if err != nil {
+      return err
+    }
+  case "down":
+    if number == 0 {
+      number = 1
+    }
+    reverted, err := migrations.Down( db, number )
+    for _, migration := range reverted {
+      fmt.Printf( "reverted %d %s\n", migration.Version, migration.Name )
+    }
+    if err != nil {
+      return err
+    }
+  default:
+    return fmt.Errorf( MIGRATE_USAGE )
+  }
+  return nil
+}
+
+// backupArgs parses "[--keys] <file>". --keys includes the
+// gatekeeper's keys and actions files
+func backupArgs( args []string, usage string ) (*backup.Options, string, error) {
+  options := &backup.Options{
+    KeysFile:    helpers.GetenvOrDefault( globals.KEYS_FILE_ENV_KEY ),
+    ActionsFile: helpers.GetenvOrDefault( globals.ACTIONS_FILE_ENV_KEY ),
+  }
+  if len(args) > 0 && args[0] == "--keys" {
+    options.WithKeys = true
+    args = args[1:]
+  }
+  if len(args) != 1 || args[0] == "" {
+    return nil, "", fmt.Errorf( usage )
+  }
+  return options, args[0], nil
+}
+
+// runBackup handles "backup [--keys] <file>". Existing files
+// are never overwritten
+func runBackup( args []string ) error {
+  options, path, err := backupArgs( args, BACKUP_USAGE )
+  if err != nil {
+    return err
+  }
+
+  err = dataSource.Open( helpers.GetenvOrDefault(globals.CNA_ADMIN_DATABASE_DSN_ENV_KEY ) )
+  if err != nil {
+    return err
+  }
+  defer dataSource.Close()
+
+  file, err := os.OpenFile( path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600 )
+  if err != nil {
+    return err
+  }
+
+  manifest, err := backup.Write( dataSource.GetDB(), file, options )
+  if err == nil {
+    err = file.Close()
+  } else {
+    file.Close()
+  }
+  if err != nil {
+    os.Remove( path )
+    return err
+  }
+
+  fmt.Printf( "wrote backup of schema version %d to %s\n", manifest.SchemaVersion, path )
+  return nil
+}
+
+// runRestore handles "restore [--keys] <file>". The database is
+// migrated first, so backups of older versions can be restored.
+// Stop the server before, since everything in the database is replaced
+func runRestore( args []string ) error {
+  options, path, err := backupArgs( args, RESTORE_USAGE )
+  if err != nil {
+    return err
+  }
+
+  file, err := os.Open( path )
+  if err != nil {
+    return err
+  }
+  defer file.Close()
+
+  err = dataSource.Init( helpers.GetenvOrDefault(globals.CNA_ADMIN_DATABASE_DSN_ENV_KEY ) )
+  if err != nil {
+    return err
+  }
+  defer dataSource.Close()
+
+  manifest, err := backup.Restore( dataSource.GetDB(), file, options )
+  if err != nil {
+    return err
+  }
+
+  fmt.Printf( "restored backup of schema version %d made at %s\n", manifest.SchemaVersion, manifest.CreatedAt.Format( "2006-01-02 15:04:05" ) )
+  return nil
+}
+
+var commands = map[string]func( args []string ) error{
+  "migrate": runMigrate,
+  "backup":  runBackup,
+  "restore": runRestore,
+}
 
 func main() {
 
+  if len(os.Args) > 1 {
+    if command, ok := commands[os.Args[1]]; ok {
+      err := command( os.Args[2:] )
+      if err != nil {
+        println("Error in "+os.Args[1]+": ", err.Error() )
+        os.Exit(1)
+      }
+      return
+    }
+  }
+
   go func() {
     log.Println( http.ListenAndServe("localhost:6060", nil))
   }()
@@ -0,0 +1,275 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package mfa
+
+import (
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base32"
+  "encoding/hex"
+  "errors"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/helpers"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/schulterklopfer/cyphernode_fauth/totp"
+  "github.com/sirupsen/logrus"
+  "strings"
+  "time"
+)
+
+const RECOVERY_CODE_COUNT = 10
+
+// Enrollment is shown to the user once, usually as qr code of URI
+type Enrollment struct {
+  Secret string `json:"secret"`
+  URI    string `json:"uri"`
+}
+
+// RequiredForAdmins tells if users with the admin role of the admin
+// app need a second factor to use it
+func RequiredForAdmins() bool {
+  return helpers.GetenvOrDefault( globals.CNA_MFA_REQUIRED_FOR_ADMINS_ENV_KEY ) == "true"
+}
+
+// second factors
+const METHOD_TOTP = "totp"
+const METHOD_WEBAUTHN = "webauthn"
+
+// Enrolled tells if the user has a confirmed totp secret or a
+// webauthn credential
+func Enrolled( userId uint ) (bool, error) {
+  methods, err := Methods( userId )
+  return len(methods) > 0, err
+}
+
+// Methods returns the second factors the user enrolled
+func Methods( userId uint ) ([]string, error) {
+  var methods []string
+
+  userTotp, err := queries.TotpOfUser( userId )
+  if err != nil && !errors.Is( err, globals.ErrNotFound ) {
+    return nil, err
+  }
+  if err == nil && userTotp.Confirmed {
+    methods = append( methods, METHOD_TOTP )
+  }
+
+  credentials, err := queries.WebauthnCredentialsOfUser( userId )
+  if err != nil {
+    return nil, err
+  }
+  if len(credentials) > 0 {
+    methods = append( methods, METHOD_WEBAUTHN )
+  }
+
+  return methods, nil
+}
+
+// Enroll creates a new totp secret for user. It is not checked at
+// login until it is confirmed with Confirm. Enrolling again before
+// replaces the secret
+func Enroll( user *models.UserModel ) (*Enrollment, error) {
+  userTotp, err := queries.TotpOfUser( user.ID )
+  if err != nil && !errors.Is( err, globals.ErrNotFound ) {
+    return nil, err
+  }
+  if userTotp != nil && userTotp.Confirmed {
+    return nil, globals.ErrTotpAlreadyEnrolled
+  }
+  if userTotp == nil {
+    userTotp = &models.TotpModel{ UserId: user.ID }
+  }
+
+  secret, err := totp.GenerateSecret()
+  if err != nil {
+    return nil, err
+  }
+  userTotp.Secret = secret
+  userTotp.LastStep = 0
+
+  err = queries.SaveTotp( userTotp )
+  if err != nil {
+    return nil, err
+  }
+
+  return &Enrollment{
+    Secret: secret,
+    URI:    totp.ProvisioningURI( helpers.GetenvOrDefault( globals.CNA_TOTP_ISSUER_ENV_KEY ), user.Login, secret ),
+  }, nil
+}
+
+// Confirm activates the totp secret of user, if code is valid, and
+// returns new recovery codes. They are shown to the user only once
+func Confirm( user *models.UserModel, code string ) ([]string, error) {
+  userTotp, err := queries.TotpOfUser( user.ID )
+  if errors.Is( err, globals.ErrNotFound ) {
+    return nil, globals.ErrTotpNotEnrolled
+  }
+  if err != nil {
+    return nil, err
+  }
+  if userTotp.Confirmed {
+    return nil, globals.ErrTotpAlreadyEnrolled
+  }
+
+  now := time.Now()
+  step, ok := totp.Validate( userTotp.Secret, code, now, userTotp.LastStep )
+  if !ok {
+    return nil, globals.ErrInvalidOtp
+  }
+
+  var codes []string
+  err = queries.Transaction( func( q *queries.Queries ) error {
+    userTotp.Confirmed = true
+    userTotp.ConfirmedAt = &now
+    userTotp.LastStep = step
+    err := q.SaveTotp( userTotp )
+    if err != nil {
+      return err
+    }
+    codes, err = replaceRecoveryCodes( q, user.ID )
+    return err
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  logwrapper.Audit( logwrapper.AUDIT_TOTP_ENROLLED, logrus.Fields{ "login": user.Login } )
+  return codes, nil
+}
+
+// Verify checks a totp code or an unused recovery code of user.
+// Every code is accepted only once
+func Verify( user *models.UserModel, code string ) error {
+  userTotp, err := queries.TotpOfUser( user.ID )
+  if errors.Is( err, globals.ErrNotFound ) {
+    return globals.ErrTotpNotEnrolled
+  }
+  if err != nil {
+    return err
+  }
+  if !userTotp.Confirmed {
+    return globals.ErrTotpNotEnrolled
+  }
+
+  if step, ok := totp.Validate( userTotp.Secret, code, time.Now(), userTotp.LastStep ); ok {
+    used, err := queries.UseTotpStep( userTotp, step )
+    if err != nil {
+      return err
+    }
+    if !used {
+      return globals.ErrInvalidOtp
+    }
+    return nil
+  }
+
+  used, err := queries.UseRecoveryCode( user.ID, hashRecoveryCode( code ) )
+  if err != nil {
+    return err
+  }
+  if !used {
+    return globals.ErrInvalidOtp
+  }
+
+  left, err := queries.UnusedRecoveryCodes( user.ID )
+  if err != nil {
+    return err
+  }
+  logwrapper.Audit( logwrapper.AUDIT_RECOVERY_CODE_USED, logrus.Fields{ "login": user.Login, "left": left } )
+  return nil
+}
+
+// Disable removes the totp secret and the recovery codes of user
+// after checking code
+func Disable( user *models.UserModel, code string ) error {
+  err := Verify( user, code )
+  if err != nil {
+    return err
+  }
+  return Reset( user, user.Login )
+}
+
+// Reset removes the totp secret and the recovery codes of user
+// without any check. Admins use it for users who lost their device
+func Reset( user *models.UserModel, resetBy string ) error {
+  err := queries.DeleteTotp( user.ID )
+  if err != nil {
+    return err
+  }
+  logwrapper.Audit( logwrapper.AUDIT_TOTP_DISABLED, logrus.Fields{ "login": user.Login, "by": resetBy } )
+  return nil
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of user after
+// checking code
+func RegenerateRecoveryCodes( user *models.UserModel, code string ) ([]string, error) {
+  err := Verify( user, code )
+  if err != nil {
+    return nil, err
+  }
+  return replaceRecoveryCodes( queries.Default(), user.ID )
+}
+
+func replaceRecoveryCodes( q *queries.Queries, userId uint ) ([]string, error) {
+  codes := make( []string, RECOVERY_CODE_COUNT )
+  codeHashes := make( []string, RECOVERY_CODE_COUNT )
+  for i := range codes {
+    code, err := newRecoveryCode()
+    if err != nil {
+      return nil, err
+    }
+    codes[i] = code
+    codeHashes[i] = hashRecoveryCode( code )
+  }
+
+  err := q.ReplaceRecoveryCodes( userId, codeHashes )
+  if err != nil {
+    return nil, err
+  }
+
+  logwrapper.Audit( logwrapper.AUDIT_RECOVERY_CODES_CREATED, logrus.Fields{ "userId": userId } )
+  return codes, nil
+}
+
+// recovery codes look like abcde-fghij and carry 50 random bits
+func newRecoveryCode() (string, error) {
+  random := make( []byte, 10 )
+  _, err := rand.Read( random )
+  if err != nil {
+    return "", err
+  }
+  code := strings.ToLower( base32.StdEncoding.EncodeToString( random ) )[:10]
+  return code[:5]+"-"+code[5:], nil
+}
+
+// recovery codes are random, so a fast hash is good enough.
+// dashes, spaces and case don't matter
+func hashRecoveryCode( code string ) string {
+  normalised := strings.ToLower( strings.NewReplacer( "-", "", " ", "" ).Replace( code ) )
+  sum := sha256.Sum256( []byte(normalised) )
+  return hex.EncodeToString( sum[:] )
+}
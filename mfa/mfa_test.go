@@ -0,0 +1,239 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package mfa_test
+
+import (
+  "errors"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/mfa"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/schulterklopfer/cyphernode_fauth/test_helpers"
+  "github.com/schulterklopfer/cyphernode_fauth/totp"
+  "github.com/schulterklopfer/cyphernode_fauth/webauthn"
+  "github.com/sirupsen/logrus"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+)
+
+func openDb( t *testing.T ) func() {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "mfa" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  err = dataSource.Init( "sqlite://"+filepath.Join( dir, "test.sqlite3" ) )
+  if err != nil {
+    os.RemoveAll( dir )
+    t.Fatal( err )
+  }
+  return func() {
+    dataSource.Close()
+    os.RemoveAll( dir )
+  }
+}
+
+func code( t *testing.T, secret string, offset int64 ) string {
+  code, err := totp.Code( secret, totp.Step( time.Now() )+offset )
+  if err != nil {
+    t.Fatal( err )
+  }
+  return code
+}
+
+func TestTotp(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  user := &models.UserModel{ Login: "alice", Password: "hash" }
+  err := queries.CreateUser( user )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  if err := mfa.Verify( user, "123456" ); err != globals.ErrTotpNotEnrolled {
+    t.Errorf( "expected %v, got %v", globals.ErrTotpNotEnrolled, err )
+  }
+
+  enrollment, err := mfa.Enroll( user )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  enrolled, _ := mfa.Enrolled( user.ID )
+  if enrolled {
+    t.Error( "unconfirmed totp must not count as enrolled" )
+  }
+
+  if _, err := mfa.Confirm( user, "000000" ); err != globals.ErrInvalidOtp {
+    t.Errorf( "expected %v, got %v", globals.ErrInvalidOtp, err )
+  }
+
+  recoveryCodes, err := mfa.Confirm( user, code( t, enrollment.Secret, -1 ) )
+  if err != nil || len(recoveryCodes) != mfa.RECOVERY_CODE_COUNT {
+    t.Fatalf( "expected %d recovery codes, got %v %v", mfa.RECOVERY_CODE_COUNT, recoveryCodes, err )
+  }
+
+  enrolled, _ = mfa.Enrolled( user.ID )
+  if !enrolled {
+    t.Error( "confirmed totp must count as enrolled" )
+  }
+  if _, err := mfa.Enroll( user ); err != globals.ErrTotpAlreadyEnrolled {
+    t.Errorf( "expected %v, got %v", globals.ErrTotpAlreadyEnrolled, err )
+  }
+
+  if err := mfa.Verify( user, code( t, enrollment.Secret, -1 ) ); err != globals.ErrInvalidOtp {
+    t.Error( "code used to confirm must not be accepted again" )
+  }
+  current := code( t, enrollment.Secret, 0 )
+  if err := mfa.Verify( user, current ); err != nil {
+    t.Errorf( "current code must be accepted, got %v", err )
+  }
+  if err := mfa.Verify( user, current ); err != globals.ErrInvalidOtp {
+    t.Error( "codes must not be replayed" )
+  }
+
+  if err := mfa.Verify( user, recoveryCodes[0] ); err != nil {
+    t.Errorf( "recovery code must be accepted, got %v", err )
+  }
+  if err := mfa.Verify( user, recoveryCodes[0] ); err != globals.ErrInvalidOtp {
+    t.Error( "recovery codes must only be used once" )
+  }
+
+  newCodes, err := mfa.RegenerateRecoveryCodes( user, recoveryCodes[1] )
+  if err != nil || len(newCodes) != mfa.RECOVERY_CODE_COUNT {
+    t.Fatalf( "expected new recovery codes, got %v", err )
+  }
+  if err := mfa.Verify( user, recoveryCodes[2] ); err != globals.ErrInvalidOtp {
+    t.Error( "old recovery codes must be replaced" )
+  }
+
+  if err := mfa.Disable( user, "000000" ); err != globals.ErrInvalidOtp {
+    t.Errorf( "disabling needs a valid code, got %v", err )
+  }
+  if err := mfa.Disable( user, newCodes[0] ); err != nil {
+    t.Fatal( err )
+  }
+  enrolled, _ = mfa.Enrolled( user.ID )
+  if enrolled {
+    t.Error( "disabled totp must not count as enrolled" )
+  }
+}
+
+func TestWebauthn(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  user := &models.UserModel{ Login: "alice", Password: "hash" }
+  err := queries.CreateUser( user )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  if _, _, err := mfa.BeginWebauthnVerification( user ); err != globals.ErrWebauthnNotEnrolled {
+    t.Errorf( "expected %v, got %v", globals.ErrWebauthnNotEnrolled, err )
+  }
+
+  authenticator := test_helpers.NewSoftwareAuthenticator( webauthn.DefaultConfig.Origin )
+  ceremonyId, options, err := mfa.BeginWebauthnRegistration( user )
+  if err != nil {
+    t.Fatal( err )
+  }
+  response, err := authenticator.Create( options )
+  if err != nil {
+    t.Fatal( err )
+  }
+  credential, err := mfa.FinishWebauthnRegistration( user, ceremonyId, "yubikey", response )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  methods, _ := mfa.Methods( user.ID )
+  if len(methods) != 1 || methods[0] != mfa.METHOD_WEBAUTHN {
+    t.Errorf( "expected webauthn as second factor, got %v", methods )
+  }
+
+  _, options, _ = mfa.BeginWebauthnRegistration( user )
+  if len(options.ExcludeCredentials) != 1 {
+    t.Error( "registered credentials must be excluded" )
+  }
+  if _, err := authenticator.Create( options ); err == nil {
+    t.Error( "authenticator must not register twice" )
+  }
+
+  clone := authenticator.Clone()
+  verify := func( authenticator *test_helpers.SoftwareAuthenticator ) error {
+    ceremonyId, options, err := mfa.BeginWebauthnVerification( user )
+    if err != nil {
+      return err
+    }
+    response, err := authenticator.Get( options )
+    if err != nil {
+      return err
+    }
+    return mfa.VerifyWebauthn( user, ceremonyId, response )
+  }
+
+  if err := verify( authenticator ); err != nil {
+    t.Fatalf( "credential must be accepted, got %v", err )
+  }
+  if err := verify( clone ); !errors.Is( err, globals.ErrInvalidWebauthnResponse ) {
+    t.Errorf( "cloned credential must be refused, got %v", err )
+  }
+
+  bob := &models.UserModel{ Login: "bob", Password: "hash" }
+  err = queries.CreateUser( bob )
+  if err != nil {
+    t.Fatal( err )
+  }
+  loginCeremonyId, loginOptions, _ := mfa.BeginWebauthnLogin()
+  assertion, _ := authenticator.Get( loginOptions )
+  if err := mfa.VerifyWebauthn( bob, loginCeremonyId, assertion ); !errors.Is( err, globals.ErrInvalidWebauthnResponse ) {
+    t.Errorf( "credential of another user must be refused, got %v", err )
+  }
+
+  loginCeremonyId, loginOptions, _ = mfa.BeginWebauthnLogin()
+  assertion, _ = authenticator.Get( loginOptions )
+  passkeyUser, err := mfa.FinishWebauthnLogin( loginCeremonyId, assertion )
+  if err != nil || passkeyUser.ID != user.ID {
+    t.Errorf( "expected passkey of alice, got %v %v", passkeyUser, err )
+  }
+
+  if err := mfa.DeleteWebauthnCredential( bob, credential.ID ); !errors.Is( err, globals.ErrNotFound ) {
+    t.Errorf( "credential of another user must not be deleted, got %v", err )
+  }
+  if err := mfa.ResetWebauthn( user, "admin" ); err != nil {
+    t.Fatal( err )
+  }
+  enrolled, _ := mfa.Enrolled( user.ID )
+  if enrolled {
+    t.Error( "reset webauthn must not count as enrolled" )
+  }
+}
@@ -0,0 +1,229 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package mfa
+
+import (
+  "encoding/base64"
+  "encoding/binary"
+  "encoding/hex"
+  "errors"
+  "fmt"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/schulterklopfer/cyphernode_fauth/webauthn"
+  "github.com/sirupsen/logrus"
+)
+
+// BeginWebauthnRegistration starts registering a webauthn credential
+// of user. Authenticators already registered are excluded
+func BeginWebauthnRegistration( user *models.UserModel ) (string, *webauthn.CreationOptions, error) {
+  credentials, err := queries.WebauthnCredentialsOfUser( user.ID )
+  if err != nil {
+    return "", nil, err
+  }
+
+  displayName := user.Name
+  if displayName == "" {
+    displayName = user.Login
+  }
+
+  return webauthn.BeginRegistration( webauthn.UserEntity{
+    Id:          userHandle( user.ID ),
+    Name:        user.Login,
+    DisplayName: displayName,
+  }, credentialIds( credentials ) )
+}
+
+// FinishWebauthnRegistration checks the response of the authenticator
+// and stores the new credential of user with name
+func FinishWebauthnRegistration( user *models.UserModel, ceremonyId string, name string, response *webauthn.RegistrationResponse ) (*models.WebauthnCredentialModel, error) {
+  registration, err := webauthn.FinishRegistration( ceremonyId, userHandle( user.ID ), response )
+  if err != nil {
+    return nil, fmt.Errorf( "%w: %s", globals.ErrInvalidWebauthnResponse, err.Error() )
+  }
+
+  credentialId := base64.RawURLEncoding.EncodeToString( registration.CredentialId )
+  _, err = queries.WebauthnCredential( credentialId )
+  if err == nil {
+    return nil, globals.ErrWebauthnCredentialRegistered
+  }
+  if !errors.Is( err, globals.ErrNotFound ) {
+    return nil, err
+  }
+
+  credential := &models.WebauthnCredentialModel{
+    UserId:       user.ID,
+    CredentialId: credentialId,
+    PublicKey:    registration.PublicKey,
+    SignCount:    registration.SignCount,
+    Aaguid:       formatAaguid( registration.Aaguid ),
+    Name:         name,
+  }
+  err = queries.CreateWebauthnCredential( credential )
+  if err != nil {
+    return nil, err
+  }
+
+  logwrapper.Audit( logwrapper.AUDIT_WEBAUTHN_REGISTERED, logrus.Fields{ "login": user.Login, "credential": credential.ID, "name": name } )
+  return credential, nil
+}
+
+// BeginWebauthnVerification starts checking a webauthn credential of
+// user as second factor
+func BeginWebauthnVerification( user *models.UserModel ) (string, *webauthn.RequestOptions, error) {
+  credentials, err := queries.WebauthnCredentialsOfUser( user.ID )
+  if err != nil {
+    return "", nil, err
+  }
+  if len(credentials) == 0 {
+    return "", nil, globals.ErrWebauthnNotEnrolled
+  }
+  // the password is the first factor already
+  return webauthn.BeginAuthentication( userHandle( user.ID ), credentialIds( credentials ), webauthn.USER_VERIFICATION_DISCOURAGED )
+}
+
+// VerifyWebauthn checks the response of the authenticator to a
+// ceremony started with BeginWebauthnVerification
+func VerifyWebauthn( user *models.UserModel, ceremonyId string, response *webauthn.AuthenticationResponse ) error {
+  _, err := verifyAssertion( user, ceremonyId, response )
+  return err
+}
+
+// BeginWebauthnLogin starts a passwordless login. The user is
+// identified by the passkey and has to be verified by the
+// authenticator, so the login counts as multi factor
+func BeginWebauthnLogin() (string, *webauthn.RequestOptions, error) {
+  return webauthn.BeginAuthentication( nil, nil, webauthn.USER_VERIFICATION_REQUIRED )
+}
+
+// FinishWebauthnLogin checks the response of the authenticator to a
+// ceremony started with BeginWebauthnLogin and returns the user of
+// the passkey
+func FinishWebauthnLogin( ceremonyId string, response *webauthn.AuthenticationResponse ) (*models.UserModel, error) {
+  credential, err := verifyAssertion( nil, ceremonyId, response )
+  if err != nil {
+    return nil, err
+  }
+
+  var user models.UserModel
+  err = queries.Get( &user, credential.UserId, false )
+  if err != nil {
+    return nil, err
+  }
+  return &user, nil
+}
+
+// DeleteWebauthnCredential removes the webauthn credential with id
+// of user
+func DeleteWebauthnCredential( user *models.UserModel, id uint ) error {
+  err := queries.DeleteWebauthnCredential( user.ID, id )
+  if err != nil {
+    return err
+  }
+  logwrapper.Audit( logwrapper.AUDIT_WEBAUTHN_REMOVED, logrus.Fields{ "login": user.Login, "credential": id, "by": user.Login } )
+  return nil
+}
+
+// ResetWebauthn removes all webauthn credentials of user without any
+// check. Admins use it for users who lost their authenticators
+func ResetWebauthn( user *models.UserModel, resetBy string ) error {
+  count, err := queries.DeleteWebauthnCredentials( user.ID )
+  if err != nil {
+    return err
+  }
+  if count == 0 {
+    return globals.ErrWebauthnNotEnrolled
+  }
+  logwrapper.Audit( logwrapper.AUDIT_WEBAUTHN_REMOVED, logrus.Fields{ "login": user.Login, "credentials": count, "by": resetBy } )
+  return nil
+}
+
+// verifyAssertion checks the response of an authenticator and stores
+// the new signature counter of the credential. With user, only
+// credentials of user are accepted
+func verifyAssertion( user *models.UserModel, ceremonyId string, response *webauthn.AuthenticationResponse ) (*models.WebauthnCredentialModel, error) {
+  credential, err := queries.WebauthnCredential( base64.RawURLEncoding.EncodeToString( response.RawId ) )
+  if errors.Is( err, globals.ErrNotFound ) {
+    return nil, fmt.Errorf( "%w: %s", globals.ErrInvalidWebauthnResponse, err.Error() )
+  }
+  if err != nil {
+    return nil, err
+  }
+  if user != nil && credential.UserId != user.ID {
+    return nil, fmt.Errorf( "%w: credential of another user", globals.ErrInvalidWebauthnResponse )
+  }
+
+  assertion, err := webauthn.FinishAuthentication( ceremonyId, response, &webauthn.Credential{
+    Id:         response.RawId,
+    PublicKey:  credential.PublicKey,
+    SignCount:  credential.SignCount,
+    UserHandle: userHandle( credential.UserId ),
+  })
+  if errors.Is( err, webauthn.ErrSignCountNotIncreased ) {
+    logwrapper.Audit( logwrapper.AUDIT_WEBAUTHN_CLONED, logrus.Fields{ "userId": credential.UserId, "credential": credential.ID } )
+  }
+  if err != nil {
+    return nil, fmt.Errorf( "%w: %s", globals.ErrInvalidWebauthnResponse, err.Error() )
+  }
+
+  used, err := queries.UseWebauthnCredential( credential, assertion.SignCount )
+  if err != nil {
+    return nil, err
+  }
+  if !used {
+    return nil, fmt.Errorf( "%w: %s", globals.ErrInvalidWebauthnResponse, webauthn.ErrSignCountNotIncreased.Error() )
+  }
+  return credential, nil
+}
+
+// the user handle of a user in webauthn ceremonies is its id
+func userHandle( userId uint ) []byte {
+  handle := make( []byte, 8 )
+  binary.BigEndian.PutUint64( handle, uint64(userId) )
+  return handle
+}
+
+func credentialIds( credentials []*models.WebauthnCredentialModel ) [][]byte {
+  ids := make( [][]byte, 0, len(credentials) )
+  for _, credential := range credentials {
+    id, err := base64.RawURLEncoding.DecodeString( credential.CredentialId )
+    if err != nil {
+      continue
+    }
+    ids = append( ids, id )
+  }
+  return ids
+}
+
+// formatAaguid formats the authenticator model id like a uuid
+func formatAaguid( aaguid []byte ) string {
+  if len(aaguid) != 16 {
+    return ""
+  }
+  encoded := hex.EncodeToString( aaguid )
+  return encoded[:8]+"-"+encoded[8:12]+"-"+encoded[12:16]+"-"+encoded[16:20]+"-"+encoded[20:]
+}
@@ -0,0 +1,260 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package migrations
+
+import (
+  "crypto/sha256"
+  "encoding/hex"
+  "errors"
+  "fmt"
+  "gorm.io/gorm"
+  "sort"
+  "time"
+)
+
+var ErrChecksumMismatch = errors.New( "applied migration does not match its definition" )
+var ErrUnknownMigration = errors.New( "database has migrations this binary does not know" )
+var ErrIrreversibleMigration = errors.New( "migration cannot be reverted" )
+
+// Migration is a single versioned step of the database schema.
+// Versions have to be unique and must never change once released.
+// Fingerprint describes what the step does, like the snapshots or
+// statements it applies, so changed steps are detected
+type Migration struct {
+  Version     int
+  Name        string
+  Fingerprint string
+  Up          func( tx *gorm.DB ) error
+  Down        func( tx *gorm.DB ) error
+}
+
+// the checksum covers version, name and fingerprint, so renamed,
+// renumbered, reordered or changed migrations are detected
+func (migration *Migration) Checksum() string {
+  sum := sha256.Sum256( []byte( fmt.Sprintf( "%d:%s:%s", migration.Version, migration.Name, migration.Fingerprint ) ) )
+  return hex.EncodeToString( sum[:] )
+}
+
+type schemaMigration struct {
+  Version   int       `gorm:"primaryKey;autoIncrement:false"`
+  Name      string
+  Checksum  string
+  AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string {
+  return "schema_migrations"
+}
+
+type MigrationStatus struct {
+  Version   int        `json:"version"`
+  Name      string     `json:"name"`
+  Applied   bool       `json:"applied"`
+  AppliedAt *time.Time `json:"appliedAt,omitempty"`
+}
+
+func byVersion( migrations []*Migration ) []*Migration {
+  sorted := make( []*Migration, len(migrations) )
+  copy( sorted, migrations )
+  sort.Slice( sorted, func( i, j int ) bool {
+    return sorted[i].Version < sorted[j].Version
+  })
+  return sorted
+}
+
+func find( migrations []*Migration, version int ) *Migration {
+  for _, migration := range migrations {
+    if migration.Version == version {
+      return migration
+    }
+  }
+  return nil
+}
+
+// loads the applied migrations and checks them against their definitions
+func applied( db *gorm.DB, migrations []*Migration ) (map[int]*schemaMigration, error) {
+  err := db.Migrator().AutoMigrate( &schemaMigration{} )
+  if err != nil {
+    return nil, err
+  }
+
+  var rows []*schemaMigration
+  err = db.Order( "version" ).Find( &rows ).Error
+  if err != nil {
+    return nil, err
+  }
+
+  appliedMigrations := make( map[int]*schemaMigration )
+  for _, row := range rows {
+    migration := find( migrations, row.Version )
+    if migration == nil {
+      return nil, fmt.Errorf( "%w: version %d", ErrUnknownMigration, row.Version )
+    }
+    if migration.Checksum() != row.Checksum {
+      return nil, fmt.Errorf( "%w: version %d", ErrChecksumMismatch, row.Version )
+    }
+    appliedMigrations[row.Version] = row
+  }
+
+  return appliedMigrations, nil
+}
+
+func status( db *gorm.DB, migrations []*Migration ) ([]*MigrationStatus, error) {
+  appliedMigrations, err := applied( db, migrations )
+  if err != nil {
+    return nil, err
+  }
+
+  statuses := make( []*MigrationStatus, 0, len(migrations) )
+  for _, migration := range byVersion( migrations ) {
+    s := &MigrationStatus{
+      Version: migration.Version,
+      Name:    migration.Name,
+    }
+    if row, ok := appliedMigrations[migration.Version]; ok {
+      s.Applied = true
+      appliedAt := row.AppliedAt
+      s.AppliedAt = &appliedAt
+    }
+    statuses = append( statuses, s )
+  }
+  return statuses, nil
+}
+
+// applies all pending migrations up to version target in their own
+// transactions. target <= 0 applies everything
+func up( db *gorm.DB, migrations []*Migration, target int ) ([]*Migration, error) {
+  appliedMigrations, err := applied( db, migrations )
+  if err != nil {
+    return nil, err
+  }
+
+  done := make( []*Migration, 0 )
+
+  for _, migration := range byVersion( migrations ) {
+    if target > 0 && migration.Version > target {
+      break
+    }
+    if _, ok := appliedMigrations[migration.Version]; ok {
+      continue
+    }
+
+    err := db.Transaction( func( tx *gorm.DB ) error {
+      err := migration.Up( tx )
+      if err != nil {
+        return err
+      }
+      return tx.Create( &schemaMigration{
+        Version:   migration.Version,
+        Name:      migration.Name,
+        Checksum:  migration.Checksum(),
+        AppliedAt: time.Now(),
+      }).Error
+    })
+
+    if err != nil {
+      return done, fmt.Errorf( "migration %d %s: %w", migration.Version, migration.Name, err )
+    }
+
+    done = append( done, migration )
+  }
+
+  return done, nil
+}
+
+// reverts the last steps applied migrations, newest first
+func down( db *gorm.DB, migrations []*Migration, steps int ) ([]*Migration, error) {
+  appliedMigrations, err := applied( db, migrations )
+  if err != nil {
+    return nil, err
+  }
+
+  sorted := byVersion( migrations )
+  done := make( []*Migration, 0 )
+
+  for i := len(sorted)-1; i >= 0 && len(done) < steps; i-- {
+    migration := sorted[i]
+    if _, ok := appliedMigrations[migration.Version]; !ok {
+      continue
+    }
+    if migration.Down == nil {
+      return done, fmt.Errorf( "%w: version %d", ErrIrreversibleMigration, migration.Version )
+    }
+
+    err := db.Transaction( func( tx *gorm.DB ) error {
+      err := migration.Down( tx )
+      if err != nil {
+        return err
+      }
+      return tx.Delete( &schemaMigration{}, migration.Version ).Error
+    })
+
+    if err != nil {
+      return done, fmt.Errorf( "migration %d %s: %w", migration.Version, migration.Name, err )
+    }
+
+    done = append( done, migration )
+  }
+
+  return done, nil
+}
+
+// Status lists all known migrations and whether they are applied
+func Status( db *gorm.DB ) ([]*MigrationStatus, error) {
+  return status( db, all )
+}
+
+// Up applies all pending migrations up to version target.
+// target <= 0 applies everything
+func Up( db *gorm.DB, target int ) ([]*Migration, error) {
+  return up( db, all, target )
+}
+
+// Down reverts the last steps applied migrations
+func Down( db *gorm.DB, steps int ) ([]*Migration, error) {
+  return down( db, all, steps )
+}
+
+// Latest is the version of the newest known migration
+func Latest() int {
+  sorted := byVersion( all )
+  return sorted[len(sorted)-1].Version
+}
+
+// Current is the version of the newest applied migration,
+// 0 if none is applied yet
+func Current( db *gorm.DB ) (int, error) {
+  appliedMigrations, err := applied( db, all )
+  if err != nil {
+    return 0, err
+  }
+  current := 0
+  for version := range appliedMigrations {
+    if version > current {
+      current = version
+    }
+  }
+  return current, nil
+}
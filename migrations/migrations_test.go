@@ -0,0 +1,240 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package migrations
+
+import (
+  "errors"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "gorm.io/driver/sqlite"
+  "gorm.io/gorm"
+  "gorm.io/gorm/logger"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+type thing struct {
+  ID   uint
+  Name string
+}
+
+type otherThing struct {
+  ID uint
+}
+
+func openTestDb( t *testing.T ) (*gorm.DB, func()) {
+  dir, err := ioutil.TempDir( "", "migrations" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  db, err := gorm.Open( sqlite.Open( filepath.Join( dir, "test.sqlite3" ) ), &gorm.Config{ Logger: logger.Discard } )
+  if err != nil {
+    os.RemoveAll( dir )
+    t.Fatal( err )
+  }
+  return db, func() {
+    sqlDb, _ := db.DB()
+    sqlDb.Close()
+    os.RemoveAll( dir )
+  }
+}
+
+func testMigrations() []*Migration {
+  return []*Migration{
+    {
+      Version: 2,
+      Name:    "other things",
+      Fingerprint: schemaOf( &otherThing{} ),
+      Up: func( tx *gorm.DB ) error {
+        return tx.Migrator().CreateTable( &otherThing{} )
+      },
+      Down: func( tx *gorm.DB ) error {
+        return tx.Migrator().DropTable( &otherThing{} )
+      },
+    },
+    {
+      Version: 1,
+      Name:    "things",
+      Fingerprint: schemaOf( &thing{} ),
+      Up: func( tx *gorm.DB ) error {
+        return tx.Migrator().CreateTable( &thing{} )
+      },
+      Down: func( tx *gorm.DB ) error {
+        return tx.Migrator().DropTable( &thing{} )
+      },
+    },
+  }
+}
+
+func TestUpAndDown( t *testing.T ) {
+  db, cleanup := openTestDb( t )
+  defer cleanup()
+
+  migrations := testMigrations()
+
+  done, err := up( db, migrations, 1 )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if len(done) != 1 || done[0].Version != 1 {
+    t.Error( "up to version 1 should apply exactly version 1" )
+  }
+  if !db.Migrator().HasTable( &thing{} ) || db.Migrator().HasTable( &otherThing{} ) {
+    t.Error( "wrong tables after up to version 1" )
+  }
+
+  done, err = up( db, migrations, 0 )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if len(done) != 1 || done[0].Version != 2 {
+    t.Error( "up should apply the remaining version 2" )
+  }
+
+  statuses, err := status( db, migrations )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if len(statuses) != 2 || statuses[0].Version != 1 || !statuses[0].Applied || !statuses[1].Applied {
+    t.Error( "status should list both migrations as applied" )
+  }
+
+  done, err = down( db, migrations, 1 )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if len(done) != 1 || done[0].Version != 2 {
+    t.Error( "down should revert the newest migration first" )
+  }
+  if db.Migrator().HasTable( &otherThing{} ) {
+    t.Error( "down should drop other things" )
+  }
+
+  statuses, err = status( db, migrations )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if !statuses[0].Applied || statuses[1].Applied {
+    t.Error( "only version 1 should be applied after down" )
+  }
+}
+
+func TestChecksumMismatch( t *testing.T ) {
+  db, cleanup := openTestDb( t )
+  defer cleanup()
+
+  migrations := testMigrations()
+  _, err := up( db, migrations, 0 )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  migrations[1].Name = "renamed things"
+  _, err = up( db, migrations, 0 )
+  if !errors.Is( err, ErrChecksumMismatch ) {
+    t.Errorf( "expected checksum mismatch, got %v", err )
+  }
+
+  // same name, different table
+  migrations = testMigrations()
+  migrations[1].Fingerprint = schemaOf( &otherThing{} )
+  _, err = up( db, migrations, 0 )
+  if !errors.Is( err, ErrChecksumMismatch ) {
+    t.Errorf( "expected checksum mismatch for a changed fingerprint, got %v", err )
+  }
+
+  _, err = status( db, testMigrations()[:1] )
+  if !errors.Is( err, ErrUnknownMigration ) {
+    t.Errorf( "expected unknown migration, got %v", err )
+  }
+}
+
+func TestIrreversibleMigration( t *testing.T ) {
+  db, cleanup := openTestDb( t )
+  defer cleanup()
+
+  migrations := testMigrations()
+  migrations[0].Down = nil
+
+  _, err := up( db, migrations, 0 )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  done, err := down( db, migrations, 2 )
+  if !errors.Is( err, ErrIrreversibleMigration ) {
+    t.Errorf( "expected irreversible migration, got %v", err )
+  }
+  if len(done) != 0 {
+    t.Error( "nothing should be reverted past an irreversible migration" )
+  }
+}
+
+// the snapshots are frozen, so models changed without a migration
+// have columns no migration creates
+func TestModelsAreMigrated( t *testing.T ) {
+  db, cleanup := openTestDb( t )
+  defer cleanup()
+
+  _, err := up( db, all, 0 )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  allModels := []interface{}{
+    &models.UserModel{}, &models.AppModel{}, &models.RoleModel{}, &models.LoginThrottleModel{},
+    &models.TotpModel{}, &models.RecoveryCodeModel{}, &models.WebauthnCredentialModel{},
+  }
+
+  tables := []string{ "user_roles" }
+
+  for _, model := range allModels {
+    statement := &gorm.Statement{ DB: db }
+    if err := statement.Parse( model ); err != nil {
+      t.Fatal( err )
+    }
+    tables = append( tables, statement.Schema.Table )
+    for _, field := range statement.Schema.Fields {
+      if field.DBName != "" && !db.Migrator().HasColumn( model, field.DBName ) {
+        t.Errorf( "no migration creates %s.%s", statement.Schema.Table, field.DBName )
+      }
+    }
+    for _, index := range statement.Schema.ParseIndexes() {
+      if !db.Migrator().HasIndex( model, index.Name ) {
+        t.Errorf( "no migration creates index %s", index.Name )
+      }
+    }
+  }
+
+  _, err = down( db, all, len(all) )
+  if err != nil {
+    t.Fatal( err )
+  }
+  for _, table := range tables {
+    if db.Migrator().HasTable( table ) {
+      t.Errorf( "expected %s to be dropped", table )
+    }
+  }
+}
@@ -0,0 +1,121 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package snapshots holds the models as the migrations creating
+// their tables left them. gorm derives table, join column and
+// constraint names from the type and field names, so those have to
+// match the models. Never change a snapshot, add a migration instead
+package snapshots
+
+import (
+  "gorm.io/gorm"
+  "time"
+)
+
+type UserModel struct {
+  ID           uint           `gorm:"primarykey"`
+  CreatedAt    time.Time
+  UpdatedAt    time.Time
+  DeletedAt    gorm.DeletedAt `gorm:"index"`
+  Login        string         `gorm:"type:varchar(30);uniqueIndex;not null"`
+  Name         string
+  Password     string         `gorm:"type:varchar(128);not null"`
+  EmailAddress string         `gorm:"type:varchar(100)"`
+  Roles        []*RoleModel   `gorm:"many2many:user_roles;constraint:OnDelete:CASCADE"`
+}
+
+// json columns are strings, like the models' Valuer types
+type AppModel struct {
+  ID              uint           `gorm:"primarykey"`
+  CreatedAt       time.Time
+  UpdatedAt       time.Time
+  DeletedAt       gorm.DeletedAt `gorm:"index"`
+  Hash            string         `gorm:"type:varchar(64);uniqueIndex;not null"`
+  Secret          string         `gorm:"type:varchar(64);uniqueIndex;not null"`
+  MountPoint      string         `gorm:"type:varchar(32);uniqueIndex;not null"`
+  Name            string         `gorm:"type:varchar(30);not null"`
+  Description     string         `gorm:"type:varchar(255)"`
+  Version         string         `gorm:"type:varchar(16)"`
+  AvailableRoles  []*RoleModel   `gorm:"foreignKey:AppId;constraint:OnDelete:CASCADE"`
+  AccessPolicies  string         `gorm:"default:'null'"`
+  Meta            string         `gorm:"default:'null'"`
+  KeyLabels       string         `gorm:"default:'null'"`
+  RequestedGroups string         `gorm:"default:'null'"`
+  ApprovedGroups  string         `gorm:"default:'null'"`
+  RoleGrants      string         `gorm:"default:'null'"`
+  ArchivedAt      *time.Time     `gorm:"index"`
+  Quarantined     bool           `gorm:"default:false"`
+  PolicyProblems  string         `gorm:"default:'null'"`
+}
+
+type RoleModel struct {
+  ID          uint           `gorm:"primarykey"`
+  CreatedAt   time.Time
+  UpdatedAt   time.Time
+  DeletedAt   gorm.DeletedAt `gorm:"index"`
+  Name        string         `gorm:"type:varchar(30)"`
+  Description string         `gorm:"type:varchar(255)"`
+  AutoAssign  bool           `gorm:"default:false"`
+  AppId       uint
+  Users       []*UserModel   `gorm:"many2many:user_roles;constraint:OnDelete:CASCADE"`
+}
+
+type LoginThrottleModel struct {
+  ID            uint      `gorm:"primarykey"`
+  Kind          string    `gorm:"type:varchar(10);uniqueIndex:idx_login_throttle_kind_key;not null"`
+  Key           string    `gorm:"type:varchar(100);uniqueIndex:idx_login_throttle_kind_key;not null"`
+  Failures      int       `gorm:"not null;default:0"`
+  LastFailureAt time.Time
+  BlockedUntil  time.Time
+  LockedOut     bool      `gorm:"not null;default:false"`
+}
+
+type TotpModel struct {
+  ID          uint       `gorm:"primarykey"`
+  UserId      uint       `gorm:"uniqueIndex;not null"`
+  Secret      string     `gorm:"type:varchar(64);not null"`
+  Confirmed   bool       `gorm:"not null;default:false"`
+  LastStep    int64      `gorm:"not null;default:0"`
+  CreatedAt   time.Time
+  ConfirmedAt *time.Time
+}
+
+type RecoveryCodeModel struct {
+  ID       uint       `gorm:"primarykey"`
+  UserId   uint       `gorm:"index;not null"`
+  CodeHash string     `gorm:"type:varchar(64);not null"`
+  UsedAt   *time.Time
+}
+
+type WebauthnCredentialModel struct {
+  ID           uint       `gorm:"primarykey"`
+  UserId       uint       `gorm:"index;not null"`
+  CredentialId string     `gorm:"type:varchar(1400);uniqueIndex;not null"`
+  PublicKey    []byte     `gorm:"not null"`
+  SignCount    uint32     `gorm:"not null;default:0"`
+  Aaguid       string     `gorm:"type:varchar(36)"`
+  Name         string     `gorm:"type:varchar(100)"`
+  CreatedAt    time.Time
+  LastUsedAt   *time.Time
+}
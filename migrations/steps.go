@@ -0,0 +1,237 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package migrations
+
+import (
+  "fmt"
+  "github.com/schulterklopfer/cyphernode_fauth/migrations/snapshots"
+  "gorm.io/gorm"
+  "reflect"
+  "strings"
+)
+
+// all migrations. append new ones, never change released ones
+var all = []*Migration{
+  {
+    Version: 1,
+    Name:    "initial schema",
+    Fingerprint: schemaOf( &snapshots.UserModel{}, &snapshots.AppModel{}, &snapshots.RoleModel{} ),
+    // idempotent, so databases created before versioned
+    // migrations existed are taken over as they are
+    Up: func( tx *gorm.DB ) error {
+      return tx.Migrator().AutoMigrate( &snapshots.UserModel{}, &snapshots.AppModel{}, &snapshots.RoleModel{} )
+    },
+    Down: func( tx *gorm.DB ) error {
+      return tx.Migrator().DropTable( "user_roles", &snapshots.RoleModel{}, &snapshots.AppModel{}, &snapshots.UserModel{} )
+    },
+  },
+  {
+    Version: 2,
+    Name:    "unique indexes",
+    Fingerprint: fmt.Sprint( uniqueIndexes ),
+    // the models used gorm v1 tags until now, which gorm v2 ignored
+    Up: func( tx *gorm.DB ) error {
+      for _, index := range uniqueIndexes {
+        err := tx.Exec( fmt.Sprintf( "CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (%s)", index.name, index.table, index.columns ) ).Error
+        if err != nil {
+          return err
+        }
+      }
+      return nil
+    },
+    Down: func( tx *gorm.DB ) error {
+      for _, index := range uniqueIndexes {
+        err := tx.Exec( fmt.Sprintf( "DROP INDEX IF EXISTS %s", index.name ) ).Error
+        if err != nil {
+          return err
+        }
+      }
+      return nil
+    },
+  },
+  {
+    Version: 3,
+    Name:    "cascading foreign keys",
+    Fingerprint: fmt.Sprint( orphans, foreignKeys ),
+    // rows orphaned while the delete hooks did not fire would make
+    // the constraints fail, so they go first. sqlite can't alter
+    // constraints. its databases got them from the initial schema
+    Up: func( tx *gorm.DB ) error {
+      err := deleteOrphans( tx )
+      if err != nil {
+        return err
+      }
+      if tx.Dialector.Name() == "sqlite" {
+        return nil
+      }
+      return replaceForeignKeys( tx, "ON DELETE CASCADE" )
+    },
+    Down: func( tx *gorm.DB ) error {
+      if tx.Dialector.Name() == "sqlite" {
+        return nil
+      }
+      return replaceForeignKeys( tx, "" )
+    },
+  },
+  {
+    Version: 4,
+    Name:    "role lookup indexes",
+    Fingerprint: fmt.Sprint( lookupIndexes ),
+    // the primary key of user_roles starts with the role, so
+    // looking up the roles of a user scanned the whole table
+    Up: func( tx *gorm.DB ) error {
+      for _, index := range lookupIndexes {
+        err := tx.Exec( fmt.Sprintf( "CREATE INDEX IF NOT EXISTS %s ON %s (%s)", index.name, index.table, index.columns ) ).Error
+        if err != nil {
+          return err
+        }
+      }
+      return nil
+    },
+    Down: func( tx *gorm.DB ) error {
+      for _, index := range lookupIndexes {
+        err := tx.Exec( fmt.Sprintf( "DROP INDEX IF EXISTS %s", index.name ) ).Error
+        if err != nil {
+          return err
+        }
+      }
+      return nil
+    },
+  },
+  {
+    Version: 5,
+    Name:    "login throttles",
+    Fingerprint: schemaOf( &snapshots.LoginThrottleModel{} ),
+    Up: func( tx *gorm.DB ) error {
+      return tx.Migrator().AutoMigrate( &snapshots.LoginThrottleModel{} )
+    },
+    Down: func( tx *gorm.DB ) error {
+      return tx.Migrator().DropTable( &snapshots.LoginThrottleModel{} )
+    },
+  },
+  {
+    Version: 6,
+    Name:    "totp",
+    Fingerprint: schemaOf( &snapshots.TotpModel{}, &snapshots.RecoveryCodeModel{} ),
+    Up: func( tx *gorm.DB ) error {
+      return tx.Migrator().AutoMigrate( &snapshots.TotpModel{}, &snapshots.RecoveryCodeModel{} )
+    },
+    Down: func( tx *gorm.DB ) error {
+      return tx.Migrator().DropTable( &snapshots.RecoveryCodeModel{}, &snapshots.TotpModel{} )
+    },
+  },
+  {
+    Version: 7,
+    Name:    "webauthn credentials",
+    Fingerprint: schemaOf( &snapshots.WebauthnCredentialModel{} ),
+    Up: func( tx *gorm.DB ) error {
+      return tx.Migrator().AutoMigrate( &snapshots.WebauthnCredentialModel{} )
+    },
+    Down: func( tx *gorm.DB ) error {
+      return tx.Migrator().DropTable( &snapshots.WebauthnCredentialModel{} )
+    },
+  },
+}
+
+// named like gorm names the indexes of uniqueIndex tags
+var uniqueIndexes = []struct{
+  name    string
+  table   string
+  columns string
+}{
+  { "idx_user_models_login", "user_models", "login" },
+  { "idx_app_models_hash", "app_models", "hash" },
+  { "idx_app_models_secret", "app_models", "secret" },
+  { "idx_app_models_mount_point", "app_models", "mount_point" },
+}
+
+var foreignKeys = []struct{
+  table      string
+  name       string
+  column     string
+  references string
+}{
+  { "role_models", "fk_app_models_available_roles", "app_id", "app_models" },
+  { "user_roles", "fk_user_roles_user_model", "user_model_id", "user_models" },
+  { "user_roles", "fk_user_roles_role_model", "role_model_id", "role_models" },
+}
+
+var lookupIndexes = []struct{
+  name    string
+  table   string
+  columns string
+}{
+  { "idx_user_roles_user_model_id", "user_roles", "user_model_id, role_model_id" },
+  { "idx_role_models_app_id", "role_models", "app_id" },
+}
+
+var orphans = []string{
+  "DELETE FROM role_models WHERE app_id IS NOT NULL AND app_id NOT IN (SELECT id FROM app_models)",
+  "DELETE FROM user_roles WHERE user_model_id NOT IN (SELECT id FROM user_models)",
+  "DELETE FROM user_roles WHERE role_model_id NOT IN (SELECT id FROM role_models)",
+}
+
+func deleteOrphans( tx *gorm.DB ) error {
+  for _, statement := range orphans {
+    err := tx.Exec( statement ).Error
+    if err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// replaceForeignKeys recreates the foreign keys with onDelete as
+// their ON DELETE clause
+func replaceForeignKeys( tx *gorm.DB, onDelete string ) error {
+  for _, foreignKey := range foreignKeys {
+    err := tx.Exec( fmt.Sprintf( "ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s", foreignKey.table, foreignKey.name ) ).Error
+    if err != nil {
+      return err
+    }
+    err = tx.Exec( fmt.Sprintf( "ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s(id) %s",
+      foreignKey.table, foreignKey.name, foreignKey.column, foreignKey.references, onDelete ) ).Error
+    if err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// schemaOf describes snapshots by their fields and tags, so
+// changing one changes the checksum of its migration
+func schemaOf( models ...interface{} ) string {
+  var schema strings.Builder
+  for _, model := range models {
+    modelType := reflect.TypeOf( model ).Elem()
+    schema.WriteString( modelType.Name() )
+    for i := 0; i < modelType.NumField(); i++ {
+      field := modelType.Field( i )
+      fmt.Fprintf( &schema, " %s %s `%s`", field.Name, field.Type, field.Tag )
+    }
+    schema.WriteString( "\n" )
+  }
+  return schema.String()
+}
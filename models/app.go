@@ -27,16 +27,44 @@ package models
 import (
   "database/sql/driver"
   "encoding/json"
-  "errors"
-  "fmt"
   "github.com/SatoshiPortal/cam/storage"
-  "github.com/jinzhu/gorm"
   "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "gorm.io/gorm"
+  "time"
 )
 
-type AccessPolicies []*storage.AccessPolicy
+// AccessCondition restricts an access policy to requests with a
+// header or query parameter matching Pattern. An empty Pattern
+// only requires the header or query parameter to be present
+type AccessCondition struct {
+  Header  string `json:"header,omitempty"`
+  Query   string `json:"query,omitempty"`
+  Pattern string `json:"pattern,omitempty"`
+}
+
+// AccessPolicy is cam's access policy with conditions on top.
+// Policies with RequireMfa only apply to sessions authenticated with
+// more than one factor
+type AccessPolicy struct {
+  storage.AccessPolicy
+  Conditions []*AccessCondition `json:"conditions,omitempty"`
+  RequireMfa bool               `json:"requireMfa,omitempty"`
+}
 
-// this is used to create jsonb Data which then
+type AccessPolicies []*AccessPolicy
+
+func AccessPoliciesFrom( camAccessPolicies []*storage.AccessPolicy ) AccessPolicies {
+  aps := make( AccessPolicies, 0, len(camAccessPolicies) )
+  for _, camAccessPolicy := range camAccessPolicies {
+    if camAccessPolicy == nil {
+      continue
+    }
+    aps = append( aps, &AccessPolicy{ AccessPolicy: *camAccessPolicy } )
+  }
+  return aps
+}
+
+// this is used to create json Data which then
 // will ne saved to the db by gorm
 func (aps AccessPolicies) Value() (driver.Value, error) {
   jsonValue, err := json.Marshal(aps)
@@ -46,15 +74,10 @@ func (aps AccessPolicies) Value() (driver.Value, error) {
   return string(jsonValue), nil
 }
 
-// convert jsonb Data from database back into
+// convert json Data from database back into
 // a struct
 func (aps *AccessPolicies) Scan(value interface{}) error {
-  jsonValue, ok := value.([]byte)
-  if !ok {
-    return errors.New(fmt.Sprint("Failed to unmarshal access policies:", value))
-  }
-  err := json.Unmarshal(jsonValue, aps)
-  return err
+  return scanJson(value, aps, "access policies")
 }
 
 
@@ -64,7 +87,7 @@ type Meta struct {
 }
 
 
-// this is used to create jsonb Data which then
+// this is used to create json Data which then
 // will ne saved to the db by gorm
 func (meta Meta) Value() (driver.Value, error) {
   jsonValue, err := json.Marshal(meta)
@@ -74,39 +97,69 @@ func (meta Meta) Value() (driver.Value, error) {
   return string(jsonValue), nil
 }
 
-// convert jsonb Data from database back into
+// convert json Data from database back into
 // a struct
 func (meta *Meta) Scan(value interface{}) error {
-  jsonValue, ok := value.([]byte)
-  if !ok {
-    return errors.New(fmt.Sprint("Failed to unmarshal access policies:", value))
+  return scanJson(value, meta, "meta")
+}
+
+
+type StringList []string
+
+// this is used to create json Data which then
+// will ne saved to the db by gorm
+func (sl StringList) Value() (driver.Value, error) {
+  jsonValue, err := json.Marshal(sl)
+  if err != nil {
+    return nil, err
   }
-  err := json.Unmarshal(jsonValue, meta)
-  return err
+  return string(jsonValue), nil
 }
 
+// convert json Data from database back into
+// a struct
+func (sl *StringList) Scan(value interface{}) error {
+  return scanJson(value, sl, "string list")
+}
+
+func (sl StringList) Contains( s string ) bool {
+  for i:=0; i<len(sl); i++ {
+    if sl[i] == s {
+      return true
+    }
+  }
+  return false
+}
 
 type AppModel struct {
   gorm.Model
-  Hash           string         `json:"hash" gorm:"type:varchar(64);unique_index;not null"`
-  Secret         string         `json:"-" gorm:"type:varchar(64);unique_index;not null"`
-  MountPoint     string         `json:"mountPoint" gorm:"type:varchar(32);unique_index;not null"`
-  Name           string         `json:"name" gorm:"type:varchar(30);not null" validate:"min=3,max=30,regexp=^[a-zA-Z0-9_\\- ]+$"`
-  Description    string         `json:"description" gorm:"type:varchar(255)"`
-  Version        string         `json:"version" gorm:"type:varchar(16)"`
-  AvailableRoles []*RoleModel   `json:"availableRoles" gorm:"foreignkey:AppId;preload"`
-  AccessPolicies AccessPolicies `json:"accessPolicies,omitempty" gorm:"type:jsonb;default:'null'"`
-  Meta           *Meta          `json:"meta,omitempty" gorm:"type:jsonb;default:'null'"`
+  Hash            string         `json:"hash" gorm:"type:varchar(64);uniqueIndex;not null"`
+  Secret          string         `json:"-" gorm:"type:varchar(64);uniqueIndex;not null"`
+  MountPoint      string         `json:"mountPoint" gorm:"type:varchar(32);uniqueIndex;not null"`
+  Name            string         `json:"name" gorm:"type:varchar(30);not null" validate:"min=3,max=30,regexp=^[a-zA-Z0-9_\\- ]+$"`
+  Description     string         `json:"description" gorm:"type:varchar(255)"`
+  Version         string         `json:"version" gorm:"type:varchar(16)"`
+  AvailableRoles  []*RoleModel   `json:"availableRoles" gorm:"foreignKey:AppId;constraint:OnDelete:CASCADE"`
+  AccessPolicies  AccessPolicies `json:"accessPolicies,omitempty" gorm:"default:'null'"`
+  Meta            *Meta          `json:"meta,omitempty" gorm:"default:'null'"`
+  KeyLabels       StringList     `json:"keyLabels,omitempty" gorm:"default:'null'"`
+  // gatekeeper groups the app asks for in its manifest
+  RequestedGroups StringList     `json:"requestedGroups,omitempty" gorm:"default:'null'"`
+  // subset of RequestedGroups an admin agreed to
+  ApprovedGroups  StringList     `json:"approvedGroups,omitempty" gorm:"default:'null'"`
+  // roles of this app given to users with certain other roles
+  RoleGrants      RoleGrants     `json:"roleGrants,omitempty" gorm:"default:'null'"`
+  // set when the app was removed from the installed apps index.
+  // roles and their assignments are kept until the app is purged
+  ArchivedAt      *time.Time     `json:"archivedAt,omitempty" gorm:"index"`
+  // set when the access policies have fatal problems. quarantined
+  // apps are not served until a fixed version is synced
+  Quarantined     bool           `json:"quarantined" gorm:"default:false"`
+  PolicyProblems  StringList     `json:"policyProblems,omitempty" gorm:"default:'null'"`
 }
 
-func ( app *AppModel ) AfterDelete( tx *gorm.DB ) {
-  var roles []RoleModel
-  tx.Model(app).Association("AvailableRoles" ).Find(&roles)
-  for i:=0; i< len(roles); i++ {
-    tx.Delete( roles[i] )
-    // Why do I have to call this manually?
-    roles[i].AfterDelete( tx )
-  }
+func ( app *AppModel ) IsArchived() bool {
+  return app.ArchivedAt != nil
 }
 
 func ( app *AppModel ) BeforeDelete( tx *gorm.DB ) (err error) {
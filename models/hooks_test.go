@@ -0,0 +1,204 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package models_test
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/sirupsen/logrus"
+  "gorm.io/gorm"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestHooks(t *testing.T) {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "models" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer os.RemoveAll( dir )
+
+  err = dataSource.Init( "sqlite://"+filepath.Join( dir, "test.sqlite3" ) )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer dataSource.Close()
+
+  db := dataSource.GetDB()
+
+  adminApp := &models.AppModel{
+    Name: "admin", Hash: "adminHash", Secret: "adminSecret", MountPoint: globals.BASE_ADMIN_MOUNTPOINT,
+    AvailableRoles: []*models.RoleModel{ { Name: globals.BASE_ADMIN_ROLE } },
+  }
+  if err := db.Create( adminApp ).Error; err != nil {
+    t.Fatal( err )
+  }
+  admin := &models.UserModel{ Login: "admin", Password: "secret", Roles: adminApp.AvailableRoles }
+  if err := db.Create( admin ).Error; err != nil {
+    t.Fatal( err )
+  }
+
+  app := &models.AppModel{ Name: "app", Hash: "appHash", Secret: "appSecret", MountPoint: "app" }
+  if err := db.Create( app ).Error; err != nil {
+    t.Fatal( err )
+  }
+
+  t.Run( "user create checks duplicates", func( t *testing.T ) {
+    err := db.Create( &models.UserModel{ Login: "admin", Password: "secret" } ).Error
+    if err != globals.ErrDuplicateUser {
+      t.Errorf( "expected duplicate user error, got %v", err )
+    }
+  })
+
+  t.Run( "user create checks roles", func( t *testing.T ) {
+    err := db.Create( &models.UserModel{ Login: "unknown", Password: "secret", Roles: []*models.RoleModel{ {} } } ).Error
+    if err != globals.ErrUserHasUnknownRole {
+      t.Errorf( "expected unknown role error, got %v", err )
+    }
+  })
+
+  t.Run( "new roles are given to admins", func( t *testing.T ) {
+    role := &models.RoleModel{ Name: "manager", AppId: app.ID }
+    if err := db.Create( role ).Error; err != nil {
+      t.Fatal( err )
+    }
+    if !hasRole( t, db, admin, role ) {
+      t.Error( "admin did not get the new role" )
+    }
+  })
+
+  var user *models.UserModel
+  var autoAssignRole *models.RoleModel
+
+  t.Run( "new auto assign roles are given to everyone", func( t *testing.T ) {
+    user = &models.UserModel{ Login: "user", Password: "secret" }
+    if err := db.Create( user ).Error; err != nil {
+      t.Fatal( err )
+    }
+    autoAssignRole = &models.RoleModel{ Name: "everyone", AppId: app.ID, AutoAssign: true }
+    if err := db.Create( autoAssignRole ).Error; err != nil {
+      t.Fatal( err )
+    }
+    if !hasRole( t, db, user, autoAssignRole ) || !hasRole( t, db, admin, autoAssignRole ) {
+      t.Error( "auto assign role was not given to all users" )
+    }
+  })
+
+  t.Run( "new users get auto assign roles", func( t *testing.T ) {
+    newUser := &models.UserModel{ Login: "newUser", Password: "secret" }
+    if err := db.Create( newUser ).Error; err != nil {
+      t.Fatal( err )
+    }
+    if !hasRole( t, db, newUser, autoAssignRole ) {
+      t.Error( "new user did not get the auto assign role" )
+    }
+  })
+
+  t.Run( "roles becoming auto assigned are given to everyone", func( t *testing.T ) {
+    role := &models.RoleModel{ Name: "later", AppId: app.ID }
+    if err := db.Create( role ).Error; err != nil {
+      t.Fatal( err )
+    }
+    if hasRole( t, db, user, role ) {
+      t.Fatal( "user should not have the role yet" )
+    }
+    if err := db.Model( role ).Update( "auto_assign", true ).Error; err != nil {
+      t.Fatal( err )
+    }
+    if !hasRole( t, db, user, role ) {
+      t.Error( "user did not get the role that became auto assigned" )
+    }
+  })
+
+  t.Run( "deleting a role removes it from its users", func( t *testing.T ) {
+    if err := db.Unscoped().Delete( autoAssignRole ).Error; err != nil {
+      t.Fatal( err )
+    }
+    if countUsersWithRole( t, db, autoAssignRole.ID ) != 0 {
+      t.Error( "deleted role is still assigned" )
+    }
+  })
+
+  t.Run( "deleting a role without id fails", func( t *testing.T ) {
+    err := db.Delete( &models.RoleModel{} ).Error
+    if err != globals.ErrNoSuchRole {
+      t.Errorf( "expected no such role error, got %v", err )
+    }
+  })
+
+  t.Run( "deleting an app deletes its roles", func( t *testing.T ) {
+    if err := db.Unscoped().Delete( app ).Error; err != nil {
+      t.Fatal( err )
+    }
+    var count int64
+    db.Unscoped().Model( &models.RoleModel{} ).Where( "app_id = ?", app.ID ).Count( &count )
+    if count != 0 {
+      t.Error( "roles of deleted app still exist" )
+    }
+    var assignments int64
+    db.Table( "user_roles" ).Where( "user_model_id = ?", user.ID ).Count( &assignments )
+    if assignments != 0 {
+      t.Error( "roles of deleted app are still assigned" )
+    }
+  })
+
+  t.Run( "deleting a user removes its role assignments", func( t *testing.T ) {
+    if err := db.Unscoped().Delete( admin ).Error; err != nil {
+      t.Fatal( err )
+    }
+    var assignments int64
+    db.Table( "user_roles" ).Where( "user_model_id = ?", admin.ID ).Count( &assignments )
+    if assignments != 0 {
+      t.Error( "deleted user still has roles" )
+    }
+  })
+}
+
+func hasRole( t *testing.T, db *gorm.DB, user *models.UserModel, role *models.RoleModel ) bool {
+  var roles []*models.RoleModel
+  err := db.Model( user ).Association( "Roles" ).Find( &roles )
+  if err != nil {
+    t.Fatal( err )
+  }
+  for _, r := range roles {
+    if r.ID == role.ID {
+      return true
+    }
+  }
+  return false
+}
+
+func countUsersWithRole( t *testing.T, db *gorm.DB, roleId uint ) int64 {
+  var count int64
+  err := db.Table( "user_roles" ).Where( "role_model_id = ?", roleId ).Count( &count ).Error
+  if err != nil {
+    t.Fatal( err )
+  }
+  return count
+}
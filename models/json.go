@@ -0,0 +1,74 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package models
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "gorm.io/gorm"
+  "gorm.io/gorm/schema"
+)
+
+// column type for json data. postgres has jsonb, everything
+// else stores json as text
+func jsonDataType( db *gorm.DB ) string {
+  if db.Dialector.Name() == "postgres" {
+    return "jsonb"
+  }
+  return "text"
+}
+
+// drivers hand out json columns as bytes or strings
+func scanJson( value interface{}, out interface{}, what string ) error {
+  var jsonValue []byte
+  switch v := value.(type) {
+  case nil:
+    return nil
+  case []byte:
+    jsonValue = v
+  case string:
+    jsonValue = []byte(v)
+  default:
+    return errors.New(fmt.Sprint("Failed to unmarshal "+what+":", value))
+  }
+  return json.Unmarshal(jsonValue, out)
+}
+
+func (AccessPolicies) GormDBDataType( db *gorm.DB, field *schema.Field ) string {
+  return jsonDataType( db )
+}
+
+func (Meta) GormDBDataType( db *gorm.DB, field *schema.Field ) string {
+  return jsonDataType( db )
+}
+
+func (StringList) GormDBDataType( db *gorm.DB, field *schema.Field ) string {
+  return jsonDataType( db )
+}
+
+func (RoleGrants) GormDBDataType( db *gorm.DB, field *schema.Field ) string {
+  return jsonDataType( db )
+}
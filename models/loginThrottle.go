@@ -0,0 +1,46 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package models
+
+import "time"
+
+const LOGIN_THROTTLE_KIND_LOGIN = "login"
+const LOGIN_THROTTLE_KIND_IP = "ip"
+
+// LoginThrottleModel counts the failed logins of a login or of a
+// source ip. Logins are refused until BlockedUntil
+type LoginThrottleModel struct {
+  ID            uint      `json:"id" gorm:"primarykey"`
+  Kind          string    `json:"kind" gorm:"type:varchar(10);uniqueIndex:idx_login_throttle_kind_key;not null"`
+  Key           string    `json:"key" gorm:"type:varchar(100);uniqueIndex:idx_login_throttle_kind_key;not null"`
+  Failures      int       `json:"failures" gorm:"not null;default:0"`
+  LastFailureAt time.Time `json:"lastFailureAt"`
+  BlockedUntil  time.Time `json:"blockedUntil"`
+  LockedOut     bool      `json:"lockedOut" gorm:"not null;default:false"`
+}
+
+func IsLoginThrottleKind( kind string ) bool {
+  return kind == LOGIN_THROTTLE_KIND_LOGIN || kind == LOGIN_THROTTLE_KIND_IP
+}
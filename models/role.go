@@ -25,21 +25,17 @@
 package models
 
 import (
-  "github.com/jinzhu/gorm"
   "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "gorm.io/gorm"
 )
 
 type RoleModel struct {
   gorm.Model
   Name string `json:"name" gorm:"type:varchar(30)" validate:"min=3,max=30,regexp=^[a-zA-Z0-9_-]+$"`
   Description string `json:"description" gorm:"type:varchar(255)"`
-  AutoAssign bool `json:"autoAssign" gorm:"default false"`
+  AutoAssign bool `json:"autoAssign" gorm:"default:false"`
   AppId uint `json:"appId"`
-  Users []*UserModel `json:"users" gorm:"many2many:user_roles;"`
-}
-
-func ( role *RoleModel ) AfterDelete( tx *gorm.DB ) {
-  role.removeFromAllUsers( tx )
+  Users []*UserModel `json:"users" gorm:"many2many:user_roles;constraint:OnDelete:CASCADE"`
 }
 
 func ( role *RoleModel ) BeforeDelete( tx *gorm.DB ) (err error) {
@@ -51,49 +47,67 @@ func ( role *RoleModel ) BeforeDelete( tx *gorm.DB ) (err error) {
   return
 }
 
-func ( role *RoleModel ) AfterSave( tx *gorm.DB ) {
-  role.AfterUpdate( tx )
-}
-
-func ( role *RoleModel ) AfterUpdate( tx *gorm.DB ) {
-  if role.AutoAssign {
-    role.addToAllUsers( tx )
-  } else {
-    role.removeFromAllUsers( tx )
-
-    // reassign to admin user
-    var adminUser UserModel
-    tx.First( &adminUser, 1 )
-    if adminUser.ID == 1 {
-      tx.Model(adminUser).Association("Roles").Append( role )
-    }
+// AfterUpdate hands a role that became auto assigned to all users.
+// Turning auto assign off keeps existing assignments, since we can't
+// tell them apart from the ones made by hand
+func ( role *RoleModel ) AfterUpdate( tx *gorm.DB ) (err error) {
+  if !role.AutoAssign {
+    return
   }
+  return role.addToAllUsers( tx )
 }
 
-func ( role *RoleModel) AfterCreate( tx *gorm.DB )  {
-  // all roles are given to the admin user
-  var adminUser UserModel
-  tx.First( &adminUser, 1 )
-  if adminUser.ID == 1 {
-    tx.Model(adminUser).Association("Roles").Append( role )
+// AfterCreate gives a new role to the admins and, if it is auto
+// assigned, to everyone else.
+// Appending an existing role to a user upserts it and lands here
+// as well, which is harmless because both steps are idempotent
+func ( role *RoleModel) AfterCreate( tx *gorm.DB ) (err error) {
+  if role.AutoAssign {
+    return role.addToAllUsers( tx )
   }
-
-  if !role.AutoAssign {
+  admins, err := adminUsers( tx )
+  if err != nil {
     return
   }
-  role.addToAllUsers( tx )
+  for i:=0; i< len(admins); i++ {
+    err = appendRoles( tx, admins[i], role )
+    if err != nil {
+      return
+    }
+  }
+  return
 }
 
-func ( role *RoleModel) addToAllUsers( tx *gorm.DB ) {
+func ( role *RoleModel) addToAllUsers( tx *gorm.DB ) error {
   var allUsers []*UserModel
-  tx.Find( &allUsers )
+  err := tx.Find( &allUsers ).Error
+  if err != nil {
+    return err
+  }
   for i:=0; i< len(allUsers); i++ {
-    tx.Model(allUsers[i]).Association("Roles").Append( role )
+    err = appendRoles( tx, allUsers[i], role )
+    if err != nil {
+      return err
+    }
   }
+  return nil
 }
 
-func ( role *RoleModel) removeFromAllUsers( tx *gorm.DB ) {
-  tx.Model(role).Association("Users" ).Clear()
+// adminUsers are the users holding the admin role of the admin app
+func adminUsers( tx *gorm.DB ) ([]*UserModel, error) {
+  var admins []*UserModel
+  err := tx.
+    Joins( "JOIN user_roles ON user_roles.user_model_id = user_models.id" ).
+    Joins( "JOIN role_models ON role_models.id = user_roles.role_model_id" ).
+    Joins( "JOIN app_models ON app_models.id = role_models.app_id" ).
+    Where( "role_models.name = ? AND app_models.mount_point = ?", globals.BASE_ADMIN_ROLE, globals.BASE_ADMIN_MOUNTPOINT ).
+    Find( &admins ).Error
+  return admins, err
 }
 
-
+// appendRoles links roles to user from inside hooks. Appending
+// associations upserts the associated rows, which would run their
+// hooks again and again, so hooks are skipped
+func appendRoles( tx *gorm.DB, user *UserModel, roles ...*RoleModel ) error {
+  return tx.Model(user).Session( &gorm.Session{ SkipHooks: true } ).Association("Roles").Append( roles )
+}
@@ -0,0 +1,70 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package models
+
+import (
+  "database/sql/driver"
+  "encoding/json"
+  "strings"
+)
+
+// RoleGrant gives the role Grant to every user having the role
+// WhenRole. Both are labels of the form mountPoint/roleName,
+// see RoleLabel
+type RoleGrant struct {
+  WhenRole string `json:"whenRole"`
+  Grant    string `json:"grant"`
+}
+
+func RoleLabel( mountPoint string, roleName string ) string {
+  return mountPoint+"/"+roleName
+}
+
+func isRoleLabel( label string ) bool {
+  parts := strings.Split( label, "/" )
+  return len(parts) == 2 && parts[0] != "" && parts[1] != ""
+}
+
+func ( grant *RoleGrant ) IsValid() bool {
+  return grant != nil && isRoleLabel( grant.WhenRole ) && isRoleLabel( grant.Grant ) && grant.WhenRole != grant.Grant
+}
+
+type RoleGrants []*RoleGrant
+
+// this is used to create json Data which then
+// will ne saved to the db by gorm
+func (grants RoleGrants) Value() (driver.Value, error) {
+  jsonValue, err := json.Marshal(grants)
+  if err != nil {
+    return nil, err
+  }
+  return string(jsonValue), nil
+}
+
+// convert json Data from database back into
+// a struct
+func (grants *RoleGrants) Scan(value interface{}) error {
+  return scanJson(value, grants, "role grants")
+}
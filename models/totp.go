@@ -0,0 +1,49 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package models
+
+import "time"
+
+// TotpModel is the totp secret of a user. It is only checked at
+// login after it was confirmed with a valid code. LastStep is the
+// time step of the last accepted code, so codes can't be replayed
+type TotpModel struct {
+  ID          uint       `json:"id" gorm:"primarykey"`
+  UserId      uint       `json:"userId" gorm:"uniqueIndex;not null"`
+  Secret      string     `json:"-" gorm:"type:varchar(64);not null"`
+  Confirmed   bool       `json:"confirmed" gorm:"not null;default:false"`
+  LastStep    int64      `json:"-" gorm:"not null;default:0"`
+  CreatedAt   time.Time  `json:"createdAt"`
+  ConfirmedAt *time.Time `json:"confirmedAt"`
+}
+
+// RecoveryCodeModel is a one time code replacing a totp code, if
+// the device is lost. Only its hash is stored
+type RecoveryCodeModel struct {
+  ID       uint       `json:"id" gorm:"primarykey"`
+  UserId   uint       `json:"userId" gorm:"index;not null"`
+  CodeHash string     `json:"-" gorm:"type:varchar(64);not null"`
+  UsedAt   *time.Time `json:"usedAt"`
+}
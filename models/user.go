@@ -25,26 +25,27 @@
 package models
 
 import (
-  "github.com/jinzhu/gorm"
+  "errors"
   "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "gorm.io/gorm"
 )
 
 type UserModel struct {
   gorm.Model
-  Login string `json:"login" gorm:"type:varchar(30);unique_index;not null" form:"login" validate:"min=3,max=30,regexp=^[a-zA-Z0-9_\\-]+$"`
+  Login string `json:"login" gorm:"type:varchar(30);uniqueIndex;not null" form:"login" validate:"min=3,max=30,regexp=^[a-zA-Z0-9_\\-]+$"`
   Name string `json:"name" form:"name"` // optional
   Password string `json:"password" gorm:"type:varchar(128);not null" form:"password" validate:"nonzero" sbjt:"hashPassword"`
   EmailAddress string `json:"email_address" gorm:"type:varchar(100)" form:"emailAddress" validate:"max=100,regexp=(^$|^[a-zA-Z0-9_.+-]+@[a-zA-Z0-9-]+\\.[a-zA-Z0-9-.]+$)"`
-  Roles []*RoleModel `json:"roles" gorm:"many2many:user_roles;association_autoupdate:false;gorm:association_autocreate:false" form:"roles" validate:"-"`
+  Roles []*RoleModel `json:"roles" gorm:"many2many:user_roles;constraint:OnDelete:CASCADE" form:"roles" validate:"-"`
 }
 
 func (user *UserModel) AfterCreate( tx *gorm.DB ) (err error) {
   var allAutoAssignRoles []*RoleModel
-  tx.Where( &RoleModel{ AutoAssign: true }).Find( &allAutoAssignRoles )
-  for i:=0; i< len(allAutoAssignRoles); i++ {
-    tx.Model(user).Association("Roles").Append(allAutoAssignRoles[i])
+  err = tx.Where( &RoleModel{ AutoAssign: true }).Find( &allAutoAssignRoles ).Error
+  if err != nil || len(allAutoAssignRoles) == 0 {
+    return
   }
-  return
+  return appendRoles( tx, user, allAutoAssignRoles... )
 }
 
 func (user *UserModel) BeforeDelete( tx *gorm.DB ) (err error) {
@@ -56,11 +57,7 @@ func (user *UserModel) BeforeDelete( tx *gorm.DB ) (err error) {
   return
 }
 
-func (user *UserModel) AfterDelete( tx *gorm.DB ) (err error) {
-  tx.Model(user).Association("Roles").Clear()
-  return
-}
-
+// BeforeSave runs on create and on update
 func (user *UserModel) BeforeSave( tx *gorm.DB ) (err error) {
   err = user.checkDuplicate(tx)
   if err != nil {
@@ -73,21 +70,12 @@ func (user *UserModel) BeforeSave( tx *gorm.DB ) (err error) {
   return
 }
 
-func (user *UserModel) BeforeCreate( tx *gorm.DB ) (err error) {
-  err = user.checkDuplicate(tx)
-  if err != nil {
-    return
-  }
-  err = user.checkRoles(tx)
-  if err != nil {
-    return
-  }
-  return
-}
-
 func (user *UserModel) checkDuplicate( tx *gorm.DB ) error {
   var existingUsers []UserModel
-  tx.Limit(1).Find( &existingUsers, "login = ? AND id != ?", user.Login, user.ID )
+  err := tx.Limit(1).Find( &existingUsers, "login = ? AND id != ?", user.Login, user.ID ).Error
+  if err != nil {
+    return err
+  }
 
   if len(existingUsers) > 0 {
     return globals.ErrDuplicateUser
@@ -101,10 +89,13 @@ func (user *UserModel) checkRoles( tx *gorm.DB ) error {
       return globals.ErrUserHasUnknownRole
     }
     var role RoleModel
-    tx.Take( &role,  user.Roles[i].ID )
-    if role.ID != user.Roles[i].ID {
+    err := tx.Take( &role,  user.Roles[i].ID ).Error
+    if errors.Is( err, gorm.ErrRecordNotFound ) {
       return globals.ErrUserHasUnknownRole
     }
+    if err != nil {
+      return err
+    }
   }
   return nil
 }
@@ -0,0 +1,44 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package models
+
+import "time"
+
+// WebauthnCredentialModel is a FIDO2/WebAuthn credential of a user,
+// used as second factor or passkey. CredentialId is base64url
+// encoded, PublicKey a COSE key. SignCount is the signature counter
+// of the last use. A counter not increasing means the credential
+// was cloned
+type WebauthnCredentialModel struct {
+  ID           uint       `json:"id" gorm:"primarykey"`
+  UserId       uint       `json:"userId" gorm:"index;not null"`
+  CredentialId string     `json:"credentialId" gorm:"type:varchar(1400);uniqueIndex;not null"`
+  PublicKey    []byte     `json:"publicKey" gorm:"not null"`
+  SignCount    uint32     `json:"signCount" gorm:"not null;default:0"`
+  Aaguid       string     `json:"aaguid" gorm:"type:varchar(36)"`
+  Name         string     `json:"name" gorm:"type:varchar(100)"`
+  CreatedAt    time.Time  `json:"createdAt"`
+  LastUsedAt   *time.Time `json:"lastUsedAt"`
+}
@@ -0,0 +1,131 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package oidc
+
+import (
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/sha256"
+  "crypto/x509"
+  "encoding/base64"
+  "encoding/pem"
+  "errors"
+  "io/ioutil"
+  "math/big"
+  "os"
+  "path/filepath"
+)
+
+const SIGNING_KEY_BITS = 2048
+
+var ErrNoSigningKey = errors.New( "oidc signing key not set" )
+var ErrInvalidSigningKey = errors.New( "oidc signing key file holds no rsa private key" )
+
+// JsonWebKey is the public part of the signing key as of RFC 7517
+type JsonWebKey struct {
+  Kty string `json:"kty"`
+  Use string `json:"use"`
+  Alg string `json:"alg"`
+  Kid string `json:"kid"`
+  N   string `json:"n"`
+  E   string `json:"e"`
+}
+
+type JsonWebKeySet struct {
+  Keys []*JsonWebKey `json:"keys"`
+}
+
+var signingKey *rsa.PrivateKey
+
+// id of the signing key in the header of tokens and in the key set
+var keyId string
+
+// LoadOrCreateSigningKey reads the rsa private key of the pem file
+// at path. If there is no such file, a new key is created and saved
+// there, so tokens stay valid across restarts
+func LoadOrCreateSigningKey( path string ) (*rsa.PrivateKey, error) {
+  content, err := ioutil.ReadFile( path )
+  if err == nil {
+    block, _ := pem.Decode( content )
+    if block == nil || block.Type != "RSA PRIVATE KEY" {
+      return nil, ErrInvalidSigningKey
+    }
+    return x509.ParsePKCS1PrivateKey( block.Bytes )
+  }
+  if !os.IsNotExist( err ) {
+    return nil, err
+  }
+
+  key, err := rsa.GenerateKey( rand.Reader, SIGNING_KEY_BITS )
+  if err != nil {
+    return nil, err
+  }
+  err = os.MkdirAll( filepath.Dir( path ), 0700 )
+  if err != nil {
+    return nil, err
+  }
+  content = pem.EncodeToMemory( &pem.Block{ Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey( key ) } )
+  err = ioutil.WriteFile( path, content, 0600 )
+  if err != nil {
+    return nil, err
+  }
+  return key, nil
+}
+
+// UseSigningKey sets the key tokens are signed with
+func UseSigningKey( key *rsa.PrivateKey ) {
+  mutex.Lock()
+  defer mutex.Unlock()
+  signingKey = key
+  keyId = ""
+  if key != nil {
+    sum := sha256.Sum256( x509.MarshalPKCS1PublicKey( &key.PublicKey ) )
+    keyId = base64.RawURLEncoding.EncodeToString( sum[:12] )
+  }
+}
+
+// Jwks returns the key set clients verify tokens with
+func Jwks() *JsonWebKeySet {
+  key, kid := currentSigningKey()
+  keySet := &JsonWebKeySet{ Keys: []*JsonWebKey{} }
+  if key == nil {
+    return keySet
+  }
+  keySet.Keys = append( keySet.Keys, &JsonWebKey{
+    Kty: "RSA",
+    Use: "sig",
+    Alg: "RS256",
+    Kid: kid,
+    N:   base64.RawURLEncoding.EncodeToString( key.N.Bytes() ),
+    E:   base64.RawURLEncoding.EncodeToString( big.NewInt( int64(key.E) ).Bytes() ),
+  })
+  return keySet
+}
+
+func currentSigningKey() (*rsa.PrivateKey, string) {
+  mutex.Lock()
+  defer mutex.Unlock()
+  return signingKey, keyId
+}
@@ -0,0 +1,402 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package oidc is a minimal OpenID Connect provider for cypherapps.
+// Every app is a client with its hash as client id and its secret as
+// client secret. Only the authorization code flow with PKCE is
+// supported. Tokens carry the roles of the user in the app
+package oidc
+
+import (
+  "crypto/rand"
+  "crypto/sha256"
+  "crypto/subtle"
+  "encoding/base64"
+  "errors"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "net/url"
+  "strings"
+  "sync"
+  "time"
+)
+
+const SCOPE_OPENID = "openid"
+const SCOPE_PROFILE = "profile"
+const SCOPE_EMAIL = "email"
+
+const RESPONSE_TYPE_CODE = "code"
+const GRANT_TYPE_AUTHORIZATION_CODE = "authorization_code"
+const CODE_CHALLENGE_METHOD_S256 = "S256"
+const PROMPT_NONE = "none"
+
+// authorization codes have to be exchanged within a minute
+const CODE_LIFETIME = time.Minute
+
+// error codes of RFC 6749, RFC 6750 and OpenID Connect
+const ERROR_INVALID_REQUEST = "invalid_request"
+const ERROR_INVALID_CLIENT = "invalid_client"
+const ERROR_INVALID_GRANT = "invalid_grant"
+const ERROR_INVALID_SCOPE = "invalid_scope"
+const ERROR_INVALID_TOKEN = "invalid_token"
+const ERROR_UNSUPPORTED_GRANT_TYPE = "unsupported_grant_type"
+const ERROR_UNSUPPORTED_RESPONSE_TYPE = "unsupported_response_type"
+const ERROR_LOGIN_REQUIRED = "login_required"
+const ERROR_SERVER_ERROR = "server_error"
+
+var ErrUnknownClient = errors.New( "unknown oidc client" )
+var ErrInvalidRedirectUri = errors.New( "redirect uri is not below the mount point of the app" )
+
+// Error is an error response of the authorization, token or
+// userinfo endpoint
+type Error struct {
+  Code        string `json:"error"`
+  Description string `json:"error_description,omitempty"`
+}
+
+func (err *Error) Error() string {
+  return err.Code+": "+err.Description
+}
+
+// Config of the provider. Apps are served below their mount point
+// at AppsBaseUrl and so must be their redirect uris
+type Config struct {
+  Issuer        string
+  AppsBaseUrl   string
+  TokenLifetime time.Duration
+}
+
+var DefaultConfig = Config{
+  Issuer:        "http://www.cna.localhost:3030/oidc",
+  AppsBaseUrl:   "http://www.cna.localhost:3030",
+  TokenLifetime: time.Hour,
+}
+
+var config = DefaultConfig
+
+// authorization codes waiting to be exchanged
+var grants = make( map[string]*grant )
+
+// guards config, signing key and grants
+var mutex sync.Mutex
+
+func Configure( newConfig Config ) {
+  mutex.Lock()
+  defer mutex.Unlock()
+  config = newConfig
+}
+
+func currentConfig() Config {
+  mutex.Lock()
+  defer mutex.Unlock()
+  return config
+}
+
+// DiscoveryDocument is the provider metadata of OpenID Connect
+// Discovery
+type DiscoveryDocument struct {
+  Issuer                                     string   `json:"issuer"`
+  AuthorizationEndpoint                      string   `json:"authorization_endpoint"`
+  TokenEndpoint                              string   `json:"token_endpoint"`
+  UserinfoEndpoint                           string   `json:"userinfo_endpoint"`
+  JwksUri                                    string   `json:"jwks_uri"`
+  ResponseTypesSupported                     []string `json:"response_types_supported"`
+  GrantTypesSupported                        []string `json:"grant_types_supported"`
+  SubjectTypesSupported                      []string `json:"subject_types_supported"`
+  IdTokenSigningAlgValuesSupported           []string `json:"id_token_signing_alg_values_supported"`
+  ScopesSupported                            []string `json:"scopes_supported"`
+  TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported"`
+  ClaimsSupported                            []string `json:"claims_supported"`
+  CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported"`
+  AuthorizationResponseIssParameterSupported bool     `json:"authorization_response_iss_parameter_supported"`
+}
+
+// Discovery returns the provider metadata
+func Discovery() *DiscoveryDocument {
+  issuer := currentConfig().Issuer
+  return &DiscoveryDocument{
+    Issuer:                            issuer,
+    AuthorizationEndpoint:             issuer+globals.OIDC_ENDPOINTS_AUTHORIZE,
+    TokenEndpoint:                     issuer+globals.OIDC_ENDPOINTS_TOKEN,
+    UserinfoEndpoint:                  issuer+globals.OIDC_ENDPOINTS_USERINFO,
+    JwksUri:                           issuer+globals.OIDC_ENDPOINTS_JWKS,
+    ResponseTypesSupported:            []string{ RESPONSE_TYPE_CODE },
+    GrantTypesSupported:               []string{ GRANT_TYPE_AUTHORIZATION_CODE },
+    SubjectTypesSupported:             []string{ "public" },
+    IdTokenSigningAlgValuesSupported:  []string{ "RS256" },
+    ScopesSupported:                   []string{ SCOPE_OPENID, SCOPE_PROFILE, SCOPE_EMAIL },
+    TokenEndpointAuthMethodsSupported: []string{ "client_secret_basic", "client_secret_post" },
+    ClaimsSupported: []string{
+      "iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "amr", "azp",
+      "preferred_username", "name", "email", "roles",
+    },
+    CodeChallengeMethodsSupported:              []string{ CODE_CHALLENGE_METHOD_S256 },
+    AuthorizationResponseIssParameterSupported: true,
+  }
+}
+
+// AuthorizationRequest are the parameters of the authorization
+// endpoint
+type AuthorizationRequest struct {
+  ResponseType        string `form:"response_type"`
+  ClientId            string `form:"client_id"`
+  RedirectUri         string `form:"redirect_uri"`
+  Scope               string `form:"scope"`
+  State               string `form:"state"`
+  Nonce               string `form:"nonce"`
+  CodeChallenge       string `form:"code_challenge"`
+  CodeChallengeMethod string `form:"code_challenge_method"`
+  Prompt              string `form:"prompt"`
+}
+
+// TokenRequest are the parameters of the token endpoint. Client id
+// and secret are taken from basic auth, if it is used
+type TokenRequest struct {
+  GrantType    string `form:"grant_type"`
+  Code         string `form:"code"`
+  RedirectUri  string `form:"redirect_uri"`
+  CodeVerifier string `form:"code_verifier"`
+  ClientId     string `form:"client_id"`
+  ClientSecret string `form:"client_secret"`
+}
+
+type grant struct {
+  clientId      string
+  redirectUri   string
+  scope         string
+  nonce         string
+  codeChallenge string
+  userId        uint
+  amr           []string
+  authTime      time.Time
+  expiresAt     time.Time
+}
+
+// Client returns the app with the hash clientId, if redirectUri is
+// below its mount point. Archived and quarantined apps are no
+// clients. Errors must not be redirected to redirectUri
+func Client( clientId string, redirectUri string ) (*models.AppModel, error) {
+  app, err := activeApp( clientId )
+  if err != nil {
+    return nil, err
+  }
+
+  appUrl := strings.TrimRight( currentConfig().AppsBaseUrl, "/" )+"/"+app.MountPoint
+  redirect, err := url.Parse( redirectUri )
+  if err != nil || redirect.Fragment != "" || redirect.User != nil ||
+    ( redirectUri != appUrl && !strings.HasPrefix( redirectUri, appUrl+"/" ) && !strings.HasPrefix( redirectUri, appUrl+"?" ) ) {
+    return nil, ErrInvalidRedirectUri
+  }
+  return app, nil
+}
+
+// Check tells if the request asks for an authorization code with a
+// PKCE challenge and the openid scope
+func (request *AuthorizationRequest) Check() *Error {
+  if request.ResponseType != RESPONSE_TYPE_CODE {
+    return &Error{ Code: ERROR_UNSUPPORTED_RESPONSE_TYPE, Description: "only the authorization code flow is supported" }
+  }
+  if !hasScope( request.Scope, SCOPE_OPENID ) {
+    return &Error{ Code: ERROR_INVALID_SCOPE, Description: "the openid scope is required" }
+  }
+  if request.CodeChallengeMethod != CODE_CHALLENGE_METHOD_S256 || len(request.CodeChallenge) != 43 {
+    return &Error{ Code: ERROR_INVALID_REQUEST, Description: "a S256 code challenge is required" }
+  }
+  return nil
+}
+
+// Url returns the url of the authorization endpoint with request.
+// Users without a session are sent back there after their login
+func (request *AuthorizationRequest) Url() string {
+  values := url.Values{}
+  for name, value := range map[string]string{
+    "response_type":         request.ResponseType,
+    "client_id":             request.ClientId,
+    "redirect_uri":          request.RedirectUri,
+    "scope":                 request.Scope,
+    "state":                 request.State,
+    "nonce":                 request.Nonce,
+    "code_challenge":        request.CodeChallenge,
+    "code_challenge_method": request.CodeChallengeMethod,
+    "prompt":                request.Prompt,
+  } {
+    if value != "" {
+      values.Set( name, value )
+    }
+  }
+  return currentConfig().Issuer+globals.OIDC_ENDPOINTS_AUTHORIZE+"?"+values.Encode()
+}
+
+// ErrorRedirect returns the redirect uri of request with err
+func (request *AuthorizationRequest) ErrorRedirect( err *Error ) string {
+  values := url.Values{ "error": { err.Code } }
+  if err.Description != "" {
+    values.Set( "error_description", err.Description )
+  }
+  return request.redirect( values )
+}
+
+// CodeRedirect returns the redirect uri of request with code
+func (request *AuthorizationRequest) CodeRedirect( code string ) string {
+  return request.redirect( url.Values{ "code": { code } } )
+}
+
+func (request *AuthorizationRequest) redirect( values url.Values ) string {
+  if request.State != "" {
+    values.Set( "state", request.State )
+  }
+  values.Set( "iss", currentConfig().Issuer )
+  separator := "?"
+  if strings.Contains( request.RedirectUri, "?" ) {
+    separator = "&"
+  }
+  return request.RedirectUri+separator+values.Encode()
+}
+
+// IssueCode returns a new authorization code of request for the user
+// with userId, who authenticated at authTime with the methods amr.
+// It can be exchanged only once
+func IssueCode( request *AuthorizationRequest, userId uint, amr []string, authTime time.Time ) (string, error) {
+  random := make( []byte, 32 )
+  _, err := rand.Read( random )
+  if err != nil {
+    return "", err
+  }
+  code := base64.RawURLEncoding.EncodeToString( random )
+
+  mutex.Lock()
+  defer mutex.Unlock()
+
+  now := time.Now()
+  for other, issued := range grants {
+    if now.After( issued.expiresAt ) {
+      delete( grants, other )
+    }
+  }
+  grants[code] = &grant{
+    clientId:      request.ClientId,
+    redirectUri:   request.RedirectUri,
+    scope:         request.Scope,
+    nonce:         request.Nonce,
+    codeChallenge: request.CodeChallenge,
+    userId:        userId,
+    amr:           amr,
+    authTime:      authTime,
+    expiresAt:     now.Add( CODE_LIFETIME ),
+  }
+  return code, nil
+}
+
+// Exchange checks request and returns the tokens of its code. Errors
+// to be sent to the client are of type *Error
+func Exchange( request *TokenRequest ) (*TokenResponse, error) {
+  if request.GrantType != GRANT_TYPE_AUTHORIZATION_CODE {
+    return nil, &Error{ Code: ERROR_UNSUPPORTED_GRANT_TYPE }
+  }
+
+  app, err := activeApp( request.ClientId )
+  if err == ErrUnknownClient || ( err == nil && subtle.ConstantTimeCompare( []byte(app.Secret), []byte(request.ClientSecret) ) != 1 ) {
+    return nil, &Error{ Code: ERROR_INVALID_CLIENT, Description: "unknown client or wrong secret" }
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  issued := takeGrant( request.Code )
+  if issued == nil || issued.clientId != request.ClientId || issued.redirectUri != request.RedirectUri {
+    return nil, &Error{ Code: ERROR_INVALID_GRANT, Description: "unknown or expired code" }
+  }
+  if !verifierMatches( request.CodeVerifier, issued.codeChallenge ) {
+    return nil, &Error{ Code: ERROR_INVALID_GRANT, Description: "code verifier does not match" }
+  }
+
+  var user models.UserModel
+  err = queries.Get( &user, issued.userId, false )
+  if errors.Is( err, globals.ErrNotFound ) {
+    return nil, &Error{ Code: ERROR_INVALID_GRANT, Description: "user does not exist anymore" }
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  return mintTokens( app, &user, issued )
+}
+
+// takeGrant removes the grant of code, so every code is exchanged
+// only once
+func takeGrant( code string ) *grant {
+  mutex.Lock()
+  defer mutex.Unlock()
+
+  issued, exists := grants[code]
+  if !exists {
+    return nil
+  }
+  delete( grants, code )
+
+  if time.Now().After( issued.expiresAt ) {
+    return nil
+  }
+  return issued
+}
+
+// activeApp returns the app with the hash clientId, unless it is
+// archived or quarantined. Apps without a secret, like the admin app,
+// cannot authenticate as clients and are never accepted
+func activeApp( clientId string ) (*models.AppModel, error) {
+  if clientId == "" {
+    return nil, ErrUnknownClient
+  }
+  app, err := queries.GetAppByHash( clientId )
+  if errors.Is( err, globals.ErrNotFound ) {
+    return nil, ErrUnknownClient
+  }
+  if err != nil {
+    return nil, err
+  }
+  if app.Secret == "" || app.IsArchived() || app.Quarantined {
+    return nil, ErrUnknownClient
+  }
+  return app, nil
+}
+
+// verifierMatches checks the PKCE code verifier against the S256
+// challenge of RFC 7636
+func verifierMatches( verifier string, challenge string ) bool {
+  if len(verifier) < 43 || len(verifier) > 128 {
+    return false
+  }
+  sum := sha256.Sum256( []byte(verifier) )
+  return subtle.ConstantTimeCompare( []byte(base64.RawURLEncoding.EncodeToString( sum[:] )), []byte(challenge) ) == 1
+}
+
+func hasScope( scope string, wanted string ) bool {
+  for _, value := range strings.Fields( scope ) {
+    if value == wanted {
+      return true
+    }
+  }
+  return false
+}
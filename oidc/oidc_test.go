@@ -0,0 +1,253 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package oidc_test
+
+import (
+  "crypto/rand"
+  "crypto/rsa"
+  "encoding/base64"
+  "github.com/dgrijalva/jwt-go"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/oidc"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/sirupsen/logrus"
+  "io/ioutil"
+  "math/big"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+)
+
+// code verifier and challenge of RFC 7636 appendix B
+const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+const challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+const redirectUri = "http://www.cna.localhost:3030/app/callback"
+
+func openDb( t *testing.T ) func() {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "oidc" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  err = dataSource.Init( "sqlite://"+filepath.Join( dir, "test.sqlite3" ) )
+  if err != nil {
+    os.RemoveAll( dir )
+    t.Fatal( err )
+  }
+  return func() {
+    dataSource.Close()
+    os.RemoveAll( dir )
+  }
+}
+
+func setup( t *testing.T ) *models.UserModel {
+  key, err := rsa.GenerateKey( rand.Reader, 2048 )
+  if err != nil {
+    t.Fatal( err )
+  }
+  oidc.UseSigningKey( key )
+  oidc.Configure( oidc.DefaultConfig )
+
+  app := &models.AppModel{ Name: "app", Hash: "appHash", Secret: "appSecret", MountPoint: "app",
+    AvailableRoles: []*models.RoleModel{ { Name: "reader" }, { Name: "writer" } } }
+  if err := queries.CreateApp( app ); err != nil {
+    t.Fatal( err )
+  }
+  secretless := &models.AppModel{ Name: "secretless", Hash: "secretlessHash", MountPoint: "secretless" }
+  if err := queries.CreateApp( secretless ); err != nil {
+    t.Fatal( err )
+  }
+  user := &models.UserModel{ Login: "alice", Name: "Alice", Password: "hash", Roles: []*models.RoleModel{ app.AvailableRoles[0] } }
+  if err := queries.CreateUser( user ); err != nil {
+    t.Fatal( err )
+  }
+  return user
+}
+
+func authorizationRequest() *oidc.AuthorizationRequest {
+  return &oidc.AuthorizationRequest{
+    ResponseType:        oidc.RESPONSE_TYPE_CODE,
+    ClientId:            "appHash",
+    RedirectUri:         redirectUri,
+    Scope:               "openid profile",
+    State:               "state",
+    Nonce:               "nonce",
+    CodeChallenge:       challenge,
+    CodeChallengeMethod: oidc.CODE_CHALLENGE_METHOD_S256,
+  }
+}
+
+func errorCode( err error ) string {
+  if oidcErr, ok := err.(*oidc.Error); ok {
+    return oidcErr.Code
+  }
+  return ""
+}
+
+func TestClient(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+  setup( t )
+
+  if _, err := oidc.Client( "appHash", redirectUri ); err != nil {
+    t.Errorf( "redirect uri below the mount point must be accepted, got %v", err )
+  }
+  for _, uri := range []string{
+    "http://www.cna.localhost:3030/other/callback",
+    "http://www.cna.localhost:3030/application",
+    "http://evil.com/app/callback",
+    redirectUri+"#fragment",
+  } {
+    if _, err := oidc.Client( "appHash", uri ); err != oidc.ErrInvalidRedirectUri {
+      t.Errorf( "expected %v for %s, got %v", oidc.ErrInvalidRedirectUri, uri, err )
+    }
+  }
+  if _, err := oidc.Client( "unknown", redirectUri ); err != oidc.ErrUnknownClient {
+    t.Errorf( "expected %v, got %v", oidc.ErrUnknownClient, err )
+  }
+  if _, err := oidc.Client( "secretlessHash", "http://www.cna.localhost:3030/secretless/callback" ); err != oidc.ErrUnknownClient {
+    t.Errorf( "apps without secret must not be clients, got %v", err )
+  }
+
+  request := authorizationRequest()
+  request.CodeChallengeMethod = "plain"
+  if err := request.Check(); err == nil || err.Code != oidc.ERROR_INVALID_REQUEST {
+    t.Errorf( "plain code challenges must be refused, got %v", err )
+  }
+  request = authorizationRequest()
+  request.Scope = "profile"
+  if err := request.Check(); err == nil || err.Code != oidc.ERROR_INVALID_SCOPE {
+    t.Errorf( "requests without openid scope must be refused, got %v", err )
+  }
+}
+
+func TestExchange(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+  user := setup( t )
+
+  request := authorizationRequest()
+  if err := request.Check(); err != nil {
+    t.Fatal( err )
+  }
+  authTime := time.Now().Add( -time.Minute )
+  code, err := oidc.IssueCode( request, user.ID, []string{ "pwd" }, authTime )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  tokenRequest := &oidc.TokenRequest{
+    GrantType:    oidc.GRANT_TYPE_AUTHORIZATION_CODE,
+    Code:         code,
+    RedirectUri:  redirectUri,
+    CodeVerifier: verifier,
+    ClientId:     "appHash",
+    ClientSecret: "wrongSecret",
+  }
+  if _, err := oidc.Exchange( tokenRequest ); errorCode( err ) != oidc.ERROR_INVALID_CLIENT {
+    t.Errorf( "expected %s, got %v", oidc.ERROR_INVALID_CLIENT, err )
+  }
+
+  tokenRequest.ClientSecret = ""
+  if _, err := oidc.Exchange( tokenRequest ); errorCode( err ) != oidc.ERROR_INVALID_CLIENT {
+    t.Errorf( "empty secret: expected %s, got %v", oidc.ERROR_INVALID_CLIENT, err )
+  }
+
+  secretlessRequest := authorizationRequest()
+  secretlessRequest.ClientId = "secretlessHash"
+  secretlessRequest.RedirectUri = "http://www.cna.localhost:3030/secretless/callback"
+  secretlessCode, _ := oidc.IssueCode( secretlessRequest, user.ID, []string{ "pwd" }, authTime )
+  _, err = oidc.Exchange( &oidc.TokenRequest{
+    GrantType:    oidc.GRANT_TYPE_AUTHORIZATION_CODE,
+    Code:         secretlessCode,
+    RedirectUri:  secretlessRequest.RedirectUri,
+    CodeVerifier: verifier,
+    ClientId:     "secretlessHash",
+  })
+  if errorCode( err ) != oidc.ERROR_INVALID_CLIENT {
+    t.Errorf( "apps without secret must not redeem codes, got %v", err )
+  }
+
+  tokenRequest.ClientSecret = "appSecret"
+  tokenRequest.CodeVerifier = verifier[1:]+"a"
+  if _, err := oidc.Exchange( tokenRequest ); errorCode( err ) != oidc.ERROR_INVALID_GRANT {
+    t.Errorf( "wrong verifier: expected %s, got %v", oidc.ERROR_INVALID_GRANT, err )
+  }
+
+  tokenRequest.Code, _ = oidc.IssueCode( request, user.ID, []string{ "pwd" }, authTime )
+  tokenRequest.CodeVerifier = verifier
+  tokens, err := oidc.Exchange( tokenRequest )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if _, err := oidc.Exchange( tokenRequest ); errorCode( err ) != oidc.ERROR_INVALID_GRANT {
+    t.Errorf( "codes must be exchanged only once, got %v", err )
+  }
+
+  keys := oidc.Jwks().Keys
+  if len(keys) != 1 {
+    t.Fatalf( "expected one key, got %d", len(keys) )
+  }
+  n, _ := base64.RawURLEncoding.DecodeString( keys[0].N )
+  e, _ := base64.RawURLEncoding.DecodeString( keys[0].E )
+  publicKey := &rsa.PublicKey{ N: new(big.Int).SetBytes( n ), E: int(new(big.Int).SetBytes( e ).Int64()) }
+
+  idToken, err := jwt.Parse( tokens.IdToken, func( token *jwt.Token ) (interface{}, error) {
+    if token.Header["kid"] != keys[0].Kid {
+      t.Errorf( "expected key id %s, got %v", keys[0].Kid, token.Header["kid"] )
+    }
+    return publicKey, nil
+  })
+  if err != nil {
+    t.Fatal( err )
+  }
+  claims := idToken.Claims.(jwt.MapClaims)
+  if claims["iss"] != oidc.DefaultConfig.Issuer || claims["aud"] != "appHash" || claims["nonce"] != "nonce" ||
+    claims["preferred_username"] != "alice" || claims["auth_time"] != float64(authTime.Unix()) {
+    t.Errorf( "unexpected id token claims %v", claims )
+  }
+  roles, _ := claims["roles"].([]interface{})
+  if len(roles) != 1 || roles[0] != "reader" {
+    t.Errorf( "expected the roles of alice in the app, got %v", claims["roles"] )
+  }
+
+  userInfo, err := oidc.UserInfo( tokens.AccessToken )
+  if err != nil || userInfo["sub"] != claims["sub"] || userInfo["name"] != "Alice" {
+    t.Errorf( "unexpected user info %v %v", userInfo, err )
+  }
+  if _, err := oidc.UserInfo( tokens.IdToken ); errorCode( err ) != oidc.ERROR_INVALID_TOKEN {
+    t.Errorf( "id tokens must not be access tokens, got %v", err )
+  }
+
+  otherKey, _ := rsa.GenerateKey( rand.Reader, 2048 )
+  oidc.UseSigningKey( otherKey )
+  if _, err := oidc.UserInfo( tokens.AccessToken ); errorCode( err ) != oidc.ERROR_INVALID_TOKEN {
+    t.Errorf( "tokens of other keys must be refused, got %v", err )
+  }
+}
@@ -0,0 +1,222 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package oidc
+
+import (
+  "errors"
+  "fmt"
+  "github.com/dgrijalva/jwt-go"
+  "github.com/schulterklopfer/cyphernode_fauth/forwardAuth"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/mfa"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/schulterklopfer/cyphernode_fauth/stores"
+  "strconv"
+  "time"
+)
+
+// type of access tokens as of RFC 9068, so id tokens are no access
+// tokens
+const ACCESS_TOKEN_TYPE = "at+jwt"
+
+// TokenResponse is the response of the token endpoint
+type TokenResponse struct {
+  AccessToken string `json:"access_token"`
+  TokenType   string `json:"token_type"`
+  ExpiresIn   int64  `json:"expires_in"`
+  IdToken     string `json:"id_token"`
+  Scope       string `json:"scope"`
+}
+
+// mintTokens signs the id token and the access token of the grant
+func mintTokens( app *models.AppModel, user *models.UserModel, issued *grant ) (*TokenResponse, error) {
+  current := currentConfig()
+  now := time.Now()
+  expiresAt := now.Add( current.TokenLifetime )
+
+  idClaims, err := userClaims( app, user, issued.scope, issued.amr )
+  if err != nil {
+    return nil, err
+  }
+  idClaims["iss"] = current.Issuer
+  idClaims["aud"] = app.Hash
+  idClaims["azp"] = app.Hash
+  idClaims["iat"] = now.Unix()
+  idClaims["exp"] = expiresAt.Unix()
+  idClaims["auth_time"] = issued.authTime.Unix()
+  idClaims["amr"] = issued.amr
+  if issued.nonce != "" {
+    idClaims["nonce"] = issued.nonce
+  }
+
+  idToken, err := sign( idClaims, "JWT" )
+  if err != nil {
+    return nil, err
+  }
+
+  accessToken, err := sign( jwt.MapClaims{
+    "iss":       current.Issuer,
+    "sub":       subject( user ),
+    "aud":       app.Hash,
+    "client_id": app.Hash,
+    "scope":     issued.scope,
+    "amr":       issued.amr,
+    "auth_time": issued.authTime.Unix(),
+    "iat":       now.Unix(),
+    "exp":       expiresAt.Unix(),
+  }, ACCESS_TOKEN_TYPE )
+  if err != nil {
+    return nil, err
+  }
+
+  return &TokenResponse{
+    AccessToken: accessToken,
+    TokenType:   "Bearer",
+    ExpiresIn:   int64(current.TokenLifetime / time.Second),
+    IdToken:     idToken,
+    Scope:       issued.scope,
+  }, nil
+}
+
+// UserInfo returns the claims of the user of accessToken. Errors to
+// be sent to the client are of type *Error
+func UserInfo( accessToken string ) (jwt.MapClaims, error) {
+  invalidToken := &Error{ Code: ERROR_INVALID_TOKEN }
+
+  token, err := jwt.Parse( accessToken, func( token *jwt.Token ) (interface{}, error) {
+    if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+      return nil, fmt.Errorf( "unexpected signing method: %v", token.Header["alg"] )
+    }
+    if token.Header["typ"] != ACCESS_TOKEN_TYPE {
+      return nil, errors.New( "not an access token" )
+    }
+    key, _ := currentSigningKey()
+    if key == nil {
+      return nil, ErrNoSigningKey
+    }
+    return &key.PublicKey, nil
+  })
+  if err != nil || !token.Valid {
+    return nil, invalidToken
+  }
+
+  claims, ok := token.Claims.(jwt.MapClaims)
+  if !ok || !claims.VerifyIssuer( currentConfig().Issuer, true ) {
+    return nil, invalidToken
+  }
+  clientId, _ := claims["client_id"].(string)
+  subjectClaim, _ := claims["sub"].(string)
+  scope, _ := claims["scope"].(string)
+  userId, err := strconv.ParseUint( subjectClaim, 10, 64 )
+  if err != nil {
+    return nil, invalidToken
+  }
+  var amr []string
+  if methods, ok := claims["amr"].([]interface{}); ok {
+    for _, method := range methods {
+      if name, ok := method.(string); ok {
+        amr = append( amr, name )
+      }
+    }
+  }
+
+  app, err := activeApp( clientId )
+  if err == ErrUnknownClient {
+    return nil, invalidToken
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  var user models.UserModel
+  err = queries.Get( &user, uint(userId), false )
+  if errors.Is( err, globals.ErrNotFound ) {
+    return nil, invalidToken
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  return userClaims( app, &user, scope, amr )
+}
+
+// userClaims are the claims about user the scope asks for and the
+// roles of user in app. Like with forward auth, admins without a
+// second factor don't get the admin role, if mfa is required
+func userClaims( app *models.AppModel, user *models.UserModel, scope string, amr []string ) (jwt.MapClaims, error) {
+  roles, err := queries.RolesOfUserInApp( user.ID, app.ID )
+  if err != nil {
+    return nil, err
+  }
+
+  roleNames := make( []string, 0, len(roles) )
+  for _, roleName := range stores.RoleNames( roles ) {
+    if roleName == globals.BASE_ADMIN_ROLE && app.MountPoint == globals.BASE_ADMIN_MOUNTPOINT &&
+      mfa.RequiredForAdmins() && !hasMethod( amr, forwardAuth.AMR_MFA ) {
+      continue
+    }
+    roleNames = append( roleNames, roleName )
+  }
+
+  claims := jwt.MapClaims{
+    "sub":   subject( user ),
+    "roles": roleNames,
+  }
+  if hasScope( scope, SCOPE_PROFILE ) {
+    claims["preferred_username"] = user.Login
+    if user.Name != "" {
+      claims["name"] = user.Name
+    }
+  }
+  if hasScope( scope, SCOPE_EMAIL ) && user.EmailAddress != "" {
+    claims["email"] = user.EmailAddress
+  }
+  return claims, nil
+}
+
+func sign( claims jwt.MapClaims, tokenType string ) (string, error) {
+  key, kid := currentSigningKey()
+  if key == nil {
+    return "", ErrNoSigningKey
+  }
+  token := jwt.NewWithClaims( jwt.SigningMethodRS256, claims )
+  token.Header["kid"] = kid
+  token.Header["typ"] = tokenType
+  return token.SignedString( key )
+}
+
+func subject( user *models.UserModel ) string {
+  return strconv.FormatUint( uint64(user.ID), 10 )
+}
+
+func hasMethod( amr []string, method string ) bool {
+  for _, other := range amr {
+    if other == method {
+      return true
+    }
+  }
+  return false
+}
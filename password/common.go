@@ -0,0 +1,63 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package password
+
+import "strings"
+
+// commonPasswords are the most common passwords of public breach
+// corpora. They are refused no matter how long they are
+var commonPasswords = toSet( `
+123456 123456789 12345678 12345 1234567 1234567890 123123 000000 111111 1234
+password password1 password123 passw0rd p@ssw0rd p@ssword pa55word pass1234
+qwerty qwerty123 qwertyuiop qwerty1 1q2w3e4r 1q2w3e4r5t 1qaz2wsx zaq12wsx
+qazwsx asdfgh asdfghjkl asdf1234 zxcvbnm zxcvbn azerty abc123 abcd1234 abcdef
+iloveyou iloveyou1 princess sunshine football baseball basketball soccer hockey
+dragon monkey shadow master superman batman trustno1 letmein letmein1 welcome
+welcome1 welcome123 admin admin123 administrator root toor changeme default
+login guest test test123 testing secret secret123 user1234 demo
+michael jennifer jordan hunter hunter2 charlie thomas jessica daniel ashley
+matthew andrew joshua robert william michelle nicole amanda starwars pokemon
+freedom whatever computer internet samsung google mustang cheese cookie
+chocolate pepper ginger killer summer winter spring autumn flower maggie
+buster tigger harley hannah banana orange purple silver golden diamond
+ranger cowboy thunder hello hello123 loveme lovely love123 mylove
+666666 654321 121212 112233 123321 696969 777777 888888 999999 987654321
+11111111 22222222 12341234 123qwe 123abc 1qazxsw2 q1w2e3r4 q1w2e3r4t5
+a1b2c3d4 aa123456 abc12345 1234qwer qwer1234 11223344 147258369 159753
+789456123 741852963 000000000 0987654321 99999999 88888888 00000000
+passport passwords password12 password1234 password01 password! qwerty12
+zaq1zaq1 asdasd asdasdasd qweqwe qweasd qweasdzxc 1q2w3e 1q2w3e4r5t6y
+bitcoin bitcoin1 satoshi satoshi1 nakamoto blockchain crypto ethereum
+lightning cyphernode cypherapps hodl hodl1234 tothemoon moon1234 wallet
+mybitcoin btc12345 satoshinakamoto
+` )
+
+func toSet( list string ) map[string]bool {
+  set := make( map[string]bool )
+  for _, entry := range strings.Fields( list ) {
+    set[strings.ToLower( entry )] = true
+  }
+  return set
+}
@@ -24,14 +24,155 @@
 
 package password
 
-import "golang.org/x/crypto/bcrypt"
+import (
+  "crypto/rand"
+  "crypto/subtle"
+  "encoding/base64"
+  "fmt"
+  "golang.org/x/crypto/argon2"
+  "golang.org/x/crypto/bcrypt"
+  "strings"
+  "sync"
+)
+
+const ALGORITHM_BCRYPT = "bcrypt"
+const ALGORITHM_ARGON2ID = "argon2id"
+
+const argon2SaltLength = 16
+const argon2KeyLength = 32
+
+// Params decide how new hashes are made. Argon2Memory is in KiB
+type Params struct {
+  Algorithm     string
+  BcryptCost    int
+  Argon2Time    uint32
+  Argon2Memory  uint32
+  Argon2Threads uint8
+}
+
+// bcrypt cost 10 keeps logins fast on small hardware
+var DefaultParams = Params{
+  Algorithm:     ALGORITHM_BCRYPT,
+  BcryptCost:    10,
+  Argon2Time:    1,
+  Argon2Memory:  64*1024,
+  Argon2Threads: 2,
+}
+
+var params = DefaultParams
+var paramsMutex sync.RWMutex
+
+// Configure sets the params of new hashes. Existing hashes are
+// still accepted, see NeedsRehash
+func Configure( newParams Params ) error {
+  switch newParams.Algorithm {
+  case ALGORITHM_BCRYPT:
+    if newParams.BcryptCost < bcrypt.MinCost || newParams.BcryptCost > bcrypt.MaxCost {
+      return fmt.Errorf( "bcrypt cost has to be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost )
+    }
+  case ALGORITHM_ARGON2ID:
+    if newParams.Argon2Time == 0 || newParams.Argon2Memory < 8*uint32(newParams.Argon2Threads) || newParams.Argon2Threads == 0 {
+      return fmt.Errorf( "argon2id needs a time and threads of at least 1 and 8 KiB of memory per thread" )
+    }
+  default:
+    return fmt.Errorf( "unknown hash algorithm %s", newParams.Algorithm )
+  }
+
+  paramsMutex.Lock()
+  defer paramsMutex.Unlock()
+  params = newParams
+  return nil
+}
+
+func currentParams() Params {
+  paramsMutex.RLock()
+  defer paramsMutex.RUnlock()
+  return params
+}
 
 func HashPassword(password string) (string, error) {
-  bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+  p := currentParams()
+  if p.Algorithm == ALGORITHM_ARGON2ID {
+    return hashArgon2id( password, p )
+  }
+  bytes, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
   return string(bytes), err
 }
 
 func CheckPasswordHash(password, hash string) bool {
+  if strings.HasPrefix( hash, "$"+ALGORITHM_ARGON2ID+"$" ) {
+    return checkArgon2id( password, hash )
+  }
   err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
   return err == nil
 }
+
+// NeedsRehash tells if hash was made with other params than new
+// hashes are. Call it after a successful CheckPasswordHash and
+// replace the hash with a new one of the password
+func NeedsRehash( hash string ) bool {
+  p := currentParams()
+
+  if strings.HasPrefix( hash, "$"+ALGORITHM_ARGON2ID+"$" ) {
+    hashParams, _, _, err := decodeArgon2id( hash )
+    return err != nil || p.Algorithm != ALGORITHM_ARGON2ID ||
+        hashParams.Argon2Time != p.Argon2Time ||
+        hashParams.Argon2Memory != p.Argon2Memory ||
+        hashParams.Argon2Threads != p.Argon2Threads
+  }
+
+  cost, err := bcrypt.Cost( []byte(hash) )
+  return err != nil || p.Algorithm != ALGORITHM_BCRYPT || cost != p.BcryptCost
+}
+
+// argon2id hashes use the PHC string format:
+// $argon2id$v=19$m=65536,t=1,p=2$<salt>$<key>
+func hashArgon2id( password string, p Params ) (string, error) {
+  salt := make( []byte, argon2SaltLength )
+  _, err := rand.Read( salt )
+  if err != nil {
+    return "", err
+  }
+  key := argon2.IDKey( []byte(password), salt, p.Argon2Time, p.Argon2Memory, p.Argon2Threads, argon2KeyLength )
+  return fmt.Sprintf( "$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", ALGORITHM_ARGON2ID, argon2.Version,
+    p.Argon2Memory, p.Argon2Time, p.Argon2Threads,
+    base64.RawStdEncoding.EncodeToString( salt ), base64.RawStdEncoding.EncodeToString( key ) ), nil
+}
+
+func checkArgon2id( password string, hash string ) bool {
+  p, salt, key, err := decodeArgon2id( hash )
+  if err != nil {
+    return false
+  }
+  other := argon2.IDKey( []byte(password), salt, p.Argon2Time, p.Argon2Memory, p.Argon2Threads, uint32(len(key)) )
+  return subtle.ConstantTimeCompare( key, other ) == 1
+}
+
+func decodeArgon2id( hash string ) (Params, []byte, []byte, error) {
+  p := Params{ Algorithm: ALGORITHM_ARGON2ID }
+  parts := strings.Split( hash, "$" )
+  if len(parts) != 6 {
+    return p, nil, nil, fmt.Errorf( "malformed argon2id hash" )
+  }
+
+  var version int
+  _, err := fmt.Sscanf( parts[2], "v=%d", &version )
+  if err != nil || version != argon2.Version {
+    return p, nil, nil, fmt.Errorf( "unsupported argon2id version" )
+  }
+
+  _, err = fmt.Sscanf( parts[3], "m=%d,t=%d,p=%d", &p.Argon2Memory, &p.Argon2Time, &p.Argon2Threads )
+  if err != nil {
+    return p, nil, nil, err
+  }
+
+  salt, err := base64.RawStdEncoding.DecodeString( parts[4] )
+  if err != nil {
+    return p, nil, nil, err
+  }
+  key, err := base64.RawStdEncoding.DecodeString( parts[5] )
+  if err != nil || len(key) == 0 {
+    return p, nil, nil, fmt.Errorf( "malformed argon2id hash" )
+  }
+  return p, salt, key, nil
+}
@@ -25,7 +25,11 @@
 package password_test
 
 import (
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
   "github.com/schulterklopfer/cyphernode_fauth/password"
+  "io/ioutil"
+  "os"
+  "path/filepath"
   "testing"
 )
 
@@ -36,4 +40,89 @@ func TestHashing(t *testing.T) {
   if err != nil || !match {
     t.Error( "Failed to hash and verify")
   }
-}
\ No newline at end of file
+}
+
+func TestArgon2id(t *testing.T) {
+  defer password.Configure( password.DefaultParams )
+
+  bcryptHash, _ := password.HashPassword( "test123" )
+
+  params := password.DefaultParams
+  params.Algorithm = password.ALGORITHM_ARGON2ID
+  params.Argon2Memory = 1024
+  err := password.Configure( params )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  argon2Hash, err := password.HashPassword( "test123" )
+  if err != nil || !password.CheckPasswordHash( "test123", argon2Hash ) {
+    t.Fatal( "Failed to hash and verify with argon2id" )
+  }
+  if password.CheckPasswordHash( "test1234", argon2Hash ) {
+    t.Error( "wrong password verified" )
+  }
+  if !password.CheckPasswordHash( "test123", bcryptHash ) {
+    t.Error( "bcrypt hashes must still verify" )
+  }
+
+  if password.NeedsRehash( argon2Hash ) || !password.NeedsRehash( bcryptHash ) {
+    t.Error( "only the bcrypt hash needs a rehash" )
+  }
+
+  params.Argon2Time = 2
+  _ = password.Configure( params )
+  if !password.NeedsRehash( argon2Hash ) {
+    t.Error( "hashes with other argon2id params need a rehash" )
+  }
+
+  params.Algorithm = "md5"
+  if password.Configure( params ) == nil {
+    t.Error( "unknown algorithms must not be configured" )
+  }
+}
+
+func TestPolicy(t *testing.T) {
+  defer password.ConfigurePolicy( password.DefaultPolicy )
+
+  checks := []struct{
+    login string
+    password string
+    err error
+  }{
+    { "someone", "short", globals.ErrPasswordTooShort },
+    { "someone", "Password1", globals.ErrPasswordTooCommon },
+    { "Satoshi_Nakamoto", "satoshi_nakamoto", globals.ErrPasswordIsLogin },
+    { "someone", "correct horse", nil },
+  }
+
+  for _, check := range checks {
+    err := password.Check( check.login, check.password )
+    if err != check.err {
+      t.Errorf( "expected %v for %s, got %v", check.err, check.password, err )
+    }
+  }
+
+  dir, err := ioutil.TempDir( "", "password" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer os.RemoveAll( dir )
+
+  breachedFile := filepath.Join( dir, "breached.txt" )
+  _ = ioutil.WriteFile( breachedFile, []byte( "# breached\nCorrect Horse\n\n" ), 0600 )
+
+  forbidden, err := password.LoadForbidden( breachedFile )
+  if err != nil || len(forbidden) != 1 {
+    t.Fatalf( "expected one forbidden password, got %v %v", forbidden, err )
+  }
+
+  password.ConfigurePolicy( password.Policy{ MinLength: 4, Forbidden: forbidden } )
+
+  if password.Check( "someone", "correct horse" ) != globals.ErrPasswordTooCommon {
+    t.Error( "breached passwords must not be used" )
+  }
+  if password.Check( "someone", "short" ) != nil {
+    t.Error( "min length is configurable" )
+  }
+}
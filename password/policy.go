@@ -0,0 +1,99 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package password
+
+import (
+  "bufio"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "os"
+  "strings"
+  "sync"
+  "unicode/utf8"
+)
+
+// Policy is what new passwords have to comply with. Forbidden
+// passwords are compared case insensitive and extend the bundled
+// list of common passwords
+type Policy struct {
+  MinLength int
+  Forbidden map[string]bool
+}
+
+var DefaultPolicy = Policy{ MinLength: 8 }
+
+var policy = DefaultPolicy
+var policyMutex sync.RWMutex
+
+// ConfigurePolicy sets the policy checked by Check
+func ConfigurePolicy( newPolicy Policy ) {
+  policyMutex.Lock()
+  defer policyMutex.Unlock()
+  policy = newPolicy
+}
+
+// LoadForbidden reads forbidden passwords from a file with one
+// password per line. Empty lines and lines starting with # are
+// skipped
+func LoadForbidden( path string ) (map[string]bool, error) {
+  file, err := os.Open( path )
+  if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+
+  forbidden := make( map[string]bool )
+  scanner := bufio.NewScanner( file )
+  for scanner.Scan() {
+    line := strings.TrimSpace( scanner.Text() )
+    if line == "" || strings.HasPrefix( line, "#" ) {
+      continue
+    }
+    forbidden[strings.ToLower( line )] = true
+  }
+  return forbidden, scanner.Err()
+}
+
+// Check tells why password can't be used by the user with login.
+// Only new passwords are checked, existing ones keep working
+func Check( login string, password string ) error {
+  policyMutex.RLock()
+  defer policyMutex.RUnlock()
+
+  if utf8.RuneCountInString( password ) < policy.MinLength {
+    return globals.ErrPasswordTooShort
+  }
+
+  lowerPassword := strings.ToLower( password )
+
+  if login != "" && lowerPassword == strings.ToLower( login ) {
+    return globals.ErrPasswordIsLogin
+  }
+
+  if commonPasswords[lowerPassword] || policy.Forbidden[lowerPassword] {
+    return globals.ErrPasswordTooCommon
+  }
+
+  return nil
+}
@@ -0,0 +1,82 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package policy
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "sync"
+  "time"
+)
+
+type cacheEntry struct {
+  updatedAt time.Time
+  engine    *Engine
+}
+
+var cache = make( map[uint]*cacheEntry )
+var cacheMutex sync.Mutex
+
+// ForApp returns the engine for the access policies of app. Engines
+// of apps stored in the database are compiled once per change. Only
+// the engine of the latest version of an app is kept
+func ForApp( app *models.AppModel ) *Engine {
+  if app.ID == 0 {
+    return NewEngine( app.AccessPolicies )
+  }
+
+  cacheMutex.Lock()
+  defer cacheMutex.Unlock()
+
+  entry, ok := cache[app.ID]
+  if ok && entry.updatedAt.Equal( app.UpdatedAt ) {
+    return entry.engine
+  }
+
+  engine := NewEngine( app.AccessPolicies )
+  for _, err := range engine.Errors {
+    logwrapper.Logger().Warnf( "Access policies of %s: %s", app.Name, err.Error() )
+  }
+
+  if ok && entry.updatedAt.After( app.UpdatedAt ) {
+    // app was loaded before its last change. keep the newer engine
+    return engine
+  }
+
+  // replaces the engine of the previous version
+  cache[app.ID] = &cacheEntry{
+    updatedAt: app.UpdatedAt,
+    engine:    engine,
+  }
+
+  return engine
+}
+
+// Forget drops the engine of a deleted app
+func Forget( appId uint ) {
+  cacheMutex.Lock()
+  defer cacheMutex.Unlock()
+  delete( cache, appId )
+}
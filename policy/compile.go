@@ -0,0 +1,145 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package policy
+
+import (
+  "errors"
+  "fmt"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "regexp"
+  "strings"
+)
+
+const EFFECT_ALLOW = "allow"
+const EFFECT_DENY = "deny"
+
+type condition struct {
+  header  string
+  query   string
+  pattern *regexp.Regexp
+}
+
+// compiledPolicy is an access policy with its regexps compiled
+// and its actions and roles normalised
+type compiledPolicy struct {
+  index      int
+  effect     string
+  anyAction  bool
+  actions    map[string]bool
+  patterns   []*regexp.Regexp
+  anyRole    bool
+  roles      map[string]bool
+  conditions []*condition
+  requireMfa bool
+  broken     bool
+}
+
+// NormaliseAction trims and lowercases an action verb
+func NormaliseAction( action string ) string {
+  return strings.ToLower( strings.TrimSpace( action ) )
+}
+
+// NormaliseEffect returns the effect of a policy. cam treats
+// everything but allow as deny, so do we. Validate reports missing
+// and unknown effects, since they turn a policy into a hard deny
+func NormaliseEffect( effect string ) string {
+  if strings.ToLower( strings.TrimSpace( effect ) ) == EFFECT_ALLOW {
+    return EFFECT_ALLOW
+  }
+  return EFFECT_DENY
+}
+
+func compileCondition( accessCondition *models.AccessCondition ) (*condition, error) {
+  if accessCondition == nil {
+    return nil, errors.New( "empty condition" )
+  }
+  if ( accessCondition.Header == "" ) == ( accessCondition.Query == "" ) {
+    return nil, errors.New( "condition needs either a header or a query parameter" )
+  }
+  c := &condition{
+    header: accessCondition.Header,
+    query:  accessCondition.Query,
+  }
+  if accessCondition.Pattern != "" {
+    pattern, err := regexp.Compile( accessCondition.Pattern )
+    if err != nil {
+      return nil, err
+    }
+    c.pattern = pattern
+  }
+  return c, nil
+}
+
+// compilePolicy compiles accessPolicy. Invalid patterns and conditions
+// are reported as errors. Invalid patterns never match, a policy with
+// an invalid condition never applies
+func compilePolicy( index int, accessPolicy *models.AccessPolicy ) (*compiledPolicy, []error) {
+  var errs []error
+
+  cp := &compiledPolicy{
+    index:   index,
+    effect:     NormaliseEffect( accessPolicy.Effect ),
+    actions:    make( map[string]bool ),
+    roles:      make( map[string]bool ),
+    requireMfa: accessPolicy.RequireMfa,
+  }
+
+  for _, action := range accessPolicy.Actions {
+    action = NormaliseAction( action )
+    if action == "*" {
+      cp.anyAction = true
+    }
+    cp.actions[action] = true
+  }
+
+  for _, p := range accessPolicy.Patterns {
+    pattern, err := regexp.Compile( p )
+    if err != nil {
+      errs = append( errs, fmt.Errorf( "policy #%d: invalid pattern %q: %s", index, p, err.Error() ) )
+      continue
+    }
+    cp.patterns = append( cp.patterns, pattern )
+  }
+
+  for _, role := range accessPolicy.Roles {
+    if role == "*" {
+      cp.anyRole = true
+    }
+    cp.roles[role] = true
+  }
+
+  for _, accessCondition := range accessPolicy.Conditions {
+    c, err := compileCondition( accessCondition )
+    if err != nil {
+      errs = append( errs, fmt.Errorf( "policy #%d: invalid condition: %s", index, err.Error() ) )
+      // never apply a policy we cannot evaluate completely
+      cp.broken = true
+      continue
+    }
+    cp.conditions = append( cp.conditions, c )
+  }
+
+  return cp, errs
+}
@@ -0,0 +1,262 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package policy
+
+import (
+  "fmt"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/mfa"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "net/http"
+  "net/url"
+  "strings"
+)
+
+// Request is what access policies are evaluated against
+type Request struct {
+  Method string
+  // path in the app including the query string
+  URI    string
+  Header http.Header
+  // names of the user's roles in the app. nil for anonymous requests
+  Roles  []string
+  // session was authenticated with more than one factor
+  Mfa    bool
+}
+
+// DropAdminWithoutMfa removes the admin role from requests to the
+// admin app, if admins need a second factor and the session has none.
+// Those admins are regular users then, so they can still enroll one
+func DropAdminWithoutMfa( app *models.AppModel, request *Request ) {
+  if request.Mfa || app.MountPoint != globals.BASE_ADMIN_MOUNTPOINT || !mfa.RequiredForAdmins() {
+    return
+  }
+
+  // keep anonymous requests anonymous
+  if request.Roles == nil {
+    return
+  }
+
+  roles := make( []string, 0, len(request.Roles) )
+  for _, role := range request.Roles {
+    if role != globals.BASE_ADMIN_ROLE {
+      roles = append( roles, role )
+    }
+  }
+  request.Roles = roles
+}
+
+// Evaluation explains why a single policy did or did not match
+type Evaluation struct {
+  Index   int    `json:"index"`
+  Effect  string `json:"effect"`
+  Matched bool   `json:"matched"`
+  Reason  string `json:"reason"`
+}
+
+type Decision struct {
+  Allowed     bool          `json:"allowed"`
+  // index of the deciding policy, -1 if no policy matched
+  PolicyIndex int           `json:"policyIndex"`
+  Reason      string        `json:"reason"`
+  // only filled in explain mode
+  Evaluations []*Evaluation `json:"evaluations,omitempty"`
+}
+
+// Engine evaluates the access policies of an app with deny-overrides
+// semantics: a single matching deny policy refuses access, no matter
+// which allow policies match. Without any matching policy access is
+// refused as well.
+type Engine struct {
+  policies []*compiledPolicy
+  // problems found while compiling the policies
+  Errors   []error
+}
+
+func NewEngine( accessPolicies models.AccessPolicies ) *Engine {
+  engine := new( Engine )
+  for index, accessPolicy := range accessPolicies {
+    if accessPolicy == nil {
+      continue
+    }
+    cp, errs := compilePolicy( index, accessPolicy )
+    engine.policies = append( engine.policies, cp )
+    engine.Errors = append( engine.Errors, errs... )
+  }
+  return engine
+}
+
+func (engine *Engine) Evaluate( request *Request ) *Decision {
+  return engine.evaluate( request, false )
+}
+
+// Explain evaluates request like Evaluate, but reports the
+// outcome of every single policy
+func (engine *Engine) Explain( request *Request ) *Decision {
+  return engine.evaluate( request, true )
+}
+
+func (engine *Engine) evaluate( request *Request, explain bool ) *Decision {
+  var query url.Values
+  if i := strings.Index( request.URI, "?" ); i != -1 {
+    query, _ = url.ParseQuery( request.URI[i+1:] )
+  }
+
+  decision := &Decision{ PolicyIndex: -1 }
+  var firstAllow *compiledPolicy
+  var firstDeny *compiledPolicy
+
+  for _, cp := range engine.policies {
+    matched, reason := cp.matches( request, query )
+
+    if explain {
+      decision.Evaluations = append( decision.Evaluations, &Evaluation{
+        Index:   cp.index,
+        Effect:  cp.effect,
+        Matched: matched,
+        Reason:  reason,
+      })
+    }
+
+    if !matched {
+      continue
+    }
+
+    if cp.effect == EFFECT_DENY && firstDeny == nil {
+      firstDeny = cp
+      if !explain {
+        // deny overrides everything else
+        break
+      }
+    }
+
+    if cp.effect == EFFECT_ALLOW && firstAllow == nil {
+      firstAllow = cp
+    }
+  }
+
+  switch {
+  case firstDeny != nil:
+    decision.PolicyIndex = firstDeny.index
+    decision.Reason = fmt.Sprintf( "denied by policy #%d", firstDeny.index )
+  case firstAllow != nil:
+    decision.Allowed = true
+    decision.PolicyIndex = firstAllow.index
+    decision.Reason = fmt.Sprintf( "allowed by policy #%d", firstAllow.index )
+  default:
+    decision.Reason = "no policy allows access"
+  }
+
+  return decision
+}
+
+func (cp *compiledPolicy) matches( request *Request, query url.Values ) (bool, string) {
+  method := NormaliseAction( request.Method )
+  if !cp.anyAction && !cp.actions[method] {
+    return false, fmt.Sprintf( "action %q not covered", method )
+  }
+
+  patternMatches := false
+  for _, pattern := range cp.patterns {
+    if pattern.MatchString( request.URI ) {
+      patternMatches = true
+      break
+    }
+  }
+  if !patternMatches {
+    return false, fmt.Sprintf( "no pattern matches %q", request.URI )
+  }
+
+  roleMatches := cp.anyRole
+  for _, role := range request.Roles {
+    if roleMatches {
+      break
+    }
+    roleMatches = cp.roles[role]
+  }
+  if !roleMatches {
+    return false, fmt.Sprintf( "none of the roles %v covered", request.Roles )
+  }
+
+  if cp.broken {
+    // conditions cannot be evaluated. fail closed
+    return cp.effect == EFFECT_DENY, "policy has invalid conditions"
+  }
+
+  for _, c := range cp.conditions {
+    if ok, reason := c.holds( request, query ); !ok {
+      return false, reason
+    }
+  }
+
+  if cp.requireMfa && !request.Mfa {
+    return false, "multi factor authentication required"
+  }
+
+  return true, "matches"
+}
+
+func (c *condition) holds( request *Request, query url.Values ) (bool, string) {
+  var name, value string
+  var present bool
+
+  if c.header != "" {
+    name = "header "+c.header
+    values := request.Header.Values( c.header )
+    present = len(values) > 0
+    if present {
+      value = values[0]
+    }
+  } else {
+    name = "query parameter "+c.query
+    values, ok := query[c.query]
+    present = ok
+    if present && len(values) > 0 {
+      value = values[0]
+    }
+  }
+
+  if !present {
+    return false, name+" missing"
+  }
+
+  if c.pattern != nil && !c.pattern.MatchString( value ) {
+    return false, fmt.Sprintf( "%s does not match %q", name, c.pattern.String() )
+  }
+
+  return true, ""
+}
+
+// RoleNamesInApp returns the names of user's roles belonging to app
+func RoleNamesInApp( user *models.UserModel, app *models.AppModel ) []string {
+  roleNames := make( []string, 0 )
+  for _, role := range user.Roles {
+    if role.AppId != app.ID {
+      continue
+    }
+    roleNames = append( roleNames, role.Name )
+  }
+  return roleNames
+}
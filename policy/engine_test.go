@@ -0,0 +1,233 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package policy_test
+
+import (
+  "github.com/SatoshiPortal/cam/storage"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/policy"
+  "gorm.io/gorm"
+  "net/http"
+  "os"
+  "strings"
+  "testing"
+  "time"
+)
+
+func accessPolicy( effect string, patterns []string, roles []string, actions []string, conditions ...*models.AccessCondition ) *models.AccessPolicy {
+  return &models.AccessPolicy{
+    AccessPolicy: storage.AccessPolicy{
+      Effect:   effect,
+      Patterns: patterns,
+      Roles:    roles,
+      Actions:  actions,
+    },
+    Conditions: conditions,
+  }
+}
+
+func TestEngine(t *testing.T) {
+  engine := policy.NewEngine( models.AccessPolicies{
+    accessPolicy( "allow", []string{"^/public"}, []string{"*"}, []string{"get"} ),
+    accessPolicy( "allow", []string{"^/api"}, []string{"user", "admin"}, []string{"*"} ),
+    accessPolicy( "deny", []string{"^/api/admin"}, []string{"user"}, []string{"*"} ),
+    accessPolicy( "allow", []string{"^/hooks"}, []string{"*"}, []string{"post"},
+      &models.AccessCondition{ Header: "X-Hook-Token", Pattern: "^[a-f0-9]{8}$" },
+      &models.AccessCondition{ Query: "source" },
+    ),
+    accessPolicy( "allow", []string{"(unclosed"}, []string{"*"}, []string{"*"} ),
+  })
+
+  if len(engine.Errors) != 1 {
+    t.Errorf( "expected one compile error, got %v", engine.Errors )
+  }
+
+  cases := []struct {
+    method  string
+    uri     string
+    header  http.Header
+    roles   []string
+    allowed bool
+    index   int
+  }{
+    {"GET", "/public/index.html", nil, nil, true, 0},
+    {" Get ", "/public/index.html", nil, nil, true, 0},
+    {"POST", "/public/index.html", nil, nil, false, -1},
+    {"GET", "/api/status", nil, nil, false, -1},
+    {"GET", "/api/status", nil, []string{"user"}, true, 1},
+    // deny overrides the matching allow policy
+    {"GET", "/api/admin/users", nil, []string{"user"}, false, 2},
+    {"GET", "/api/admin/users", nil, []string{"user", "admin"}, false, 2},
+    {"GET", "/api/admin/users", nil, []string{"admin"}, true, 1},
+    {"POST", "/hooks/new?source=ci", http.Header{"X-Hook-Token": {"deadbeef"}}, nil, true, 3},
+    {"POST", "/hooks/new?source=ci", http.Header{"X-Hook-Token": {"nope"}}, nil, false, -1},
+    {"POST", "/hooks/new", http.Header{"X-Hook-Token": {"deadbeef"}}, nil, false, -1},
+  }
+
+  for _, c := range cases {
+    decision := engine.Evaluate( &policy.Request{ Method: c.method, URI: c.uri, Header: c.header, Roles: c.roles } )
+    if decision.Allowed != c.allowed || decision.PolicyIndex != c.index {
+      t.Errorf( "%s %s %v: expected allowed=%v by #%d, got allowed=%v by #%d (%s)",
+        c.method, c.uri, c.roles, c.allowed, c.index, decision.Allowed, decision.PolicyIndex, decision.Reason )
+    }
+  }
+
+  decision := engine.Explain( &policy.Request{ Method: "GET", URI: "/api/admin/users", Roles: []string{"user"} } )
+  if len(decision.Evaluations) != 5 {
+    t.Fatalf( "expected 5 evaluations, got %d", len(decision.Evaluations) )
+  }
+  if !decision.Evaluations[1].Matched || !decision.Evaluations[2].Matched || decision.Evaluations[0].Matched {
+    t.Errorf( "unexpected evaluations %v", decision.Evaluations )
+  }
+}
+
+func TestBrokenDenyPolicyFailsClosed(t *testing.T) {
+  engine := policy.NewEngine( models.AccessPolicies{
+    accessPolicy( "allow", []string{".*"}, []string{"*"}, []string{"*"} ),
+    accessPolicy( "deny", []string{"^/secret"}, []string{"*"}, []string{"*"}, &models.AccessCondition{} ),
+  })
+
+  if engine.Evaluate( &policy.Request{ Method: "GET", URI: "/secret" } ).Allowed {
+    t.Error( "broken deny policy must refuse access" )
+  }
+
+  if !engine.Evaluate( &policy.Request{ Method: "GET", URI: "/anything" } ).Allowed {
+    t.Error( "broken deny policy must only refuse access to what it covers" )
+  }
+}
+
+func TestRequireMfa(t *testing.T) {
+  mfaPolicy := accessPolicy( "allow", []string{"^/wallet"}, []string{"user"}, []string{"*"} )
+  mfaPolicy.RequireMfa = true
+  engine := policy.NewEngine( models.AccessPolicies{ mfaPolicy } )
+
+  if engine.Evaluate( &policy.Request{ Method: "POST", URI: "/wallet/spend", Roles: []string{"user"} } ).Allowed {
+    t.Error( "sessions without mfa must be refused" )
+  }
+
+  if !engine.Evaluate( &policy.Request{ Method: "POST", URI: "/wallet/spend", Roles: []string{"user"}, Mfa: true } ).Allowed {
+    t.Error( "sessions with mfa must be allowed" )
+  }
+}
+
+func TestSimulateForApp(t *testing.T) {
+  app := &models.AppModel{
+    AccessPolicies: models.AccessPolicies{
+      accessPolicy( "allow", []string{"^/"}, []string{"viewer"}, []string{"get"} ),
+      accessPolicy( "allow", []string{"^/settings"}, []string{"operator"}, []string{"get", "post"} ),
+    },
+  }
+
+  result := policy.SimulateForApp( app, &policy.Request{ Method: "POST", URI: "/settings", Roles: []string{"viewer", "operator"} } )
+
+  if !result.Decision.Allowed || result.Policy != app.AccessPolicies[1] {
+    t.Errorf( "expected access by policy #1, got %v", result.Decision )
+  }
+
+  if len(result.Decision.Evaluations) != 2 || len(result.Roles) != 2 {
+    t.Errorf( "unexpected simulation result %v", result )
+  }
+}
+
+func TestSimulateQuarantinedApp(t *testing.T) {
+  app := &models.AppModel{
+    Quarantined: true,
+    AccessPolicies: models.AccessPolicies{
+      accessPolicy( "allow", []string{"^/"}, []string{"*"}, []string{"*"} ),
+    },
+  }
+
+  result := policy.SimulateForApp( app, &policy.Request{ Method: "GET", URI: "/", Roles: []string{"viewer"} } )
+  if result.Decision.Allowed || result.Decision.PolicyIndex != -1 || result.Policy != nil ||
+    !strings.Contains( result.Decision.Reason, "quarantined" ) {
+    t.Errorf( "expected quarantined apps to refuse access, got %v", result.Decision )
+  }
+}
+
+func TestSimulateAdminWithoutMfa(t *testing.T) {
+  _ = os.Setenv( globals.CNA_MFA_REQUIRED_FOR_ADMINS_ENV_KEY, "true" )
+  defer os.Unsetenv( globals.CNA_MFA_REQUIRED_FOR_ADMINS_ENV_KEY )
+
+  app := &models.AppModel{
+    MountPoint: globals.BASE_ADMIN_MOUNTPOINT,
+    AccessPolicies: models.AccessPolicies{
+      accessPolicy( "allow", []string{"^/users"}, []string{globals.BASE_ADMIN_ROLE}, []string{"*"} ),
+    },
+  }
+
+  request := &policy.Request{ Method: "GET", URI: "/users", Roles: []string{globals.BASE_ADMIN_ROLE} }
+  result := policy.SimulateForApp( app, request )
+  if result.Decision.Allowed || len(result.Roles) != 0 {
+    t.Errorf( "expected admins without mfa to be refused, got %v with %v", result.Decision, result.Roles )
+  }
+  if len(request.Roles) != 1 {
+    t.Error( "the simulated request must not be changed" )
+  }
+
+  request.Mfa = true
+  if result := policy.SimulateForApp( app, request ); !result.Decision.Allowed {
+    t.Errorf( "expected admins with mfa to be allowed, got %v", result.Decision )
+  }
+
+  _ = os.Unsetenv( globals.CNA_MFA_REQUIRED_FOR_ADMINS_ENV_KEY )
+  request.Mfa = false
+  if result := policy.SimulateForApp( app, request ); !result.Decision.Allowed {
+    t.Errorf( "expected admins to be allowed if mfa is optional, got %v", result.Decision )
+  }
+}
+
+func TestForApp(t *testing.T) {
+  updatedAt := time.Now()
+  app := &models.AppModel{
+    Model: gorm.Model{ ID: 42, UpdatedAt: updatedAt },
+    AccessPolicies: models.AccessPolicies{
+      accessPolicy( "allow", []string{"^/"}, []string{"*"}, []string{"get"} ),
+    },
+  }
+  defer policy.Forget( app.ID )
+
+  engine := policy.ForApp( app )
+  if policy.ForApp( app ) != engine {
+    t.Error( "expected the engine to be compiled once per change" )
+  }
+
+  updated := *app
+  updated.UpdatedAt = updatedAt.Add( time.Second )
+  updatedEngine := policy.ForApp( &updated )
+  if updatedEngine == engine || policy.ForApp( &updated ) != updatedEngine {
+    t.Error( "expected the engine of the updated app to replace the previous one" )
+  }
+
+  // apps loaded before the change must not evict the newer engine
+  if policy.ForApp( app ) == updatedEngine || policy.ForApp( &updated ) != updatedEngine {
+    t.Error( "expected the engine of the updated app to be kept" )
+  }
+
+  policy.Forget( app.ID )
+  if policy.ForApp( &updated ) == updatedEngine {
+    t.Error( "expected the engine of a forgotten app to be compiled again" )
+  }
+}
@@ -0,0 +1,203 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package policy
+
+import (
+  "fmt"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "reflect"
+  "sort"
+  "strings"
+)
+
+var knownActions = map[string]bool{
+  "*": true, "get": true, "head": true, "post": true, "put": true,
+  "patch": true, "delete": true, "options": true,
+}
+
+// Problem found in an app's access policies. Fatal problems make
+// the policies unusable, the app has to be quarantined
+type Problem struct {
+  PolicyIndex int    `json:"policyIndex"`
+  Message     string `json:"message"`
+  Fatal       bool   `json:"fatal"`
+}
+
+func (problem *Problem) String() string {
+  severity := "warning"
+  if problem.Fatal {
+    severity = "error"
+  }
+  return fmt.Sprintf( "%s: policy #%d: %s", severity, problem.PolicyIndex, problem.Message )
+}
+
+func HasFatalProblems( problems []*Problem ) bool {
+  for _, problem := range problems {
+    if problem.Fatal {
+      return true
+    }
+  }
+  return false
+}
+
+// Validate lints accessPolicies against the names of the roles an app
+// has and returns a normalised copy of them with trimmed, lowercased
+// actions and explicit effects
+func Validate( accessPolicies models.AccessPolicies, roleNames []string ) (models.AccessPolicies, []*Problem) {
+  problems := make( []*Problem, 0 )
+  normalised := make( models.AccessPolicies, 0, len(accessPolicies) )
+
+  report := func( index int, fatal bool, format string, args ...interface{} ) {
+    problems = append( problems, &Problem{
+      PolicyIndex: index,
+      Message:     fmt.Sprintf( format, args... ),
+      Fatal:       fatal,
+    })
+  }
+
+  knownRoles := make( map[string]bool )
+  for _, roleName := range roleNames {
+    knownRoles[roleName] = true
+  }
+
+  for index, accessPolicy := range accessPolicies {
+    if accessPolicy == nil {
+      report( index, true, "empty policy" )
+      continue
+    }
+
+    ap := *accessPolicy
+
+    switch strings.ToLower( strings.TrimSpace( ap.Effect ) ) {
+    case EFFECT_ALLOW, EFFECT_DENY:
+    case "":
+      // deny overrides, so this refuses what other policies allow
+      report( index, false, "no effect, treated as deny which overrides matching allow policies" )
+    default:
+      report( index, true, "unknown effect %q", ap.Effect )
+    }
+    ap.Effect = NormaliseEffect( ap.Effect )
+
+    ap.Actions = make( []string, 0, len(accessPolicy.Actions) )
+    for _, action := range accessPolicy.Actions {
+      action = NormaliseAction( action )
+      if !knownActions[action] {
+        report( index, true, "unknown action %q", action )
+        continue
+      }
+      ap.Actions = append( ap.Actions, action )
+    }
+
+    for _, role := range ap.Roles {
+      if role == "*" || knownRoles[role] {
+        continue
+      }
+      // a deny policy for an unknown role protects nothing
+      report( index, ap.Effect == EFFECT_DENY, "unknown role %q", role )
+    }
+
+    _, compileErrors := compilePolicy( index, &ap )
+    for _, err := range compileErrors {
+      report( index, true, "%s", err.Error() )
+    }
+
+    if len(ap.Patterns) == 0 || len(ap.Actions) == 0 || len(ap.Roles) == 0 {
+      report( index, false, "unreachable: needs at least one pattern, action and role" )
+    }
+
+    normalised = append( normalised, &ap )
+  }
+
+  lintOverlaps( normalised, report )
+
+  return normalised, problems
+}
+
+// ValidateApp validates and normalises the access policies of app in
+// place. Fatal problems quarantine the app
+func ValidateApp( app *models.AppModel, roleNames []string ) {
+  normalised, problems := Validate( app.AccessPolicies, roleNames )
+
+  app.AccessPolicies = normalised
+  app.Quarantined = HasFatalProblems( problems )
+  app.PolicyProblems = make( models.StringList, 0, len(problems) )
+  for _, problem := range problems {
+    app.PolicyProblems = append( app.PolicyProblems, problem.String() )
+  }
+}
+
+func lintOverlaps( accessPolicies models.AccessPolicies, report func( int, bool, string, ...interface{} ) ) {
+  for i, later := range accessPolicies {
+    for j, earlier := range accessPolicies[:i] {
+      if !samePatterns( earlier, later ) {
+        continue
+      }
+      if earlier.Effect == later.Effect && covers( earlier.Actions, later.Actions ) && covers( earlier.Roles, later.Roles ) {
+        report( i, false, "unreachable: same patterns, actions and roles as policy #%d", j )
+        continue
+      }
+      if earlier.Effect == EFFECT_DENY && later.Effect == EFFECT_ALLOW && covers( earlier.Actions, later.Actions ) && covers( earlier.Roles, later.Roles ) {
+        report( i, false, "unreachable: always overridden by deny policy #%d", j )
+        continue
+      }
+      if earlier.Effect != later.Effect {
+        report( i, false, "overlaps policy #%d with the opposite effect", j )
+      }
+    }
+  }
+}
+
+func samePatterns( a *models.AccessPolicy, b *models.AccessPolicy ) bool {
+  return reflect.DeepEqual( sortedCopy( a.Patterns ), sortedCopy( b.Patterns ) )
+}
+
+// true, if everything in subset is covered by set
+func covers( set []string, subset []string ) bool {
+  for _, s := range set {
+    if s == "*" {
+      return true
+    }
+  }
+  for _, s := range subset {
+    found := false
+    for _, t := range set {
+      if s == t {
+        found = true
+        break
+      }
+    }
+    if !found {
+      return false
+    }
+  }
+  return true
+}
+
+func sortedCopy( s []string ) []string {
+  c := make( []string, len(s) )
+  copy( c, s )
+  sort.Strings( c )
+  return c
+}
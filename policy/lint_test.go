@@ -0,0 +1,97 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package policy_test
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/policy"
+  "strings"
+  "testing"
+)
+
+func TestValidate(t *testing.T) {
+  accessPolicies := models.AccessPolicies{
+    accessPolicy( "allow", []string{"^/public"}, []string{"*"}, []string{" GET ", "Options"} ),
+    accessPolicy( "allow", []string{"^/api"}, []string{"user"}, []string{"get"} ),
+    accessPolicy( "deny", []string{"^/api"}, []string{"user"}, []string{"*"} ),
+    accessPolicy( "allow", []string{"^/public"}, []string{"*"}, []string{"get"} ),
+  }
+
+  normalised, problems := policy.Validate( accessPolicies, []string{"user"} )
+
+  if policy.HasFatalProblems( problems ) {
+    t.Errorf( "expected no fatal problems, got %v", problems )
+  }
+
+  if strings.Join( normalised[0].Actions, "," ) != "get,options" {
+    t.Errorf( "expected normalised actions, got %v", normalised[0].Actions )
+  }
+
+  if accessPolicies[0].Actions[0] != " GET " {
+    t.Error( "validation must not modify the original policies" )
+  }
+
+  warnings := make( map[int]bool )
+  for _, problem := range problems {
+    warnings[problem.PolicyIndex] = true
+  }
+
+  // deny overlaps allow, duplicate of #0
+  if !warnings[2] || !warnings[3] || warnings[0] || warnings[1] {
+    t.Errorf( "unexpected warnings %v", problems )
+  }
+
+  // missing effects are a hard deny, which has to be reported
+  normalised, problems = policy.Validate( models.AccessPolicies{
+    accessPolicy( "", []string{"^/"}, []string{"*"}, []string{"get"} ),
+  }, []string{"user"} )
+  if normalised[0].Effect != policy.EFFECT_DENY || len(problems) != 1 || problems[0].Fatal ||
+    !strings.Contains( problems[0].Message, "treated as deny" ) {
+    t.Errorf( "expected a warning about the missing effect, got %v", problems )
+  }
+
+  fatalPolicies := []models.AccessPolicies{
+    {accessPolicy( "allow", []string{"(unclosed"}, []string{"*"}, []string{"get"} )},
+    {accessPolicy( "allow", []string{"^/"}, []string{"*"}, []string{"fetch"} )},
+    {accessPolicy( "permit", []string{"^/"}, []string{"*"}, []string{"get"} )},
+    {accessPolicy( "deny", []string{"^/"}, []string{"usr"}, []string{"get"} )},
+    {accessPolicy( "allow", []string{"^/"}, []string{"*"}, []string{"get"}, &models.AccessCondition{} )},
+  }
+
+  for _, aps := range fatalPolicies {
+    _, problems := policy.Validate( aps, []string{"user"} )
+    if !policy.HasFatalProblems( problems ) {
+      t.Errorf( "expected fatal problems for %v", aps[0] )
+    }
+  }
+
+  // unknown role in allow policy only warns
+  _, problems = policy.Validate( models.AccessPolicies{
+    accessPolicy( "allow", []string{"^/"}, []string{"usr"}, []string{"get"} ),
+  }, []string{"user"} )
+  if len(problems) != 1 || problems[0].Fatal {
+    t.Errorf( "expected a single warning, got %v", problems )
+  }
+}
@@ -0,0 +1,148 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package policy
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "net/http"
+)
+
+// SimulationInput describes a request to simulate. The roles are
+// taken from the user with UserId or Login, if one is given, else
+// from Roles. Without user and roles the request is anonymous. Mfa
+// simulates a session authenticated with more than one factor
+type SimulationInput struct {
+  MountPoint string            `json:"mountPoint"`
+  UserId     uint              `json:"userId,omitempty"`
+  Login      string            `json:"login,omitempty"`
+  Roles      []string          `json:"roles,omitempty"`
+  Method     string            `json:"method"`
+  URI        string            `json:"uri"`
+  Header     map[string]string `json:"header,omitempty"`
+  Mfa        bool              `json:"mfa,omitempty"`
+}
+
+type SimulationResult struct {
+  Decision *Decision            `json:"decision"`
+  // the deciding policy, if any
+  Policy   *models.AccessPolicy `json:"policy,omitempty"`
+  // roles the policies were evaluated with. nil for anonymous requests
+  Roles    []string             `json:"roles"`
+  // problems found while compiling the app's policies
+  Errors   []string             `json:"errors,omitempty"`
+}
+
+// Simulate evaluates the access policies of the app mounted at
+// input.MountPoint the same way ForwardUserAuth does
+func Simulate( input *SimulationInput ) (*SimulationResult, error) {
+  if input.MountPoint == "" || input.URI == "" {
+    return nil, globals.ErrIncompleteSimulation
+  }
+
+  app, err := queries.GetAppByMountPoint( input.MountPoint )
+  if err != nil {
+    return nil, err
+  }
+
+  roles := input.Roles
+
+  if input.UserId != 0 || input.Login != "" {
+    user, err := simulatedUser( input )
+    if err != nil {
+      return nil, err
+    }
+    roles = RoleNamesInApp( user, app )
+  }
+
+  request := &Request{
+    Method: input.Method,
+    URI:    input.URI,
+    Header: make( http.Header ),
+    Roles:  roles,
+    Mfa:    input.Mfa,
+  }
+
+  for name, value := range input.Header {
+    request.Header.Set( name, value )
+  }
+
+  return SimulateForApp( app, request ), nil
+}
+
+// SimulateForApp explains how request is decided by the access
+// policies of app. app does not need to be stored in the database,
+// so manifests can be tested before they are installed. Quarantined
+// apps refuse every request, like in ForwardUserAuth
+func SimulateForApp( app *models.AppModel, request *Request ) *SimulationResult {
+  simulated := *request
+  DropAdminWithoutMfa( app, &simulated )
+
+  if app.Quarantined {
+    return &SimulationResult{
+      Decision: &Decision{ Allowed: false, PolicyIndex: -1, Reason: "app is quarantined" },
+      Roles:    simulated.Roles,
+    }
+  }
+
+  engine := ForApp( app )
+
+  result := &SimulationResult{
+    Decision: engine.Explain( &simulated ),
+    Roles:    simulated.Roles,
+  }
+
+  if result.Decision.PolicyIndex >= 0 {
+    result.Policy = app.AccessPolicies[result.Decision.PolicyIndex]
+  }
+
+  for _, err := range engine.Errors {
+    result.Errors = append( result.Errors, err.Error() )
+  }
+
+  return result
+}
+
+func simulatedUser( input *SimulationInput ) (*models.UserModel, error) {
+  var users []*models.UserModel
+  var err error
+
+  if input.UserId != 0 {
+    err = queries.Find( &users, []interface{}{"id = ?", input.UserId}, "", 1, 0, true )
+  } else {
+    err = queries.Find( &users, []interface{}{"login = ?", input.Login}, "", 1, 0, true )
+  }
+
+  if err != nil {
+    return nil, err
+  }
+
+  if len(users) == 0 {
+    return nil, globals.ErrNoSuchUser
+  }
+
+  return users[0], nil
+}
@@ -26,97 +26,145 @@ package queries
 
 import (
   "errors"
-  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
   "github.com/schulterklopfer/cyphernode_fauth/globals"
   "github.com/schulterklopfer/cyphernode_fauth/models"
   "gopkg.in/validator.v2"
+  "gorm.io/gorm"
+  "time"
 )
 
 func CreateApp( app *models.AppModel ) error {
+  return Default().CreateApp( app )
+}
+
+func (q *Queries) CreateApp( app *models.AppModel ) error {
   if app.ID != 0 {
     // app must not have any ID possibly existing in DB
     return errors.New( "app ID must be 0" )
   }
-  db := dataSource.GetDB()
+  db := q.db
 
   var existingApps []models.AppModel
-  db.Limit(1).Find( &existingApps, models.AppModel{Hash: app.Hash} )
+  err := db.Limit(1).Find( &existingApps, "hash = ?", app.Hash ).Error
+  if err != nil {
+    return err
+  }
 
   if len(existingApps) > 0 {
     return errors.New( "app with same hash already exists" )
   }
 
-  err := validator.Validate(app)
+  err = validator.Validate(app)
   if err != nil {
     return err
   }
-  db.Create(app)
-  return nil
+  return db.Create(app).Error
+}
+
+// DeleteApp deletes an app, its roles and their assignments for good.
+// The admin app can't be deleted
+func DeleteApp( id uint ) (*DeleteReport, error) {
+  return Default().DeleteApp( id )
 }
 
-func DeleteApp( id uint ) error {
+func (q *Queries) DeleteApp( id uint ) (*DeleteReport, error) {
   if id == 0 {
-    return errors.New("no such app")
-  }
-  if id == 1 {
-    return globals.ErrActionForbidden
+    return nil, notFound( &models.AppModel{}, id )
   }
-  db := dataSource.GetDB()
-  var app models.AppModel
-  db.Take( &app, id )
-  if app.ID == 0 {
-    return errors.New("no such app")
+
+  report := newDeleteReport()
+
+  err := q.db.Transaction( func( tx *gorm.DB ) error {
+    var apps []*models.AppModel
+    err := tx.Limit(1).Find( &apps, id ).Error
+    if err != nil {
+      return err
+    }
+    if len(apps) == 0 {
+      return notFound( &models.AppModel{}, id )
+    }
+    if apps[0].MountPoint == globals.BASE_ADMIN_MOUNTPOINT {
+      return globals.ErrActionForbidden
+    }
+
+    var roles []*models.RoleModel
+    err = tx.Unscoped().Where( "app_id = ?", id ).Find( &roles ).Error
+    if err != nil {
+      return err
+    }
+
+    err = deleteRoles( tx, report, roles )
+    if err != nil {
+      return err
+    }
+
+    err = tx.Unscoped().Delete( apps[0] ).Error
+    if err != nil {
+      return err
+    }
+    report.Apps = append( report.Apps, id )
+    return nil
+  })
+
+  if err != nil {
+    return nil, err
   }
-  db.Unscoped().Delete( &app )
-  return nil
+  return report, nil
 }
 
-func RemoveRoleFromApp(  app *models.AppModel, roleId uint ) error {
-  if roleId == 1 && app.ID == 1 {
-    return globals.ErrActionForbidden
-  }
-  //db := dataSource.GetDB()
+func RemoveRoleFromApp(  app *models.AppModel, roleId uint ) (*DeleteReport, error) {
+  return Default().RemoveRoleFromApp( app, roleId )
+}
 
+// RemoveRoleFromApp deletes a role of app. The admin role of the
+// admin app can't be removed
+func (q *Queries) RemoveRoleFromApp(  app *models.AppModel, roleId uint ) (*DeleteReport, error) {
   var role models.RoleModel
 
-  err := Get( &role, roleId, false )
+  err := q.Get( &role, roleId, false )
 
   if err != nil {
-    return err
+    return nil, err
   }
 
-  if role.ID == 0 || role.AppId != app.ID {
-    return globals.ErrNoSuchRole
+  if role.AppId != app.ID {
+    return nil, notFound( &role, roleId )
   }
 
-  //db.Model(app).Association("AvailableRoles").Delete( role )
-  return DeleteRole( role.ID )
+  return q.DeleteRole( role.ID )
 }
 
 func CreateRoleForApp( app *models.AppModel, role *models.RoleModel ) error {
-  db := dataSource.GetDB()
+  return Default().CreateRoleForApp( app, role )
+}
+
+func (q *Queries) CreateRoleForApp( app *models.AppModel, role *models.RoleModel ) error {
+  db := q.db
 
   if role.ID != 0 {
     return globals.ErrCannotAddExistingRole
   }
 
-  db.Model(app).Association("AvailableRoles").Append( role )
-  return db.Error
+  return db.Model(app).Association("AvailableRoles").Append( role )
 }
 
 func GetAppByHash( hash string ) (*models.AppModel, error) {
+  return Default().GetAppByHash( hash )
+}
+
+func (q *Queries) GetAppByHash( hash string ) (*models.AppModel, error) {
   var apps []*models.AppModel
-  err := Find( &apps,  []interface{}{"hash = ?", hash}, "", 1,0,false)
+  err := q.Find( &apps,  []interface{}{"hash = ?", hash}, "", 1,0,false)
 
   if err != nil {
     return nil, err
   }
 
   if len(apps) == 0 {
-    return nil, nil
+    return nil, notFound( &models.AppModel{}, hash )
   }
 
-  err = LoadRoles( apps[0] )
+  err = q.LoadRoles( apps[0] )
   if err != nil {
     return nil, err
   }
@@ -125,21 +173,118 @@ func GetAppByHash( hash string ) (*models.AppModel, error) {
 }
 
 func GetAppByMountPoint( mountPoint string ) (*models.AppModel, error) {
+  return Default().GetAppByMountPoint( mountPoint )
+}
+
+func (q *Queries) GetAppByMountPoint( mountPoint string ) (*models.AppModel, error) {
   var apps []*models.AppModel
-  err := Find( &apps,  []interface{}{"mount_point = ?", mountPoint}, "", 1,0,false)
+  // archived apps are not served anymore
+  err := q.Find( &apps,  []interface{}{"mount_point = ? AND archived_at IS NULL", mountPoint}, "", 1,0,false)
 
   if err != nil {
     return nil, err
   }
 
   if len(apps) == 0 {
-    return nil, globals.ErrNoSuchApp
+    return nil, notFound( &models.AppModel{}, mountPoint )
   }
 
-  err = LoadRoles( apps[0] )
+  err = q.LoadRoles( apps[0] )
   if err != nil {
     return nil, err
   }
 
   return apps[0], nil
-}
\ No newline at end of file
+}
+
+// ApproveAppGroups sets the gatekeeper groups an admin agreed to.
+// Only groups the app requested can be approved.
+func ApproveAppGroups( app *models.AppModel, groups []string ) error {
+  return Default().ApproveAppGroups( app, groups )
+}
+
+func (q *Queries) ApproveAppGroups( app *models.AppModel, groups []string ) error {
+  if app == nil || app.ID == 0 {
+    return globals.ErrNoSuchApp
+  }
+
+  approvedGroups := make( models.StringList, 0, len(groups) )
+  for _, group := range groups {
+    if !app.RequestedGroups.Contains( group ) {
+      return globals.ErrGroupNotRequested
+    }
+    if !approvedGroups.Contains( group ) {
+      approvedGroups = append( approvedGroups, group )
+    }
+  }
+
+  db := q.db
+  app.ApprovedGroups = approvedGroups
+  return db.Model( app ).Update( "approved_groups", app.ApprovedGroups ).Error
+}
+
+// ArchiveApp marks an app as removed from the installed apps index.
+// Its roles and their assignments are kept, so reinstalling the app
+// restores them
+func ArchiveApp( app *models.AppModel ) error {
+  return Default().ArchiveApp( app )
+}
+
+func (q *Queries) ArchiveApp( app *models.AppModel ) error {
+  if app == nil || app.ID == 0 {
+    return globals.ErrNoSuchApp
+  }
+  if app.MountPoint == globals.BASE_ADMIN_MOUNTPOINT {
+    return globals.ErrActionForbidden
+  }
+  db := q.db
+  archivedAt := time.Now()
+  err := db.Model( app ).Update( "archived_at", &archivedAt ).Error
+  if err != nil {
+    return err
+  }
+  app.ArchivedAt = &archivedAt
+  return nil
+}
+
+func RestoreApp( app *models.AppModel ) error {
+  return Default().RestoreApp( app )
+}
+
+func (q *Queries) RestoreApp( app *models.AppModel ) error {
+  if app == nil || app.ID == 0 {
+    return globals.ErrNoSuchApp
+  }
+  db := q.db
+  err := db.Model( app ).Update( "archived_at", nil ).Error
+  if err != nil {
+    return err
+  }
+  app.ArchivedAt = nil
+  return nil
+}
+
+func ArchivedApps( apps *[]*models.AppModel ) error {
+  return Default().ArchivedApps( apps )
+}
+
+func (q *Queries) ArchivedApps( apps *[]*models.AppModel ) error {
+  return q.Find( apps, []interface{}{"archived_at IS NOT NULL"}, "archived_at", -1, 0, true )
+}
+
+// PurgeApp deletes an archived app for good
+func PurgeApp( id uint ) (*DeleteReport, error) {
+  return Default().PurgeApp( id )
+}
+
+func (q *Queries) PurgeApp( id uint ) (*DeleteReport, error) {
+  var app models.AppModel
+  err := q.Get( &app, id, false )
+  if err != nil {
+    return nil, err
+  }
+  if !app.IsArchived() {
+    return nil, globals.ErrAppNotArchived
+  }
+  return q.DeleteApp( app.ID )
+}
@@ -0,0 +1,110 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package queries_test
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/password"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/sirupsen/logrus"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestAuthenticate(t *testing.T) {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "queries" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer os.RemoveAll( dir )
+
+  err = dataSource.Init( "sqlite://"+filepath.Join( dir, "test.sqlite3" ) )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer dataSource.Close()
+  defer password.Configure( password.DefaultParams )
+
+  hashedPassword, err := password.HashPassword( "correct horse" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  err = queries.CreateUser( &models.UserModel{ Login: "someone", Password: hashedPassword } )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  _, err = queries.Authenticate( "someone", "wrong horse" )
+  if err != globals.ErrInvalidCredentials {
+    t.Errorf( "expected %v for a wrong password, got %v", globals.ErrInvalidCredentials, err )
+  }
+
+  _, err = queries.Authenticate( "nobody", "correct horse" )
+  if err != globals.ErrInvalidCredentials {
+    t.Errorf( "expected %v for an unknown login, got %v", globals.ErrInvalidCredentials, err )
+  }
+
+  user, err := queries.Authenticate( "someone", "correct horse" )
+  if err != nil || user.Login != "someone" {
+    t.Fatalf( "expected someone to be authenticated, got %v", err )
+  }
+  if user.Password != hashedPassword {
+    t.Error( "hash with current params must not be replaced" )
+  }
+
+  params := password.DefaultParams
+  params.Algorithm = password.ALGORITHM_ARGON2ID
+  params.Argon2Memory = 1024
+  err = password.Configure( params )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  _, err = queries.Authenticate( "someone", "correct horse" )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  var rehashed models.UserModel
+  err = queries.Get( &rehashed, user.ID, false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if !strings.HasPrefix( rehashed.Password, "$argon2id$" ) || password.NeedsRehash( rehashed.Password ) {
+    t.Errorf( "expected an argon2id hash with current params, got %s", rehashed.Password )
+  }
+
+  _, err = queries.Authenticate( "someone", "correct horse" )
+  if err != nil {
+    t.Error( "rehashed password must still work" )
+  }
+}
@@ -0,0 +1,106 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package queries
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "gorm.io/gorm"
+)
+
+// DeleteReport lists what a delete removed from the database
+type DeleteReport struct {
+  Apps  []uint `json:"apps"`
+  Roles []uint `json:"roles"`
+  Users []uint `json:"users"`
+  // number of roles taken from users
+  Assignments int64 `json:"assignments"`
+}
+
+func newDeleteReport() *DeleteReport {
+  return &DeleteReport{
+    Apps:  make( []uint, 0 ),
+    Roles: make( []uint, 0 ),
+    Users: make( []uint, 0 ),
+  }
+}
+
+// deleteAssignments removes the rows of user_roles where column is one
+// of ids. the foreign keys would do the same, but only the database
+// knows how many rows it removed
+func deleteAssignments( tx *gorm.DB, report *DeleteReport, column string, ids []uint ) error {
+  if len(ids) == 0 {
+    return nil
+  }
+  result := tx.Exec( "DELETE FROM user_roles WHERE "+column+" IN ?", ids )
+  if result.Error != nil {
+    return result.Error
+  }
+  report.Assignments += result.RowsAffected
+  return nil
+}
+
+func deleteRoles( tx *gorm.DB, report *DeleteReport, roles []*models.RoleModel ) error {
+  ids := make( []uint, len(roles) )
+  for i, role := range roles {
+    ids[i] = role.ID
+  }
+
+  err := deleteAssignments( tx, report, "role_model_id", ids )
+  if err != nil {
+    return err
+  }
+
+  for _, role := range roles {
+    err = tx.Unscoped().Delete( role ).Error
+    if err != nil {
+      return err
+    }
+    report.Roles = append( report.Roles, role.ID )
+  }
+  return nil
+}
+
+// adminUserIds returns the ids of the users holding the admin role of
+// the admin app
+func adminUserIds( tx *gorm.DB ) ([]uint, error) {
+  var ids []uint
+  err := tx.Table( "user_roles" ).
+    Joins( "JOIN role_models ON role_models.id = user_roles.role_model_id" ).
+    Joins( "JOIN app_models ON app_models.id = role_models.app_id" ).
+    Where( "role_models.name = ? AND app_models.mount_point = ?", globals.BASE_ADMIN_ROLE, globals.BASE_ADMIN_MOUNTPOINT ).
+    Pluck( "user_roles.user_model_id", &ids ).Error
+  return ids, err
+}
+
+func isAdminRole( tx *gorm.DB, role *models.RoleModel ) (bool, error) {
+  if role.Name != globals.BASE_ADMIN_ROLE {
+    return false, nil
+  }
+  var count int64
+  err := tx.Model( &models.AppModel{} ).
+    Where( "id = ? AND mount_point = ?", role.AppId, globals.BASE_ADMIN_MOUNTPOINT ).
+    Count( &count ).Error
+  return count > 0, err
+}
@@ -0,0 +1,163 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package queries_test
+
+import (
+  "errors"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/sirupsen/logrus"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestDeleteReports(t *testing.T) {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "queries" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer os.RemoveAll( dir )
+
+  err = dataSource.Init( "sqlite://"+filepath.Join( dir, "test.sqlite3" ) )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer dataSource.Close()
+
+  adminApp := &models.AppModel{
+    Name: "admin", Hash: "adminHash", Secret: "adminSecret", MountPoint: globals.BASE_ADMIN_MOUNTPOINT,
+    AvailableRoles: []*models.RoleModel{ { Name: globals.BASE_ADMIN_ROLE } },
+  }
+  if err := queries.CreateApp( adminApp ); err != nil {
+    t.Fatal( err )
+  }
+  adminRole := adminApp.AvailableRoles[0]
+
+  admin := &models.UserModel{ Login: "admin", Password: "secret", Roles: []*models.RoleModel{ adminRole } }
+  if err := queries.CreateUser( admin ); err != nil {
+    t.Fatal( err )
+  }
+
+  app := &models.AppModel{
+    Name: "app", Hash: "appHash", Secret: "appSecret", MountPoint: "app",
+    AvailableRoles: []*models.RoleModel{ { Name: "reader" }, { Name: "writer" } },
+  }
+  if err := queries.CreateApp( app ); err != nil {
+    t.Fatal( err )
+  }
+  reader := app.AvailableRoles[0]
+  writer := app.AvailableRoles[1]
+
+  user := &models.UserModel{ Login: "user", Password: "secret", Roles: []*models.RoleModel{ reader, writer } }
+  if err := queries.CreateUser( user ); err != nil {
+    t.Fatal( err )
+  }
+
+  t.Run( "admin app, admin role and last admin are kept", func( t *testing.T ) {
+    if _, err := queries.DeleteApp( adminApp.ID ); !errors.Is( err, globals.ErrActionForbidden ) {
+      t.Errorf( "expected forbidden deleting the admin app, got %v", err )
+    }
+    if _, err := queries.DeleteRole( adminRole.ID ); !errors.Is( err, globals.ErrActionForbidden ) {
+      t.Errorf( "expected forbidden deleting the admin role, got %v", err )
+    }
+    if _, err := queries.DeleteUser( admin.ID ); !errors.Is( err, globals.ErrActionForbidden ) {
+      t.Errorf( "expected forbidden deleting the last admin, got %v", err )
+    }
+    if err := queries.ArchiveApp( adminApp ); !errors.Is( err, globals.ErrActionForbidden ) {
+      t.Errorf( "expected forbidden archiving the admin app, got %v", err )
+    }
+    if err := queries.RenameRole( adminRole, "root", "" ); !errors.Is( err, globals.ErrActionForbidden ) {
+      t.Errorf( "expected forbidden renaming the admin role, got %v", err )
+    }
+  })
+
+  t.Run( "missing rows", func( t *testing.T ) {
+    if _, err := queries.DeleteApp( 1000 ); !errors.Is( err, globals.ErrNoSuchApp ) {
+      t.Errorf( "expected no such app, got %v", err )
+    }
+    if _, err := queries.DeleteRole( 1000 ); !errors.Is( err, globals.ErrNoSuchRole ) {
+      t.Errorf( "expected no such role, got %v", err )
+    }
+    if _, err := queries.DeleteUser( 1000 ); !errors.Is( err, globals.ErrNoSuchUser ) {
+      t.Errorf( "expected no such user, got %v", err )
+    }
+  })
+
+  t.Run( "delete role", func( t *testing.T ) {
+    report, err := queries.DeleteRole( writer.ID )
+    if err != nil {
+      t.Fatal( err )
+    }
+    // the user and the admin, who gets every new role
+    if len(report.Roles) != 1 || report.Roles[0] != writer.ID || report.Assignments != 2 {
+      t.Errorf( "unexpected report %+v", report )
+    }
+  })
+
+  t.Run( "delete user", func( t *testing.T ) {
+    report, err := queries.DeleteUser( user.ID )
+    if err != nil {
+      t.Fatal( err )
+    }
+    if len(report.Users) != 1 || report.Users[0] != user.ID || report.Assignments != 1 {
+      t.Errorf( "unexpected report %+v", report )
+    }
+  })
+
+  t.Run( "delete app", func( t *testing.T ) {
+    report, err := queries.DeleteApp( app.ID )
+    if err != nil {
+      t.Fatal( err )
+    }
+    if len(report.Apps) != 1 || len(report.Roles) != 1 || report.Roles[0] != reader.ID || report.Assignments != 1 {
+      t.Errorf( "unexpected report %+v", report )
+    }
+    var roles []*models.RoleModel
+    if err := queries.Find( &roles, []interface{}{"app_id = ?", app.ID}, "", -1, 0, false ); err != nil || len(roles) != 0 {
+      t.Error( "roles of deleted app still exist" )
+    }
+  })
+
+  t.Run( "foreign keys cascade", func( t *testing.T ) {
+    other := &models.UserModel{ Login: "other", Password: "secret", Roles: []*models.RoleModel{ adminRole } }
+    if err := queries.CreateUser( other ); err != nil {
+      t.Fatal( err )
+    }
+    db := dataSource.GetDB()
+    if err := db.Exec( "DELETE FROM user_models WHERE id = ?", other.ID ).Error; err != nil {
+      t.Fatal( err )
+    }
+    var count int64
+    db.Table( "user_roles" ).Where( "user_model_id = ?", other.ID ).Count( &count )
+    if count != 0 {
+      t.Error( "role assignments of deleted user were not cascaded" )
+    }
+  })
+}
@@ -0,0 +1,85 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package queries
+
+import (
+  "errors"
+  "fmt"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "gorm.io/gorm"
+)
+
+// NotFoundError is returned when a lookup finds nothing. It matches
+// globals.ErrNotFound and the no such user, role or app error of its
+// model with errors.Is
+type NotFoundError struct {
+  Model string
+  Key   interface{}
+}
+
+func (err *NotFoundError) Error() string {
+  return fmt.Sprintf( "%s %v not found", err.Model, err.Key )
+}
+
+func (err *NotFoundError) Is( target error ) bool {
+  switch target {
+  case globals.ErrNotFound:
+    return true
+  case globals.ErrNoSuchUser:
+    return err.Model == "user"
+  case globals.ErrNoSuchRole:
+    return err.Model == "role"
+  case globals.ErrNoSuchApp:
+    return err.Model == "app"
+  }
+  return false
+}
+
+func notFound( model interface{}, key interface{} ) error {
+  name := fmt.Sprintf( "%T", model )
+  switch model.(type) {
+  case *models.UserModel, *[]*models.UserModel:
+    name = "user"
+  case *models.RoleModel, *[]*models.RoleModel:
+    name = "role"
+  case *models.AppModel, *[]*models.AppModel:
+    name = "app"
+  case *models.LoginThrottleModel:
+    name = "login throttle"
+  case *models.TotpModel:
+    name = "totp"
+  case *models.WebauthnCredentialModel:
+    name = "webauthn credential"
+  }
+  return &NotFoundError{ Model: name, Key: key }
+}
+
+// notFoundOr turns gorm's record not found into a NotFoundError
+func notFoundOr( err error, model interface{}, key interface{} ) error {
+  if errors.Is( err, gorm.ErrRecordNotFound ) {
+    return notFound( model, key )
+  }
+  return err
+}
@@ -0,0 +1,144 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+package queries_test
+
+import (
+  "context"
+  "errors"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/sirupsen/logrus"
+  "gorm.io/gorm"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestErrors(t *testing.T) {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "queries" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer os.RemoveAll( dir )
+
+  err = dataSource.Init( "sqlite://"+filepath.Join( dir, "test.sqlite3" ) )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer dataSource.Close()
+
+  t.Run( "not found", func( t *testing.T ) {
+    var user models.UserModel
+    err := queries.Get( &user, 1000, true )
+    var notFound *queries.NotFoundError
+    if !errors.As( err, &notFound ) || notFound.Model != "user" || notFound.Key != uint(1000) {
+      t.Fatalf( "expected user not found error, got %v", err )
+    }
+    if !errors.Is( err, globals.ErrNotFound ) || !errors.Is( err, globals.ErrNoSuchUser ) || errors.Is( err, globals.ErrNoSuchApp ) {
+      t.Error( "not found error matches the wrong sentinels" )
+    }
+
+    _, err = queries.GetAppByHash( "unknown" )
+    if !errors.Is( err, globals.ErrNoSuchApp ) {
+      t.Errorf( "expected app not found error, got %v", err )
+    }
+
+    err = queries.AddRoleToUser( &user, 1000 )
+    if !errors.Is( err, globals.ErrNoSuchRole ) {
+      t.Errorf( "expected role not found error, got %v", err )
+    }
+  })
+
+  t.Run( "database errors are returned", func( t *testing.T ) {
+    var users []*models.UserModel
+    err := queries.Find( &users, []interface{}{"no_such_column = ?", 1}, "", -1, 0, true )
+    if err == nil {
+      t.Error( "expected an error for an unknown column" )
+    }
+  })
+
+  t.Run( "lookups before writes return database errors", func( t *testing.T ) {
+    app := &models.AppModel{ Name: "shop", Hash: "shopHash", Secret: "shopSecret", MountPoint: "shop",
+      AvailableRoles: []*models.RoleModel{ { Name: "customer" } } }
+    if err := queries.CreateApp( app ); err != nil {
+      t.Fatal( err )
+    }
+
+    errQuery := errors.New( "query failed" )
+    db := dataSource.GetDB()
+    _ = db.Callback().Query().Before( "gorm:query" ).Register( "test:fail", func( tx *gorm.DB ) {
+      _ = tx.AddError( errQuery )
+    })
+    defer db.Callback().Query().Remove( "test:fail" )
+
+    err := queries.CreateApp( &models.AppModel{ Name: "blog", Hash: "blogHash", Secret: "blogSecret", MountPoint: "blog" } )
+    if !errors.Is( err, errQuery ) {
+      t.Errorf( "expected the app lookup error, got %v", err )
+    }
+
+    err = queries.CreateUser( &models.UserModel{ Login: "alice", Password: "secret", Roles: app.AvailableRoles } )
+    if !errors.Is( err, errQuery ) {
+      t.Errorf( "expected the user lookup error, got %v", err )
+    }
+
+    // only fail looking up single roles, after looking for duplicate users
+    _ = db.Callback().Query().Replace( "test:fail", func( tx *gorm.DB ) {
+      if _, ok := tx.Statement.Dest.(*models.RoleModel); ok {
+        _ = tx.AddError( errQuery )
+      }
+    })
+
+    err = queries.CreateUser( &models.UserModel{ Login: "alice", Password: "secret", Roles: app.AvailableRoles } )
+    if !errors.Is( err, errQuery ) {
+      t.Errorf( "expected the role lookup error, got %v", err )
+    }
+  })
+
+  t.Run( "limit 0", func( t *testing.T ) {
+    user := &models.UserModel{ Login: "someone", Password: "secret" }
+    if err := queries.CreateUser( user ); err != nil {
+      t.Fatal( err )
+    }
+    users := []*models.UserModel{ user }
+    err := queries.Find( &users, nil, "", 0, 0, true )
+    if err != nil || len(users) != 0 {
+      t.Errorf( "expected no users, got %d and %v", len(users), err )
+    }
+  })
+
+  t.Run( "cancelled context", func( t *testing.T ) {
+    ctx, cancel := context.WithCancel( context.Background() )
+    cancel()
+    var users []*models.UserModel
+    err := queries.WithContext( ctx ).Find( &users, nil, "", -1, 0, false )
+    if !errors.Is( err, context.Canceled ) {
+      t.Errorf( "expected context canceled, got %v", err )
+    }
+  })
+}
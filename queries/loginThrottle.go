@@ -0,0 +1,81 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package queries
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "time"
+)
+
+// LoginThrottle returns the throttle of key. Keys without failed
+// logins get a new throttle, which is not saved yet
+func LoginThrottle( kind string, key string ) (*models.LoginThrottleModel, error) {
+  return Default().LoginThrottle( kind, key )
+}
+
+func (q *Queries) LoginThrottle( kind string, key string ) (*models.LoginThrottleModel, error) {
+  var throttles []*models.LoginThrottleModel
+  err := q.db.Limit(1).Find( &throttles, "kind = ? AND key = ?", kind, key ).Error
+  if err != nil {
+    return nil, err
+  }
+  if len(throttles) == 0 {
+    return &models.LoginThrottleModel{ Kind: kind, Key: key }, nil
+  }
+  return throttles[0], nil
+}
+
+func SaveLoginThrottle( throttle *models.LoginThrottleModel ) error {
+  return Default().SaveLoginThrottle( throttle )
+}
+
+func (q *Queries) SaveLoginThrottle( throttle *models.LoginThrottleModel ) error {
+  return q.db.Save( throttle ).Error
+}
+
+// BlockedLoginThrottles finds the throttles refusing logins at now
+func BlockedLoginThrottles( throttles *[]*models.LoginThrottleModel, now time.Time ) error {
+  return Default().BlockedLoginThrottles( throttles, now )
+}
+
+func (q *Queries) BlockedLoginThrottles( throttles *[]*models.LoginThrottleModel, now time.Time ) error {
+  return q.db.Order( "blocked_until desc" ).Find( throttles, "blocked_until > ?", now ).Error
+}
+
+// DeleteLoginThrottle forgets the failed logins of key
+func DeleteLoginThrottle( kind string, key string ) error {
+  return Default().DeleteLoginThrottle( kind, key )
+}
+
+func (q *Queries) DeleteLoginThrottle( kind string, key string ) error {
+  result := q.db.Where( "kind = ? AND key = ?", kind, key ).Delete( &models.LoginThrottleModel{} )
+  if result.Error != nil {
+    return result.Error
+  }
+  if result.RowsAffected == 0 {
+    return notFound( &models.LoginThrottleModel{}, kind+" "+key )
+  }
+  return nil
+}
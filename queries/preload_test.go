@@ -0,0 +1,211 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package queries_test
+
+import (
+  "fmt"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/sirupsen/logrus"
+  "gorm.io/gorm"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+const preloadUsers = 50
+
+// openPreloadDb creates apps with two roles each and users having
+// the roles of every app. It returns a counter of executed queries
+func openPreloadDb( tb testing.TB ) (*int64, []*models.AppModel, []*models.UserModel, func()) {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "queries" )
+  if err != nil {
+    tb.Fatal( err )
+  }
+
+  err = dataSource.Init( "sqlite://"+filepath.Join( dir, "test.sqlite3" ) )
+  if err != nil {
+    tb.Fatal( err )
+  }
+
+  apps := make( []*models.AppModel, 0 )
+  roles := make( []*models.RoleModel, 0 )
+  for i:=0; i<3; i++ {
+    app := &models.AppModel{
+      Name: fmt.Sprintf( "app%d", i ), Hash: fmt.Sprintf( "hash%d", i ), Secret: fmt.Sprintf( "secret%d", i ), MountPoint: fmt.Sprintf( "app%d", i ),
+      AvailableRoles: []*models.RoleModel{ { Name: "reader" }, { Name: "writer" } },
+    }
+    if err := queries.CreateApp( app ); err != nil {
+      tb.Fatal( err )
+    }
+    apps = append( apps, app )
+    roles = append( roles, app.AvailableRoles... )
+  }
+
+  users := make( []*models.UserModel, 0 )
+  for i:=0; i<preloadUsers; i++ {
+    user := &models.UserModel{ Login: fmt.Sprintf( "user%d", i ), Password: "secret", Roles: roles }
+    if err := queries.CreateUser( user ); err != nil {
+      tb.Fatal( err )
+    }
+    users = append( users, user )
+  }
+
+  var count int64
+  err = dataSource.GetDB().Callback().Query().After( "gorm:query" ).Register( "test:count", func( db *gorm.DB ) {
+    count++
+  })
+  if err != nil {
+    tb.Fatal( err )
+  }
+
+  return &count, apps, users, func() {
+    dataSource.Close()
+    os.RemoveAll( dir )
+  }
+}
+
+func TestPreload(t *testing.T) {
+  count, apps, users, closeDb := openPreloadDb( t )
+  defer closeDb()
+
+  var found []*models.UserModel
+  *count = 0
+  err := queries.Find( &found, nil, "id", -1, 0, true )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if len(found) != preloadUsers || len(found[preloadUsers-1].Roles) != 6 {
+    t.Errorf( "expected %d users with 6 roles each, got %d", preloadUsers, len(found) )
+  }
+  // users, user_roles and role_models
+  if *count != 3 {
+    t.Errorf( "expected 3 queries, got %d", *count )
+  }
+
+  var app models.AppModel
+  err = queries.Get( &app, apps[1].ID, true )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if len(app.AvailableRoles) != 2 {
+    t.Errorf( "expected 2 roles, got %v", app.AvailableRoles )
+  }
+
+  *count = 0
+  roles, err := queries.RolesOfUserInApp( users[0].ID, apps[1].ID )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if len(roles) != 2 || roles[0].AppId != apps[1].ID || roles[1].AppId != apps[1].ID {
+    t.Errorf( "expected the 2 roles of app %d, got %v", apps[1].ID, roles )
+  }
+  if *count != 1 {
+    t.Errorf( "expected 1 query, got %d", *count )
+  }
+
+  roles, err = queries.RolesOfUserInApp( 0, apps[1].ID )
+  if err != nil || len(roles) != 0 {
+    t.Errorf( "expected no roles, got %v, %v", roles, err )
+  }
+
+  // soft deleted users keep their assignments, but not their roles
+  err = dataSource.GetDB().Delete( users[1] ).Error
+  if err != nil {
+    t.Fatal( err )
+  }
+  roles, err = queries.RolesOfUserInApp( users[1].ID, apps[1].ID )
+  if err != nil || len(roles) != 0 {
+    t.Errorf( "expected no roles of deleted user, got %v, %v", roles, err )
+  }
+}
+
+// the roles forward auth needs for a request, loaded with all
+// roles of the user like before and with the focused query
+func BenchmarkForwardAuthRoles(b *testing.B) {
+  count, apps, users, closeDb := openPreloadDb( b )
+  defer closeDb()
+
+  b.Run( "all roles", func(b *testing.B) {
+    *count = 0
+    for i:=0; i<b.N; i++ {
+      var user models.UserModel
+      if err := queries.Get( &user, users[i%preloadUsers].ID, false ); err != nil {
+        b.Fatal( err )
+      }
+      if err := queries.LoadRoles( &user ); err != nil {
+        b.Fatal( err )
+      }
+    }
+    b.ReportMetric( float64(*count)/float64(b.N), "queries/op" )
+  })
+
+  b.Run( "roles in app", func(b *testing.B) {
+    *count = 0
+    for i:=0; i<b.N; i++ {
+      if _, err := queries.RolesOfUserInApp( users[i%preloadUsers].ID, apps[1].ID ); err != nil {
+        b.Fatal( err )
+      }
+    }
+    b.ReportMetric( float64(*count)/float64(b.N), "queries/op" )
+  })
+}
+
+// users with their roles, loaded per row like before and preloaded
+func BenchmarkFindRecursive(b *testing.B) {
+  count, _, _, closeDb := openPreloadDb( b )
+  defer closeDb()
+
+  b.Run( "per row", func(b *testing.B) {
+    *count = 0
+    for i:=0; i<b.N; i++ {
+      var users []*models.UserModel
+      if err := queries.Find( &users, nil, "", -1, 0, false ); err != nil {
+        b.Fatal( err )
+      }
+      for _, user := range users {
+        if err := queries.LoadRoles( user ); err != nil {
+          b.Fatal( err )
+        }
+      }
+    }
+    b.ReportMetric( float64(*count)/float64(b.N), "queries/op" )
+  })
+
+  b.Run( "preloaded", func(b *testing.B) {
+    *count = 0
+    for i:=0; i<b.N; i++ {
+      var users []*models.UserModel
+      if err := queries.Find( &users, nil, "", -1, 0, true ); err != nil {
+        b.Fatal( err )
+      }
+    }
+    b.ReportMetric( float64(*count)/float64(b.N), "queries/op" )
+  })
+}
@@ -64,6 +64,9 @@ func TestQueries(t *testing.T) {
     app := new ( models.AppModel )
     app.Name = "App1"
     app.Description = "Description"
+    app.Hash = "app1Hash"
+    app.Secret = "app1Secret"
+    app.MountPoint = "app1"
     _ = queries.CreateApp(app)
 
     user := new ( models.UserModel )
@@ -88,6 +91,9 @@ func createApp( t *testing.T ) {
   app = new(models.AppModel)
   app.Name = "First app"
   app.Description = "First app description"
+  app.Hash = "firstHash"
+  app.Secret = "firstSecret"
+  app.MountPoint = "first"
 
   err := queries.CreateApp(app)
 
@@ -107,6 +113,9 @@ func createApp( t *testing.T ) {
   app = new(models.AppModel)
   app.Name = "Second app"
   app.Description = "Second app description"
+  app.Hash = "secondHash"
+  app.Secret = "secondSecret"
+  app.MountPoint = "second"
 
   _ = queries.CreateApp(app)
 }
@@ -115,7 +124,7 @@ func deleteApp( t *testing.T ) {
   db := dataSource.GetDB()
   var app *models.AppModel
 
-  err := queries.DeleteApp( 0 )
+  _, err := queries.DeleteApp( 0 )
   if err == nil {
     t.Error( "Deleted app with no primary key" )
   }
@@ -123,7 +132,7 @@ func deleteApp( t *testing.T ) {
   app = new( models.AppModel )
   db.Take(app, 1)
 
-  _ = queries.DeleteApp( 1 )
+  _, _ = queries.DeleteApp( 1 )
 
   app = new( models.AppModel )
   db.Take(app, 1)
@@ -135,7 +144,7 @@ func deleteApp( t *testing.T ) {
   app = new( models.AppModel )
   db.Take(app, 2)
 
-  _ = queries.DeleteApp( 2 )
+  _, _ = queries.DeleteApp( 2 )
 
   app = new( models.AppModel )
   db.Take(app, 2)
@@ -257,7 +266,7 @@ func deleteRole( t *testing.T ) {
   db := dataSource.GetDB()
   var role *models.RoleModel
 
-  err := queries.DeleteRole( 0 )
+  _, err := queries.DeleteRole( 0 )
   if err == nil {
     t.Error( "Deleted role with no primary key" )
   }
@@ -265,7 +274,7 @@ func deleteRole( t *testing.T ) {
   role = new( models.RoleModel )
   db.Take(role, 1)
 
-  _ = queries.DeleteRole( 1 )
+  _, _ = queries.DeleteRole( 1 )
 
   role = new( models.RoleModel )
   db.Take(role, 1)
@@ -277,7 +286,7 @@ func deleteRole( t *testing.T ) {
   role = new( models.RoleModel )
   db.Take(role, 2)
 
-  _ = queries.DeleteRole( 2 )
+  _, _ = queries.DeleteRole( 2 )
 
   role = new( models.RoleModel )
   db.Take(role, 2)
@@ -289,7 +298,7 @@ func deleteRole( t *testing.T ) {
   role = new( models.RoleModel )
   db.Take(role, 3)
 
-  _ = queries.DeleteRole( 3 )
+  _, _ = queries.DeleteRole( 3 )
 
   role = new( models.RoleModel )
   db.Take(role, 3)
@@ -301,7 +310,7 @@ func deleteRole( t *testing.T ) {
   role = new( models.RoleModel )
   db.Take(role, 4)
 
-  _ = queries.DeleteRole( 4 )
+  _, _ = queries.DeleteRole( 4 )
 
   role = new( models.RoleModel )
   db.Take(role, 4)
@@ -448,7 +457,7 @@ func deleteUser( t *testing.T) {
   db := dataSource.GetDB()
   var user *models.UserModel
 
-  err := queries.DeleteUser( 0 )
+  _, err := queries.DeleteUser( 0 )
   if err == nil {
     t.Error( "Deleted user with no primary key" )
   }
@@ -456,7 +465,7 @@ func deleteUser( t *testing.T) {
   user = new( models.UserModel )
   db.Take( user, 1)
 
-  _ = queries.DeleteUser( 1 )
+  _, _ = queries.DeleteUser( 1 )
 
   user = new( models.UserModel )
   db.Take( user, 1)
@@ -468,7 +477,7 @@ func deleteUser( t *testing.T) {
   user = new( models.UserModel )
   db.Take( user, 2)
 
-  _ = queries.DeleteUser( 2 )
+  _, _ = queries.DeleteUser( 2 )
 
   user = new( models.UserModel )
   db.Take( user, 2)
@@ -548,17 +557,26 @@ func findUsers( t *testing.T ) {
 func roleAutoAssign( t *testing.T ) {
   var user *models.UserModel
 
-  user = new (models.UserModel)
-  _ = queries.Get( user, 3, true )
+  var users []*models.UserModel
+  _ = queries.Find( &users, []interface{}{"login = ?", "login1"}, "", 1,0,false )
+  if len(users) != 1 {
+    t.Fatal( "unable to load user" )
+  }
+  user = users[0]
+
+  app, _ := queries.GetAppByHash( "app1Hash" )
+  if app == nil {
+    t.Fatal( "unable to load app" )
+  }
 
   var role models.RoleModel
 
   role.Name = "autoassign"
   role.AutoAssign = true
-  role.AppId = 3
+  role.AppId = app.ID
 
   _ = queries.CreateRole(&role)
-  _ = queries.Get( user, 3, true )
+  _ = queries.Get( user, user.ID, true )
 
   if len(user.Roles) != 1 {
     t.Error( "Autoassign failed: new role" )
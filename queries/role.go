@@ -26,56 +26,144 @@ package queries
 
 import (
   "errors"
-  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
   "github.com/schulterklopfer/cyphernode_fauth/globals"
   "github.com/schulterklopfer/cyphernode_fauth/models"
   "gopkg.in/validator.v2"
+  "gorm.io/gorm"
 )
 
 func CreateRole( role *models.RoleModel ) error {
+  return Default().CreateRole( role )
+}
+
+func (q *Queries) CreateRole( role *models.RoleModel ) error {
 
   if role.ID != 0 {
     // role must not have any ID possibly existing in DB
     return errors.New( "role ID must be 0" )
   }
 
-  db := dataSource.GetDB()
+  db := q.db
   err := validator.Validate(role)
   if err != nil {
     return err
   }
-  db.Create(role)
-  return nil
+  return db.Create(role).Error
 }
 
-func DeleteRole( id uint ) error {
+// DeleteRole deletes a role and takes it from its users. The admin
+// role of the admin app can't be deleted
+func DeleteRole( id uint ) (*DeleteReport, error) {
+  return Default().DeleteRole( id )
+}
+
+func (q *Queries) DeleteRole( id uint ) (*DeleteReport, error) {
   if id == 0 {
-    return errors.New("no such role")
+    return nil, notFound( &models.RoleModel{}, id )
   }
-  if id == 1 {
-    return globals.ErrActionForbidden
-  }
-  db := dataSource.GetDB()
-  var role models.RoleModel
-  db.Take( &role, id )
-  if role.ID == 0 {
-    return errors.New("no such role")
+
+  report := newDeleteReport()
+
+  err := q.db.Transaction( func( tx *gorm.DB ) error {
+    var roles []*models.RoleModel
+    err := tx.Unscoped().Limit(1).Find( &roles, id ).Error
+    if err != nil {
+      return err
+    }
+    if len(roles) == 0 {
+      return notFound( &models.RoleModel{}, id )
+    }
+
+    adminRole, err := isAdminRole( tx, roles[0] )
+    if err != nil {
+      return err
+    }
+    if adminRole {
+      return globals.ErrActionForbidden
+    }
+
+    return deleteRoles( tx, report, roles )
+  })
+
+  if err != nil {
+    return nil, err
   }
-  db.Unscoped().Delete( &role)
-  role.ID = 0
-  return nil
+  return report, nil
 }
 
 func UsersForRole( users *[]*models.UserModel, role *models.RoleModel ) error {
+  return Default().UsersForRole( users, role )
+}
+
+func (q *Queries) UsersForRole( users *[]*models.UserModel, role *models.RoleModel ) error {
   if role == nil {
-    return errors.New("no such role")
+    return globals.ErrNoSuchRole
   }
-  db := dataSource.GetDB()
-  db.Model(role).Association("Users").Find( users )
-  return nil
+  return q.db.Model(role).Association("Users").Find( users )
+}
+
+func RolesOfUserInApp( userId uint, appId uint ) ([]*models.RoleModel, error) {
+  return Default().RolesOfUserInApp( userId, appId )
+}
+
+// RolesOfUserInApp returns the roles the user with userId has in
+// the app with appId with a single query. Unknown and deleted users
+// have no roles
+func (q *Queries) RolesOfUserInApp( userId uint, appId uint ) ([]*models.RoleModel, error) {
+  roles := make( []*models.RoleModel, 0 )
+  err := q.db.
+    Joins( "JOIN user_roles ON user_roles.role_model_id = role_models.id" ).
+    Joins( "JOIN user_models ON user_models.id = user_roles.user_model_id AND user_models.deleted_at IS NULL" ).
+    Where( "user_roles.user_model_id = ? AND role_models.app_id = ?", userId, appId ).
+    Find( &roles ).Error
+  if err != nil {
+    return nil, err
+  }
+  return roles, nil
 }
 
 func AllRoles( allRoles *[]models.RoleModel ) error {
-  db := dataSource.GetDB()
+  return Default().AllRoles( allRoles )
+}
+
+func (q *Queries) AllRoles( allRoles *[]models.RoleModel ) error {
+  db := q.db
   return db.Find( allRoles ).Error
-}
\ No newline at end of file
+}
+func RenameRole( role *models.RoleModel, name string, description string ) error {
+  return Default().RenameRole( role, name, description )
+}
+
+// renames role in place, so users keep their assignments
+func (q *Queries) RenameRole( role *models.RoleModel, name string, description string ) error {
+  if role == nil || role.ID == 0 {
+    return globals.ErrNoSuchRole
+  }
+  adminRole, err := isAdminRole( q.db, role )
+  if err != nil {
+    return err
+  }
+  if adminRole {
+    return globals.ErrActionForbidden
+  }
+
+  renamedRole := *role
+  renamedRole.Name = name
+  renamedRole.Description = description
+  err = validator.Validate( renamedRole )
+  if err != nil {
+    return err
+  }
+
+  db := q.db
+  err = db.Model( role ).Updates( map[string]interface{}{
+    "name": name,
+    "description": description,
+  }).Error
+  if err != nil {
+    return err
+  }
+  role.Name = name
+  role.Description = description
+  return nil
+}
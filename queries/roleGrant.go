@@ -0,0 +1,132 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package queries
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/roleGrants"
+)
+
+// RoleGrantReport lists the labels of the roles role grants gave to
+// users by login
+type RoleGrantReport map[string][]string
+
+// GrantRoles applies the role grants of the admin's config file and
+// of all installed apps to users
+func GrantRoles( users []*models.UserModel ) (RoleGrantReport, error) {
+  return Default().GrantRoles( users )
+}
+
+func (q *Queries) GrantRoles( users []*models.UserModel ) (RoleGrantReport, error) {
+  grants, err := roleGrants.Load()
+  if err != nil {
+    return nil, err
+  }
+
+  var apps []*models.AppModel
+  err = q.Find( &apps, []interface{}{"archived_at IS NULL"}, "", -1, 0, false )
+  if err != nil {
+    return nil, err
+  }
+
+  for _, app := range apps {
+    grants = append( grants, app.RoleGrants... )
+  }
+
+  return q.ApplyRoleGrants( grants, users )
+}
+
+func ApplyRoleGrants( grants models.RoleGrants, users []*models.UserModel ) (RoleGrantReport, error) {
+  return Default().ApplyRoleGrants( grants, users )
+}
+
+// ApplyRoleGrants gives users the roles granted to them because of
+// roles they already have. Roles are only added, never removed.
+func (q *Queries) ApplyRoleGrants( grants models.RoleGrants, users []*models.UserModel ) (RoleGrantReport, error) {
+  report := make( RoleGrantReport )
+  if len(grants) == 0 || len(users) == 0 {
+    return report, nil
+  }
+
+  var apps []*models.AppModel
+  err := q.Find( &apps, []interface{}{"archived_at IS NULL"}, "", -1, 0, true )
+  if err != nil {
+    return nil, err
+  }
+
+  rolesByLabel := make( map[string]*models.RoleModel )
+  labelsById := make( map[uint]string )
+  for _, app := range apps {
+    for _, role := range app.AvailableRoles {
+      label := models.RoleLabel( app.MountPoint, role.Name )
+      rolesByLabel[label] = role
+      labelsById[role.ID] = label
+    }
+  }
+
+  db := q.db
+
+  for _, user := range users {
+    if user == nil || user.ID == 0 {
+      continue
+    }
+
+    err := q.LoadRoles( user )
+    if err != nil {
+      return nil, err
+    }
+
+    hasRole := make( map[string]bool )
+    for _, role := range user.Roles {
+      if label, ok := labelsById[role.ID]; ok {
+        hasRole[label] = true
+      }
+    }
+
+    // grants can build on each other, so repeat until
+    // nothing changes anymore
+    for changed := true; changed; {
+      changed = false
+      for _, grant := range grants {
+        if !grant.IsValid() || !hasRole[grant.WhenRole] || hasRole[grant.Grant] {
+          continue
+        }
+        role, ok := rolesByLabel[grant.Grant]
+        if !ok {
+          continue
+        }
+        err := db.Model( user ).Association( "Roles" ).Append( role )
+        if err != nil {
+          return nil, err
+        }
+        report[user.Login] = append( report[user.Login], grant.Grant )
+        hasRole[grant.Grant] = true
+        changed = true
+      }
+    }
+  }
+
+  return report, nil
+}
@@ -0,0 +1,163 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package queries_test
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/sirupsen/logrus"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "testing"
+)
+
+func TestRoleGrants(t *testing.T) {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "queries" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer os.RemoveAll( dir )
+
+  err = dataSource.Init( "sqlite://"+filepath.Join( dir, "test.sqlite3" ) )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer dataSource.Close()
+
+  grantsFile := filepath.Join( dir, "roleGrants.json" )
+  os.Setenv( globals.CNA_ROLE_GRANTS_FILE_ENV_KEY, grantsFile )
+  defer os.Unsetenv( globals.CNA_ROLE_GRANTS_FILE_ENV_KEY )
+  _ = ioutil.WriteFile( grantsFile, []byte(`[
+    {"whenRole": "app/reader", "grant": "app/writer"},
+    {"whenRole": "app/writer", "grant": "app/editor"},
+    {"whenRole": "app/reader", "grant": "app/nope"},
+    {"whenRole": "app/reader", "grant": "nope/reader"},
+    {"whenRole": "app/reader", "grant": "wiki/viewer"}
+  ]`), 0644 )
+
+  // the admin app comes first, it can't be archived
+  apps := []*models.AppModel{
+    { Name: "admin", Hash: "adminHash", Secret: "adminSecret", MountPoint: globals.BASE_ADMIN_MOUNTPOINT },
+    { Name: "app", Hash: "appHash", Secret: "appSecret", MountPoint: "app",
+      AvailableRoles: []*models.RoleModel{ { Name: "reader" }, { Name: "writer" }, { Name: "editor" } } },
+    { Name: "shop", Hash: "shopHash", Secret: "shopSecret", MountPoint: "shop",
+      AvailableRoles: []*models.RoleModel{ { Name: "customer" } },
+      RoleGrants: models.RoleGrants{ { WhenRole: "app/editor", Grant: "shop/customer" } } },
+    { Name: "wiki", Hash: "wikiHash", Secret: "wikiSecret", MountPoint: "wiki",
+      AvailableRoles: []*models.RoleModel{ { Name: "viewer" } } },
+  }
+  for _, app := range apps {
+    if err := queries.CreateApp( app ); err != nil {
+      t.Fatal( err )
+    }
+  }
+  reader, writer := apps[1].AvailableRoles[0], apps[1].AvailableRoles[1]
+  if err := queries.ArchiveApp( apps[3] ); err != nil {
+    t.Fatal( err )
+  }
+
+  roleLabels := func( user *models.UserModel ) string {
+    roles, err := queries.GetRolesOfUser( user )
+    if err != nil {
+      t.Fatal( err )
+    }
+    labels := make( []string, 0, len(roles) )
+    for _, role := range roles {
+      for _, app := range apps {
+        if app.ID == role.AppId {
+          labels = append( labels, models.RoleLabel( app.MountPoint, role.Name ) )
+        }
+      }
+    }
+    sort.Strings( labels )
+    return strings.Join( labels, " " )
+  }
+
+  // grants are applied when users are created, they build on each other
+  // and skip unknown roles, unknown apps and archived apps
+  alice := &models.UserModel{ Login: "alice", Password: "secret", Roles: []*models.RoleModel{ reader } }
+  if err := queries.CreateUser( alice ); err != nil {
+    t.Fatal( err )
+  }
+  if labels := roleLabels( alice ); labels != "app/editor app/reader app/writer shop/customer" {
+    t.Errorf( "expected alice to be granted app/writer, app/editor and shop/customer, got %s", labels )
+  }
+
+  bob := &models.UserModel{ Login: "bob", Password: "secret" }
+  if err := queries.CreateUser( bob ); err != nil {
+    t.Fatal( err )
+  }
+  if labels := roleLabels( bob ); labels != "" {
+    t.Errorf( "expected bob to be granted nothing, got %s", labels )
+  }
+
+  report, err := queries.GrantRoles( []*models.UserModel{ alice, bob } )
+  if err != nil || len(report) != 0 {
+    t.Errorf( "granting again must change nothing, got %v %v", report, err )
+  }
+
+  // revoked roles are granted again as long as the user has the role
+  // they are granted for
+  if err := queries.RemoveRoleFromUser( alice, writer.ID ); err != nil {
+    t.Fatal( err )
+  }
+  report, err = queries.GrantRoles( []*models.UserModel{ alice } )
+  if err != nil || strings.Join( report["alice"], " " ) != "app/writer" {
+    t.Errorf( "expected app/writer to be granted to alice again, got %v %v", report, err )
+  }
+
+  // grants only add roles. revoking the role a grant is based on
+  // keeps the granted roles
+  if err := queries.RemoveRoleFromUser( alice, reader.ID ); err != nil {
+    t.Fatal( err )
+  }
+  report, err = queries.GrantRoles( []*models.UserModel{ alice } )
+  if err != nil || len(report) != 0 {
+    t.Errorf( "expected nothing to be granted, got %v %v", report, err )
+  }
+  if labels := roleLabels( alice ); labels != "app/editor app/writer shop/customer" {
+    t.Errorf( "expected alice to keep the granted roles, got %s", labels )
+  }
+
+  if err := queries.AddRoleToUser( bob, reader.ID ); err != nil {
+    t.Fatal( err )
+  }
+  report, err = queries.ApplyRoleGrants( models.RoleGrants{ { WhenRole: "app/reader", Grant: "wiki/viewer" } }, []*models.UserModel{ bob } )
+  if err != nil || len(report) != 0 {
+    t.Errorf( "expected roles of archived apps not to be granted, got %v %v", report, err )
+  }
+
+  _ = ioutil.WriteFile( grantsFile, []byte(`[{"whenRole": "app/reader"`), 0644 )
+  if _, err := queries.GrantRoles( []*models.UserModel{ bob } ); err == nil {
+    t.Error( "broken grants files must fail" )
+  }
+}
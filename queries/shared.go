@@ -25,45 +25,100 @@
 package queries
 
 import (
+  "context"
   "github.com/schulterklopfer/cyphernode_fauth/dataSource"
   "github.com/schulterklopfer/cyphernode_fauth/models"
   "gopkg.in/validator.v2"
+  "gorm.io/gorm"
+  "reflect"
 )
 
+// Queries runs queries against a database handle, which
+// might be a transaction. The package level functions
+// use the default handle of the dataSource.
+type Queries struct {
+  db *gorm.DB
+}
+
+func With( db *gorm.DB ) *Queries {
+  return &Queries{ db: db }
+}
+
+func Default() *Queries {
+  return With( dataSource.GetDB() )
+}
+
+// WithContext runs the queries of the default handle with ctx,
+// so they are cancelled with it and respect its deadline
+func WithContext( ctx context.Context ) *Queries {
+  return Default().WithContext( ctx )
+}
+
+func (q *Queries) WithContext( ctx context.Context ) *Queries {
+  return With( q.db.WithContext( ctx ) )
+}
+
+// Transaction runs fn in a database transaction. Everything fn
+// does with q is rolled back if fn returns an error
+func Transaction( fn func( q *Queries ) error ) error {
+  return Default().Transaction( fn )
+}
+
+// Transaction nests as a savepoint, if q already runs in a transaction
+func (q *Queries) Transaction( fn func( q *Queries ) error ) error {
+  return q.db.Transaction( func( tx *gorm.DB ) error {
+    return fn( With( tx ) )
+  })
+}
+
 func Create(model interface{} ) error {
-  db := dataSource.GetDB()
+  return Default().Create( model )
+}
+
+func (q *Queries) Create(model interface{} ) error {
   err := validator.Validate( model )
   if err != nil {
     return err
   }
-  return db.Create( model ).Error
+  return q.db.Create( model ).Error
 }
 
-
 func Get( model interface{}, id uint, recursive bool ) error {
-  db := dataSource.GetDB()
-  db.Take(model, id)
+  return Default().Get( model, id, recursive )
+}
+
+// Get loads the row with id into model. A NotFoundError is returned
+// if there is none
+func (q *Queries) Get( model interface{}, id uint, recursive bool ) error {
+  db := q.db
   if recursive {
-    err := LoadRoles(model)
-    if err != nil {
-      return err
-    }
+    db = preloadRoles( db, model )
+  }
+  err := db.Take(model, id).Error
+  if err != nil {
+    return notFoundOr( err, model, id )
   }
   return nil
 }
 
 func Update( model interface{} ) error {
-  db := dataSource.GetDB()
+  return Default().Update( model )
+}
 
+func (q *Queries) Update( model interface{} ) error {
   err := validator.Validate( model )
 
   if err != nil {
     return err
   }
-  return db.Save( model ).Error
+  return q.db.Save( model ).Error
 }
 
 func Find( out interface{}, where []interface{}, order string, limit int, offset int, recursive bool ) error {
+  return Default().Find( out, where, order, limit, offset, recursive )
+}
+
+func (q *Queries) Find( out interface{}, where []interface{}, order string, limit int, offset int, recursive bool ) error {
 
   /*
      where == nil -> no where
@@ -72,16 +127,23 @@ func Find( out interface{}, where []interface{}, order string, limit int, offset
      offset == 0 -> no offset
   */
 
-  db := dataSource.GetDB()
+  db := q.db
 
   if len(where) > 0 {
-    db = db.Where( where[0].(string), where[1:] )
+    db = db.Where( where[0].(string), where[1:]... )
   }
 
   if order != "" {
     db = db.Order( order )
   }
 
+  if limit == 0 {
+    // gorm ignores a limit of 0
+    value := reflect.ValueOf( out ).Elem()
+    value.Set( reflect.MakeSlice( value.Type(), 0, 0 ) )
+    return nil
+  }
+
   if limit != -1 {
     db = db.Limit( limit )
   }
@@ -90,41 +152,49 @@ func Find( out interface{}, where []interface{}, order string, limit int, offset
     db = db.Offset( offset )
   }
 
-  db.Find( out )
-
   if recursive {
-    switch out.(type) {
-    case *[]*models.UserModel:
-      users := *out.(*[]*models.UserModel)
-      for i:=0; i<len(users); i++ {
-        _ = LoadRoles(users[i])
-      }
-    case *[]*models.AppModel:
-      apps := *out.(*[]*models.AppModel)
-      for i:=0; i<len(apps); i++ {
-        _ = LoadRoles(apps[i])
-      }
-    }
+    db = preloadRoles( db, out )
   }
 
-  return db.Error
+  return db.Find( out ).Error
 
 }
 
+// preloadRoles loads the roles of users or apps with one query
+// per association instead of one per row
+func preloadRoles( db *gorm.DB, model interface{} ) *gorm.DB {
+  switch model.(type) {
+  case *models.UserModel, *[]*models.UserModel:
+    return db.Preload( "Roles" )
+  case *models.AppModel, *[]*models.AppModel:
+    return db.Preload( "AvailableRoles" )
+  }
+  return db
+}
+
 func LoadRoles( in interface{} ) error {
-  db := dataSource.GetDB()
+  return Default().LoadRoles( in )
+}
+
+func (q *Queries) LoadRoles( in interface{} ) error {
   var roles []*models.RoleModel
   switch in.(type) {
   case *models.UserModel:
     if in.(*models.UserModel).ID > 0 {
-      db.Model(in).Association("Roles").Find(&roles)
+      err := q.db.Model(in).Association("Roles").Find(&roles)
+      if err != nil {
+        return err
+      }
       in.(*models.UserModel).Roles = roles
     }
   case *models.AppModel:
     if in.(*models.AppModel).ID > 0 {
-      db.Model(in).Association("AvailableRoles").Find(&roles)
+      err := q.db.Model(in).Association("AvailableRoles").Find(&roles)
+      if err != nil {
+        return err
+      }
       in.(*models.AppModel).AvailableRoles = roles
     }
   }
-  return db.Error
+  return nil
 }
@@ -0,0 +1,145 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package queries
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "gorm.io/gorm"
+  "time"
+)
+
+func TotpOfUser( userId uint ) (*models.TotpModel, error) {
+  return Default().TotpOfUser( userId )
+}
+
+func (q *Queries) TotpOfUser( userId uint ) (*models.TotpModel, error) {
+  var totps []*models.TotpModel
+  err := q.db.Limit(1).Find( &totps, "user_id = ?", userId ).Error
+  if err != nil {
+    return nil, err
+  }
+  if len(totps) == 0 {
+    return nil, notFound( &models.TotpModel{}, userId )
+  }
+  return totps[0], nil
+}
+
+func SaveTotp( totp *models.TotpModel ) error {
+  return Default().SaveTotp( totp )
+}
+
+func (q *Queries) SaveTotp( totp *models.TotpModel ) error {
+  return q.db.Save( totp ).Error
+}
+
+// UseTotpStep stores step as the last used step of totp, unless a
+// later step was used in the meantime
+func UseTotpStep( totp *models.TotpModel, step int64 ) (bool, error) {
+  return Default().UseTotpStep( totp, step )
+}
+
+func (q *Queries) UseTotpStep( totp *models.TotpModel, step int64 ) (bool, error) {
+  result := q.db.Model( &models.TotpModel{} ).
+    Where( "id = ? AND last_step < ?", totp.ID, step ).
+    UpdateColumn( "last_step", step )
+  if result.Error != nil {
+    return false, result.Error
+  }
+  totp.LastStep = step
+  return result.RowsAffected == 1, nil
+}
+
+// DeleteTotp removes the totp secret and the recovery codes of a
+// user
+func DeleteTotp( userId uint ) error {
+  return Default().DeleteTotp( userId )
+}
+
+func (q *Queries) DeleteTotp( userId uint ) error {
+  return q.db.Transaction( func( tx *gorm.DB ) error {
+    _, err := With( tx ).TotpOfUser( userId )
+    if err != nil {
+      return err
+    }
+    return deleteTotp( tx, userId )
+  })
+}
+
+func deleteTotp( tx *gorm.DB, userId uint ) error {
+  err := tx.Where( "user_id = ?", userId ).Delete( &models.RecoveryCodeModel{} ).Error
+  if err != nil {
+    return err
+  }
+  return tx.Where( "user_id = ?", userId ).Delete( &models.TotpModel{} ).Error
+}
+
+// ReplaceRecoveryCodes replaces all recovery codes of a user with
+// the ones with codeHashes
+func ReplaceRecoveryCodes( userId uint, codeHashes []string ) error {
+  return Default().ReplaceRecoveryCodes( userId, codeHashes )
+}
+
+func (q *Queries) ReplaceRecoveryCodes( userId uint, codeHashes []string ) error {
+  return q.db.Transaction( func( tx *gorm.DB ) error {
+    err := tx.Where( "user_id = ?", userId ).Delete( &models.RecoveryCodeModel{} ).Error
+    if err != nil {
+      return err
+    }
+    if len(codeHashes) == 0 {
+      return nil
+    }
+    codes := make( []*models.RecoveryCodeModel, len(codeHashes) )
+    for i, codeHash := range codeHashes {
+      codes[i] = &models.RecoveryCodeModel{ UserId: userId, CodeHash: codeHash }
+    }
+    return tx.Create( &codes ).Error
+  })
+}
+
+// UseRecoveryCode marks the unused recovery code of a user with
+// codeHash as used. It tells if there was such a code
+func UseRecoveryCode( userId uint, codeHash string ) (bool, error) {
+  return Default().UseRecoveryCode( userId, codeHash )
+}
+
+func (q *Queries) UseRecoveryCode( userId uint, codeHash string ) (bool, error) {
+  result := q.db.Model( &models.RecoveryCodeModel{} ).
+    Where( "user_id = ? AND code_hash = ? AND used_at IS NULL", userId, codeHash ).
+    UpdateColumn( "used_at", time.Now() )
+  return result.RowsAffected > 0, result.Error
+}
+
+// UnusedRecoveryCodes counts the recovery codes of a user left
+func UnusedRecoveryCodes( userId uint ) (int64, error) {
+  return Default().UnusedRecoveryCodes( userId )
+}
+
+func (q *Queries) UnusedRecoveryCodes( userId uint ) (int64, error) {
+  var count int64
+  err := q.db.Model( &models.RecoveryCodeModel{} ).
+    Where( "user_id = ? AND used_at IS NULL", userId ).
+    Count( &count ).Error
+  return count, err
+}
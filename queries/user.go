@@ -25,111 +25,246 @@
 package queries
 
 import (
-  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
   "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
   "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/password"
   "gopkg.in/validator.v2"
+  "gorm.io/gorm"
+  "sync"
 )
 
 func CreateUser( user *models.UserModel ) error {
-  db := dataSource.GetDB()
+  return Default().CreateUser( user )
+}
+
+func (q *Queries) CreateUser( user *models.UserModel ) error {
+  db := q.db
   err := validator.Validate( user )
   if err != nil {
     return err
   }
   // update associations, but don't upsert roles.
-  return db.Create( user ).Error
+  err = db.Create( user ).Error
+  if err != nil {
+    return err
+  }
+  _, err = q.GrantRoles( []*models.UserModel{ user } )
+  return err
 }
 
 func UpdateUser( user *models.UserModel ) error {
-  db := dataSource.GetDB()
-  tx := db.Begin()
+  return Default().UpdateUser( user )
+}
 
+func (q *Queries) UpdateUser( user *models.UserModel ) error {
   err := validator.Validate( user )
   if err != nil {
-    tx.Rollback()
-    return err
-  }
-  err = tx.Model(&user).Association("Roles").Replace(user.Roles)
-  if err != nil {
-    tx.Rollback()
-    return err
-  }
-  err = tx.Save( user ).Error
-  if err != nil {
-    tx.Rollback()
     return err
   }
 
-  tx.Commit()
-  return nil
+  // nests as a savepoint, if q already runs in a transaction
+  return q.db.Transaction( func( tx *gorm.DB ) error {
+    err := tx.Model(&user).Association("Roles").Replace(user.Roles)
+    if err != nil {
+      return err
+    }
+    return tx.Save( user ).Error
+  })
 }
 
 
-func DeleteUser( id uint ) error {
+// DeleteUser deletes a user and its role assignments for good. The
+// last admin can't be deleted
+func DeleteUser( id uint ) (*DeleteReport, error) {
+  return Default().DeleteUser( id )
+}
+
+func (q *Queries) DeleteUser( id uint ) (*DeleteReport, error) {
   if id == 0 {
-    return globals.ErrNoSuchUser
+    return nil, notFound( &models.UserModel{}, id )
   }
-  if id == 1 {
-    return globals.ErrActionForbidden
+
+  report := newDeleteReport()
+
+  err := q.db.Transaction( func( tx *gorm.DB ) error {
+    var users []*models.UserModel
+    err := tx.Unscoped().Limit(1).Find( &users, id ).Error
+    if err != nil {
+      return err
+    }
+    if len(users) == 0 {
+      return notFound( &models.UserModel{}, id )
+    }
+
+    adminIds, err := adminUserIds( tx )
+    if err != nil {
+      return err
+    }
+    if len(adminIds) == 1 && adminIds[0] == id {
+      return globals.ErrActionForbidden
+    }
+
+    err = deleteAssignments( tx, report, "user_model_id", []uint{ id } )
+    if err != nil {
+      return err
+    }
+
+    err = deleteTotp( tx, id )
+    if err != nil {
+      return err
+    }
+
+    err = deleteWebauthnCredentials( tx, id )
+    if err != nil {
+      return err
+    }
+
+    err = tx.Unscoped().Delete( users[0] ).Error
+    if err != nil {
+      return err
+    }
+    report.Users = append( report.Users, id )
+    return nil
+  })
+
+  if err != nil {
+    return nil, err
   }
-  db := dataSource.GetDB()
-  var user models.UserModel
-  db.Take( &user, id )
-  return db.Unscoped().Delete( &user ).Error
+  return report, nil
 }
 
-func RemoveRoleFromUser(  user *models.UserModel, roleId uint ) error {
-  if roleId == 1 && user.ID == 1 {
-    return globals.ErrActionForbidden
+// IsAdminUser tells if the user has the admin role of the admin app
+func IsAdminUser( userId uint ) (bool, error) {
+  return Default().IsAdminUser( userId )
+}
+
+func (q *Queries) IsAdminUser( userId uint ) (bool, error) {
+  adminIds, err := adminUserIds( q.db )
+  if err != nil {
+    return false, err
   }
+  for _, adminId := range adminIds {
+    if adminId == userId {
+      return true, nil
+    }
+  }
+  return false, nil
+}
 
-  db := dataSource.GetDB()
+func RemoveRoleFromUser(  user *models.UserModel, roleId uint ) error {
+  return Default().RemoveRoleFromUser( user, roleId )
+}
 
+// RemoveRoleFromUser takes a role from user. The last admin keeps
+// the admin role
+func (q *Queries) RemoveRoleFromUser(  user *models.UserModel, roleId uint ) error {
   var role models.RoleModel
 
-  err := Get( &role, roleId, false )
+  err := q.Get( &role, roleId, false )
+
+  if err != nil {
+    return err
+  }
 
+  adminRole, err := isAdminRole( q.db, &role )
   if err != nil {
     return err
   }
 
-  if role.ID == 0 {
-    return globals.ErrNoSuchRole
+  if adminRole {
+    adminIds, err := adminUserIds( q.db )
+    if err != nil {
+      return err
+    }
+    if len(adminIds) == 1 && adminIds[0] == user.ID {
+      return globals.ErrActionForbidden
+    }
   }
 
-  db.Model(&user).Association("Roles").Delete( &role )
-  return db.Error
+  return q.db.Model(user).Association("Roles").Delete( &role )
 }
 
 func AddRoleToUser( user *models.UserModel, roleId uint ) error {
-  db := dataSource.GetDB()
+  return Default().AddRoleToUser( user, roleId )
+}
 
+func (q *Queries) AddRoleToUser( user *models.UserModel, roleId uint ) error {
   var role models.RoleModel
 
-  err := Get( &role, roleId, false )
+  err := q.Get( &role, roleId, false )
 
   if err != nil {
     return err
   }
 
-  if role.ID == 0 {
-    return globals.ErrNoSuchRole
-  }
-
   for i:=0; i<len( user.Roles ); i++ {
     if user.Roles[i].ID == roleId {
       return globals.ErrUserAlreadyHasRole
     }
   }
 
-  db.Model(&user).Association("Roles").Append( &role )
-  return db.Error
+  return q.db.Model(user).Association("Roles").Append( &role )
 }
 
 func GetRolesOfUser( user *models.UserModel ) ( []*models.RoleModel, error) {
-  db := dataSource.GetDB()
+  return Default().GetRolesOfUser( user )
+}
+
+func (q *Queries) GetRolesOfUser( user *models.UserModel ) ( []*models.RoleModel, error) {
   var roles []*models.RoleModel
-  db.Model( &user ).Association( "Roles" ).Find(&roles)
-  return roles, db.Error
+  err := q.db.Model( user ).Association( "Roles" ).Find(&roles)
+  return roles, err
+}
+
+
+// Authenticate returns the user with login, if plainPassword is its
+// password. Unknown logins and wrong passwords both fail with
+// globals.ErrInvalidCredentials and take about the same time. Hashes
+// made with outdated params are replaced with new ones
+func Authenticate( login string, plainPassword string ) (*models.UserModel, error) {
+  return Default().Authenticate( login, plainPassword )
+}
+
+func (q *Queries) Authenticate( login string, plainPassword string ) (*models.UserModel, error) {
+  var users []*models.UserModel
+  err := q.db.Limit(1).Find( &users, "login = ?", login ).Error
+  if err != nil {
+    return nil, err
+  }
+
+  if len(users) == 0 {
+    password.CheckPasswordHash( plainPassword, unknownUserHash() )
+    return nil, globals.ErrInvalidCredentials
+  }
+
+  user := users[0]
+  if !password.CheckPasswordHash( plainPassword, user.Password ) {
+    return nil, globals.ErrInvalidCredentials
+  }
+
+  if password.NeedsRehash( user.Password ) {
+    hashedPassword, err := password.HashPassword( plainPassword )
+    if err == nil {
+      err = q.db.Model( user ).UpdateColumn( "password", hashedPassword ).Error
+    }
+    if err != nil {
+      // the old hash still works, try again next time
+      logwrapper.Logger().Warnf( "failed to rehash password of %s: %s", user.Login, err.Error() )
+    }
+  }
+
+  return user, nil
+}
+
+var unknownUserHashOnce sync.Once
+var unknownUserHashValue string
+
+// unknownUserHash is checked against for unknown logins, so they
+// can't be told apart from known ones by timing
+func unknownUserHash() string {
+  unknownUserHashOnce.Do( func() {
+    unknownUserHashValue, _ = password.HashPassword( "unknown user" )
+  })
+  return unknownUserHashValue
 }
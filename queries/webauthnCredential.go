@@ -0,0 +1,121 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package queries
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "gorm.io/gorm"
+  "time"
+)
+
+// WebauthnCredentialsOfUser returns the webauthn credentials of a
+// user in the order they were registered
+func WebauthnCredentialsOfUser( userId uint ) ([]*models.WebauthnCredentialModel, error) {
+  return Default().WebauthnCredentialsOfUser( userId )
+}
+
+func (q *Queries) WebauthnCredentialsOfUser( userId uint ) ([]*models.WebauthnCredentialModel, error) {
+  var credentials []*models.WebauthnCredentialModel
+  err := q.db.Order( "id" ).Find( &credentials, "user_id = ?", userId ).Error
+  return credentials, err
+}
+
+// WebauthnCredential returns the webauthn credential with the
+// base64url encoded credentialId
+func WebauthnCredential( credentialId string ) (*models.WebauthnCredentialModel, error) {
+  return Default().WebauthnCredential( credentialId )
+}
+
+func (q *Queries) WebauthnCredential( credentialId string ) (*models.WebauthnCredentialModel, error) {
+  var credentials []*models.WebauthnCredentialModel
+  err := q.db.Limit(1).Find( &credentials, "credential_id = ?", credentialId ).Error
+  if err != nil {
+    return nil, err
+  }
+  if len(credentials) == 0 {
+    return nil, notFound( &models.WebauthnCredentialModel{}, credentialId )
+  }
+  return credentials[0], nil
+}
+
+func CreateWebauthnCredential( credential *models.WebauthnCredentialModel ) error {
+  return Default().CreateWebauthnCredential( credential )
+}
+
+func (q *Queries) CreateWebauthnCredential( credential *models.WebauthnCredentialModel ) error {
+  return q.db.Create( credential ).Error
+}
+
+// UseWebauthnCredential stores signCount as the signature counter
+// of credential, unless a later one was stored in the meantime.
+// Authenticators without a counter always send zero
+func UseWebauthnCredential( credential *models.WebauthnCredentialModel, signCount uint32 ) (bool, error) {
+  return Default().UseWebauthnCredential( credential, signCount )
+}
+
+func (q *Queries) UseWebauthnCredential( credential *models.WebauthnCredentialModel, signCount uint32 ) (bool, error) {
+  now := time.Now()
+  result := q.db.Model( &models.WebauthnCredentialModel{} ).
+    Where( "id = ? AND (sign_count < ? OR sign_count = 0)", credential.ID, signCount ).
+    UpdateColumns( map[string]interface{}{ "sign_count": signCount, "last_used_at": now } )
+  if result.Error != nil {
+    return false, result.Error
+  }
+  credential.SignCount = signCount
+  credential.LastUsedAt = &now
+  return result.RowsAffected == 1, nil
+}
+
+// DeleteWebauthnCredential removes the webauthn credential with id of
+// a user
+func DeleteWebauthnCredential( userId uint, id uint ) error {
+  return Default().DeleteWebauthnCredential( userId, id )
+}
+
+func (q *Queries) DeleteWebauthnCredential( userId uint, id uint ) error {
+  result := q.db.Where( "user_id = ?", userId ).Delete( &models.WebauthnCredentialModel{}, id )
+  if result.Error != nil {
+    return result.Error
+  }
+  if result.RowsAffected == 0 {
+    return notFound( &models.WebauthnCredentialModel{}, id )
+  }
+  return nil
+}
+
+// DeleteWebauthnCredentials removes all webauthn credentials of a
+// user. It tells how many there were
+func DeleteWebauthnCredentials( userId uint ) (int64, error) {
+  return Default().DeleteWebauthnCredentials( userId )
+}
+
+func (q *Queries) DeleteWebauthnCredentials( userId uint ) (int64, error) {
+  result := q.db.Where( "user_id = ?", userId ).Delete( &models.WebauthnCredentialModel{} )
+  return result.RowsAffected, result.Error
+}
+
+func deleteWebauthnCredentials( tx *gorm.DB, userId uint ) error {
+  return tx.Where( "user_id = ?", userId ).Delete( &models.WebauthnCredentialModel{} ).Error
+}
@@ -0,0 +1,68 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package roleGrants
+
+import (
+  "encoding/json"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/helpers"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "io/ioutil"
+  "os"
+)
+
+// Load reads the role grants configured by the admin. The file is
+// read every time, since grants are only applied when apps are
+// synced or users are created. A missing file means no grants.
+func Load() (models.RoleGrants, error) {
+  grantsJsonBytes, err := ioutil.ReadFile( helpers.GetenvOrDefault( globals.CNA_ROLE_GRANTS_FILE_ENV_KEY ) )
+
+  if os.IsNotExist( err ) {
+    return models.RoleGrants{}, nil
+  }
+
+  if err != nil {
+    return nil, err
+  }
+
+  var grants models.RoleGrants
+  err = json.Unmarshal( grantsJsonBytes, &grants )
+
+  if err != nil {
+    return nil, err
+  }
+
+  validGrants := make( models.RoleGrants, 0, len(grants) )
+  for _, grant := range grants {
+    if !grant.IsValid() {
+      logwrapper.Logger().Warnf( "Ignoring invalid role grant %v", grant )
+      continue
+    }
+    validGrants = append( validGrants, grant )
+  }
+
+  return validGrants, nil
+}
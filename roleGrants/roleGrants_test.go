@@ -0,0 +1,71 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package roleGrants_test
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/roleGrants"
+  "github.com/sirupsen/logrus"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestLoad(t *testing.T) {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "roleGrants" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer os.RemoveAll( dir )
+
+  grantsFile := filepath.Join( dir, "roleGrants.json" )
+  os.Setenv( globals.CNA_ROLE_GRANTS_FILE_ENV_KEY, grantsFile )
+  defer os.Unsetenv( globals.CNA_ROLE_GRANTS_FILE_ENV_KEY )
+
+  grants, err := roleGrants.Load()
+  if err != nil || grants == nil || len(grants) != 0 {
+    t.Errorf( "expected no grants without a file, got %v %v", grants, err )
+  }
+
+  _ = ioutil.WriteFile( grantsFile, []byte(`[
+    {"whenRole": "admin/admin", "grant": "app/operator"},
+    {"whenRole": "admin/admin", "grant": "operator"},
+    {"whenRole": "app/operator", "grant": "app/operator"},
+    {"whenRole": "/admin", "grant": "app/"},
+    null
+  ]`), 0644 )
+  grants, err = roleGrants.Load()
+  if err != nil || len(grants) != 1 || grants[0].WhenRole != "admin/admin" || grants[0].Grant != "app/operator" {
+    t.Errorf( "expected only the valid grant, got %v %v", grants, err )
+  }
+
+  _ = ioutil.WriteFile( grantsFile, []byte(`{"whenRole": "admin/admin"`), 0644 )
+  if _, err := roleGrants.Load(); err == nil {
+    t.Error( "broken files must fail" )
+  }
+}
@@ -0,0 +1,100 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package stores
+
+import (
+  "context"
+  "github.com/schulterklopfer/cyphernode_fauth/cyphernodeKeys"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+)
+
+type databaseStore struct{}
+
+type cyphernodeKeysStore struct{}
+
+// Database returns the stores backed by the default database
+// handle and the cyphernode keys instance
+func Database() *Stores {
+  store := &databaseStore{}
+  return &Stores{
+    Apps:  store,
+    Users: store,
+    Roles: store,
+    Keys:  &cyphernodeKeysStore{},
+  }
+}
+
+func (store *databaseStore) AppById( ctx context.Context, id uint ) (*models.AppModel, error) {
+  var app models.AppModel
+  err := queries.WithContext( ctx ).Get( &app, id, false )
+  if err != nil {
+    return nil, err
+  }
+  return &app, nil
+}
+
+func (store *databaseStore) AppByMountPoint( ctx context.Context, mountPoint string ) (*models.AppModel, error) {
+  var apps []*models.AppModel
+  err := queries.WithContext( ctx ).Find( &apps, []interface{}{"mount_point = ? AND archived_at IS NULL", mountPoint}, "", 1, 0, false )
+  if err != nil {
+    return nil, err
+  }
+  if len(apps) == 0 {
+    return nil, &queries.NotFoundError{ Model: "app", Key: mountPoint }
+  }
+  return apps[0], nil
+}
+
+func (store *databaseStore) UserById( ctx context.Context, id uint ) (*models.UserModel, error) {
+  var user models.UserModel
+  err := queries.WithContext( ctx ).Get( &user, id, false )
+  if err != nil {
+    return nil, err
+  }
+  return &user, nil
+}
+
+func (store *databaseStore) RolesOfUserInApp( ctx context.Context, userId uint, appId uint ) ([]*models.RoleModel, error) {
+  return queries.WithContext( ctx ).RolesOfUserInApp( userId, appId )
+}
+
+// the instance is looked up on every call, since it
+// is initialised after the stores are created
+func (store *cyphernodeKeysStore) CheckSignature( keyLabel string, signed string, expected string ) bool {
+  return cyphernodeKeys.Instance().CheckSignature( keyLabel, signed, expected )
+}
+
+func (store *cyphernodeKeysStore) ActionAllowed( keyLabel string, method string, uri string ) bool {
+  return cyphernodeKeys.Instance().ActionAllowed( keyLabel, method, uri )
+}
+
+func (store *cyphernodeKeysStore) ActionAllowedForGroups( groups []string, method string, uri string ) bool {
+  return cyphernodeKeys.Instance().ActionAllowedForGroups( groups, method, uri )
+}
+
+func (store *cyphernodeKeysStore) BearerFromKey( keyLabel string ) (string, error) {
+  return cyphernodeKeys.Instance().BearerFromKey( keyLabel )
+}
@@ -0,0 +1,199 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package stores
+
+import (
+  "context"
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "fmt"
+  "github.com/schulterklopfer/cyphernode_fauth/cyphernodeKeys"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "strings"
+  "sync"
+)
+
+// Memory keeps apps, users and their roles in maps. It is
+// meant for tests, which don't want to start a database
+type Memory struct {
+  apps  map[uint]*models.AppModel
+  users map[uint]*models.UserModel
+  mutex sync.RWMutex
+}
+
+// MemoryKeys keeps gatekeeper keys and action rules as lines of the
+// cyphernode config files and parses them with cyphernodeKeys, so
+// actions are matched exactly like they are in production
+type MemoryKeys struct {
+  keyLines    []string
+  actionLines []string
+  keys        *cyphernodeKeys.CyphernodeKeys
+  mutex       sync.RWMutex
+}
+
+func NewMemory() *Memory {
+  return &Memory{
+    apps:  make( map[uint]*models.AppModel ),
+    users: make( map[uint]*models.UserModel ),
+  }
+}
+
+func NewMemoryKeys() *MemoryKeys {
+  keys := &MemoryKeys{}
+  keys.parse()
+  return keys
+}
+
+// InMemory returns stores backed by memory and keys
+func InMemory( memory *Memory, keys *MemoryKeys ) *Stores {
+  return &Stores{
+    Apps:  memory,
+    Users: memory,
+    Roles: memory,
+    Keys:  keys,
+  }
+}
+
+// AddApp stores app by its ID, which has to be set
+func (memory *Memory) AddApp( app *models.AppModel ) {
+  memory.mutex.Lock()
+  defer memory.mutex.Unlock()
+  memory.apps[app.ID] = app
+}
+
+// AddUser stores user with its roles by its ID, which has to be set
+func (memory *Memory) AddUser( user *models.UserModel ) {
+  memory.mutex.Lock()
+  defer memory.mutex.Unlock()
+  memory.users[user.ID] = user
+}
+
+func (memory *Memory) AppById( ctx context.Context, id uint ) (*models.AppModel, error) {
+  memory.mutex.RLock()
+  defer memory.mutex.RUnlock()
+  if app, exists := memory.apps[id]; exists {
+    return app, nil
+  }
+  return nil, &queries.NotFoundError{ Model: "app", Key: id }
+}
+
+func (memory *Memory) AppByMountPoint( ctx context.Context, mountPoint string ) (*models.AppModel, error) {
+  memory.mutex.RLock()
+  defer memory.mutex.RUnlock()
+  for _, app := range memory.apps {
+    if app.MountPoint == mountPoint && !app.IsArchived() {
+      return app, nil
+    }
+  }
+  return nil, &queries.NotFoundError{ Model: "app", Key: mountPoint }
+}
+
+func (memory *Memory) UserById( ctx context.Context, id uint ) (*models.UserModel, error) {
+  memory.mutex.RLock()
+  defer memory.mutex.RUnlock()
+  if user, exists := memory.users[id]; exists {
+    return user, nil
+  }
+  return nil, &queries.NotFoundError{ Model: "user", Key: id }
+}
+
+func (memory *Memory) RolesOfUserInApp( ctx context.Context, userId uint, appId uint ) ([]*models.RoleModel, error) {
+  memory.mutex.RLock()
+  defer memory.mutex.RUnlock()
+  if user, exists := memory.users[userId]; exists {
+    return rolesInApp( user.Roles, appId ), nil
+  }
+  return []*models.RoleModel{}, nil
+}
+
+// AddKey stores the hex key of keyLabel and the groups it belongs to
+func (keys *MemoryKeys) AddKey( keyLabel string, keyHex string, groups ...string ) {
+  keys.mutex.Lock()
+  defer keys.mutex.Unlock()
+  keys.keyLines = append( keys.keyLines, fmt.Sprintf( "kapi_id=\"%s\";kapi_key=\"%s\";kapi_groups=\"%s\"", keyLabel, keyHex, strings.Join( groups, "," ) ) )
+  keys.parse()
+}
+
+// AddAction lets group call action, which is a pattern like the
+// ones in api.properties, e.g. GET:wallet/*/info
+func (keys *MemoryKeys) AddAction( action string, group string ) {
+  keys.mutex.Lock()
+  defer keys.mutex.Unlock()
+  keys.actionLines = append( keys.actionLines, "action_"+action+"="+group )
+  keys.parse()
+}
+
+// lines are valid by construction, so parsing never fails
+func (keys *MemoryKeys) parse() {
+  keys.keys, _ = cyphernodeKeys.New(
+    strings.NewReader( strings.Join( keys.keyLines, "\n" ) ),
+    strings.NewReader( strings.Join( keys.actionLines, "\n" ) ),
+  )
+}
+
+// Sign signs like the gatekeeper does, so tests can create tokens
+func (keys *MemoryKeys) Sign( keyLabel string, toSign string ) string {
+  keys.mutex.RLock()
+  defer keys.mutex.RUnlock()
+  h := hmac.New( sha256.New, []byte(keys.keys.KeyForLabel( keyLabel )) )
+  h.Write([]byte(toSign))
+  return hex.EncodeToString(h.Sum(nil))
+}
+
+func (keys *MemoryKeys) CheckSignature( keyLabel string, signed string, expected string ) bool {
+  keys.mutex.RLock()
+  defer keys.mutex.RUnlock()
+  return keys.keys.CheckSignature( keyLabel, signed, expected )
+}
+
+func (keys *MemoryKeys) ActionAllowed( keyLabel string, method string, uri string ) bool {
+  keys.mutex.RLock()
+  defer keys.mutex.RUnlock()
+  return keys.keys.ActionAllowed( keyLabel, method, uri )
+}
+
+func (keys *MemoryKeys) ActionAllowedForGroups( groups []string, method string, uri string ) bool {
+  keys.mutex.RLock()
+  defer keys.mutex.RUnlock()
+  return keys.keys.ActionAllowedForGroups( groups, method, uri )
+}
+
+func (keys *MemoryKeys) BearerFromKey( keyLabel string ) (string, error) {
+  keys.mutex.RLock()
+  defer keys.mutex.RUnlock()
+  return keys.keys.BearerFromKey( keyLabel )
+}
+
+func rolesInApp( roles []*models.RoleModel, appId uint ) []*models.RoleModel {
+  inApp := make( []*models.RoleModel, 0 )
+  for _, role := range roles {
+    if role.AppId == appId {
+      inApp = append( inApp, role )
+    }
+  }
+  return inApp
+}
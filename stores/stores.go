@@ -0,0 +1,79 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package stores
+
+import (
+  "context"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+)
+
+// AppStore looks up the apps requests are authorized against.
+// Their roles are not loaded
+type AppStore interface {
+  AppById( ctx context.Context, id uint ) (*models.AppModel, error)
+  // AppByMountPoint never returns archived apps
+  AppByMountPoint( ctx context.Context, mountPoint string ) (*models.AppModel, error)
+}
+
+// UserStore looks up the users of session tokens
+type UserStore interface {
+  UserById( ctx context.Context, id uint ) (*models.UserModel, error)
+}
+
+// RoleStore looks up the roles a user has in an app. Unknown
+// users have no roles
+type RoleStore interface {
+  RolesOfUserInApp( ctx context.Context, userId uint, appId uint ) ([]*models.RoleModel, error)
+}
+
+// KeyStore checks gatekeeper keys and the actions their groups
+// are allowed to call, and mints bearers with them
+type KeyStore interface {
+  CheckSignature( keyLabel string, signed string, expected string ) bool
+  ActionAllowed( keyLabel string, method string, uri string ) bool
+  ActionAllowedForGroups( groups []string, method string, uri string ) bool
+  // BearerFromKey creates a short lived gatekeeper bearer signed
+  // with the key of keyLabel
+  BearerFromKey( keyLabel string ) (string, error)
+}
+
+// Stores bundles everything forward auth needs to decide
+// about a request. Lookups which find nothing return an
+// error matching globals.ErrNotFound
+type Stores struct {
+  Apps  AppStore
+  Users UserStore
+  Roles RoleStore
+  Keys  KeyStore
+}
+
+// RoleNames returns the names of roles
+func RoleNames( roles []*models.RoleModel ) []string {
+  names := make( []string, 0, len(roles) )
+  for _, role := range roles {
+    names = append( names, role.Name )
+  }
+  return names
+}
@@ -0,0 +1,253 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package stores_test
+
+import (
+  "context"
+  "errors"
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/hex"
+  "github.com/schulterklopfer/cyphernode_fauth/cyphernodeKeys"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/schulterklopfer/cyphernode_fauth/stores"
+  "github.com/sirupsen/logrus"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+// fixture is what every store implementation is filled with
+type fixture struct {
+  shop  *models.AppModel
+  blog  *models.AppModel
+  alice *models.UserModel
+  bob   *models.UserModel
+}
+
+// createFixture stores the fixture in the database. shop is installed,
+// blog archived. alice is a customer of shop and a reader of blog
+func createFixture( t *testing.T ) *fixture {
+  f := &fixture{
+    shop: &models.AppModel{ Name: "shop", Hash: "shopHash", Secret: "shopSecret", MountPoint: "shop",
+      AvailableRoles: []*models.RoleModel{ { Name: "customer" }, { Name: "cashier" } } },
+    blog: &models.AppModel{ Name: "blog", Hash: "blogHash", Secret: "blogSecret", MountPoint: "blog",
+      AvailableRoles: []*models.RoleModel{ { Name: "reader" } } },
+  }
+  // the admin app comes first, it can't be archived
+  adminApp := &models.AppModel{ Name: "admin", Hash: "adminHash", Secret: "adminSecret", MountPoint: globals.BASE_ADMIN_MOUNTPOINT }
+  for _, app := range []*models.AppModel{ adminApp, f.shop, f.blog } {
+    if err := queries.CreateApp( app ); err != nil {
+      t.Fatal( err )
+    }
+  }
+  if err := queries.ArchiveApp( f.blog ); err != nil {
+    t.Fatal( err )
+  }
+
+  f.alice = &models.UserModel{ Login: "alice", Password: "hash",
+    Roles: []*models.RoleModel{ f.shop.AvailableRoles[0], f.blog.AvailableRoles[0] } }
+  f.bob = &models.UserModel{ Login: "bob", Password: "hash" }
+  for _, user := range []*models.UserModel{ f.alice, f.bob } {
+    if err := queries.CreateUser( user ); err != nil {
+      t.Fatal( err )
+    }
+  }
+  return f
+}
+
+// gatekeeper keys and actions of the fixture
+const testKeys = `kapi_id="000";kapi_key="aaaa";kapi_groups="stats";eval ugroups_${kapi_id}=${kapi_groups};eval ukey_${kapi_id}=${kapi_key}
+kapi_id="003";kapi_key="cccc";kapi_groups="stats,spender";eval ugroups_${kapi_id}=${kapi_groups};eval ukey_${kapi_id}=${kapi_key}
+`
+
+const testActions = `action_getinfo=stats
+action_GET:wallet/*/info=stats
+action_wallet/**=spender
+`
+
+// createKeyFiles initialises the cyphernode keys instance
+func createKeyFiles( t *testing.T, dir string ) {
+  keysFile := filepath.Join( dir, "keys.properties" )
+  actionsFile := filepath.Join( dir, "api.properties" )
+  _ = ioutil.WriteFile( keysFile, []byte(testKeys), 0644 )
+  _ = ioutil.WriteFile( actionsFile, []byte(testActions), 0644 )
+  err := cyphernodeKeys.Init( keysFile, actionsFile )
+  if err != nil {
+    t.Fatal( err )
+  }
+}
+
+func (f *fixture) memory() *stores.Stores {
+  memory := stores.NewMemory()
+  memory.AddApp( f.shop )
+  memory.AddApp( f.blog )
+  memory.AddUser( f.alice )
+  memory.AddUser( f.bob )
+  keys := stores.NewMemoryKeys()
+  keys.AddKey( "000", "aaaa", "stats" )
+  keys.AddKey( "003", "cccc", "stats", "spender" )
+  keys.AddAction( "getinfo", "stats" )
+  keys.AddAction( "GET:wallet/*/info", "stats" )
+  keys.AddAction( "wallet/**", "spender" )
+  return stores.InMemory( memory, keys )
+}
+
+func TestStores(t *testing.T) {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "stores" )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer os.RemoveAll( dir )
+
+  err = dataSource.Init( "sqlite://"+filepath.Join( dir, "test.sqlite3" ) )
+  if err != nil {
+    t.Fatal( err )
+  }
+  defer dataSource.Close()
+
+  f := createFixture( t )
+  createKeyFiles( t, dir )
+
+  t.Run( "Database", func(t *testing.T) { testContract( t, stores.Database(), f ) } )
+  t.Run( "Memory", func(t *testing.T) { testContract( t, f.memory(), f ) } )
+}
+
+// testContract checks what forward auth relies on, see Stores
+func testContract( t *testing.T, s *stores.Stores, f *fixture ) {
+  ctx := context.Background()
+
+  app, err := s.Apps.AppById( ctx, f.shop.ID )
+  if err != nil || app.MountPoint != "shop" || app.Secret != "shopSecret" {
+    t.Errorf( "expected shop, got %v %v", app, err )
+  }
+  app, err = s.Apps.AppById( ctx, f.blog.ID )
+  if err != nil || !app.IsArchived() {
+    t.Errorf( "expected archived apps to be found by id, got %v %v", app, err )
+  }
+  if _, err := s.Apps.AppById( ctx, 99 ); !errors.Is( err, globals.ErrNotFound ) {
+    t.Errorf( "expected unknown apps not to be found, got %v", err )
+  }
+
+  app, err = s.Apps.AppByMountPoint( ctx, "shop" )
+  if err != nil || app.ID != f.shop.ID {
+    t.Errorf( "expected shop, got %v %v", app, err )
+  }
+  for _, mountPoint := range []string{ "blog", "wiki" } {
+    if _, err := s.Apps.AppByMountPoint( ctx, mountPoint ); !errors.Is( err, globals.ErrNotFound ) {
+      t.Errorf( "expected %s not to be found, got %v", mountPoint, err )
+    }
+  }
+
+  user, err := s.Users.UserById( ctx, f.alice.ID )
+  if err != nil || user.Login != "alice" {
+    t.Errorf( "expected alice, got %v %v", user, err )
+  }
+  if _, err := s.Users.UserById( ctx, 99 ); !errors.Is( err, globals.ErrNotFound ) {
+    t.Errorf( "expected unknown users not to be found, got %v", err )
+  }
+
+  cases := []struct {
+    name   string
+    userId uint
+    appId  uint
+    roles  []string
+  }{
+    {"alice in shop", f.alice.ID, f.shop.ID, []string{ "customer" }},
+    {"alice in blog", f.alice.ID, f.blog.ID, []string{ "reader" }},
+    {"bob in shop", f.bob.ID, f.shop.ID, []string{}},
+    {"unknown user", 99, f.shop.ID, []string{}},
+    {"unknown app", f.alice.ID, 99, []string{}},
+  }
+
+  for _, testCase := range cases {
+    roles, err := s.Roles.RolesOfUserInApp( ctx, testCase.userId, testCase.appId )
+    if err != nil || roles == nil {
+      t.Errorf( "%s: expected roles, got %v %v", testCase.name, roles, err )
+      continue
+    }
+    names := stores.RoleNames( roles )
+    if len(names) != len(testCase.roles) || ( len(names) > 0 && names[0] != testCase.roles[0] ) {
+      t.Errorf( "%s: expected %v, got %v", testCase.name, testCase.roles, names )
+    }
+  }
+
+  sign := func( keyHex string, toSign string ) string {
+    h := hmac.New( sha256.New, []byte(keyHex) )
+    h.Write( []byte(toSign) )
+    return hex.EncodeToString( h.Sum(nil) )
+  }
+  if !s.Keys.CheckSignature( "000", "header.payload", sign( "aaaa", "header.payload" ) ) {
+    t.Error( "expected signatures with the key of the label to be valid" )
+  }
+  if s.Keys.CheckSignature( "000", "header.payload", sign( "cccc", "header.payload" ) ) ||
+    s.Keys.CheckSignature( "999", "header.payload", sign( "", "header.payload" ) ) {
+    t.Error( "expected signatures with other or unknown keys to be invalid" )
+  }
+
+  bearer, err := s.Keys.BearerFromKey( "000" )
+  parts := strings.Split( strings.TrimPrefix( bearer, "Bearer " ), "." )
+  if err != nil || len(parts) != 3 || !s.Keys.CheckSignature( "000", parts[0]+"."+parts[1], parts[2] ) {
+    t.Errorf( "expected a bearer signed with the key of the label, got %s %v", bearer, err )
+  }
+  if _, err := s.Keys.BearerFromKey( "999" ); err == nil {
+    t.Error( "expected no bearer for unknown keys" )
+  }
+
+  actions := []struct {
+    keyLabel string
+    method   string
+    uri      string
+    allowed  bool
+  }{
+    {"000", "GET", "/getinfo", true},
+    {"000", "GET", "/getinfo/?verbose=1", true},
+    {"000", "GET", "/wallet/main/info", true},
+    {"000", "POST", "/wallet/main/info", false},
+    {"000", "GET", "/wallet/main/spend", false},
+    {"003", "POST", "/wallet/main/spend", true},
+    {"003", "GET", "/unknown", false},
+    {"999", "GET", "/getinfo", false},
+  }
+
+  for _, action := range actions {
+    if s.Keys.ActionAllowed( action.keyLabel, action.method, action.uri ) != action.allowed {
+      t.Errorf( "%s %s %s: expected allowed to be %v", action.keyLabel, action.method, action.uri, action.allowed )
+    }
+  }
+
+  if !s.Keys.ActionAllowedForGroups( []string{ "spender" }, "DELETE", "/wallet/main" ) ||
+    s.Keys.ActionAllowedForGroups( []string{ "stats" }, "DELETE", "/wallet/main" ) ||
+    s.Keys.ActionAllowedForGroups( nil, "GET", "/getinfo" ) {
+    t.Error( "expected actions to be allowed for their groups only" )
+  }
+}
@@ -0,0 +1,221 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package test_helpers
+
+import (
+  "bytes"
+  "crypto/ecdsa"
+  "crypto/elliptic"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/binary"
+  "encoding/json"
+  "errors"
+  "github.com/schulterklopfer/cyphernode_fauth/webauthn"
+  "github.com/ugorji/go/codec"
+)
+
+var ErrNoCredential = errors.New( "authenticator has no matching credential" )
+
+// SoftwareAuthenticator is a FIDO2 authenticator with ES256 keys in
+// memory, which answers ceremonies like a browser on Origin would.
+// Every credential is a passkey
+type SoftwareAuthenticator struct {
+  Origin       string
+  // sets the user verified flag
+  UserVerified bool
+  credentials  []*softwareCredential
+}
+
+type softwareCredential struct {
+  id         []byte
+  rpId       string
+  userHandle []byte
+  key        *ecdsa.PrivateKey
+  signCount  uint32
+}
+
+func NewSoftwareAuthenticator( origin string ) *SoftwareAuthenticator {
+  return &SoftwareAuthenticator{ Origin: origin, UserVerified: true }
+}
+
+// Clone returns an authenticator with copies of the credentials, as
+// if their keys were extracted
+func (authenticator *SoftwareAuthenticator) Clone() *SoftwareAuthenticator {
+  clone := *authenticator
+  clone.credentials = make( []*softwareCredential, len(authenticator.credentials) )
+  for i, credential := range authenticator.credentials {
+    copied := *credential
+    clone.credentials[i] = &copied
+  }
+  return &clone
+}
+
+// Create answers a registration ceremony like
+// navigator.credentials.create with a new credential and
+// attestation none
+func (authenticator *SoftwareAuthenticator) Create( options *webauthn.CreationOptions ) (*webauthn.RegistrationResponse, error) {
+  for _, excluded := range options.ExcludeCredentials {
+    if authenticator.find( options.Rp.Id, excluded.Id ) != nil {
+      return nil, errors.New( "credential already registered" )
+    }
+  }
+
+  key, err := ecdsa.GenerateKey( elliptic.P256(), rand.Reader )
+  if err != nil {
+    return nil, err
+  }
+  credential := &softwareCredential{
+    id:         make( []byte, 16 ),
+    rpId:       options.Rp.Id,
+    userHandle: options.User.Id,
+    key:        key,
+  }
+  _, err = rand.Read( credential.id )
+  if err != nil {
+    return nil, err
+  }
+
+  var publicKey []byte
+  err = codec.NewEncoderBytes( &publicKey, &codec.CborHandle{} ).Encode( map[int]interface{}{
+    1: 2, 3: webauthn.ALG_ES256, -1: 1,
+    -2: padded( key.X.Bytes() ), -3: padded( key.Y.Bytes() ),
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  attestedCredential := make( []byte, 18 )
+  binary.BigEndian.PutUint16( attestedCredential[16:], uint16(len(credential.id)) )
+  attestedCredential = append( append( attestedCredential, credential.id... ), publicKey... )
+  authData := authenticator.authData( credential, webauthn.FLAG_ATTESTED_CREDENTIAL_DATA, attestedCredential )
+
+  var attestationObject []byte
+  err = codec.NewEncoderBytes( &attestationObject, &codec.CborHandle{} ).Encode( map[string]interface{}{
+    "fmt": "none", "attStmt": map[string]interface{}{}, "authData": authData,
+  })
+  if err != nil {
+    return nil, err
+  }
+
+  clientDataJSON, err := authenticator.clientData( webauthn.CLIENT_DATA_TYPE_CREATE, options.Challenge )
+  if err != nil {
+    return nil, err
+  }
+
+  authenticator.credentials = append( authenticator.credentials, credential )
+
+  return &webauthn.RegistrationResponse{
+    Id:    base64.RawURLEncoding.EncodeToString( credential.id ),
+    RawId: credential.id,
+    Type:  webauthn.PUBLIC_KEY_CREDENTIAL_TYPE,
+    Response: webauthn.AuthenticatorAttestationResponse{
+      ClientDataJSON:    clientDataJSON,
+      AttestationObject: attestationObject,
+    },
+  }, nil
+}
+
+// Get answers an authentication ceremony like
+// navigator.credentials.get with the first allowed credential or,
+// if none are listed, the first passkey of the relying party
+func (authenticator *SoftwareAuthenticator) Get( options *webauthn.RequestOptions ) (*webauthn.AuthenticationResponse, error) {
+  var credential *softwareCredential
+  if len(options.AllowCredentials) == 0 {
+    credential = authenticator.find( options.RpId, nil )
+  }
+  for _, allowed := range options.AllowCredentials {
+    if credential = authenticator.find( options.RpId, allowed.Id ); credential != nil {
+      break
+    }
+  }
+  if credential == nil {
+    return nil, ErrNoCredential
+  }
+
+  credential.signCount++
+  authData := authenticator.authData( credential, 0, nil )
+
+  clientDataJSON, err := authenticator.clientData( webauthn.CLIENT_DATA_TYPE_GET, options.Challenge )
+  if err != nil {
+    return nil, err
+  }
+
+  clientDataHash := sha256.Sum256( clientDataJSON )
+  digest := sha256.Sum256( append( append( []byte{}, authData... ), clientDataHash[:]... ) )
+  signature, err := ecdsa.SignASN1( rand.Reader, credential.key, digest[:] )
+  if err != nil {
+    return nil, err
+  }
+
+  return &webauthn.AuthenticationResponse{
+    Id:    base64.RawURLEncoding.EncodeToString( credential.id ),
+    RawId: credential.id,
+    Type:  webauthn.PUBLIC_KEY_CREDENTIAL_TYPE,
+    Response: webauthn.AuthenticatorAssertionResponse{
+      ClientDataJSON:    clientDataJSON,
+      AuthenticatorData: authData,
+      Signature:         signature,
+      UserHandle:        credential.userHandle,
+    },
+  }, nil
+}
+
+// find returns the credential with id for rpId, or the first one
+// for rpId without id
+func (authenticator *SoftwareAuthenticator) find( rpId string, id []byte ) *softwareCredential {
+  for _, credential := range authenticator.credentials {
+    if credential.rpId == rpId && ( id == nil || bytes.Equal( credential.id, id ) ) {
+      return credential
+    }
+  }
+  return nil
+}
+
+func (authenticator *SoftwareAuthenticator) authData( credential *softwareCredential, flags byte, attestedCredential []byte ) []byte {
+  rpIdHash := sha256.Sum256( []byte(credential.rpId) )
+  flags |= webauthn.FLAG_USER_PRESENT
+  if authenticator.UserVerified {
+    flags |= webauthn.FLAG_USER_VERIFIED
+  }
+  authData := append( rpIdHash[:], flags, 0, 0, 0, 0 )
+  binary.BigEndian.PutUint32( authData[33:], credential.signCount )
+  return append( authData, attestedCredential... )
+}
+
+func (authenticator *SoftwareAuthenticator) clientData( clientDataType string, challenge []byte ) ([]byte, error) {
+  return json.Marshal( map[string]interface{}{
+    "type":        clientDataType,
+    "challenge":   base64.RawURLEncoding.EncodeToString( challenge ),
+    "origin":      authenticator.Origin,
+    "crossOrigin": false,
+  })
+}
+
+// padded left pads a coordinate to 32 bytes
+func padded( coordinate []byte ) []byte {
+  return append( make( []byte, 32-len(coordinate) ), coordinate... )
+}
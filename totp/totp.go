@@ -0,0 +1,129 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package totp implements time based one time passwords as of
+// RFC 6238 with the defaults authenticator apps expect: HMAC-SHA1,
+// six digits and a period of 30 seconds
+package totp
+
+import (
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/sha1"
+  "crypto/subtle"
+  "encoding/base32"
+  "encoding/binary"
+  "fmt"
+  "net/url"
+  "strings"
+  "time"
+)
+
+const DIGITS = 6
+const PERIOD = 30
+const SECRET_LENGTH = 20
+
+// 10^DIGITS
+const modulus = 1000000
+
+// codes of the steps before and after the current one are accepted
+// as well, since clocks drift
+const SKEW = 1
+
+var encoding = base32.StdEncoding.WithPadding( base32.NoPadding )
+
+// GenerateSecret returns a new random secret in base32
+func GenerateSecret() (string, error) {
+  secret := make( []byte, SECRET_LENGTH )
+  _, err := rand.Read( secret )
+  if err != nil {
+    return "", err
+  }
+  return encoding.EncodeToString( secret ), nil
+}
+
+// Step returns the time step of t
+func Step( t time.Time ) int64 {
+  return t.Unix() / PERIOD
+}
+
+// Code returns the code of secret for the time step
+func Code( secret string, step int64 ) (string, error) {
+  key, err := encoding.DecodeString( strings.ToUpper( strings.TrimSpace( secret ) ) )
+  if err != nil {
+    return "", err
+  }
+
+  message := make( []byte, 8 )
+  binary.BigEndian.PutUint64( message, uint64(step) )
+
+  mac := hmac.New( sha1.New, key )
+  mac.Write( message )
+  sum := mac.Sum( nil )
+
+  // dynamic truncation, see RFC 4226 section 5.3
+  offset := sum[len(sum)-1] & 0x0f
+  value := binary.BigEndian.Uint32( sum[offset:offset+4] ) & 0x7fffffff
+
+  return fmt.Sprintf( "%0*d", DIGITS, value % modulus ), nil
+}
+
+// Validate checks code against secret at t. Codes of steps up to
+// lastStep were used before and are refused. The step of the
+// accepted code is returned, so it can be stored as the new lastStep
+func Validate( secret string, code string, t time.Time, lastStep int64 ) (int64, bool) {
+  code = strings.ReplaceAll( code, " ", "" )
+  if len(code) != DIGITS {
+    return 0, false
+  }
+
+  current := Step( t )
+  for step := current-SKEW; step <= current+SKEW; step++ {
+    if step <= lastStep {
+      continue
+    }
+    expected, err := Code( secret, step )
+    if err != nil {
+      return 0, false
+    }
+    if subtle.ConstantTimeCompare( []byte(expected), []byte(code) ) == 1 {
+      return step, true
+    }
+  }
+  return 0, false
+}
+
+// ProvisioningURI returns the otpauth uri of secret, which is shown
+// as qr code to be scanned by authenticator apps
+func ProvisioningURI( issuer string, account string, secret string ) string {
+  query := url.Values{}
+  query.Set( "secret", secret )
+  query.Set( "issuer", issuer )
+  query.Set( "algorithm", "SHA1" )
+  query.Set( "digits", fmt.Sprintf( "%d", DIGITS ) )
+  query.Set( "period", fmt.Sprintf( "%d", PERIOD ) )
+
+  label := url.PathEscape( issuer+":"+account )
+  return "otpauth://totp/"+label+"?"+query.Encode()
+}
@@ -0,0 +1,90 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package totp_test
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/totp"
+  "strings"
+  "testing"
+  "time"
+)
+
+// base32 of the RFC 6238 test secret 12345678901234567890
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+  // the last six digits of the sha1 test vectors of RFC 6238
+  vectors := map[int64]string{
+    59:         "287082",
+    1111111109: "081804",
+    1111111111: "050471",
+    1234567890: "005924",
+    2000000000: "279037",
+  }
+
+  for unix, expected := range vectors {
+    code, err := totp.Code( rfcSecret, totp.Step( time.Unix( unix, 0 ) ) )
+    if err != nil || code != expected {
+      t.Errorf( "expected %s at %d, got %s %v", expected, unix, code, err )
+    }
+  }
+}
+
+func TestValidate(t *testing.T) {
+  now := time.Unix( 1234567890, 0 )
+  step := totp.Step( now )
+
+  previous, _ := totp.Code( rfcSecret, step-1 )
+  current, _ := totp.Code( rfcSecret, step )
+  tooOld, _ := totp.Code( rfcSecret, step-2 )
+
+  if usedStep, ok := totp.Validate( rfcSecret, current, now, 0 ); !ok || usedStep != step {
+    t.Error( "current code must be valid" )
+  }
+  if _, ok := totp.Validate( rfcSecret, previous[:3]+" "+previous[3:], now, 0 ); !ok {
+    t.Error( "code of the previous step must be valid" )
+  }
+  if _, ok := totp.Validate( rfcSecret, tooOld, now, 0 ); ok {
+    t.Error( "code two steps ago must be invalid" )
+  }
+  if _, ok := totp.Validate( rfcSecret, current, now, step ); ok {
+    t.Error( "used code must not be valid again" )
+  }
+  if _, ok := totp.Validate( rfcSecret, "12345", now, 0 ); ok {
+    t.Error( "short code must be invalid" )
+  }
+}
+
+func TestProvisioningURI(t *testing.T) {
+  secret, err := totp.GenerateSecret()
+  if err != nil || len(secret) != 32 {
+    t.Fatalf( "expected a secret of 32 base32 characters, got %s %v", secret, err )
+  }
+
+  uri := totp.ProvisioningURI( "Cyphernode", "alice", secret )
+  if !strings.HasPrefix( uri, "otpauth://totp/Cyphernode:alice?" ) || !strings.Contains( uri, "secret="+secret ) || !strings.Contains( uri, "issuer=Cyphernode" ) {
+    t.Errorf( "unexpected provisioning uri %s", uri )
+  }
+}
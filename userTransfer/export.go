@@ -0,0 +1,79 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package userTransfer
+
+import (
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "sort"
+)
+
+// Export returns records of all users, sorted by login. Password
+// hashes are left out unless withPasswordHashes is set
+func Export( withPasswordHashes bool ) ([]*Record, error) {
+  var users []*models.UserModel
+  err := queries.Find( &users, nil, "login", -1, 0, true )
+  if err != nil {
+    return nil, err
+  }
+
+  var apps []*models.AppModel
+  err = queries.Find( &apps, nil, "", -1, 0, false )
+  if err != nil {
+    return nil, err
+  }
+
+  mountPoints := make( map[uint]string )
+  for _, app := range apps {
+    mountPoints[app.ID] = app.MountPoint
+  }
+
+  records := make( []*Record, 0, len(users) )
+  for _, user := range users {
+    record := &Record{
+      Login:        user.Login,
+      Name:         user.Name,
+      EmailAddress: user.EmailAddress,
+      Roles:        roleLabels( user.Roles, mountPoints ),
+    }
+    if withPasswordHashes {
+      record.PasswordHash = user.Password
+    }
+    records = append( records, record )
+  }
+  return records, nil
+}
+
+// roleLabels returns the sorted labels of roles
+func roleLabels( roles []*models.RoleModel, mountPoints map[uint]string ) []string {
+  labels := make( []string, 0, len(roles) )
+  for _, role := range roles {
+    if mountPoint, ok := mountPoints[role.AppId]; ok {
+      labels = append( labels, models.RoleLabel( mountPoint, role.Name ) )
+    }
+  }
+  sort.Strings( labels )
+  return labels
+}
@@ -0,0 +1,291 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package userTransfer
+
+import (
+  "errors"
+  "fmt"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/password"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "golang.org/x/crypto/bcrypt"
+)
+
+// ImportReport lists the logins of the users an import created,
+// updated or left as they were and the problems of invalid records
+type ImportReport struct {
+  Created   []string       `json:"created"`
+  Updated   []string       `json:"updated"`
+  Unchanged []string       `json:"unchanged"`
+  Errors    []*RecordError `json:"errors"`
+  DryRun    bool           `json:"dryRun"`
+}
+
+type RecordError struct {
+  Line  int    `json:"line"`
+  Login string `json:"login"`
+  Error string `json:"error"`
+}
+
+func newImportReport( dryRun bool ) *ImportReport {
+  return &ImportReport{
+    Created:   make( []string, 0 ),
+    Updated:   make( []string, 0 ),
+    Unchanged: make( []string, 0 ),
+    Errors:    make( []*RecordError, 0 ),
+    DryRun:    dryRun,
+  }
+}
+
+func (report *ImportReport) addError( record *Record, err error ) {
+  report.Errors = append( report.Errors, &RecordError{
+    Line:  record.line,
+    Login: record.Login,
+    Error: err.Error(),
+  })
+}
+
+// rolls back dry runs
+var errDryRun = errors.New( "dry run" )
+
+// Import creates users of records which don't exist yet and updates
+// the ones which do, matched by login. Roles are only added, never
+// removed: users keep roles their records don't list, so importing
+// the same records again changes nothing. Revoke roles in the users
+// api instead.
+// Nothing is imported if one of the records is invalid, in which case
+// globals.ErrInvalidImport is returned with the report. A dry run
+// validates and reports like a real import, but changes nothing
+func Import( records []*Record, dryRun bool ) (*ImportReport, error) {
+  report := newImportReport( dryRun )
+
+  err := queries.Transaction( func( q *queries.Queries ) error {
+    rolesByLabel, err := rolesByLabel( q )
+    if err != nil {
+      return err
+    }
+
+    seen := make( map[string]bool )
+
+    for i, record := range records {
+      // records which were not decoded are numbered by position
+      if record.line == 0 {
+        record.line = i+1
+      }
+
+      if seen[record.Login] {
+        report.addError( record, fmt.Errorf( "login %s is imported twice", record.Login ) )
+        continue
+      }
+      seen[record.Login] = true
+
+      // a failed record must not abort the whole transaction
+      err := q.Transaction( func( q *queries.Queries ) error {
+        return importRecord( q, record, rolesByLabel, report )
+      })
+      if err != nil {
+        report.addError( record, err )
+      }
+    }
+
+    if len(report.Errors) > 0 {
+      return globals.ErrInvalidImport
+    }
+    if dryRun {
+      return errDryRun
+    }
+    return nil
+  })
+
+  if err == errDryRun {
+    return report, nil
+  }
+  if err == globals.ErrInvalidImport {
+    return report, err
+  }
+  if err != nil {
+    return nil, err
+  }
+  return report, nil
+}
+
+func importRecord( q *queries.Queries, record *Record, rolesByLabel map[string]*models.RoleModel, report *ImportReport ) error {
+  if record.Password != "" && record.PasswordHash != "" {
+    return errors.New( "password and password hash can't be imported both" )
+  }
+
+  if record.PasswordHash != "" {
+    if _, err := bcrypt.Cost( []byte(record.PasswordHash) ); err != nil {
+      return errors.New( "password hash is no bcrypt hash" )
+    }
+  }
+
+  roles := make( []*models.RoleModel, 0, len(record.Roles) )
+  for _, label := range record.Roles {
+    role, ok := rolesByLabel[label]
+    if !ok {
+      return fmt.Errorf( "unknown role %s", label )
+    }
+    roles = append( roles, role )
+  }
+
+  var users []*models.UserModel
+  err := q.Find( &users, []interface{}{"login = ?", record.Login}, "", 1, 0, true )
+  if err != nil {
+    return err
+  }
+
+  if len(users) == 0 {
+    return createUser( q, record, roles, report )
+  }
+  return updateUser( q, users[0], record, roles, report )
+}
+
+func createUser( q *queries.Queries, record *Record, roles []*models.RoleModel, report *ImportReport ) error {
+  user := &models.UserModel{
+    Login:        record.Login,
+    Name:         record.Name,
+    EmailAddress: record.EmailAddress,
+    Password:     record.PasswordHash,
+    Roles:        roles,
+  }
+
+  if record.Password != "" {
+    hashedPassword, err := hashPassword( record.Login, record.Password )
+    if err != nil {
+      return err
+    }
+    user.Password = hashedPassword
+  }
+
+  if user.Password == "" {
+    return errors.New( "new users need a password or password hash" )
+  }
+
+  err := q.CreateUser( user )
+  if err != nil {
+    return err
+  }
+
+  report.Created = append( report.Created, user.Login )
+  return nil
+}
+
+func updateUser( q *queries.Queries, user *models.UserModel, record *Record, roles []*models.RoleModel, report *ImportReport ) error {
+  changed := false
+
+  if record.Name != "" && record.Name != user.Name {
+    user.Name = record.Name
+    changed = true
+  }
+
+  if record.EmailAddress != "" && record.EmailAddress != user.EmailAddress {
+    user.EmailAddress = record.EmailAddress
+    changed = true
+  }
+
+  if record.PasswordHash != "" && record.PasswordHash != user.Password {
+    user.Password = record.PasswordHash
+    changed = true
+  }
+
+  if record.Password != "" && !password.CheckPasswordHash( record.Password, user.Password ) {
+    hashedPassword, err := hashPassword( record.Login, record.Password )
+    if err != nil {
+      return err
+    }
+    user.Password = hashedPassword
+    changed = true
+  }
+
+  addedRoles := false
+  for _, role := range roles {
+    if hasRole( user, role ) {
+      continue
+    }
+    user.Roles = append( user.Roles, role )
+    addedRoles = true
+  }
+
+  if !changed && !addedRoles {
+    report.Unchanged = append( report.Unchanged, user.Login )
+    return nil
+  }
+
+  err := q.UpdateUser( user )
+  if err != nil {
+    return err
+  }
+
+  if addedRoles {
+    _, err = q.GrantRoles( []*models.UserModel{ user } )
+    if err != nil {
+      return err
+    }
+  }
+
+  report.Updated = append( report.Updated, user.Login )
+  return nil
+}
+
+// Plain text passwords have to comply with the password policy,
+// hashes are taken as they are. Dry runs hash as well, plain text
+// passwords never make it into the database, not even into a
+// transaction which is rolled back
+func hashPassword( login string, plainPassword string ) (string, error) {
+  err := password.Check( login, plainPassword )
+  if err != nil {
+    return "", err
+  }
+  return password.HashPassword( plainPassword )
+}
+
+func hasRole( user *models.UserModel, role *models.RoleModel ) bool {
+  for _, userRole := range user.Roles {
+    if userRole.ID == role.ID {
+      return true
+    }
+  }
+  return false
+}
+
+// rolesByLabel maps the labels of the roles of all apps which are
+// not archived to the roles
+func rolesByLabel( q *queries.Queries ) (map[string]*models.RoleModel, error) {
+  var apps []*models.AppModel
+  err := q.Find( &apps, []interface{}{"archived_at IS NULL"}, "", -1, 0, true )
+  if err != nil {
+    return nil, err
+  }
+
+  roles := make( map[string]*models.RoleModel )
+  for _, app := range apps {
+    for _, role := range app.AvailableRoles {
+      roles[models.RoleLabel( app.MountPoint, role.Name )] = role
+    }
+  }
+  return roles, nil
+}
@@ -0,0 +1,182 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package userTransfer
+
+import (
+  "encoding/csv"
+  "encoding/json"
+  "fmt"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/helpers"
+  "io"
+  "strings"
+)
+
+const FORMAT_CSV = "csv"
+const FORMAT_JSON = "json"
+
+// Record is a user and its roles as labels of the form
+// mountPoint/roleName, see models.RoleLabel. Imports only add
+// Roles, see Import. Password is a plain text password,
+// PasswordHash a bcrypt hash. Only one may be set
+type Record struct {
+  Login        string   `json:"login"`
+  Name         string   `json:"name,omitempty"`
+  EmailAddress string   `json:"emailAddress,omitempty"`
+  Password     string   `json:"password,omitempty"`
+  PasswordHash string   `json:"passwordHash,omitempty"`
+  Roles        []string `json:"roles"`
+
+  // line in the csv file or position in the json list
+  line int
+}
+
+var csvColumns = []string{ "login", "name", "emailAddress", "password", "passwordHash", "roles" }
+
+// Decode reads records in format. Csv files need a header naming
+// their columns. Roles are separated by spaces
+func Decode( reader io.Reader, format string ) ([]*Record, error) {
+  switch format {
+  case FORMAT_JSON:
+    return decodeJson( reader )
+  case FORMAT_CSV:
+    return decodeCsv( reader )
+  }
+  return nil, globals.ErrUnknownFormat
+}
+
+// Encode writes records in format. The password hash column is
+// left out of csv files, if no record has one
+func Encode( writer io.Writer, format string, records []*Record ) error {
+  switch format {
+  case FORMAT_JSON:
+    return json.NewEncoder( writer ).Encode( records )
+  case FORMAT_CSV:
+    return encodeCsv( writer, records )
+  }
+  return globals.ErrUnknownFormat
+}
+
+func decodeJson( reader io.Reader ) ([]*Record, error) {
+  records := make( []*Record, 0 )
+  err := json.NewDecoder( reader ).Decode( &records )
+  if err != nil {
+    return nil, err
+  }
+  for i, record := range records {
+    if record == nil {
+      return nil, fmt.Errorf( "record %d is empty", i+1 )
+    }
+    record.line = i+1
+  }
+  return records, nil
+}
+
+func decodeCsv( reader io.Reader ) ([]*Record, error) {
+  csvReader := csv.NewReader( reader )
+  csvReader.TrimLeadingSpace = true
+
+  header, err := csvReader.Read()
+  if err != nil {
+    return nil, err
+  }
+
+  columns := make( map[string]int )
+  for i, column := range header {
+    column = strings.TrimSpace( column )
+    if helpers.SliceIndex( len(csvColumns), func(i int) bool {
+      return csvColumns[i] == column
+    }) == -1 {
+      return nil, fmt.Errorf( "unknown column %s", column )
+    }
+    columns[column] = i
+  }
+  if _, ok := columns["login"]; !ok {
+    return nil, fmt.Errorf( "no login column" )
+  }
+
+  value := func( row []string, column string ) string {
+    if i, ok := columns[column]; ok {
+      return strings.TrimSpace( row[i] )
+    }
+    return ""
+  }
+
+  records := make( []*Record, 0 )
+  for line := 2; ; line++ {
+    row, err := csvReader.Read()
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      return nil, err
+    }
+    records = append( records, &Record{
+      Login:        value( row, "login" ),
+      Name:         value( row, "name" ),
+      EmailAddress: value( row, "emailAddress" ),
+      Password:     value( row, "password" ),
+      PasswordHash: value( row, "passwordHash" ),
+      Roles:        strings.Fields( value( row, "roles" ) ),
+      line:         line,
+    })
+  }
+  return records, nil
+}
+
+func encodeCsv( writer io.Writer, records []*Record ) error {
+  withPasswordHashes := false
+  for _, record := range records {
+    if record.PasswordHash != "" {
+      withPasswordHashes = true
+      break
+    }
+  }
+
+  header := []string{ "login", "name", "emailAddress", "roles" }
+  if withPasswordHashes {
+    header = []string{ "login", "name", "emailAddress", "passwordHash", "roles" }
+  }
+
+  csvWriter := csv.NewWriter( writer )
+  err := csvWriter.Write( header )
+  if err != nil {
+    return err
+  }
+
+  for _, record := range records {
+    row := []string{ record.Login, record.Name, record.EmailAddress, strings.Join( record.Roles, " " ) }
+    if withPasswordHashes {
+      row = []string{ record.Login, record.Name, record.EmailAddress, record.PasswordHash, strings.Join( record.Roles, " " ) }
+    }
+    err := csvWriter.Write( row )
+    if err != nil {
+      return err
+    }
+  }
+
+  csvWriter.Flush()
+  return csvWriter.Error()
+}
@@ -0,0 +1,265 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package userTransfer_test
+
+import (
+  "bytes"
+  "github.com/schulterklopfer/cyphernode_fauth/dataSource"
+  "github.com/schulterklopfer/cyphernode_fauth/globals"
+  "github.com/schulterklopfer/cyphernode_fauth/logwrapper"
+  "github.com/schulterklopfer/cyphernode_fauth/models"
+  "github.com/schulterklopfer/cyphernode_fauth/queries"
+  "github.com/schulterklopfer/cyphernode_fauth/userTransfer"
+  "github.com/sirupsen/logrus"
+  "golang.org/x/crypto/bcrypt"
+  "gorm.io/gorm"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "reflect"
+  "strings"
+  "testing"
+)
+
+func openDb( t *testing.T ) func() {
+  logwrapper.Logger().SetLevel( logrus.PanicLevel )
+  dir, err := ioutil.TempDir( "", "userTransfer" )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  err = dataSource.Init( "sqlite://"+filepath.Join( dir, "test.sqlite3" ) )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  for _, app := range []*models.AppModel{
+    { Name: "admin", Hash: "adminHash", Secret: "adminSecret", MountPoint: globals.BASE_ADMIN_MOUNTPOINT,
+      AvailableRoles: []*models.RoleModel{ { Name: globals.BASE_ADMIN_ROLE } } },
+    { Name: "app", Hash: "appHash", Secret: "appSecret", MountPoint: "app",
+      AvailableRoles: []*models.RoleModel{ { Name: "reader" }, { Name: "writer" } } },
+  } {
+    if err := queries.CreateApp( app ); err != nil {
+      t.Fatal( err )
+    }
+  }
+
+  return func() {
+    dataSource.Close()
+    os.RemoveAll( dir )
+  }
+}
+
+func userCount( t *testing.T ) int {
+  var users []*models.UserModel
+  if err := queries.Find( &users, nil, "", -1, 0, false ); err != nil {
+    t.Fatal( err )
+  }
+  return len(users)
+}
+
+func TestImport(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  hash, _ := bcrypt.GenerateFromPassword( []byte("secret"), bcrypt.MinCost )
+
+  records, err := userTransfer.Decode( strings.NewReader(
+    "login,name,passwordHash,roles\n"+
+    "alice,Alice,"+string(hash)+",app/reader app/writer\n"+
+    "bob,,"+string(hash)+",app/reader\n" ), userTransfer.FORMAT_CSV )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if len(records) != 2 || records[0].Name != "Alice" || !reflect.DeepEqual( records[0].Roles, []string{ "app/reader", "app/writer" } ) {
+    t.Fatalf( "unexpected records %v", records )
+  }
+
+  report, err := userTransfer.Import( records, true )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if !report.DryRun || len(report.Created) != 2 || userCount( t ) != 0 {
+    t.Errorf( "dry run must report without creating users, got %v", report )
+  }
+
+  report, err = userTransfer.Import( records, false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if !reflect.DeepEqual( report.Created, []string{ "alice", "bob" } ) || userCount( t ) != 2 {
+    t.Errorf( "expected alice and bob to be created, got %v", report )
+  }
+
+  report, err = userTransfer.Import( records, false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if len(report.Unchanged) != 2 || len(report.Created) != 0 || len(report.Updated) != 0 {
+    t.Errorf( "importing again must change nothing, got %v", report )
+  }
+
+  records, err = userTransfer.Decode( strings.NewReader(
+    `[{"login": "bob", "emailAddress": "bob@example.com", "roles": ["app/writer"]}]` ), userTransfer.FORMAT_JSON )
+  if err != nil {
+    t.Fatal( err )
+  }
+  report, err = userTransfer.Import( records, false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if !reflect.DeepEqual( report.Updated, []string{ "bob" } ) {
+    t.Errorf( "expected bob to be updated, got %v", report )
+  }
+
+  // roles are only added. bob keeps app/reader
+
+  exported, err := userTransfer.Export( false )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if len(exported) != 2 || exported[1].Login != "bob" || exported[1].EmailAddress != "bob@example.com" ||
+      !reflect.DeepEqual( exported[1].Roles, []string{ "app/reader", "app/writer" } ) {
+    t.Errorf( "unexpected export %v", exported[1] )
+  }
+  for _, record := range exported {
+    if record.PasswordHash != "" {
+      t.Errorf( "password hash of %s exported without asking", record.Login )
+    }
+  }
+
+  exported, err = userTransfer.Export( true )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if exported[0].PasswordHash != string(hash) {
+    t.Errorf( "expected password hash of alice, got %s", exported[0].PasswordHash )
+  }
+
+  var csv bytes.Buffer
+  err = userTransfer.Encode( &csv, userTransfer.FORMAT_CSV, exported )
+  if err != nil {
+    t.Fatal( err )
+  }
+  records, err = userTransfer.Decode( &csv, userTransfer.FORMAT_CSV )
+  if err != nil {
+    t.Fatal( err )
+  }
+  report, err = userTransfer.Import( records, false )
+  if err != nil || len(report.Unchanged) != 2 {
+    t.Errorf( "importing an export must change nothing, got %v, %v", report, err )
+  }
+}
+
+func TestInvalidImport(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  hash, _ := bcrypt.GenerateFromPassword( []byte("secret"), bcrypt.MinCost )
+
+  records := []*userTransfer.Record{
+    { Login: "valid", PasswordHash: string(hash) },
+    { Login: "unknownRole", PasswordHash: string(hash), Roles: []string{ "app/nope" } },
+    { Login: "both", Password: "secret", PasswordHash: string(hash) },
+    { Login: "noPassword" },
+    { Login: "notBcrypt", PasswordHash: "secret" },
+    { Login: "x", PasswordHash: string(hash) },
+    { Login: "weak", Password: "password1" },
+    { Login: "valid", PasswordHash: string(hash) },
+  }
+
+  report, err := userTransfer.Import( records, false )
+  if err != globals.ErrInvalidImport {
+    t.Fatalf( "expected %v, got %v", globals.ErrInvalidImport, err )
+  }
+  if len(report.Errors) != 7 {
+    t.Errorf( "expected 7 errors, got %d", len(report.Errors) )
+  }
+  for _, recordError := range report.Errors {
+    if recordError.Login == "weak" && recordError.Error != globals.ErrPasswordTooCommon.Error() {
+      t.Errorf( "expected %v for weak, got %s", globals.ErrPasswordTooCommon, recordError.Error )
+    }
+    if recordError.Login == "valid" && recordError.Line != 8 {
+      t.Errorf( "expected the second valid record to fail, got line %d", recordError.Line )
+    }
+  }
+  if userCount( t ) != 0 {
+    t.Error( "nothing must be imported if a record is invalid" )
+  }
+
+  _, err = userTransfer.Decode( strings.NewReader( "login,password,secret\n" ), userTransfer.FORMAT_CSV )
+  if err == nil {
+    t.Error( "unknown csv columns must fail" )
+  }
+
+  _, err = userTransfer.Decode( strings.NewReader( "" ), "xml" )
+  if err != globals.ErrUnknownFormat {
+    t.Errorf( "expected %v, got %v", globals.ErrUnknownFormat, err )
+  }
+}
+
+func TestDryRunHashesPasswords(t *testing.T) {
+  closeDb := openDb( t )
+  defer closeDb()
+
+  hash, _ := bcrypt.GenerateFromPassword( []byte("secret"), bcrypt.MinCost )
+  _, err := userTransfer.Import( []*userTransfer.Record{ { Login: "bob", PasswordHash: string(hash) } }, false )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  // passwords of users written to the database, even if rolled back
+  var written []string
+  recordPassword := func( db *gorm.DB ) {
+    for _, value := range []interface{}{ db.Statement.Dest, db.Statement.Model } {
+      if user, ok := value.( *models.UserModel ); ok && user.Password != "" {
+        written = append( written, user.Password )
+      }
+    }
+  }
+  db := dataSource.GetDB()
+  _ = db.Callback().Create().Before( "gorm:create" ).Register( "test:passwords", recordPassword )
+  _ = db.Callback().Update().Before( "gorm:update" ).Register( "test:passwords", recordPassword )
+
+  plainPassword := "correct horse battery staple"
+  report, err := userTransfer.Import( []*userTransfer.Record{
+    { Login: "alice", Password: plainPassword },
+    { Login: "bob", Password: plainPassword },
+  }, true )
+  if err != nil || len(report.Created) != 1 || len(report.Updated) != 1 {
+    t.Fatalf( "expected alice to be created and bob to be updated, got %v %v", report, err )
+  }
+  if len(written) == 0 {
+    t.Fatal( "expected the dry run to write passwords" )
+  }
+  for _, password := range written {
+    if password == plainPassword {
+      t.Fatal( "dry runs must not write plain text passwords" )
+    }
+  }
+  if userCount( t ) != 1 {
+    t.Error( "dry runs must not create users" )
+  }
+}
@@ -0,0 +1,167 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package webauthn
+
+import (
+  "bytes"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/binary"
+  "encoding/json"
+  "github.com/ugorji/go/codec"
+  "strings"
+)
+
+const FLAG_USER_PRESENT = 0x01
+const FLAG_USER_VERIFIED = 0x04
+const FLAG_ATTESTED_CREDENTIAL_DATA = 0x40
+const FLAG_EXTENSION_DATA = 0x80
+
+// integers are decoded as int64, no matter if they are negative
+var cborHandle = newCborHandle()
+
+func newCborHandle() *codec.CborHandle {
+  handle := &codec.CborHandle{}
+  handle.SignedInteger = true
+  return handle
+}
+
+type clientData struct {
+  Type        string `json:"type"`
+  Challenge   string `json:"challenge"`
+  Origin      string `json:"origin"`
+  CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// attestation statements are not verified, so the format does not
+// matter
+type attestationObject struct {
+  Fmt      string                 `codec:"fmt"`
+  AttStmt  map[string]interface{} `codec:"attStmt"`
+  AuthData []byte                 `codec:"authData"`
+}
+
+// authenticatorData as signed by the authenticator. The credential
+// fields are only set, if the attested credential data flag is
+type authenticatorData struct {
+  raw          []byte
+  rpIdHash     []byte
+  flags        byte
+  signCount    uint32
+  aaguid       []byte
+  credentialId []byte
+  publicKey    []byte
+}
+
+// checkClientData checks the client data of a ceremony was collected
+// for its challenge on a page of the configured origin
+func checkClientData( raw []byte, clientDataType string, challenge []byte, current Config ) error {
+  var data clientData
+  err := json.Unmarshal( raw, &data )
+  if err != nil {
+    return invalid( "client data: %s", err.Error() )
+  }
+  if data.Type != clientDataType {
+    return invalid( "client data type %q", data.Type )
+  }
+  signedChallenge, err := base64.RawURLEncoding.DecodeString( strings.TrimRight( data.Challenge, "=" ) )
+  if err != nil || !bytes.Equal( signedChallenge, challenge ) {
+    return invalid( "challenge does not match" )
+  }
+  if data.Origin != current.Origin {
+    return invalid( "origin %q", data.Origin )
+  }
+  if data.CrossOrigin {
+    return invalid( "cross origin ceremony" )
+  }
+  return nil
+}
+
+func parseAttestationObject( raw []byte ) (*attestationObject, error) {
+  var attestation attestationObject
+  err := codec.NewDecoderBytes( raw, cborHandle ).Decode( &attestation )
+  if err != nil {
+    return nil, invalid( "attestation object: %s", err.Error() )
+  }
+  return &attestation, nil
+}
+
+// parseAuthenticatorData splits raw into its fields. The credential
+// public key has no length prefix, so it ends where its cbor ends
+func parseAuthenticatorData( raw []byte ) (*authenticatorData, error) {
+  if len(raw) < 37 {
+    return nil, invalid( "authenticator data is too short" )
+  }
+
+  data := &authenticatorData{
+    raw:       raw,
+    rpIdHash:  raw[:32],
+    flags:     raw[32],
+    signCount: binary.BigEndian.Uint32( raw[33:37] ),
+  }
+
+  if data.flags & FLAG_ATTESTED_CREDENTIAL_DATA == 0 {
+    return data, nil
+  }
+
+  rest := raw[37:]
+  if len(rest) < 18 {
+    return nil, invalid( "attested credential data is too short" )
+  }
+  data.aaguid = rest[:16]
+  length := int(binary.BigEndian.Uint16( rest[16:18] ))
+  rest = rest[18:]
+  if len(rest) < length {
+    return nil, invalid( "credential id is too short" )
+  }
+  data.credentialId = rest[:length]
+  rest = rest[length:]
+
+  var key interface{}
+  decoder := codec.NewDecoderBytes( rest, cborHandle )
+  err := decoder.Decode( &key )
+  if err != nil {
+    return nil, invalid( "credential public key: %s", err.Error() )
+  }
+  data.publicKey = rest[:decoder.NumBytesRead()]
+
+  return data, nil
+}
+
+// check tells if data was made for the configured relying party
+// with the user present and, if required, verified
+func (data *authenticatorData) check( userVerification string, current Config ) error {
+  rpIdHash := sha256.Sum256( []byte(current.RpId) )
+  if !bytes.Equal( data.rpIdHash, rpIdHash[:] ) {
+    return invalid( "relying party id does not match" )
+  }
+  if data.flags & FLAG_USER_PRESENT == 0 {
+    return invalid( "user was not present" )
+  }
+  if userVerification == USER_VERIFICATION_REQUIRED && data.flags & FLAG_USER_VERIFIED == 0 {
+    return invalid( "user was not verified" )
+  }
+  return nil
+}
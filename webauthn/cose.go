@@ -0,0 +1,135 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package webauthn
+
+import (
+  "crypto"
+  "crypto/ecdsa"
+  "crypto/ed25519"
+  "crypto/elliptic"
+  "crypto/rsa"
+  "crypto/sha256"
+  "encoding/asn1"
+  "github.com/ugorji/go/codec"
+  "math/big"
+)
+
+// labels and values of COSE keys, RFC 8152
+const coseKty = 1
+const coseAlg = 3
+const coseCrv = -1
+const coseX = -2
+const coseY = -3
+const coseN = -1
+const coseE = -2
+
+const coseKtyOkp = 1
+const coseKtyEc2 = 2
+const coseKtyRsa = 3
+
+const coseCrvP256 = 1
+const coseCrvEd25519 = 6
+
+type publicKey struct {
+  alg int64
+  key crypto.PublicKey
+}
+
+// parsePublicKey reads a COSE key of one of the supported algorithms
+func parsePublicKey( raw []byte ) (*publicKey, error) {
+  var params map[int64]interface{}
+  err := codec.NewDecoderBytes( raw, cborHandle ).Decode( &params )
+  if err != nil {
+    return nil, invalid( "credential public key: %s", err.Error() )
+  }
+
+  intParam := func( label int64 ) int64 {
+    value, _ := params[label].(int64)
+    return value
+  }
+  bytesParam := func( label int64 ) []byte {
+    value, _ := params[label].([]byte)
+    return value
+  }
+
+  kty := intParam( coseKty )
+  alg := intParam( coseAlg )
+
+  switch {
+  case kty == coseKtyEc2 && alg == ALG_ES256:
+    x := bytesParam( coseX )
+    y := bytesParam( coseY )
+    if intParam( coseCrv ) != coseCrvP256 || len(x) != 32 || len(y) != 32 {
+      return nil, ErrUnsupportedKey
+    }
+    key := &ecdsa.PublicKey{ Curve: elliptic.P256(), X: new(big.Int).SetBytes( x ), Y: new(big.Int).SetBytes( y ) }
+    if !key.Curve.IsOnCurve( key.X, key.Y ) {
+      return nil, ErrUnsupportedKey
+    }
+    return &publicKey{ alg: alg, key: key }, nil
+
+  case kty == coseKtyOkp && alg == ALG_EDDSA:
+    x := bytesParam( coseX )
+    if intParam( coseCrv ) != coseCrvEd25519 || len(x) != ed25519.PublicKeySize {
+      return nil, ErrUnsupportedKey
+    }
+    return &publicKey{ alg: alg, key: ed25519.PublicKey( x ) }, nil
+
+  case kty == coseKtyRsa && alg == ALG_RS256:
+    n := bytesParam( coseN )
+    e := new(big.Int).SetBytes( bytesParam( coseE ) )
+    if len(n) < 256 || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+      return nil, ErrUnsupportedKey
+    }
+    return &publicKey{ alg: alg, key: &rsa.PublicKey{ N: new(big.Int).SetBytes( n ), E: int(e.Int64()) } }, nil
+  }
+
+  return nil, ErrUnsupportedKey
+}
+
+// verify checks signature over signed. ES256 signatures are DER
+// encoded
+func (key *publicKey) verify( signed []byte, signature []byte ) error {
+  digest := sha256.Sum256( signed )
+  valid := false
+
+  switch verifier := key.key.(type) {
+  case *ecdsa.PublicKey:
+    var rs struct {
+      R, S *big.Int
+    }
+    rest, err := asn1.Unmarshal( signature, &rs )
+    valid = err == nil && len(rest) == 0 && ecdsa.Verify( verifier, digest[:], rs.R, rs.S )
+  case ed25519.PublicKey:
+    valid = ed25519.Verify( verifier, signed, signature )
+  case *rsa.PublicKey:
+    valid = rsa.VerifyPKCS1v15( verifier, crypto.SHA256, digest[:], signature ) == nil
+  }
+
+  if !valid {
+    return ErrInvalidSignature
+  }
+  return nil
+}
@@ -0,0 +1,512 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+// Package webauthn implements the relying party side of the
+// registration and authentication ceremonies of WebAuthn with FIDO2
+// authenticators and passkeys. Attestation statements are not
+// verified, so any authenticator model is accepted. Keys have to
+// be ES256, EdDSA or RS256
+package webauthn
+
+import (
+  "bytes"
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "strings"
+  "sync"
+  "time"
+)
+
+const ALG_ES256 = -7
+const ALG_EDDSA = -8
+const ALG_RS256 = -257
+
+const USER_VERIFICATION_REQUIRED = "required"
+const USER_VERIFICATION_PREFERRED = "preferred"
+const USER_VERIFICATION_DISCOURAGED = "discouraged"
+
+const PUBLIC_KEY_CREDENTIAL_TYPE = "public-key"
+
+const CLIENT_DATA_TYPE_CREATE = "webauthn.create"
+const CLIENT_DATA_TYPE_GET = "webauthn.get"
+
+const CHALLENGE_LENGTH = 32
+
+var ErrCeremonyNotFound = errors.New( "webauthn ceremony not found or expired" )
+var ErrInvalidResponse = errors.New( "invalid webauthn response" )
+var ErrUnsupportedKey = errors.New( "unsupported webauthn public key" )
+var ErrInvalidSignature = errors.New( "invalid webauthn signature" )
+var ErrSignCountNotIncreased = errors.New( "webauthn signature counter did not increase, the credential may be cloned" )
+
+// Config of the relying party. Credentials are bound to the domain
+// RpId. Origin is the origin of the pages running the ceremonies.
+// Ceremonies not finished within Timeout fail. Anyone can start
+// ceremonies, so at most MaxCeremonies are kept and the oldest ones
+// are dropped to make room for new ones
+type Config struct {
+  RpId          string
+  RpName        string
+  Origin        string
+  Timeout       time.Duration
+  MaxCeremonies int
+}
+
+var DefaultConfig = Config{
+  RpId:          "www.cna.localhost",
+  RpName:        "Cyphernode",
+  Origin:        "http://www.cna.localhost:3030",
+  Timeout:       5*time.Minute,
+  MaxCeremonies: 10000,
+}
+
+var config = DefaultConfig
+
+// ceremonies waiting for the response of an authenticator by id
+var ceremonies = make( map[string]*ceremony )
+
+// guards config and ceremonies
+var mutex sync.Mutex
+
+func Configure( newConfig Config ) {
+  mutex.Lock()
+  defer mutex.Unlock()
+  config = newConfig
+}
+
+func currentConfig() Config {
+  mutex.Lock()
+  defer mutex.Unlock()
+  return config
+}
+
+// Base64URL is binary data, which is base64url encoded without
+// padding in json, as in the json of credentials and options of
+// WebAuthn level 3
+type Base64URL []byte
+
+func (data Base64URL) MarshalJSON() ([]byte, error) {
+  return json.Marshal( base64.RawURLEncoding.EncodeToString( data ) )
+}
+
+func (data *Base64URL) UnmarshalJSON( raw []byte ) error {
+  var encoded string
+  err := json.Unmarshal( raw, &encoded )
+  if err != nil {
+    return err
+  }
+  decoded, err := base64.RawURLEncoding.DecodeString( strings.TrimRight( encoded, "=" ) )
+  if err != nil {
+    return err
+  }
+  *data = decoded
+  return nil
+}
+
+type RelyingParty struct {
+  Id   string `json:"id"`
+  Name string `json:"name"`
+}
+
+// UserEntity is the user a credential is created for. Id is the user
+// handle, which authenticators return with passkeys
+type UserEntity struct {
+  Id          Base64URL `json:"id"`
+  Name        string    `json:"name"`
+  DisplayName string    `json:"displayName"`
+}
+
+type CredentialParameter struct {
+  Type string `json:"type"`
+  Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+  Type string    `json:"type"`
+  Id   Base64URL `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+  ResidentKey        string `json:"residentKey"`
+  RequireResidentKey bool   `json:"requireResidentKey"`
+  UserVerification   string `json:"userVerification"`
+}
+
+// CreationOptions are passed as publicKey to
+// navigator.credentials.create
+type CreationOptions struct {
+  Challenge              Base64URL              `json:"challenge"`
+  Rp                     RelyingParty           `json:"rp"`
+  User                   UserEntity             `json:"user"`
+  PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+  // in milliseconds
+  Timeout                int64                  `json:"timeout"`
+  ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+  AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+  Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are passed as publicKey to navigator.credentials.get.
+// Without AllowCredentials the authenticator offers its passkeys
+type RequestOptions struct {
+  Challenge        Base64URL              `json:"challenge"`
+  // in milliseconds
+  Timeout          int64                  `json:"timeout"`
+  RpId             string                 `json:"rpId"`
+  AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+  UserVerification string                 `json:"userVerification"`
+}
+
+type AuthenticatorAttestationResponse struct {
+  ClientDataJSON    Base64URL `json:"clientDataJSON"`
+  AttestationObject Base64URL `json:"attestationObject"`
+}
+
+// RegistrationResponse is the json of the credential
+// navigator.credentials.create resolves with
+type RegistrationResponse struct {
+  Id       string                           `json:"id"`
+  RawId    Base64URL                        `json:"rawId"`
+  Type     string                           `json:"type"`
+  Response AuthenticatorAttestationResponse `json:"response"`
+}
+
+type AuthenticatorAssertionResponse struct {
+  ClientDataJSON    Base64URL `json:"clientDataJSON"`
+  AuthenticatorData Base64URL `json:"authenticatorData"`
+  Signature         Base64URL `json:"signature"`
+  UserHandle        Base64URL `json:"userHandle"`
+}
+
+// AuthenticationResponse is the json of the credential
+// navigator.credentials.get resolves with
+type AuthenticationResponse struct {
+  Id       string                         `json:"id"`
+  RawId    Base64URL                      `json:"rawId"`
+  Type     string                         `json:"type"`
+  Response AuthenticatorAssertionResponse `json:"response"`
+}
+
+// Registration is a credential created in a registration ceremony.
+// PublicKey is a COSE key
+type Registration struct {
+  CredentialId []byte
+  PublicKey    []byte
+  SignCount    uint32
+  Aaguid       []byte
+  UserVerified bool
+}
+
+// Credential is a registered credential as the relying party stored
+// it
+type Credential struct {
+  Id         []byte
+  PublicKey  []byte
+  SignCount  uint32
+  UserHandle []byte
+}
+
+// Assertion is the outcome of an authentication ceremony. SignCount
+// has to be stored as the new signature counter of the credential
+type Assertion struct {
+  CredentialId []byte
+  SignCount    uint32
+  UserVerified bool
+}
+
+type ceremony struct {
+  clientDataType   string
+  challenge        []byte
+  userHandle       []byte
+  allowed          [][]byte
+  userVerification string
+  expiresAt        time.Time
+}
+
+// BeginRegistration starts a ceremony creating a credential of user.
+// Authenticators holding one of the exclude credentials refuse to
+// create another one. It returns the id of the ceremony and the
+// options for the client
+func BeginRegistration( user UserEntity, exclude [][]byte ) (string, *CreationOptions, error) {
+  current := currentConfig()
+  ceremonyId, challenge, err := newCeremony( &ceremony{
+    clientDataType:   CLIENT_DATA_TYPE_CREATE,
+    userHandle:       user.Id,
+    userVerification: USER_VERIFICATION_PREFERRED,
+  }, current.Timeout )
+  if err != nil {
+    return "", nil, err
+  }
+
+  return ceremonyId, &CreationOptions{
+    Challenge: challenge,
+    Rp:        RelyingParty{ Id: current.RpId, Name: current.RpName },
+    User:      user,
+    PubKeyCredParams: []CredentialParameter{
+      { Type: PUBLIC_KEY_CREDENTIAL_TYPE, Alg: ALG_ES256 },
+      { Type: PUBLIC_KEY_CREDENTIAL_TYPE, Alg: ALG_EDDSA },
+      { Type: PUBLIC_KEY_CREDENTIAL_TYPE, Alg: ALG_RS256 },
+    },
+    Timeout:            int64(current.Timeout / time.Millisecond),
+    ExcludeCredentials: descriptors( exclude ),
+    AuthenticatorSelection: AuthenticatorSelection{
+      ResidentKey:      "preferred",
+      UserVerification: USER_VERIFICATION_PREFERRED,
+    },
+    Attestation: "none",
+  }, nil
+}
+
+// FinishRegistration checks the response of the authenticator to
+// the registration ceremony with ceremonyId. The ceremony must have
+// been started for the user with userHandle
+func FinishRegistration( ceremonyId string, userHandle []byte, response *RegistrationResponse ) (*Registration, error) {
+  started, err := takeCeremony( ceremonyId, CLIENT_DATA_TYPE_CREATE )
+  if err != nil {
+    return nil, err
+  }
+  if !bytes.Equal( started.userHandle, userHandle ) {
+    return nil, ErrCeremonyNotFound
+  }
+  if response.Type != PUBLIC_KEY_CREDENTIAL_TYPE {
+    return nil, invalid( "credential type %q", response.Type )
+  }
+
+  current := currentConfig()
+  err = checkClientData( response.Response.ClientDataJSON, CLIENT_DATA_TYPE_CREATE, started.challenge, current )
+  if err != nil {
+    return nil, err
+  }
+
+  attestation, err := parseAttestationObject( response.Response.AttestationObject )
+  if err != nil {
+    return nil, err
+  }
+
+  authData, err := parseAuthenticatorData( attestation.AuthData )
+  if err != nil {
+    return nil, err
+  }
+  err = authData.check( started.userVerification, current )
+  if err != nil {
+    return nil, err
+  }
+  if authData.flags & FLAG_ATTESTED_CREDENTIAL_DATA == 0 {
+    return nil, invalid( "no attested credential data" )
+  }
+  if !bytes.Equal( authData.credentialId, response.RawId ) {
+    return nil, invalid( "credential id does not match the attested one" )
+  }
+
+  _, err = parsePublicKey( authData.publicKey )
+  if err != nil {
+    return nil, err
+  }
+
+  return &Registration{
+    CredentialId: authData.credentialId,
+    PublicKey:    authData.publicKey,
+    SignCount:    authData.signCount,
+    Aaguid:       authData.aaguid,
+    UserVerified: authData.flags & FLAG_USER_VERIFIED != 0,
+  }, nil
+}
+
+// BeginAuthentication starts a ceremony, in which the user proves
+// possession of a credential. With userHandle only credentials of
+// that user are accepted, with allowed only those credentials.
+// Without both the user is identified by the passkey. It returns
+// the id of the ceremony and the options for the client
+func BeginAuthentication( userHandle []byte, allowed [][]byte, userVerification string ) (string, *RequestOptions, error) {
+  current := currentConfig()
+  ceremonyId, challenge, err := newCeremony( &ceremony{
+    clientDataType:   CLIENT_DATA_TYPE_GET,
+    userHandle:       userHandle,
+    allowed:          allowed,
+    userVerification: userVerification,
+  }, current.Timeout )
+  if err != nil {
+    return "", nil, err
+  }
+
+  return ceremonyId, &RequestOptions{
+    Challenge:        challenge,
+    Timeout:          int64(current.Timeout / time.Millisecond),
+    RpId:             current.RpId,
+    AllowCredentials: descriptors( allowed ),
+    UserVerification: userVerification,
+  }, nil
+}
+
+// FinishAuthentication checks the response of the authenticator to
+// the authentication ceremony with ceremonyId. credential is the
+// stored credential with the id of the response
+func FinishAuthentication( ceremonyId string, response *AuthenticationResponse, credential *Credential ) (*Assertion, error) {
+  started, err := takeCeremony( ceremonyId, CLIENT_DATA_TYPE_GET )
+  if err != nil {
+    return nil, err
+  }
+  if response.Type != PUBLIC_KEY_CREDENTIAL_TYPE {
+    return nil, invalid( "credential type %q", response.Type )
+  }
+  if !bytes.Equal( response.RawId, credential.Id ) {
+    return nil, invalid( "credential id does not match the stored one" )
+  }
+  if len(started.allowed) > 0 && !containsId( started.allowed, credential.Id ) {
+    return nil, invalid( "credential is not allowed" )
+  }
+  if len(started.userHandle) > 0 && !bytes.Equal( started.userHandle, credential.UserHandle ) {
+    return nil, invalid( "credential belongs to another user" )
+  }
+  userHandle := response.Response.UserHandle
+  if len(started.userHandle) == 0 && len(userHandle) == 0 {
+    return nil, invalid( "user handle is missing" )
+  }
+  if len(userHandle) > 0 && !bytes.Equal( userHandle, credential.UserHandle ) {
+    return nil, invalid( "user handle does not match the credential" )
+  }
+
+  current := currentConfig()
+  err = checkClientData( response.Response.ClientDataJSON, CLIENT_DATA_TYPE_GET, started.challenge, current )
+  if err != nil {
+    return nil, err
+  }
+
+  authData, err := parseAuthenticatorData( response.Response.AuthenticatorData )
+  if err != nil {
+    return nil, err
+  }
+  err = authData.check( started.userVerification, current )
+  if err != nil {
+    return nil, err
+  }
+
+  key, err := parsePublicKey( credential.PublicKey )
+  if err != nil {
+    return nil, err
+  }
+
+  clientDataHash := sha256.Sum256( response.Response.ClientDataJSON )
+  signed := append( append( []byte{}, authData.raw... ), clientDataHash[:]... )
+  err = key.verify( signed, response.Response.Signature )
+  if err != nil {
+    return nil, err
+  }
+
+  // authenticators without a counter always send zero
+  if ( authData.signCount != 0 || credential.SignCount != 0 ) && authData.signCount <= credential.SignCount {
+    return nil, ErrSignCountNotIncreased
+  }
+
+  return &Assertion{
+    CredentialId: credential.Id,
+    SignCount:    authData.signCount,
+    UserVerified: authData.flags & FLAG_USER_VERIFIED != 0,
+  }, nil
+}
+
+// newCeremony stores started with a new id and challenge. Expired
+// ceremonies are dropped on the way, and the oldest ones if there
+// are too many
+func newCeremony( started *ceremony, timeout time.Duration ) (string, []byte, error) {
+  random := make( []byte, 16+CHALLENGE_LENGTH )
+  _, err := rand.Read( random )
+  if err != nil {
+    return "", nil, err
+  }
+  ceremonyId := base64.RawURLEncoding.EncodeToString( random[:16] )
+  started.challenge = random[16:]
+
+  mutex.Lock()
+  defer mutex.Unlock()
+
+  now := time.Now()
+  for id, other := range ceremonies {
+    if now.After( other.expiresAt ) {
+      delete( ceremonies, id )
+    }
+  }
+  for len(ceremonies) > 0 && len(ceremonies) >= config.MaxCeremonies {
+    delete( ceremonies, oldestCeremony() )
+  }
+  started.expiresAt = now.Add( timeout )
+  ceremonies[ceremonyId] = started
+
+  return ceremonyId, started.challenge, nil
+}
+
+// oldestCeremony returns the id of the ceremony expiring first,
+// which is the one started first. mutex must be locked
+func oldestCeremony() string {
+  var oldestId string
+  var oldest *ceremony
+  for id, other := range ceremonies {
+    if oldest == nil || other.expiresAt.Before( oldest.expiresAt ) {
+      oldestId, oldest = id, other
+    }
+  }
+  return oldestId
+}
+
+// takeCeremony removes the ceremony with id, so every challenge is
+// answered only once
+func takeCeremony( id string, clientDataType string ) (*ceremony, error) {
+  mutex.Lock()
+  defer mutex.Unlock()
+
+  started, exists := ceremonies[id]
+  if !exists {
+    return nil, ErrCeremonyNotFound
+  }
+  delete( ceremonies, id )
+
+  if started.clientDataType != clientDataType || time.Now().After( started.expiresAt ) {
+    return nil, ErrCeremonyNotFound
+  }
+  return started, nil
+}
+
+func descriptors( ids [][]byte ) []CredentialDescriptor {
+  list := make( []CredentialDescriptor, len(ids) )
+  for i, id := range ids {
+    list[i] = CredentialDescriptor{ Type: PUBLIC_KEY_CREDENTIAL_TYPE, Id: id }
+  }
+  return list
+}
+
+func containsId( ids [][]byte, id []byte ) bool {
+  for _, other := range ids {
+    if bytes.Equal( other, id ) {
+      return true
+    }
+  }
+  return false
+}
+
+func invalid( format string, args ...interface{} ) error {
+  return fmt.Errorf( "%w: %s", ErrInvalidResponse, fmt.Sprintf( format, args... ) )
+}
@@ -0,0 +1,212 @@
+/*
+ * MIT License
+ *
+ * Copyright (c) 2021 schulterklopfer/__escapee__
+ *
+ * Permission is hereby granted, free of charge, to any person obtaining a copy
+ * of this software and associated documentation files (the "Software"), to deal
+ * in the Software without restriction, including without limitation the rights
+ * to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+ * copies of the Software, and to permit persons to whom the Software is
+ * furnished to do so, subject to the following conditions:
+ *
+ * The above copyright notice and this permission notice shall be included in all
+ * copies or substantial portions of the Software.
+ *
+ * THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+ * IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+ * FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+ * AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+ * LIABILIT * Y, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+ * OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+ * SOFTWARE.
+ */
+
+package webauthn_test
+
+import (
+  "errors"
+  "github.com/schulterklopfer/cyphernode_fauth/test_helpers"
+  "github.com/schulterklopfer/cyphernode_fauth/webauthn"
+  "testing"
+)
+
+const origin = "https://cna.example.com"
+
+var alice = webauthn.UserEntity{ Id: []byte{ 0, 0, 0, 0, 0, 0, 0, 1 }, Name: "alice", DisplayName: "Alice" }
+
+func configure() {
+  config := webauthn.DefaultConfig
+  config.RpId = "cna.example.com"
+  config.Origin = origin
+  webauthn.Configure( config )
+}
+
+// register creates a credential of alice with authenticator
+func register( t *testing.T, authenticator *test_helpers.SoftwareAuthenticator ) *webauthn.Credential {
+  ceremonyId, options, err := webauthn.BeginRegistration( alice, nil )
+  if err != nil {
+    t.Fatal( err )
+  }
+  response, err := authenticator.Create( options )
+  if err != nil {
+    t.Fatal( err )
+  }
+  registration, err := webauthn.FinishRegistration( ceremonyId, alice.Id, response )
+  if err != nil {
+    t.Fatal( err )
+  }
+  return &webauthn.Credential{
+    Id:         registration.CredentialId,
+    PublicKey:  registration.PublicKey,
+    SignCount:  registration.SignCount,
+    UserHandle: alice.Id,
+  }
+}
+
+// authenticate runs an authentication ceremony with authenticator
+// and stores the new sign count in credential
+func authenticate( authenticator *test_helpers.SoftwareAuthenticator, credential *webauthn.Credential, userHandle []byte, allowed [][]byte ) error {
+  ceremonyId, options, err := webauthn.BeginAuthentication( userHandle, allowed, webauthn.USER_VERIFICATION_REQUIRED )
+  if err != nil {
+    return err
+  }
+  response, err := authenticator.Get( options )
+  if err != nil {
+    return err
+  }
+  assertion, err := webauthn.FinishAuthentication( ceremonyId, response, credential )
+  if err != nil {
+    return err
+  }
+  credential.SignCount = assertion.SignCount
+  return nil
+}
+
+func TestRegistration(t *testing.T) {
+  configure()
+  authenticator := test_helpers.NewSoftwareAuthenticator( origin )
+
+  ceremonyId, options, err := webauthn.BeginRegistration( alice, [][]byte{ []byte("other") } )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if options.Rp.Id != "cna.example.com" || len(options.ExcludeCredentials) != 1 || len(options.Challenge) != webauthn.CHALLENGE_LENGTH {
+    t.Errorf( "unexpected options %+v", options )
+  }
+
+  response, err := authenticator.Create( options )
+  if err != nil {
+    t.Fatal( err )
+  }
+
+  if _, err := webauthn.FinishRegistration( ceremonyId, []byte("mallory"), response ); err != webauthn.ErrCeremonyNotFound {
+    t.Errorf( "ceremony of another user must not be finished, got %v", err )
+  }
+
+  ceremonyId, options, _ = webauthn.BeginRegistration( alice, nil )
+  response, _ = authenticator.Create( options )
+  registration, err := webauthn.FinishRegistration( ceremonyId, alice.Id, response )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if len(registration.CredentialId) != 16 || len(registration.PublicKey) == 0 || !registration.UserVerified {
+    t.Errorf( "unexpected registration %+v", registration )
+  }
+
+  if _, err := webauthn.FinishRegistration( ceremonyId, alice.Id, response ); err != webauthn.ErrCeremonyNotFound {
+    t.Errorf( "ceremonies must be finished only once, got %v", err )
+  }
+
+  phishing := test_helpers.NewSoftwareAuthenticator( "https://cna.example.com.evil.com" )
+  ceremonyId, options, _ = webauthn.BeginRegistration( alice, nil )
+  response, _ = phishing.Create( options )
+  if _, err := webauthn.FinishRegistration( ceremonyId, alice.Id, response ); !errors.Is( err, webauthn.ErrInvalidResponse ) {
+    t.Errorf( "responses of other origins must be refused, got %v", err )
+  }
+
+  ceremonyId, options, _ = webauthn.BeginRegistration( alice, nil )
+  options.Rp.Id = "evil.com"
+  response, _ = authenticator.Create( options )
+  if _, err := webauthn.FinishRegistration( ceremonyId, alice.Id, response ); !errors.Is( err, webauthn.ErrInvalidResponse ) {
+    t.Errorf( "credentials of other relying parties must be refused, got %v", err )
+  }
+}
+
+func TestAuthentication(t *testing.T) {
+  configure()
+  authenticator := test_helpers.NewSoftwareAuthenticator( origin )
+  credential := register( t, authenticator )
+
+  if err := authenticate( authenticator, credential, alice.Id, [][]byte{ credential.Id } ); err != nil {
+    t.Fatalf( "second factor must be accepted, got %v", err )
+  }
+  if err := authenticate( authenticator, credential, nil, nil ); err != nil {
+    t.Fatalf( "passkey must be accepted, got %v", err )
+  }
+  if credential.SignCount != 2 {
+    t.Errorf( "expected sign count 2, got %d", credential.SignCount )
+  }
+
+  if err := authenticate( authenticator, credential, []byte("mallory"), nil ); !errors.Is( err, webauthn.ErrInvalidResponse ) {
+    t.Errorf( "credential of another user must be refused, got %v", err )
+  }
+
+  clone := authenticator.Clone()
+  if err := authenticate( authenticator, credential, nil, nil ); err != nil {
+    t.Fatal( err )
+  }
+  if err := authenticate( clone, credential, nil, nil ); err != webauthn.ErrSignCountNotIncreased {
+    t.Errorf( "expected %v, got %v", webauthn.ErrSignCountNotIncreased, err )
+  }
+
+  authenticator.UserVerified = false
+  if err := authenticate( authenticator, credential, nil, nil ); !errors.Is( err, webauthn.ErrInvalidResponse ) {
+    t.Errorf( "unverified user must be refused, got %v", err )
+  }
+  authenticator.UserVerified = true
+
+  ceremonyId, options, _ := webauthn.BeginAuthentication( nil, nil, webauthn.USER_VERIFICATION_REQUIRED )
+  response, _ := authenticator.Get( options )
+  response.Response.Signature[len(response.Response.Signature)-1] ^= 1
+  if _, err := webauthn.FinishAuthentication( ceremonyId, response, credential ); err != webauthn.ErrInvalidSignature {
+    t.Errorf( "expected %v, got %v", webauthn.ErrInvalidSignature, err )
+  }
+
+  ceremonyId, options, _ = webauthn.BeginAuthentication( nil, nil, webauthn.USER_VERIFICATION_REQUIRED )
+  response, _ = authenticator.Get( options )
+  response.Response.UserHandle = nil
+  if _, err := webauthn.FinishAuthentication( ceremonyId, response, credential ); !errors.Is( err, webauthn.ErrInvalidResponse ) {
+    t.Errorf( "passkeys without user handle must be refused, got %v", err )
+  }
+}
+
+func TestMaxCeremonies(t *testing.T) {
+  config := webauthn.DefaultConfig
+  config.RpId = "cna.example.com"
+  config.Origin = origin
+  config.MaxCeremonies = 2
+  webauthn.Configure( config )
+  defer configure()
+
+  authenticator := test_helpers.NewSoftwareAuthenticator( origin )
+  credential := register( t, authenticator )
+
+  oldestId, oldestOptions, _ := webauthn.BeginAuthentication( nil, nil, webauthn.USER_VERIFICATION_REQUIRED )
+  for i := 0; i < 2; i++ {
+    if _, _, err := webauthn.BeginAuthentication( nil, nil, webauthn.USER_VERIFICATION_REQUIRED ); err != nil {
+      t.Fatal( err )
+    }
+  }
+
+  response, err := authenticator.Get( oldestOptions )
+  if err != nil {
+    t.Fatal( err )
+  }
+  if _, err := webauthn.FinishAuthentication( oldestId, response, credential ); err != webauthn.ErrCeremonyNotFound {
+    t.Errorf( "oldest ceremony must be dropped, got %v", err )
+  }
+  if err := authenticate( authenticator, credential, nil, nil ); err != nil {
+    t.Errorf( "new ceremonies must still work, got %v", err )
+  }
+}